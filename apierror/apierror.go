@@ -0,0 +1,110 @@
+package apierror
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	loggermiddleware "github.com/meateam/api-gateway/logger"
+	"google.golang.org/grpc/status"
+)
+
+// Error is the JSON response of a failed request.
+type Error struct {
+	// Code is the HTTP status code of the response.
+	Code int `json:"code"`
+
+	// Message is a message describing the error that is safe to show to the client.
+	Message string `json:"message"`
+
+	// TraceID is the APM trace id of the request.
+	TraceID string `json:"traceID,omitempty"`
+
+	// Details are the details of the gRPC status of the error, if there are any.
+	Details []interface{} `json:"details,omitempty"`
+}
+
+// meta is the gin.Error meta of the errors aborted by this package.
+type meta struct {
+	// message is the message of the error that is shown to the client,
+	// if it's empty then the status text of the response code is shown.
+	message string
+}
+
+// Abort aborts the request of c with the status code and records err, which is returned.
+// The message of err is not shown to the client, who gets the status text of code
+// and the details of the gRPC status of err if there are any.
+func Abort(c *gin.Context, code int, err error) error {
+	if err == nil {
+		err = errors.New(http.StatusText(code))
+	}
+
+	return abort(c, code, err, "")
+}
+
+// AbortWithMessage aborts the request of c with the status code and message,
+// which is shown to the client. Returns the recorded error.
+func AbortWithMessage(c *gin.Context, code int, message string) error {
+	return abort(c, code, errors.New(message), message)
+}
+
+// AbortWithError aborts the request of c with the status code and err, which is returned.
+// The message of err is shown to the client, who also gets the details of the gRPC status
+// of err if there are any, so err must be safe to show.
+func AbortWithError(c *gin.Context, code int, err error) error {
+	return abort(c, code, err, status.Convert(err).Message())
+}
+
+// AbortWithStatus aborts the request of c with the status code,
+// the client gets the status text of code.
+func AbortWithStatus(c *gin.Context, code int) {
+	abort(c, code, errors.New(http.StatusText(code)), "")
+}
+
+// abort sets the status of c to code, aborts it and records err with message.
+func abort(c *gin.Context, code int, err error, message string) error {
+	c.Status(code)
+	c.Abort()
+
+	return c.Error(err).SetType(gin.ErrorTypePrivate).SetMeta(meta{message: message})
+}
+
+// New creates the Error of the request of c from err with the status code.
+func New(c *gin.Context, code int, err *gin.Error) *Error {
+	apiErr := &Error{
+		Code:    code,
+		Message: http.StatusText(code),
+		TraceID: loggermiddleware.ExtractTraceID(c),
+	}
+
+	if m, ok := err.Meta.(meta); ok && m.message != "" {
+		apiErr.Message = m.message
+	}
+
+	if s, ok := status.FromError(err.Err); ok && s != nil {
+		apiErr.Details = s.Details()
+	}
+
+	return apiErr
+}
+
+// Middleware returns a middleware that renders the last error of each failed request as a JSON Error,
+// unless the handler already responded. The error's status code is the response's status code, which
+// is 500 if the handler didn't set an error status.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		err := c.Errors.Last()
+		if err == nil || c.Writer.Written() {
+			return
+		}
+
+		code := c.Writer.Status()
+		if code < http.StatusBadRequest {
+			code = http.StatusInternalServerError
+		}
+
+		c.JSON(code, New(c, code, err))
+	}
+}
@@ -0,0 +1,137 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	grpcStatus, err := status.New(codes.NotFound, "file 5f8 not found in mongo collection").
+		WithDetails(&errdetails.ResourceInfo{ResourceType: "file", ResourceName: "5f8"})
+	if err != nil {
+		t.Fatalf("failed creating status: %v", err)
+	}
+
+	quotaStatus, err := status.New(codes.ResourceExhausted, "upload requires 10 bytes, only 5 remain").
+		WithDetails(&errdetails.QuotaFailure{
+			Violations: []*errdetails.QuotaFailure_Violation{{Subject: "owner:5f8", Description: "quota exceeded"}},
+		})
+	if err != nil {
+		t.Fatalf("failed creating status: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		handler     gin.HandlerFunc
+		wantStatus  int
+		wantMessage string
+		wantDetails int
+	}{
+		{
+			name: "gRPC error",
+			handler: func(c *gin.Context) {
+				Abort(c, http.StatusNotFound, grpcStatus.Err())
+			},
+			wantStatus:  http.StatusNotFound,
+			wantMessage: http.StatusText(http.StatusNotFound),
+			wantDetails: 1,
+		},
+		{
+			name: "message",
+			handler: func(c *gin.Context) {
+				AbortWithMessage(c, http.StatusBadRequest, "id is required")
+			},
+			wantStatus:  http.StatusBadRequest,
+			wantMessage: "id is required",
+		},
+		{
+			name: "gRPC error with message",
+			handler: func(c *gin.Context) {
+				AbortWithError(c, http.StatusInsufficientStorage, quotaStatus.Err())
+			},
+			wantStatus:  http.StatusInsufficientStorage,
+			wantMessage: "upload requires 10 bytes, only 5 remain",
+			wantDetails: 1,
+		},
+		{
+			name: "status",
+			handler: func(c *gin.Context) {
+				AbortWithStatus(c, http.StatusUnauthorized)
+			},
+			wantStatus:  http.StatusUnauthorized,
+			wantMessage: http.StatusText(http.StatusUnauthorized),
+		},
+		{
+			name: "error without status",
+			handler: func(c *gin.Context) {
+				_ = c.Error(errors.New("failed"))
+			},
+			wantStatus:  http.StatusInternalServerError,
+			wantMessage: http.StatusText(http.StatusInternalServerError),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(Middleware())
+			r.GET("/", tt.handler)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+
+			if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+				t.Errorf("expected a JSON response, got content type %q", contentType)
+			}
+
+			var apiErr Error
+			if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil {
+				t.Fatalf("failed decoding error: %v", err)
+			}
+
+			if apiErr.Code != tt.wantStatus || apiErr.Message != tt.wantMessage {
+				t.Errorf("expected error %d %q, got %d %q", tt.wantStatus, tt.wantMessage, apiErr.Code, apiErr.Message)
+			}
+
+			if len(apiErr.Details) != tt.wantDetails {
+				t.Errorf("expected %d details, got %d", tt.wantDetails, len(apiErr.Details))
+			}
+
+			if strings.Contains(w.Body.String(), "mongo") {
+				t.Errorf("expected the gRPC error message not to leak, got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestMiddleware_Responded(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "partial")
+		AbortWithStatus(c, http.StatusInternalServerError)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if w.Body.String() != "partial" {
+		t.Errorf("expected a responded request not to be rendered, got %q", w.Body.String())
+	}
+}
@@ -0,0 +1,6 @@
+/*
+Package apierror is the error model of the api-gateway's responses.
+Handlers abort failed requests with Abort, AbortWithMessage or AbortWithStatus,
+and Middleware renders the error of the request as a JSON Error.
+*/
+package apierror
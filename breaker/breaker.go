@@ -0,0 +1,239 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// State is the state of a Breaker.
+type State int
+
+const (
+	// Closed is the state of a breaker that lets all calls through.
+	Closed State = iota
+
+	// Open is the state of a breaker that rejects all calls.
+	Open
+
+	// HalfOpen is the state of a breaker that lets a limited number of probe calls through,
+	// to check whether the backend recovered.
+	HalfOpen
+)
+
+// String returns the name of s.
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// Config is the configuration of a Breaker.
+type Config struct {
+	// FailureThreshold is the number of consecutive failed calls that opens the breaker.
+	FailureThreshold int
+
+	// OpenTimeout is the time an open breaker rejects calls before it becomes half-open.
+	OpenTimeout time.Duration
+
+	// HalfOpenProbes is the number of concurrent probe calls a half-open breaker lets through,
+	// and the number of successful probes that close it.
+	HalfOpenProbes int
+}
+
+// errCallerDone is recorded as the result of a call that failed since its caller's context was done,
+// which is neither a success nor a failure of the backend.
+var errCallerDone = errors.New("caller context done")
+
+// OpenError is the error of a call that was rejected by an open breaker.
+type OpenError struct {
+	Backend    string
+	RetryAfter time.Duration
+}
+
+// Error returns the message of e.
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("circuit breaker of %s is open, retry after %v", e.Backend, e.RetryAfter)
+}
+
+// GRPCStatus returns the gRPC status of e, which is codes.Unavailable.
+func (e *OpenError) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, e.Error())
+}
+
+// Breaker is a circuit breaker of a single backend, its methods are safe
+// to be called by multiple goroutines simultaneously.
+type Breaker struct {
+	backend string
+	config  Config
+	now     func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probes   int
+	succeeds int
+
+	// generation is incremented on each state change, so results of calls
+	// that were allowed in a previous state are ignored.
+	generation uint64
+}
+
+// NewBreaker creates a closed Breaker of backend.
+func NewBreaker(backend string, config Config) *Breaker {
+	if config.HalfOpenProbes < 1 {
+		config.HalfOpenProbes = 1
+	}
+
+	return &Breaker{backend: backend, config: config, now: time.Now}
+}
+
+// State returns the current state of b.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.config.OpenTimeout {
+		return HalfOpen
+	}
+
+	return b.state
+}
+
+// Allow checks if a call is allowed by b. If the call is allowed then done must be called
+// with the result of the call. Otherwise the returned error is an *OpenError.
+func (b *Breaker) Allow() (done func(err error), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open {
+		openFor := b.now().Sub(b.openedAt)
+		if openFor < b.config.OpenTimeout {
+			return nil, &OpenError{Backend: b.backend, RetryAfter: b.config.OpenTimeout - openFor}
+		}
+
+		b.setState(HalfOpen)
+	}
+
+	if b.state == HalfOpen {
+		if b.probes >= b.config.HalfOpenProbes {
+			return nil, &OpenError{Backend: b.backend, RetryAfter: time.Second}
+		}
+
+		b.probes++
+	}
+
+	generation := b.generation
+
+	return func(err error) { b.done(generation, err) }, nil
+}
+
+// done records the result of a call that was allowed in generation.
+func (b *Breaker) done(generation uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	if err == errCallerDone {
+		if b.state == HalfOpen {
+			b.probes--
+		}
+
+		return
+	}
+
+	failed := IsFailure(err)
+	switch b.state {
+	case Closed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+
+		b.failures++
+		if b.failures >= b.config.FailureThreshold {
+			b.setState(Open)
+		}
+	case HalfOpen:
+		b.probes--
+		if failed {
+			b.setState(Open)
+			return
+		}
+
+		b.succeeds++
+		if b.succeeds >= b.config.HalfOpenProbes {
+			b.setState(Closed)
+		}
+	}
+}
+
+// setState changes the state of b to state and resets its counters.
+func (b *Breaker) setState(state State) {
+	b.state = state
+	b.generation++
+	b.failures = 0
+	b.probes = 0
+	b.succeeds = 0
+	if state == Open {
+		b.openedAt = b.now()
+	}
+}
+
+// IsFailure returns whether err indicates that the backend is failing,
+// as opposed to errors of the call itself such as codes.NotFound or codes.PermissionDenied.
+func IsFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	default:
+		return false
+	}
+}
+
+// Set is a set of breakers of the backends, the breaker of each backend is created on first use.
+type Set struct {
+	config Config
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewSet creates a Set whose breakers are configured by config.
+func NewSet(config Config) *Set {
+	return &Set{config: config, breakers: make(map[string]*Breaker)}
+}
+
+// Breaker returns the breaker of backend.
+func (s *Set) Breaker(backend string) *Breaker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.breakers[backend]
+	if !ok {
+		b = NewBreaker(backend, s.config)
+		s.breakers[backend] = b
+	}
+
+	return b
+}
+
+// State returns the state of the breaker of backend.
+func (s *Set) State(backend string) State {
+	return s.Breaker(backend).State()
+}
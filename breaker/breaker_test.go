@@ -0,0 +1,233 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	errUnavailable = status.Error(codes.Unavailable, "unavailable")
+	errNotFound    = status.Error(codes.NotFound, "not found")
+)
+
+// newTestBreaker creates a Breaker whose clock is advanced by the returned func.
+func newTestBreaker(config Config) (*Breaker, func(time.Duration)) {
+	now := time.Now()
+	b := NewBreaker("file-service:8080", config)
+	b.now = func() time.Time { return now }
+
+	return b, func(d time.Duration) { now = now.Add(d) }
+}
+
+// call makes a call with the result err through b, returns the error of b if the call was rejected.
+func call(b *Breaker, err error) error {
+	done, allowErr := b.Allow()
+	if allowErr != nil {
+		return allowErr
+	}
+
+	done(err)
+
+	return nil
+}
+
+func TestBreaker(t *testing.T) {
+	b, advance := newTestBreaker(Config{FailureThreshold: 3, OpenTimeout: 10 * time.Second, HalfOpenProbes: 1})
+
+	for i := 0; i < 5; i++ {
+		if err := call(b, errNotFound); err != nil {
+			t.Fatalf("expected call errors not to open the breaker, got %v", err)
+		}
+	}
+
+	call(b, errUnavailable)
+	call(b, errUnavailable)
+	call(b, nil)
+	call(b, errUnavailable)
+	call(b, errUnavailable)
+	if state := b.State(); state != Closed {
+		t.Fatalf("expected a success to reset the failures, got state %v", state)
+	}
+
+	call(b, errUnavailable)
+	if state := b.State(); state != Open {
+		t.Fatalf("expected breaker to open after 3 consecutive failures, got state %v", state)
+	}
+
+	advance(4 * time.Second)
+	err := call(b, nil)
+	openErr, ok := err.(*OpenError)
+	if !ok {
+		t.Fatalf("expected an open breaker to reject calls with *OpenError, got %v", err)
+	}
+
+	if openErr.RetryAfter != 6*time.Second {
+		t.Errorf("expected retry after 6s, got %v", openErr.RetryAfter)
+	}
+
+	if code := status.Code(err); code != codes.Unavailable {
+		t.Errorf("expected rejected call code %v, got %v", codes.Unavailable, code)
+	}
+
+	advance(6 * time.Second)
+	if state := b.State(); state != HalfOpen {
+		t.Fatalf("expected breaker to be half-open after the open timeout, got state %v", state)
+	}
+
+	done, err := b.Allow()
+	if err != nil {
+		t.Fatalf("expected a half-open breaker to allow a probe, got %v", err)
+	}
+
+	if err := call(b, nil); err == nil {
+		t.Error("expected a half-open breaker to reject calls beyond the probes")
+	}
+
+	done(errUnavailable)
+	if state := b.State(); state != Open {
+		t.Fatalf("expected a failed probe to open the breaker, got state %v", state)
+	}
+
+	advance(10 * time.Second)
+	if err := call(b, nil); err != nil {
+		t.Fatalf("expected a half-open breaker to allow a probe, got %v", err)
+	}
+
+	if state := b.State(); state != Closed {
+		t.Errorf("expected a successful probe to close the breaker, got state %v", state)
+	}
+}
+
+func TestBreaker_IgnoresStaleResults(t *testing.T) {
+	b, _ := newTestBreaker(Config{FailureThreshold: 1, OpenTimeout: time.Minute})
+
+	done, err := b.Allow()
+	if err != nil {
+		t.Fatalf("expected a closed breaker to allow calls, got %v", err)
+	}
+
+	call(b, errUnavailable)
+	done(nil)
+
+	if state := b.State(); state != Open {
+		t.Errorf("expected a result of a call from before opening to be ignored, got state %v", state)
+	}
+}
+
+func TestSet_Interceptors(t *testing.T) {
+	cc, err := grpc.Dial("file-service:8080", grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("failed dialing: %v", err)
+	}
+	defer cc.Close()
+
+	s := NewSet(Config{FailureThreshold: 1, OpenTimeout: 1500 * time.Millisecond})
+	unary := s.UnaryClientInterceptor()
+	stream := s.StreamClientInterceptor()
+
+	invoked := 0
+	invoker := func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+		invoked++
+		return errUnavailable
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/files", func(c *gin.Context) {
+		err := unary(c.Request.Context(), "/file.FileService/GetFileByID", nil, nil, cc, invoker)
+		c.AbortWithError(http.StatusServiceUnavailable, err)
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/files", nil))
+	if state := s.State(cc.Target()); state != Open {
+		t.Fatalf("expected the breaker of %s to open, got state %v", cc.Target(), state)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files", nil))
+	if invoked != 1 {
+		t.Errorf("expected an open breaker not to invoke the call, got %d invocations", invoked)
+	}
+
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Errorf("expected Retry-After header 2, got %q", retryAfter)
+	}
+
+	_, err = stream(context.Background(), &grpc.StreamDesc{}, cc, "/upload.Upload/UploadPart",
+		func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+			return nil, errors.New("unexpected stream")
+		})
+	if _, ok := err.(*OpenError); !ok {
+		t.Errorf("expected an open breaker to reject streams, got %v", err)
+	}
+}
+
+func TestSet_IgnoresCallerDeadline(t *testing.T) {
+	cc, err := grpc.Dial("file-service:8080", grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("failed dialing: %v", err)
+	}
+	defer cc.Close()
+
+	s := NewSet(Config{FailureThreshold: 1, OpenTimeout: time.Minute})
+	unary := s.UnaryClientInterceptor()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	invoker := func(
+		ctx context.Context,
+		_ string,
+		_, _ interface{},
+		_ *grpc.ClientConn,
+		_ ...grpc.CallOption) error {
+		<-ctx.Done()
+		return status.Error(codes.DeadlineExceeded, "context deadline exceeded")
+	}
+
+	err = unary(ctx, "/file.FileService/GetFileByID", nil, nil, cc, invoker)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected the call to exceed its deadline, got %v", err)
+	}
+
+	if state := s.State(cc.Target()); state != Closed {
+		t.Errorf("expected a call that exceeded its caller's deadline not to open the breaker, got state %v", state)
+	}
+}
+
+func TestMiddleware_ConcurrentRejections(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/files", func(c *gin.Context) {
+		var wg sync.WaitGroup
+		for i := 1; i <= 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				setRetryAfter(c.Request.Context(), &OpenError{RetryAfter: time.Duration(i) * time.Second})
+			}(i)
+		}
+
+		wg.Wait()
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files", nil))
+
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "8" {
+		t.Errorf("expected the longest Retry-After 8, got %q", retryAfter)
+	}
+}
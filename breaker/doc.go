@@ -0,0 +1,7 @@
+/*
+Package breaker implements circuit breakers for the gRPC calls to the backend services.
+A Set holds a Breaker for each backend, use its UnaryClientInterceptor and StreamClientInterceptor
+to guard the calls to the backends, and Middleware to respond with a Retry-After header
+when a call is rejected by an open breaker.
+*/
+package breaker
@@ -0,0 +1,65 @@
+package breaker
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// UnaryClientInterceptor returns a grpc.UnaryClientInterceptor that guards the gRPC calls
+// with the breaker of their backend. The backend is the target of the connection, e.g. "file-service:8080".
+func (s *Set) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		fullMethod string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		done, err := s.Breaker(cc.Target()).Allow()
+		if err != nil {
+			setRetryAfter(ctx, err)
+			return err
+		}
+
+		err = invoker(ctx, fullMethod, req, reply, cc, opts...)
+		done(callResult(ctx, err))
+
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a grpc.StreamClientInterceptor that guards opening gRPC streams
+// with the breaker of their backend. Only the result of opening the stream is recorded by the breaker.
+func (s *Set) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		fullMethod string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		done, err := s.Breaker(cc.Target()).Allow()
+		if err != nil {
+			setRetryAfter(ctx, err)
+			return nil, err
+		}
+
+		stream, err := streamer(ctx, desc, cc, fullMethod, opts...)
+		done(callResult(ctx, err))
+
+		return stream, err
+	}
+}
+
+// callResult returns the result to record of a call of ctx that returned err. A call that failed after ctx
+// was done, e.g. by the deadline of the request, failed by its caller rather than by the backend.
+func callResult(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return errCallerDone
+	}
+
+	return err
+}
@@ -0,0 +1,92 @@
+package breaker
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// retryAfterKey is the context key of the *retryAfter of the request.
+type retryAfterKey struct{}
+
+// retryAfter is the longest retry-after seconds of the calls of a request that were rejected by open breakers.
+// It's recorded by the interceptors of the request's calls, which may run concurrently.
+type retryAfter struct {
+	seconds int64
+}
+
+// record records the retry-after of err, if it's longer than the recorded one.
+func (r *retryAfter) record(err *OpenError) {
+	seconds := int64(math.Ceil(err.RetryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	for {
+		recorded := atomic.LoadInt64(&r.seconds)
+		if seconds <= recorded || atomic.CompareAndSwapInt64(&r.seconds, recorded, seconds) {
+			return
+		}
+	}
+}
+
+// retryAfterWriter is a gin.ResponseWriter that sets the Retry-After header of the response
+// to the recorded retry-after when the response's header is written.
+type retryAfterWriter struct {
+	gin.ResponseWriter
+	recorded *retryAfter
+}
+
+// setHeader sets the Retry-After header if a call was rejected and the header wasn't written yet.
+func (w *retryAfterWriter) setHeader() {
+	if seconds := atomic.LoadInt64(&w.recorded.seconds); seconds > 0 && !w.Written() {
+		w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	}
+}
+
+// WriteHeaderNow writes the header of the response.
+func (w *retryAfterWriter) WriteHeaderNow() {
+	w.setHeader()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+// Write writes data to the response, writing its header first.
+func (w *retryAfterWriter) Write(data []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString writes s to the response, writing its header first.
+func (w *retryAfterWriter) WriteString(s string) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Middleware returns a gin middleware that sets the Retry-After header of the response
+// when a gRPC call of the request is rejected by an open breaker. The rejected call fails
+// with codes.Unavailable, which the handlers respond with as 503 Service Unavailable.
+// The rejections are recorded by the calls, which may run concurrently, and the header
+// is set by the handler's goroutine when the response's header is written.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		recorded := &retryAfter{}
+		c.Writer = &retryAfterWriter{ResponseWriter: c.Writer, recorded: recorded}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), retryAfterKey{}, recorded))
+		c.Next()
+	}
+}
+
+// setRetryAfter records the retry-after of the request of ctx if err is an *OpenError.
+func setRetryAfter(ctx context.Context, err error) {
+	openErr, ok := err.(*OpenError)
+	if !ok {
+		return
+	}
+
+	if recorded, ok := ctx.Value(retryAfterKey{}).(*retryAfter); ok {
+		recorded.record(openErr)
+	}
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"sync"
+)
+
+// Cache stores values by key, evicting values to stay within its size bound.
+// Implementations are safe for concurrent use.
+type Cache interface {
+	// Get returns the value of key, ok is false if it isn't cached.
+	Get(key string) (value []byte, ok bool)
+
+	// Set stores value for key, a value that's larger than the cache isn't stored.
+	Set(key string, value []byte)
+
+	// DeletePrefix removes the values of the keys that start with prefix.
+	DeletePrefix(prefix string)
+
+	// MaxValueSize returns the size of the largest value that's stored, larger values aren't stored by Set.
+	MaxValueSize() int64
+}
+
+// Tiered is a Cache of tiers, from the fastest to the slowest.
+type Tiered []Cache
+
+// Get returns the value of key from the fastest tier that has it, and stores it in the faster tiers.
+func (t Tiered) Get(key string) ([]byte, bool) {
+	for i, tier := range t {
+		if value, ok := tier.Get(key); ok {
+			for _, faster := range t[:i] {
+				faster.Set(key, value)
+			}
+
+			return value, true
+		}
+	}
+
+	return nil, false
+}
+
+// Set stores value for key in all of the tiers.
+func (t Tiered) Set(key string, value []byte) {
+	for _, tier := range t {
+		tier.Set(key, value)
+	}
+}
+
+// DeletePrefix removes the values of the keys that start with prefix from all of the tiers.
+func (t Tiered) DeletePrefix(prefix string) {
+	for _, tier := range t {
+		tier.DeletePrefix(prefix)
+	}
+}
+
+// MaxValueSize returns the size of the largest value that's stored in any of the tiers.
+func (t Tiered) MaxValueSize() int64 {
+	maxSize := int64(0)
+	for _, tier := range t {
+		if size := tier.MaxValueSize(); size > maxSize {
+			maxSize = size
+		}
+	}
+
+	return maxSize
+}
+
+// call is a load of a key in a Group, done is closed once value and err are set.
+type call struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// Group deduplicates concurrent loads of the same key. The zero value is ready for use.
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// Do calls load and returns its results, unless a load of key is already in progress,
+// in which case it waits for it and returns its results.
+func (g *Group) Do(key string, load func() ([]byte, error)) ([]byte, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+
+		return c.value, c.err
+	}
+
+	c := &call{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.value, c.err = load()
+	close(c.done)
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	return c.value, c.err
+}
+
+// Load returns the value of key from c, or loads it once with g for the concurrent
+// requests of key and stores it in c. Failed loads aren't cached. If c is nil then
+// the value is loaded without caching it.
+func Load(c Cache, g *Group, key string, load func() ([]byte, error)) ([]byte, error) {
+	if c == nil {
+		return g.Do(key, load)
+	}
+
+	if value, ok := c.Get(key); ok {
+		return value, nil
+	}
+
+	return g.Do(key, func() ([]byte, error) {
+		// The value may have been stored by a load that finished since the first lookup.
+		if value, ok := c.Get(key); ok {
+			return value, nil
+		}
+
+		value, err := load()
+		if err != nil {
+			return nil, err
+		}
+
+		c.Set(key, value)
+
+		return value, nil
+	})
+}
@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testEviction checks that c evicts the least recently used values beyond 10 bytes.
+func testEviction(t *testing.T, c Cache) {
+	c.Set("a", []byte("aaaa"))
+	c.Set("b", []byte("bbbb"))
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+
+	c.Set("c", []byte("cccc"))
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected the least recently used b to be evicted")
+	}
+
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("expected %s to be cached", key)
+		}
+	}
+
+	c.Set("big", []byte("more than ten bytes"))
+	if _, ok := c.Get("big"); ok {
+		t.Error("expected a value larger than the cache not to be cached")
+	}
+
+	c.Set("file/1/a", []byte("1"))
+	c.Set("file/1/b", []byte("2"))
+	c.DeletePrefix("file/1/")
+	if _, ok := c.Get("file/1/a"); ok {
+		t.Error("expected the values of the prefix to be deleted")
+	}
+
+	if value, ok := c.Get("c"); !ok || string(value) != "cccc" {
+		t.Errorf("expected c to be cached as %q, got %q", "cccc", value)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	testEviction(t, NewLRU(10))
+}
+
+func TestDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatalf("failed creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := NewDisk(dir, 10)
+	if err != nil {
+		t.Fatalf("failed creating disk cache: %v", err)
+	}
+
+	testEviction(t, d)
+}
+
+func TestDisk_LoadsExistingValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatalf("failed creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := NewDisk(dir, 10)
+	if err != nil {
+		t.Fatalf("failed creating disk cache: %v", err)
+	}
+
+	d.Set("file/1", []byte("old"))
+	d.Set("file/2", []byte("new"))
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(d.path("file/1"), old, old); err != nil {
+		t.Fatalf("failed setting modification time: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "partial.tmp"), []byte("x"), 0600); err != nil {
+		t.Fatalf("failed writing temporary file: %v", err)
+	}
+
+	d, err = NewDisk(dir, 6)
+	if err != nil {
+		t.Fatalf("failed reopening disk cache: %v", err)
+	}
+
+	if value, ok := d.Get("file/2"); !ok || string(value) != "new" {
+		t.Errorf("expected file/2 to be loaded as %q, got %q", "new", value)
+	}
+
+	d.Set("file/3", []byte("3"))
+	if _, ok := d.Get("file/1"); ok {
+		t.Error("expected the least recently modified value to be evicted first")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "partial.tmp")); !os.IsNotExist(err) {
+		t.Error("expected the temporary file to be removed")
+	}
+}
+
+func TestTiered(t *testing.T) {
+	fast, slow := NewLRU(10), NewLRU(100)
+	tiered := Tiered{fast, slow}
+
+	slow.Set("a", []byte("a"))
+	if _, ok := tiered.Get("a"); !ok {
+		t.Fatal("expected a from the slow tier")
+	}
+
+	if _, ok := fast.Get("a"); !ok {
+		t.Error("expected a to be promoted to the fast tier")
+	}
+
+	tiered.DeletePrefix("a")
+	if _, ok := slow.Get("a"); ok {
+		t.Error("expected a to be deleted from all of the tiers")
+	}
+
+	if got := tiered.MaxValueSize(); got != 100 {
+		t.Errorf("expected the largest value to fit the largest tier, got %d", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	c := NewLRU(100)
+	var g Group
+	var loads int64
+	release := make(chan struct{})
+	load := func() ([]byte, error) {
+		atomic.AddInt64(&loads, 1)
+		<-release
+
+		return []byte("value"), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, err := Load(c, &g, "key", load); err != nil || string(value) != "value" {
+				t.Errorf("expected value, got %q with error %v", value, err)
+			}
+		}()
+	}
+
+	// Let the loads start before releasing them.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if _, err := Load(c, &g, "key", load); err != nil || loads != 1 {
+		t.Errorf("expected a single load, got %d", loads)
+	}
+
+	failed := errors.New("failed")
+	if _, err := Load(c, &g, "failing", func() ([]byte, error) { return nil, failed }); err != failed {
+		t.Errorf("expected the load's error, got %v", err)
+	}
+
+	if _, ok := c.Get("failing"); ok {
+		t.Error("expected a failed load not to be cached")
+	}
+}
@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"container/list"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// diskFileExt is the extension of the files of the values in a Disk cache.
+const diskFileExt = ".cache"
+
+// diskEntry is a value in a Disk cache, an element of its recency list.
+type diskEntry struct {
+	key  string
+	size int64
+}
+
+// Disk is a Cache of at most maxBytes of values in files in a local directory, evicting the least
+// recently used values first. The values in the directory are kept across restarts.
+type Disk struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	recency *list.List
+	entries map[string]*list.Element
+}
+
+// NewDisk creates a Disk cache of at most maxBytes of values in dir, creating dir if it doesn't exist.
+// Values already in dir are loaded, the least recently modified first to be evicted.
+func NewDisk(dir string, maxBytes int64) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+
+	d := &Disk{
+		dir:      dir,
+		maxBytes: maxBytes,
+		recency:  list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+
+	var evicted []string
+	for _, file := range files {
+		// Remove the temporary files of values that weren't completely written.
+		if strings.HasSuffix(file.Name(), ".tmp") {
+			os.Remove(filepath.Join(dir, file.Name()))
+			continue
+		}
+
+		key, err := url.PathUnescape(strings.TrimSuffix(file.Name(), diskFileExt))
+		if file.IsDir() || !strings.HasSuffix(file.Name(), diskFileExt) || err != nil {
+			continue
+		}
+
+		evicted = append(evicted, d.add(key, file.Size())...)
+	}
+
+	d.removeFiles(evicted)
+
+	return d, nil
+}
+
+// path returns the path of the file of the value of key.
+func (d *Disk) path(key string) string {
+	return filepath.Join(d.dir, url.PathEscape(key)+diskFileExt)
+}
+
+// Get returns the value of key and marks it as the most recently used.
+func (d *Disk) Get(key string) ([]byte, bool) {
+	d.mu.Lock()
+	element, ok := d.entries[key]
+	if ok {
+		d.recency.MoveToFront(element)
+	}
+	d.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	value, err := ioutil.ReadFile(d.path(key))
+	if err != nil {
+		// The file was removed, forget it.
+		d.mu.Lock()
+		if element, ok := d.entries[key]; ok {
+			d.remove(element)
+		}
+		d.mu.Unlock()
+
+		return nil, false
+	}
+
+	return value, true
+}
+
+// Set stores value for key as the most recently used, and evicts the least recently used
+// values until the cache is within its size. The value is written to a temporary file
+// that's renamed, so a value is never read partially written.
+func (d *Disk) Set(key string, value []byte) {
+	if int64(len(value)) > d.maxBytes {
+		return
+	}
+
+	tmp, err := ioutil.TempFile(d.dir, "*.tmp")
+	if err != nil {
+		return
+	}
+
+	_, err = tmp.Write(value)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.path(key))
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	d.mu.Lock()
+	evicted := d.add(key, int64(len(value)))
+	d.mu.Unlock()
+
+	d.removeFiles(evicted)
+}
+
+// DeletePrefix removes the values of the keys that start with prefix.
+func (d *Disk) DeletePrefix(prefix string) {
+	var deleted []string
+
+	d.mu.Lock()
+	for key, element := range d.entries {
+		if strings.HasPrefix(key, prefix) {
+			d.remove(element)
+			deleted = append(deleted, key)
+		}
+	}
+	d.mu.Unlock()
+
+	d.removeFiles(deleted)
+}
+
+// MaxValueSize returns the size of the largest value that's stored, which is the size of d.
+func (d *Disk) MaxValueSize() int64 {
+	return d.maxBytes
+}
+
+// Size returns the total bytes of the values in d.
+func (d *Disk) Size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.size
+}
+
+// add indexes the value of key with size as the most recently used, and returns the keys
+// that were evicted to stay within the cache's size, d.mu must be held.
+func (d *Disk) add(key string, size int64) []string {
+	if element, ok := d.entries[key]; ok {
+		d.remove(element)
+	}
+
+	d.entries[key] = d.recency.PushFront(&diskEntry{key: key, size: size})
+	d.size += size
+
+	var evicted []string
+	for d.size > d.maxBytes {
+		entry := d.remove(d.recency.Back())
+		evicted = append(evicted, entry.key)
+	}
+
+	return evicted
+}
+
+// remove removes element from the index of d and returns its entry, d.mu must be held.
+func (d *Disk) remove(element *list.Element) *diskEntry {
+	entry := d.recency.Remove(element).(*diskEntry)
+	delete(d.entries, entry.key)
+	d.size -= entry.size
+
+	return entry
+}
+
+// removeFiles removes the files of the values of keys.
+func (d *Disk) removeFiles(keys []string) {
+	for _, key := range keys {
+		os.Remove(d.path(key))
+	}
+}
@@ -0,0 +1,6 @@
+/*
+Package cache implements size-bounded caches of generated content, such as thumbnails.
+LRU caches in memory and Disk caches in a local directory, a Tiered cache combines them.
+Use Load with a Group to generate a missing value once for all of the concurrent requests of its key.
+*/
+package cache
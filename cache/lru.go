@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// lruEntry is a value in an LRU, an element of its recency list.
+type lruEntry struct {
+	key   string
+	value []byte
+}
+
+// LRU is a Cache in memory of at most maxBytes of values, evicting the least recently used values first.
+type LRU struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	recency *list.List
+	entries map[string]*list.Element
+}
+
+// NewLRU creates an LRU of at most maxBytes of values.
+func NewLRU(maxBytes int64) *LRU {
+	return &LRU{
+		maxBytes: maxBytes,
+		recency:  list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value of key and marks it as the most recently used.
+func (l *LRU) Get(key string) ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	element, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	l.recency.MoveToFront(element)
+
+	return element.Value.(*lruEntry).value, true
+}
+
+// Set stores value for key as the most recently used, and evicts the least recently used
+// values until the LRU is within its size.
+func (l *LRU) Set(key string, value []byte) {
+	if int64(len(value)) > l.maxBytes {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if element, ok := l.entries[key]; ok {
+		l.remove(element)
+	}
+
+	l.entries[key] = l.recency.PushFront(&lruEntry{key: key, value: value})
+	l.size += int64(len(value))
+
+	for l.size > l.maxBytes {
+		l.remove(l.recency.Back())
+	}
+}
+
+// DeletePrefix removes the values of the keys that start with prefix.
+func (l *LRU) DeletePrefix(prefix string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, element := range l.entries {
+		if strings.HasPrefix(key, prefix) {
+			l.remove(element)
+		}
+	}
+}
+
+// MaxValueSize returns the size of the largest value that's stored, which is the size of l.
+func (l *LRU) MaxValueSize() int64 {
+	return l.maxBytes
+}
+
+// Size returns the total bytes of the values in l.
+func (l *LRU) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.size
+}
+
+// remove removes element from l, l.mu must be held.
+func (l *LRU) remove(element *list.Element) {
+	entry := l.recency.Remove(element).(*lruEntry)
+	delete(l.entries, entry.key)
+	l.size -= int64(len(entry.value))
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
 	"github.com/meateam/api-gateway/factory"
 	"github.com/meateam/api-gateway/file"
 	loggermiddleware "github.com/meateam/api-gateway/logger"
@@ -135,7 +136,7 @@ func (r *Router) Setup(rg *gin.RouterGroup) {
 func (r *Router) GetTransfersInfo(c *gin.Context) {
 	reqUser := user.ExtractRequestUser(c)
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 
@@ -146,11 +147,11 @@ func (r *Router) GetTransfersInfo(c *gin.Context) {
 
 	isAllUsers, err := strconv.ParseBool(isGetAll)
 	if isGetAll != "" && err != nil {
-		c.String(http.StatusBadRequest, fmt.Sprintf("please enter a valid value for %s query", QueryGetAll))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("please enter a valid value for %s query", QueryGetAll))
 		return
 	}
 	if isAllUsers && fileID == "" {
-		c.String(http.StatusBadRequest, fmt.Sprintf("please enter a header %s, if all query is true", HeaderFileID))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("please enter a header %s, if all query is true", HeaderFileID))
 		return
 	}
 
@@ -168,7 +169,7 @@ func (r *Router) GetTransfersInfo(c *gin.Context) {
 	transfersResponse, err := r.dropboxClient().GetTransfersInfo(c.Request.Context(), transferRequest)
 	if err != nil && status.Code(err) != codes.Unimplemented {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
@@ -181,30 +182,30 @@ func (r *Router) GetTransfersInfo(c *gin.Context) {
 func (r *Router) CreateExternalShareRequest(c *gin.Context) {
 	reqUser := user.ExtractRequestUser(c)
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 
 	createRequest := &createExternalShareRequest{}
 	if err := c.ShouldBindJSON(createRequest); err != nil {
-		c.AbortWithStatus(http.StatusBadRequest)
+		apierror.AbortWithStatus(c, http.StatusBadRequest)
 		return
 	}
 
 	fileID := c.Param(ParamFileID)
 	if fileID == "" {
-		c.String(http.StatusBadRequest, "%s is a required field", ParamFileID)
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("%s is a required field", ParamFileID))
 		return
 	}
 
 	if createRequest.Destination != viper.GetString(ConfigCtsDest) && createRequest.Destination != viper.GetString(ConfigTomcalDest) {
-		c.String(http.StatusBadRequest, fmt.Sprintf("destination %s doesnt supported", createRequest.Destination))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("destination %s doesnt supported", createRequest.Destination))
 		return
 	}
 
 	permission, err := permission.IsPermitted(c, r.permissionClient(), fileID, reqUser.ID, permission.GetFilePermissionsRole)
 	if err != nil || !permission.GetPermitted() {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 
@@ -230,7 +231,7 @@ func (r *Router) CreateExternalShareRequest(c *gin.Context) {
 	})
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return
 	}
 
@@ -242,29 +243,29 @@ func (r *Router) CreateExternalShareRequest(c *gin.Context) {
 func (r *Router) CanApproveToUser(c *gin.Context) {
 	reqUser := user.ExtractRequestUser(c)
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 
 	userID := c.Param(ParamUserID)
 	if userID == "" {
-		c.String(http.StatusBadRequest, fmt.Sprintf("%s field is required", ParamUserID))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("%s field is required", ParamUserID))
 		return
 	}
 
 	approverID := c.Param(ParamApproverID)
 	if approverID == "" {
-		c.String(http.StatusBadRequest, fmt.Sprintf("%s field is required", ParamApproverID))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("%s field is required", ParamApproverID))
 		return
 	}
 
 	destination := c.GetHeader(HeaderDestionation)
 	if destination == "" {
-		c.String(http.StatusBadRequest, fmt.Sprintf("%s header is required", HeaderDestionation))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("%s header is required", HeaderDestionation))
 		return
 	}
 	if destination != viper.GetString(ConfigCtsDest) && destination != viper.GetString(ConfigTomcalDest) {
-		c.String(http.StatusBadRequest, fmt.Sprintf("destination %s doesnt supported", destination))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("destination %s doesnt supported", destination))
 		return
 	}
 
@@ -278,7 +279,7 @@ func (r *Router) CanApproveToUser(c *gin.Context) {
 
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return
 	}
 
@@ -289,22 +290,22 @@ func (r *Router) CanApproveToUser(c *gin.Context) {
 func (r *Router) GetApproverInfo(c *gin.Context) {
 	reqUser := user.ExtractRequestUser(c)
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 	userID := c.Param(ParamUserID)
 	if userID == "" {
-		c.String(http.StatusBadRequest, fmt.Sprintf("%s field is required", ParamUserID))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("%s field is required", ParamUserID))
 		return
 	}
 
 	destination := c.GetHeader(HeaderDestionation)
 	if destination == "" {
-		c.String(http.StatusBadRequest, fmt.Sprintf("%s header is required", HeaderDestionation))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("%s header is required", HeaderDestionation))
 		return
 	}
 	if destination != viper.GetString(ConfigCtsDest) && destination != viper.GetString(ConfigTomcalDest) {
-		c.String(http.StatusBadRequest, fmt.Sprintf("destination %s doesnt supported", destination))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("destination %s doesnt supported", destination))
 		return
 	}
 
@@ -317,7 +318,7 @@ func (r *Router) GetApproverInfo(c *gin.Context) {
 
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return
 	}
 
@@ -335,7 +336,7 @@ func (r *Router) HandleUserFilePermission(
 	reqUser := user.ExtractRequestUser(c)
 
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 
 		return "", nil
 	}
@@ -349,13 +350,13 @@ func (r *Router) HandleUserFilePermission(
 
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return "", nil
 	}
 
 	if userStringRole == "" {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 	}
 
 	return userStringRole, foundPermission
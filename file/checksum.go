@@ -0,0 +1,191 @@
+package file
+
+import (
+	"context"
+	"crypto/md5" // nolint: gosec
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	loggermiddleware "github.com/meateam/api-gateway/logger"
+)
+
+const (
+	// DigestHeader is the header of the digests of a file's content, as in RFC 3230.
+	DigestHeader = "Digest"
+
+	// ContentMD5Header is the header of the base64 encoded MD5 of an uploaded content, as in RFC 1864.
+	ContentMD5Header = "Content-MD5"
+
+	// DigestAlgorithmMD5 is the name of the MD5 digest algorithm in the Digest header.
+	DigestAlgorithmMD5 = "MD5"
+
+	// DigestAlgorithmSHA256 is the name of the SHA-256 digest algorithm in the Digest header.
+	DigestAlgorithmSHA256 = "SHA-256"
+
+	// checksumNotFoundMessage is the message of ErrChecksumNotFound.
+	checksumNotFoundMessage = "checksum not found"
+)
+
+// ErrChecksumNotFound is returned by a ChecksumStore when the checksum of a key doesn't exist.
+var ErrChecksumNotFound = errors.New(checksumNotFoundMessage)
+
+// Checksum is the base64 encoded digests of the content of the object of a file with Key.
+// Keys aren't reused, so the checksum of a key is never changed.
+type Checksum struct {
+	Key       string    `json:"key"`
+	MD5       string    `json:"md5,omitempty"`
+	SHA256    string    `json:"sha256,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ChecksumStore stores the checksums of the contents of the files by their object keys.
+// Checksums are kept beside file service rather than in the files' metadata, since its files have no field
+// for them, and since keying them by object follows the contents of the files through versions and copies.
+type ChecksumStore interface {
+	// Set sets the checksum of checksum.Key.
+	Set(ctx context.Context, checksum *Checksum) error
+
+	// Get returns the checksum of key, or ErrChecksumNotFound if it doesn't exist.
+	Get(ctx context.Context, key string) (*Checksum, error)
+}
+
+// Digest returns the value of the Digest header of the digests of c.
+func (c *Checksum) Digest() string {
+	digests := make([]string, 0, 2)
+	if c.MD5 != "" {
+		digests = append(digests, DigestAlgorithmMD5+"="+c.MD5)
+	}
+
+	if c.SHA256 != "" {
+		digests = append(digests, DigestAlgorithmSHA256+"="+c.SHA256)
+	}
+
+	return strings.Join(digests, ",")
+}
+
+// Verify returns a non-nil error if any digest of c differs from the digest of actual.
+// Digests that are missing from c aren't verified.
+func (c *Checksum) Verify(actual *Checksum) error {
+	if c.MD5 != "" && c.MD5 != actual.MD5 {
+		return fmt.Errorf("content doesn't match its %s digest", DigestAlgorithmMD5)
+	}
+
+	if c.SHA256 != "" && c.SHA256 != actual.SHA256 {
+		return fmt.Errorf("content doesn't match its %s digest", DigestAlgorithmSHA256)
+	}
+
+	return nil
+}
+
+// ParseChecksum returns the checksum of the Content-MD5 header and the MD5 and SHA-256 digests
+// of the Digest header, or nil if there's none. The other digest algorithms are ignored.
+// Returns a non-nil error if the headers are malformed or their MD5 digests differ.
+func ParseChecksum(header http.Header) (*Checksum, error) {
+	checksum := &Checksum{}
+	if contentMD5 := header.Get(ContentMD5Header); contentMD5 != "" {
+		if !isDigest(contentMD5, md5.Size) {
+			return nil, fmt.Errorf("%s is invalid", ContentMD5Header)
+		}
+
+		checksum.MD5 = contentMD5
+	}
+
+	for _, digest := range strings.Split(header.Get(DigestHeader), ",") {
+		digest = strings.TrimSpace(digest)
+		if digest == "" {
+			continue
+		}
+
+		equals := strings.Index(digest, "=")
+		if equals <= 0 {
+			return nil, fmt.Errorf("%s is invalid", DigestHeader)
+		}
+
+		algorithm, value := digest[:equals], digest[equals+1:]
+		switch {
+		case strings.EqualFold(algorithm, DigestAlgorithmMD5):
+			if !isDigest(value, md5.Size) || (checksum.MD5 != "" && checksum.MD5 != value) {
+				return nil, fmt.Errorf("%s %s is invalid", DigestHeader, DigestAlgorithmMD5)
+			}
+
+			checksum.MD5 = value
+		case strings.EqualFold(algorithm, DigestAlgorithmSHA256):
+			if !isDigest(value, sha256.Size) {
+				return nil, fmt.Errorf("%s %s is invalid", DigestHeader, DigestAlgorithmSHA256)
+			}
+
+			checksum.SHA256 = value
+		}
+	}
+
+	if checksum.MD5 == "" && checksum.SHA256 == "" {
+		return nil, nil
+	}
+
+	return checksum, nil
+}
+
+// isDigest reports whether value is a base64 encoded digest of size bytes.
+func isDigest(value string, size int) bool {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+
+	return err == nil && len(decoded) == size
+}
+
+// Hasher is an io.Writer that computes the checksum of the content written to it.
+type Hasher struct {
+	md5    hash.Hash
+	sha256 hash.Hash
+	writer io.Writer
+}
+
+// NewHasher creates a Hasher of the MD5 and SHA-256 digests.
+func NewHasher() *Hasher {
+	h := &Hasher{md5: md5.New(), sha256: sha256.New()} // nolint: gosec
+	h.writer = io.MultiWriter(h.md5, h.sha256)
+
+	return h
+}
+
+// Write adds p to the content of h.
+func (h *Hasher) Write(p []byte) (int, error) {
+	return h.writer.Write(p)
+}
+
+// Checksum returns the checksum of key of the content written to h.
+func (h *Hasher) Checksum(key string) *Checksum {
+	return &Checksum{
+		Key:       key,
+		MD5:       base64.StdEncoding.EncodeToString(h.md5.Sum(nil)),
+		SHA256:    base64.StdEncoding.EncodeToString(h.sha256.Sum(nil)),
+		CreatedAt: time.Now(),
+	}
+}
+
+// setDigestHeader sets the Digest header of the response of c to the checksum of key,
+// if it's known. Files that were uploaded before their checksums were kept have none.
+func (r *Router) setDigestHeader(c *gin.Context, key string) {
+	if r.checksums == nil {
+		return
+	}
+
+	checksum, err := r.checksums.Get(c.Request.Context(), key)
+	if err == ErrChecksumNotFound {
+		return
+	}
+
+	if err != nil {
+		loggermiddleware.LogError(r.logger, fmt.Errorf("failed getting checksum of %s: %v", key, err))
+		return
+	}
+
+	c.Header(DigestHeader, checksum.Digest())
+}
@@ -0,0 +1,99 @@
+package file
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const (
+	// helloMD5 and helloSHA256 are the base64 encoded digests of "hello".
+	helloMD5    = "XUFAKrxLKna5cZ2REBfFkg=="
+	helloSHA256 = "LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="
+)
+
+// checksumHeader returns the header of the Content-MD5 and Digest values.
+func checksumHeader(contentMD5 string, digest string) http.Header {
+	header := http.Header{}
+	if contentMD5 != "" {
+		header.Set(ContentMD5Header, contentMD5)
+	}
+
+	if digest != "" {
+		header.Set(DigestHeader, digest)
+	}
+
+	return header
+}
+
+func TestParseChecksum(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  http.Header
+		want    *Checksum
+		wantErr bool
+	}{
+		{name: "none", header: checksumHeader("", "")},
+		{
+			name:   "content md5",
+			header: checksumHeader(helloMD5, ""),
+			want:   &Checksum{MD5: helloMD5},
+		},
+		{
+			name:   "digests",
+			header: checksumHeader("", "sha-256="+helloSHA256+", md5="+helloMD5+", unixsum=30637"),
+			want:   &Checksum{MD5: helloMD5, SHA256: helloSHA256},
+		},
+		{
+			name:   "content md5 and digest",
+			header: checksumHeader(helloMD5, "SHA-256="+helloSHA256),
+			want:   &Checksum{MD5: helloMD5, SHA256: helloSHA256},
+		},
+		{name: "invalid content md5", header: checksumHeader("hello", ""), wantErr: true},
+		{name: "sha-256 of md5 size", header: checksumHeader("", "SHA-256="+helloMD5), wantErr: true},
+		{name: "digest without value", header: checksumHeader("", "SHA-256"), wantErr: true},
+		{
+			name:    "conflicting md5",
+			header:  checksumHeader(helloMD5, "MD5=1B2M2Y8AsgTpgAmY7PhCfg=="),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseChecksum(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseChecksum() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if (tt.want == nil) != (got == nil) || (tt.want != nil && *got != *tt.want) {
+				t.Errorf("ParseChecksum() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasher_Checksum(t *testing.T) {
+	hasher := NewHasher()
+	if _, err := io.Copy(hasher, strings.NewReader("hello")); err != nil {
+		t.Fatalf("failed hashing content: %v", err)
+	}
+
+	checksum := hasher.Checksum("key")
+	if checksum.Key != "key" || checksum.MD5 != helloMD5 || checksum.SHA256 != helloSHA256 {
+		t.Fatalf("Checksum() = %+v, want the digests of hello", checksum)
+	}
+
+	if digest := checksum.Digest(); digest != "MD5="+helloMD5+",SHA-256="+helloSHA256 {
+		t.Errorf("Digest() = %s", digest)
+	}
+
+	if err := (&Checksum{SHA256: helloSHA256}).Verify(checksum); err != nil {
+		t.Errorf("expected a matching digest to be verified, got %v", err)
+	}
+
+	if err := (&Checksum{MD5: "1B2M2Y8AsgTpgAmY7PhCfg=="}).Verify(checksum); err == nil {
+		t.Error("expected a mismatching digest not to be verified")
+	}
+}
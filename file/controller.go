@@ -5,8 +5,6 @@ import (
 	"fmt"
 	"sync"
 
-	"github.com/gin-gonic/gin"
-	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
 	loggermiddleware "github.com/meateam/api-gateway/logger"
 	fpb "github.com/meateam/file-service/proto/file"
 	ppb "github.com/meateam/permission-service/proto"
@@ -17,24 +15,27 @@ import (
 	"google.golang.org/grpc/status"
 )
 
-// deleteFileAndPremission deletes the file and the permissions to it from db
-func deleteFileAndPremission(ctx *gin.Context,
+// deleteFileAndPremission deletes the file and the permissions to it from db.
+// If the file couldn't be deleted then its permissions are recreated.
+// The cached permissions of the users whose permissions were deleted are invalidated in cache.
+func deleteFileAndPremission(ctx context.Context,
 	logger *logrus.Logger,
 	fileClient fpb.FileServiceClient,
 	permissionClient ppb.PermissionClient,
-	fileID string) *fpb.File {
+	cache *PermissionCache,
+	fileID string) (*fpb.File, error) {
 	filePermissions, err := permissionClient.GetFilePermissions(ctx, &ppb.GetFilePermissionsRequest{FileID: fileID})
 	if err != nil {
-		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(logger, ctx.AbortWithError(httpStatusCode, fmt.Errorf("failed to get file's  %s permission to delete: %v", fileID, err)))
-		return nil
+		return nil, status.Errorf(status.Code(err), "failed to get file's %s permission to delete: %v", fileID, err)
 	}
 
 	// Delete file's permissions
 	if _, err := permissionClient.DeleteFilePermissions(ctx, &ppb.DeleteFilePermissionsRequest{FileID: fileID}); err != nil {
-		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(logger, ctx.AbortWithError(httpStatusCode, fmt.Errorf("failed deleting file's %s permissions: %v", fileID, err)))
-		return nil
+		return nil, status.Errorf(status.Code(err), "failed deleting file's %s permissions: %v", fileID, err)
+	}
+
+	for _, permission := range filePermissions.GetPermissions() {
+		cache.InvalidateUser(permission.GetUserID())
 	}
 
 	// Delete file from db
@@ -42,41 +43,36 @@ func deleteFileAndPremission(ctx *gin.Context,
 	if err != nil || deletedFile == nil {
 		if status.Code(err) != codes.NotFound {
 			// Add permission rollback
-			AddPermissionsOnError(ctx, err, fileID, filePermissions.GetPermissions(), permissionClient, logger)
-		} 
-
-		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))		
-		loggermiddleware.LogError(logger, ctx.AbortWithError(httpStatusCode, fmt.Errorf("failed deleting file: %v", err)))
-		return nil
-	} 
-	
-	return deletedFile.GetFile()
+			AddPermissionsOnError(ctx, fileID, filePermissions.GetPermissions(), permissionClient, cache, logger)
+		}
+
+		return nil, status.Errorf(status.Code(err), "failed deleting file %s: %v", fileID, err)
+	}
+
+	return deletedFile.GetFile(), nil
 }
 
 // DeleteFile deletes fileID from file service and upload service, returns a slice of IDs of the files
 // that were deleted if there were any files that are descendants of fileID and any error if occurred.
+// The cached permissions of the users whose permissions were deleted are invalidated in cache, which may be nil.
 // nolint: gocyclo
-func DeleteFile(ctx *gin.Context,
+func DeleteFile(ctx context.Context,
 	logger *logrus.Logger,
 	fileClient fpb.FileServiceClient,
 	uploadClient upb.UploadClient,
 	searchClient spb.SearchClient,
 	permissionClient ppb.PermissionClient,
+	cache *PermissionCache,
 	fileID string,
 	userID string) ([]string, error) {
 	file, err := fileClient.GetFileByID(ctx, &fpb.GetByFileByIDRequest{Id: fileID})
 	if err != nil {
-		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(logger, ctx.AbortWithError(httpStatusCode, fmt.Errorf("failed getting file to delete: %v", err)))
-		return nil, err
+		return nil, status.Errorf(status.Code(err), "failed getting file to delete: %v", err)
 	}
 
 	getDescendantsByIDRes, err := fileClient.GetDescendantsByID(ctx, &fpb.GetDescendantsByIDRequest{Id: fileID})
 	if err != nil {
-		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		errFmt := fmt.Errorf("failed getting file's descendants to delete: %v", err)
-		loggermiddleware.LogError(logger, ctx.AbortWithError(httpStatusCode, errFmt))
-		return nil, errFmt
+		return nil, status.Errorf(status.Code(err), "failed getting file's descendants to delete: %v", err)
 	}
 
 	descendants := getDescendantsByIDRes.GetDescendants()
@@ -86,21 +82,23 @@ func DeleteFile(ctx *gin.Context,
 	// Deleting the file and permissions from db
 	// Only the owner of the file can delete the file instance.
 	// If the user requesting to delete isn't the owner- delete it's permission to this file
+	var failedFiles []string
 	if file.GetOwnerID() == userID {
-		deletedFile := deleteFileAndPremission(ctx, logger, fileClient, permissionClient, fileID)
-		if deletedFile != nil {
+		deletedFile, err := deleteFileAndPremission(ctx, logger, fileClient, permissionClient, cache, fileID)
+		if err != nil {
+			loggermiddleware.LogError(logger, err)
+			failedFiles = append(failedFiles, fileID)
+		} else {
 			deletedFiles = append(deletedFiles, deletedFile)
 		}
-
 	} else {
 		if _, err := permissionClient.DeletePermission(
 			ctx,
 			&ppb.DeletePermissionRequest{FileID: fileID, UserID: userID}); err != nil {
-			httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-			errFmt := fmt.Errorf("failed getting file's descendants to delete: %v", err)
-			loggermiddleware.LogError(logger, ctx.AbortWithError(httpStatusCode, errFmt))
-			return nil, errFmt
+			return nil, status.Errorf(status.Code(err), "failed deleting user's permission to file: %v", err)
 		}
+
+		cache.InvalidateUser(userID)
 	}
 
 	// Delete file's descendants
@@ -109,11 +107,14 @@ func DeleteFile(ctx *gin.Context,
 		parent := descendants[i].GetParent()
 
 		if file.GetOwnerID() == userID {
-			deletedFile := deleteFileAndPremission(ctx, logger, fileClient, permissionClient, file.GetId())
-			if deletedFile != nil {
+			deletedFile, err := deleteFileAndPremission(
+				ctx, logger, fileClient, permissionClient, cache, file.GetId())
+			if err != nil {
+				loggermiddleware.LogError(logger, err)
+				failedFiles = append(failedFiles, file.GetId())
+			} else {
 				deletedFiles = append(deletedFiles, deletedFile)
 			}
-
 		} else if parent == nil || parent.GetOwnerID() == userID {
 			floatFiles = append(floatFiles, file.GetId())
 		}
@@ -172,8 +173,8 @@ func DeleteFile(ctx *gin.Context,
 		}(bucket, keys)
 	}
 
-	if len(ids) < len(deletedFiles) {
-		return nil, fmt.Errorf("failed deleting files")
+	if len(failedFiles) > 0 {
+		return ids, fmt.Errorf("failed deleting files: %v", failedFiles)
 	}
 
 	return ids, nil
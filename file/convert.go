@@ -0,0 +1,272 @@
+package file
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	fpb "github.com/meateam/file-service/proto/file"
+	"github.com/meateam/gotenberg-go-client/v6"
+)
+
+const (
+	// HTMLMimeType is the mime type of a .html file.
+	HTMLMimeType = "text/html"
+
+	// MarkdownMimeType is the mime type of a .md file.
+	MarkdownMimeType = "text/markdown"
+
+	// XMarkdownMimeType is the legacy mime type of a .md file.
+	XMarkdownMimeType = "text/x-markdown"
+
+	// CsvMimeType is the mime type of a .csv file.
+	CsvMimeType = "text/csv"
+
+	// TsvMimeType is the mime type of a .tsv file.
+	TsvMimeType = "text/tab-separated-values"
+
+	// BmpMimeType is the mime type of a .bmp file.
+	BmpMimeType = "image/bmp"
+
+	// WebpMimeType is the mime type of a .webp file.
+	WebpMimeType = "image/webp"
+
+	// SvgMimeType is the mime type of a .svg file.
+	SvgMimeType = "image/svg+xml"
+
+	// maxPreviewTableRows is the maximal number of rows of a CSV or TSV file that are previewed.
+	maxPreviewTableRows = 10000
+
+	// previewHead is the head of the HTML documents that are converted to PDF. Its content security
+	// policy keeps gotenberg from loading any resource that isn't inline, so previewed documents
+	// can't make it request external or internal URLs.
+	previewHead = `<meta charset="utf-8">` +
+		`<meta http-equiv="Content-Security-Policy" ` +
+		`content="default-src 'none'; style-src 'unsafe-inline'; img-src data:">`
+
+	// markdownIndex is the index of gotenberg's markdown conversion, rendering markdownFilename.
+	markdownIndex = `<!doctype html><html><head>` + previewHead + `</head>` +
+		`<body>{{ toHTML "` + markdownFilename + `" }}</body></html>`
+
+	// markdownFilename is the name of the markdown document in gotenberg's markdown conversion.
+	markdownFilename = "document.md"
+
+	// htmlIndexFilename is the name of the index document of gotenberg's HTML and markdown conversions.
+	htmlIndexFilename = "index.html"
+)
+
+var (
+	// tableTemplate renders the rows of a CSV or TSV file as an HTML table, the first row as its header.
+	tableTemplate = template.Must(template.New("table").Parse(`<!doctype html>
+<html>
+<head>{{.Head}}<title>{{.Title}}</title>
+<style>
+table { border-collapse: collapse; font-family: sans-serif; font-size: 10px; }
+th, td { border: 1px solid #999; padding: 2px 4px; text-align: left; }
+th { background: #eee; }
+</style>
+</head>
+<body>
+<table>
+{{range $i, $row := .Rows}}<tr>{{range $row}}{{if eq $i 0}}<th>{{.}}</th>{{else}}<td>{{.}}</td>{{end}}{{end}}</tr>
+{{end}}</table>
+{{if .Truncated}}<p>Only the first {{len .Rows}} rows are shown.</p>{{end}}
+</body>
+</html>`))
+
+	// imageTemplate renders an image that fits in the page.
+	imageTemplate = template.Must(template.New("image").Parse(`<!doctype html>
+<html>
+<head>{{.Head}}<title>{{.Title}}</title>
+<style>
+html, body { margin: 0; }
+img { display: block; margin: auto; max-width: 100%; max-height: 100vh; }
+</style>
+</head>
+<body><img src="{{.Src}}" alt="{{.Title}}"></body>
+</html>`))
+)
+
+// Converter creates the gotenberg request that converts file with content to PDF.
+// The files of the request may be written to dir, which is removed after the conversion.
+type Converter func(dir string, file *fpb.File, content io.Reader) (gotenberg.Request, error)
+
+// ConverterRegistry maps the mime types of files to the Converters that convert them to PDF previews.
+// It's safe for concurrent use.
+type ConverterRegistry struct {
+	mu         sync.RWMutex
+	converters map[string]Converter
+}
+
+// NewConverterRegistry creates an empty ConverterRegistry.
+func NewConverterRegistry() *ConverterRegistry {
+	return &ConverterRegistry{converters: make(map[string]Converter)}
+}
+
+// Register registers converter as the Converter of files of mimeTypes, replacing their previous Converter.
+func (r *ConverterRegistry) Register(converter Converter, mimeTypes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, mimeType := range mimeTypes {
+		r.converters[mediaType(mimeType)] = converter
+	}
+}
+
+// Lookup returns the Converter of files of mimeType, ok is false if they can't be converted.
+func (r *ConverterRegistry) Lookup(mimeType string) (converter Converter, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	converter, ok = r.converters[mediaType(mimeType)]
+
+	return converter, ok
+}
+
+// Converters is the registry of the Converters of the files that can be previewed as PDF.
+var Converters = NewConverterRegistry()
+
+func init() {
+	Converters.Register(ConvertOffice, TypesConvertableToPdf...)
+	Converters.Register(ConvertHTML, HTMLMimeType)
+	Converters.Register(ConvertMarkdown, MarkdownMimeType, XMarkdownMimeType)
+	Converters.Register(ConvertTable(','), CsvMimeType)
+	Converters.Register(ConvertTable('\t'), TsvMimeType)
+	Converters.Register(ConvertImage, PngMimeType, JpegMimeType, GifMimeType, BmpMimeType, WebpMimeType, SvgMimeType)
+}
+
+// mediaType returns mimeType without its parameters, in lower case.
+func mediaType(mimeType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+}
+
+// ConvertOffice converts an office document with gotenberg's office endpoint.
+func ConvertOffice(_ string, file *fpb.File, content io.Reader) (gotenberg.Request, error) {
+	convertRequest, err := gotenberg.NewOfficeRequest(file.GetName(), content)
+	if err != nil {
+		return nil, err
+	}
+
+	convertRequest.ResultFilename(file.GetName())
+
+	return convertRequest, nil
+}
+
+// ConvertHTML converts an HTML document with gotenberg's HTML endpoint, without loading its resources.
+func ConvertHTML(dir string, _ *fpb.File, content io.Reader) (gotenberg.Request, error) {
+	index, err := writeFile(dir, htmlIndexFilename, io.MultiReader(strings.NewReader(previewHead), content))
+	if err != nil {
+		return nil, err
+	}
+
+	return gotenberg.NewHTMLRequest(index)
+}
+
+// ConvertMarkdown converts a markdown document with gotenberg's markdown endpoint.
+func ConvertMarkdown(dir string, _ *fpb.File, content io.Reader) (gotenberg.Request, error) {
+	markdown, err := writeFile(dir, markdownFilename, content)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := writeFile(dir, htmlIndexFilename, strings.NewReader(markdownIndex))
+	if err != nil {
+		return nil, err
+	}
+
+	return gotenberg.NewMarkdownRequest(index, markdown)
+}
+
+// ConvertTable returns a Converter of delimited files, such as CSV and TSV, whose fields are separated
+// by comma. The rows are rendered as an HTML table, up to maxPreviewTableRows rows.
+func ConvertTable(comma rune) Converter {
+	return func(dir string, file *fpb.File, content io.Reader) (gotenberg.Request, error) {
+		reader := csv.NewReader(content)
+		reader.Comma = comma
+		reader.LazyQuotes = true
+		reader.FieldsPerRecord = -1
+
+		var rows [][]string
+		truncated := false
+		for {
+			row, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			if len(rows) == maxPreviewTableRows {
+				truncated = true
+				break
+			}
+
+			rows = append(rows, row)
+		}
+
+		return renderHTML(dir, tableTemplate, map[string]interface{}{
+			"Head":      template.HTML(previewHead),
+			"Title":     file.GetName(),
+			"Rows":      rows,
+			"Truncated": truncated,
+		})
+	}
+}
+
+// ConvertImage converts an image to a PDF of a page that fits the image, with gotenberg's HTML endpoint.
+func ConvertImage(dir string, file *fpb.File, content io.Reader) (gotenberg.Request, error) {
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return renderHTML(dir, imageTemplate, map[string]interface{}{
+		"Head":  template.HTML(previewHead),
+		"Title": file.GetName(),
+		// The image is inlined since the content security policy only allows data urls.
+		"Src": template.URL("data:" + mediaType(file.GetType()) + ";base64," + base64.StdEncoding.EncodeToString(data)),
+	})
+}
+
+// renderHTML renders t with data to the index of an HTML conversion in dir.
+func renderHTML(dir string, t *template.Template, data interface{}) (gotenberg.Request, error) {
+	index, err := os.Create(filepath.Join(dir, htmlIndexFilename))
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.Execute(index, data)
+	if closeErr := index.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gotenberg.NewHTMLRequest(index.Name())
+}
+
+// writeFile writes content to the file name in dir and returns its path.
+func writeFile(dir string, name string, content io.Reader) (string, error) {
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(f, content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return path, err
+}
@@ -0,0 +1,157 @@
+package file
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/meateam/api-gateway/cache"
+	dpb "github.com/meateam/download-service/proto"
+	fpb "github.com/meateam/file-service/proto/file"
+	"github.com/meateam/gotenberg-go-client/v6"
+)
+
+func TestConverterRegistry(t *testing.T) {
+	for _, mimeType := range []string{DocxMimeType, "text/CSV; charset=utf-8", MarkdownMimeType, JpegMimeType} {
+		if !IsFileConvertableToPdf(mimeType) {
+			t.Errorf("expected %s to be convertable", mimeType)
+		}
+	}
+
+	for _, mimeType := range []string{PdfMimeType, "text/plain", FolderMimeType} {
+		if IsFileConvertableToPdf(mimeType) {
+			t.Errorf("expected %s not to be convertable", mimeType)
+		}
+	}
+
+	registry := NewConverterRegistry()
+	registry.Register(ConvertHTML, "Application/XHTML+xml")
+	if _, ok := registry.Lookup("application/xhtml+xml; charset=utf-8"); !ok {
+		t.Error("expected a registered converter to be looked up by its media type")
+	}
+}
+
+// convert converts file with content by converter in a temporary directory,
+// and returns the contents of the files that converter wrote by their names.
+func convert(t *testing.T, converter Converter, file *fpb.File, content string) map[string]string {
+	dir, err := ioutil.TempDir("", "preview")
+	if err != nil {
+		t.Fatalf("failed creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := converter(dir, file, strings.NewReader(content)); err != nil {
+		t.Fatalf("failed converting %s: %v", file.GetName(), err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatalf("failed listing files: %v", err)
+	}
+
+	files := make(map[string]string)
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed reading %s: %v", path, err)
+		}
+
+		files[filepath.Base(path)] = string(content)
+	}
+
+	return files
+}
+
+func TestConvertHTML(t *testing.T) {
+	files := convert(t, ConvertHTML, &fpb.File{Name: "a.html"}, `<img src="http://internal/">`)
+
+	if index := files[htmlIndexFilename]; !strings.HasPrefix(index, previewHead) ||
+		!strings.HasSuffix(index, `<img src="http://internal/">`) {
+		t.Errorf("expected the document after the content security policy, got %q", index)
+	}
+}
+
+func TestConvertMarkdown(t *testing.T) {
+	files := convert(t, ConvertMarkdown, &fpb.File{Name: "a.md"}, "# Title")
+
+	if files[markdownFilename] != "# Title" {
+		t.Errorf("expected the markdown document, got %q", files[markdownFilename])
+	}
+
+	if index := files[htmlIndexFilename]; !strings.Contains(index, `{{ toHTML "`+markdownFilename+`" }}`) ||
+		!strings.Contains(index, previewHead) {
+		t.Errorf("expected the index to render the markdown document, got %q", index)
+	}
+}
+
+func TestConvertTable(t *testing.T) {
+	files := convert(t, ConvertTable('\t'), &fpb.File{Name: "a.tsv"}, "name\tsize\n<b>a</b>\t1\nshort\n")
+	index := files[htmlIndexFilename]
+
+	for _, want := range []string{
+		previewHead,
+		"<th>name</th><th>size</th>",
+		"<td>&lt;b&gt;a&lt;/b&gt;</td>",
+		"<td>short</td>",
+	} {
+		if !strings.Contains(index, want) {
+			t.Errorf("expected the table to contain %q, got %q", want, index)
+		}
+	}
+
+	rows := strings.Repeat("a,b\n", maxPreviewTableRows+1)
+	index = convert(t, ConvertTable(','), &fpb.File{Name: "a.csv"}, rows)[htmlIndexFilename]
+	if strings.Count(index, "<tr>") != maxPreviewTableRows || !strings.Contains(index, "Only the first") {
+		t.Errorf("expected the table to be truncated to %d rows", maxPreviewTableRows)
+	}
+}
+
+func TestConvertImage(t *testing.T) {
+	files := convert(t, ConvertImage, &fpb.File{Name: "a.png", Type: PngMimeType}, "image")
+
+	if index := files[htmlIndexFilename]; !strings.Contains(index, `src="data:image/png;base64,aW1hZ2U="`) {
+		t.Errorf("expected the image to be inlined, got %q", index)
+	}
+}
+
+func TestRouter_ConvertedPreviewFormats(t *testing.T) {
+	var conversions int64
+	gotenbergServer := newFakeGotenberg(t, &conversions)
+	defer gotenbergServer.Close()
+
+	r := &Router{
+		previewCache:    cache.NewLRU(1 << 20),
+		gotenbergClient: &gotenberg.Client{Hostname: gotenbergServer.URL},
+	}
+	r.downloadClient = func() dpb.DownloadClient {
+		return &fakeDownloadClient{contents: map[string]string{"csv": "name,size\na,1\n", "md": "# Title"}}
+	}
+
+	tests := []struct {
+		file *fpb.File
+		want string
+	}{
+		{file: &fpb.File{Id: "csv", Key: "csv", Name: "a.csv", Type: CsvMimeType}, want: "<td>a</td><td>1</td>"},
+		{file: &fpb.File{Id: "md", Key: "md", Name: "a.md", Type: MarkdownMimeType}, want: "PDF of # Title"},
+	}
+
+	for _, tt := range tests {
+		if pdf, err := r.convertedPreview(tt.file); err != nil || !strings.Contains(string(pdf), tt.want) {
+			t.Errorf("%s: expected the converted document to contain %q, got %q with error %v",
+				tt.file.GetName(), tt.want, pdf, err)
+		}
+	}
+
+	text := &fpb.File{Id: "txt", Key: "txt", Name: "a.txt", Type: "text/plain"}
+	if _, err := r.convertedPreview(text); previewErrorStatus(err) != http.StatusUnsupportedMediaType {
+		t.Errorf("expected a file without a converter to be unsupported, got %v", err)
+	}
+
+	if atomic.LoadInt64(&conversions) != 2 {
+		t.Errorf("expected 2 conversions, got %d", conversions)
+	}
+}
@@ -10,12 +10,14 @@ import (
 
 	"github.com/gin-gonic/gin"
 	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
+	"github.com/meateam/api-gateway/cache"
 	"github.com/meateam/api-gateway/factory"
 	loggermiddleware "github.com/meateam/api-gateway/logger"
+	"github.com/meateam/api-gateway/metrics"
 	oauth "github.com/meateam/api-gateway/oauth"
 	"github.com/meateam/api-gateway/user"
 	"github.com/meateam/api-gateway/utils"
-	"github.com/meateam/download-service/download"
 	dpb "github.com/meateam/download-service/proto"
 	drp "github.com/meateam/dropbox-service/proto/dropbox"
 	fpb "github.com/meateam/file-service/proto/file"
@@ -25,6 +27,7 @@ import (
 	spb "github.com/meateam/search-service/proto"
 	upb "github.com/meateam/upload-service/proto"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -98,6 +101,9 @@ const (
 	// permitted to make the UpdateFiles action.
 	UpdateFilesRole = ppb.Role_WRITE
 
+	// FolderMimeType is the custom mime type of a folder.
+	FolderMimeType = "application/vnd.drive.folder"
+
 	// PdfMimeType is the mime type of a .pdf file.
 	PdfMimeType = "application/pdf"
 
@@ -133,10 +139,17 @@ const (
 
 	// fileIDIsRequiredMessage is the error message for missing fileID
 	fileIDIsRequiredMessage = "fileID is required"
+
+	// fileIsTrashedMessage is the error message for a file that's in the trash
+	fileIsTrashedMessage = "file is in the trash"
+
+	// fileCannotBePreviewedMessage is the error message for a file whose type can't be previewed
+	fileCannotBePreviewedMessage = "file type cannot be previewed"
 )
 
 var (
-	// TypesConvertableToPdf is a slice of the names of the mime types that can be converted to PDF and previewed.
+	// TypesConvertableToPdf is a slice of the names of the mime types of the office documents
+	// that are converted to PDF with ConvertOffice and previewed.
 	TypesConvertableToPdf = []string{
 		DocMimeType,
 		DocxMimeType,
@@ -181,6 +194,33 @@ type Router struct {
 	gotenbergClient *gotenberg.Client
 	oAuthMiddleware *oauth.Middleware
 	logger          *logrus.Logger
+
+	// trash stores the trashed files, files are deleted permanently on delete if it's nil.
+	trash TrashStore
+
+	// versions keeps the replaced contents of updated files, files have no versions if it's nil.
+	versions *Versions
+
+	// checksums stores the checksums of the files' contents, responses have no digests if it's nil.
+	checksums ChecksumStore
+
+	// permissionCache caches resolved permissions of listings, nothing is cached if it's nil.
+	permissionCache *PermissionCache
+
+	// previewCache caches the PDF previews of office documents, nothing is cached if it's nil.
+	previewCache cache.Cache
+
+	// previewGroup deduplicates concurrent conversions of the same file version.
+	previewGroup cache.Group
+
+	// thumbnailCache caches the generated thumbnails, nothing is cached if it's nil.
+	thumbnailCache cache.Cache
+
+	// thumbnailGroup deduplicates concurrent generations of the same thumbnail.
+	thumbnailGroup cache.Group
+
+	// pdfRenderer renders the thumbnails of PDFs and office documents, they have no thumbnails if it's nil.
+	pdfRenderer PDFRenderer
 }
 
 // Permission is a struct that describes a user's permission to a file.
@@ -241,7 +281,12 @@ type updateFilesRequest struct {
 
 // NewRouter creates a new Router, and initializes clients of File Service
 // and Download Service with the given connections. If logger is non-nil then it will
-// be set as-is, otherwise logger would default to logrus.New().
+// be set as-is, otherwise logger would default to logrus.New(). If trash is nil then
+// deleted files are deleted permanently instead of being moved to the trash. If permissionCache
+// is nil then the permissions resolved for listings aren't cached. If previewCache is nil then
+// office documents are converted on every preview, and if thumbnailCache is nil then thumbnails
+// are generated on every request. If versions is nil then files have no versions. If checksums is nil
+// then the digests of the files' contents aren't responded.
 func NewRouter(
 	fileConn *grpcPoolTypes.ConnPool,
 	downloadConn *grpcPoolTypes.ConnPool,
@@ -251,6 +296,12 @@ func NewRouter(
 	searchConn *grpcPoolTypes.ConnPool,
 	gotenbergClient *gotenberg.Client,
 	oAuthMiddleware *oauth.Middleware,
+	trash TrashStore,
+	versions *Versions,
+	checksums ChecksumStore,
+	permissionCache *PermissionCache,
+	previewCache cache.Cache,
+	thumbnailCache cache.Cache,
 	logger *logrus.Logger,
 ) *Router {
 	// If no logger is given, use a default logger.
@@ -288,6 +339,20 @@ func NewRouter(
 
 	r.oAuthMiddleware = oAuthMiddleware
 
+	r.trash = trash
+
+	r.versions = versions
+
+	r.checksums = checksums
+
+	r.permissionCache = permissionCache
+
+	r.previewCache = previewCache
+
+	r.thumbnailCache = thumbnailCache
+
+	r.pdfRenderer = newPdftoppmRenderer(viper.GetString(ConfigThumbnailPdfRenderer))
+
 	return r
 }
 
@@ -299,16 +364,21 @@ func (r *Router) Setup(rg *gin.RouterGroup) {
 	rg.GET("/files", checkGetFileScope, r.GetFilesByFolder)
 	rg.GET("/files/:id", checkGetFileScope, r.GetFileByID)
 	rg.GET("/files/:id/ancestors", r.GetFileAncestors)
+	rg.GET("/files/:id/thumbnail", checkGetFileScope, r.GetThumbnail)
+	rg.GET("/zip", checkGetFileScope, r.DownloadZip)
 	rg.DELETE("/files/:id", checkDeleteFileScope, r.DeleteFileByID)
 	rg.PUT("/files/:id", r.UpdateFile)
 	rg.PUT("/files", r.UpdateFiles)
+
+	r.setupTrash(rg)
+	r.setupVersions(rg)
 }
 
 // GetFileByID is the request handler for GET /files/:id
 func (r *Router) GetFileByID(c *gin.Context) {
 	fileID := c.Param(ParamFileID)
 	if fileID == "" {
-		c.String(http.StatusBadRequest, fileIDIsRequiredMessage)
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fileIDIsRequiredMessage)
 		return
 	}
 
@@ -323,9 +393,10 @@ func (r *Router) GetFileByID(c *gin.Context) {
 		canDownload := r.oAuthMiddleware.ValidateRequiredScope(c, oauth.DownloadScope)
 
 		if !canDownload {
-			loggermiddleware.LogError(r.logger, c.AbortWithError(
+			loggermiddleware.LogError(r.logger, apierror.AbortWithMessage(
+				c,
 				http.StatusForbidden,
-				fmt.Errorf("required scope '%s' is not supplied", oauth.DownloadScope),
+				fmt.Sprintf("required scope '%s' is not supplied", oauth.DownloadScope),
 			))
 			return
 		}
@@ -337,20 +408,26 @@ func (r *Router) GetFileByID(c *gin.Context) {
 	userFilePermission, foundPermission := r.HandleUserFilePermission(c, fileID, GetFileByIDRole)
 	if userFilePermission == "" {
 		if !r.HandleUserFilePermit(c, fileID, GetFileByIDRole) {
-			c.AbortWithStatus(http.StatusUnauthorized)
+			apierror.AbortWithStatus(c, http.StatusUnauthorized)
 			return
 		}
 	}
 
+	if r.isTrashed(c.Request.Context(), fileID) {
+		apierror.AbortWithMessage(c, http.StatusNotFound, fileIsTrashedMessage)
+		return
+	}
+
 	getFileByIDRequest := &fpb.GetByFileByIDRequest{Id: fileID}
 	file, err := r.fileClient().GetFileByID(c.Request.Context(), getFileByIDRequest)
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
 
+	r.setDigestHeader(c, file.GetKey())
 	c.JSON(http.StatusOK, CreateGetFileResponse(file, userFilePermission, foundPermission))
 }
 
@@ -369,10 +446,13 @@ func queryParamsToMap(c *gin.Context, paramNames ...string) map[string]string {
 }
 
 // GetFilesByFolder is the request handler for GET /files request.
+// The files are filtered and sorted by the folder query parameters, if a page is requested with
+// pageNum or pageSize then a page of the files is responded with the count of the matching files.
+// The count includes the files that the requester isn't permitted to, which are omitted from the page.
 func (r *Router) GetFilesByFolder(c *gin.Context) {
 	reqUser := user.ExtractRequestUser(c)
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 
@@ -400,7 +480,7 @@ func (r *Router) GetFilesByFolder(c *gin.Context) {
 		// In the future - we may allow other apps to get the
 		// shared files which belong to them.
 		if !stringInSlice(appID, AllowedAllOperationsApps) {
-			c.AbortWithStatus(http.StatusForbidden)
+			apierror.AbortWithStatus(c, http.StatusForbidden)
 			return
 		}
 
@@ -415,11 +495,22 @@ func (r *Router) GetFilesByFolder(c *gin.Context) {
 
 	if userFilePermission == "" {
 		if !r.HandleUserFilePermit(c, filesParent, GetFilesByFolderRole) {
-			c.AbortWithStatus(http.StatusUnauthorized)
+			apierror.AbortWithStatus(c, http.StatusUnauthorized)
 			return
 		}
 	}
 
+	if r.isTrashed(c.Request.Context(), filesParent) {
+		apierror.AbortWithMessage(c, http.StatusNotFound, fileIsTrashedMessage)
+		return
+	}
+
+	query, err := parseFolderQuery(c)
+	if err != nil {
+		apierror.AbortWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	paramMap := queryParamsToMap(c, ParamFileName, ParamFileType, ParamFileDescription, ParamFileSize,
 		ParamFileCreatedAt, ParamFileUpdatedAt)
 
@@ -446,33 +537,46 @@ func (r *Router) GetFilesByFolder(c *gin.Context) {
 	)
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
 
-	files := filesResp.GetFiles()
+	// The requester is permitted to the folder so it's permitted to all of its files,
+	// the permission of each file is resolved only for the files in the requested page.
+	files, itemCount := query.apply(r.withoutTrashed(c.Request.Context(), filesResp.GetFiles()))
+	resolver := NewPermissionResolver(
+		r.fileClient(),
+		r.permissionClient(),
+		r.permissionCache,
+		reqUser.ID,
+		GetFilesByFolderRole,
+	)
+
 	responseFiles := make([]*GetFileByIDResponse, 0, len(files))
-	for _, file := range files {
-		userFilePermission, foundPermission, err := CheckUserFilePermission(c.Request.Context(),
-			r.fileClient(),
-			r.permissionClient(),
-			reqUser.ID,
-			file.GetId(),
-			GetFilesByFolderRole)
-		if err != nil {
-			httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-			loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+	for _, resolved := range resolver.ResolveFiles(c.Request.Context(), files) {
+		if resolved.Err != nil {
+			httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(resolved.Err))
+			loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, resolved.Err))
 
 			return
 		}
 
-		if userFilePermission != "" {
-			responseFiles = append(responseFiles, CreateGetFileResponse(file, userFilePermission, foundPermission))
+		if resolved.Role != "" {
+			responseFiles = append(responseFiles, CreateGetFileResponse(resolved.File, resolved.Role, resolved.Permission))
 		}
 	}
 
-	c.JSON(http.StatusOK, responseFiles)
+	if !query.paginated {
+		c.JSON(http.StatusOK, responseFiles)
+		return
+	}
+
+	c.JSON(http.StatusOK, &getFilesByFolderResponse{
+		Files:     responseFiles,
+		PageNum:   query.pageNum,
+		ItemCount: itemCount,
+	})
 }
 
 // GetSharedFiles is the request handler for GET /files?shares.
@@ -481,7 +585,7 @@ func (r *Router) GetFilesByFolder(c *gin.Context) {
 func (r *Router) GetSharedFiles(c *gin.Context, queryAppID string) {
 	reqUser := user.ExtractRequestUser(c)
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 
@@ -502,7 +606,7 @@ func (r *Router) GetSharedFiles(c *gin.Context, queryAppID string) {
 
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
@@ -511,6 +615,8 @@ func (r *Router) GetSharedFiles(c *gin.Context, queryAppID string) {
 	filesSuccesful := make([]*GetFileByIDResponse, 0, len(permissions.GetPermissions()))
 	filesFailed := make([]string, 0, len(permissions.GetPermissions()))
 
+	sharedFiles := make([]*fpb.File, 0, len(permissions.GetPermissions()))
+	sharedPermissions := make(map[string]*ppb.GetUserPermissionsResponse_FileRole, len(permissions.GetPermissions()))
 	for _, permission := range permissions.GetPermissions() {
 		file, err := r.fileClient().GetFileByID(c.Request.Context(), &fpb.GetByFileByIDRequest{Id: permission.GetFileID()})
 		if err != nil {
@@ -522,19 +628,26 @@ func (r *Router) GetSharedFiles(c *gin.Context, queryAppID string) {
 		// Filter files which belong to the requesting user.
 		// The creator of the permission is not necessarily the owner of the file!
 		if file.GetOwnerID() != reqUser.ID {
-			userPermission := &ppb.PermissionObject{
-				FileID:  permission.GetFileID(),
-				UserID:  reqUser.ID,
-				Role:    permission.GetRole(),
-				Creator: permission.GetCreator(),
-			}
-			filesSuccesful = append(
-				filesSuccesful,
-				CreateGetFileResponse(file, permission.GetRole().String(), userPermission),
-			)
+			sharedFiles = append(sharedFiles, file)
+			sharedPermissions[file.GetId()] = permission
 		}
 	}
 
+	// Shared files that are in the trash, or under a folder that is, are hidden.
+	for _, file := range r.withoutTrashed(c.Request.Context(), sharedFiles) {
+		permission := sharedPermissions[file.GetId()]
+		userPermission := &ppb.PermissionObject{
+			FileID:  permission.GetFileID(),
+			UserID:  reqUser.ID,
+			Role:    permission.GetRole(),
+			Creator: permission.GetCreator(),
+		}
+		filesSuccesful = append(
+			filesSuccesful,
+			CreateGetFileResponse(file, permission.GetRole().String(), userPermission),
+		)
+	}
+
 	var errMsg string
 	if len(filesFailed) > 0 {
 		errMsg = "file not found"
@@ -552,16 +665,18 @@ func (r *Router) GetSharedFiles(c *gin.Context, queryAppID string) {
 }
 
 // DeleteFileByID is the request handler for DELETE /files/:id request.
+// If the requester owns the file and the trash is enabled then the file is moved to the trash,
+// otherwise it's deleted permanently.
 func (r *Router) DeleteFileByID(c *gin.Context) {
 	reqUser := user.ExtractRequestUser(c)
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 
 	fileID := c.Param(ParamFileID)
 	if fileID == "" {
-		c.String(http.StatusBadRequest, fileIDIsRequiredMessage)
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fileIDIsRequiredMessage)
 		return
 	}
 
@@ -585,10 +700,31 @@ func (r *Router) DeleteFileByID(c *gin.Context) {
 
 	// If the user doesn't have direct premission, then he can't delete the file
 	if !hasDirectPermission.GetPermitted() {
-		c.AbortWithStatus(http.StatusForbidden)
+		apierror.AbortWithStatus(c, http.StatusForbidden)
 		return	
 	}
 
+	if r.trash != nil {
+		file, err := r.fileClient().GetFileByID(c.Request.Context(), &fpb.GetByFileByIDRequest{Id: fileID})
+		if err != nil {
+			httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+			loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+			return
+		}
+
+		// Only the owner's files are moved to the trash, other users only lose their permission to the file.
+		if file.GetOwnerID() == reqUser.ID {
+			if err := r.moveToTrash(c.Request.Context(), file, reqUser.ID); err != nil {
+				loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusInternalServerError, err))
+				return
+			}
+
+			c.JSON(http.StatusOK, []string{fileID})
+			return
+		}
+	}
+
 	ids, err := DeleteFile(
 		c,
 		r.logger,
@@ -596,11 +732,13 @@ func (r *Router) DeleteFileByID(c *gin.Context) {
 		r.uploadClient(),
 		r.searchClient(),
 		r.permissionClient(),
+		r.permissionCache,
 		fileID,
 		reqUser.ID)
+	r.deleteVersions(c.Request.Context(), ids)
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
@@ -609,6 +747,8 @@ func (r *Router) DeleteFileByID(c *gin.Context) {
 }
 
 // Download is the request handler for /files/:id?alt=media request.
+// It supports Range and If-Range requests responded with 206 Partial Content,
+// and If-None-Match and If-Modified-Since requests responded with 304 Not Modified.
 func (r *Router) Download(c *gin.Context) {
 	// Get file ID from param.
 	fileID := c.Param(ParamFileID)
@@ -617,24 +757,71 @@ func (r *Router) Download(c *gin.Context) {
 
 	if role == "" {
 		if !r.HandleUserFilePermit(c, fileID, DownloadRole) {
-			c.AbortWithStatus(http.StatusUnauthorized)
+			apierror.AbortWithStatus(c, http.StatusUnauthorized)
 			return
 		}
 	}
 
+	if r.isTrashed(c.Request.Context(), fileID) {
+		apierror.AbortWithMessage(c, http.StatusNotFound, fileIsTrashedMessage)
+		return
+	}
+
 	// Get the file meta from the file service
 	fileMeta, err := r.fileClient().GetFileByID(c.Request.Context(), &fpb.GetByFileByIDRequest{Id: fileID})
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
 
+	r.serveContent(c, fileMeta)
+}
+
+// serveContent writes the content of fileMeta to the response, or its preview if it's requested.
+func (r *Router) serveContent(c *gin.Context, fileMeta *fpb.File) {
 	filename := fileMeta.GetName()
 	contentType := fileMeta.GetType()
 	contentLength := fmt.Sprintf("%d", fileMeta.GetSize())
 
+	preview, ok := c.GetQuery(QueryFileDownloadPreview)
+	isPreview := ok && preview != "false"
+
+	// Conditional and range requests are only supported for the file's content, not for its preview.
+	var ranges []byteRange
+	var err error
+	if !isPreview {
+		etag := fileETag(fileMeta)
+		lastModified := fileLastModified(fileMeta)
+		c.Header("ETag", etag)
+		c.Header("Last-Modified", lastModified.Format(http.TimeFormat))
+		c.Header("Accept-Ranges", rangeUnit)
+		r.setDigestHeader(c, fileMeta.GetKey())
+
+		if notModified(c.Request, etag, lastModified) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		ranges, err = requestRanges(c.Request, fileMeta.GetSize(), etag, lastModified)
+		if err != nil {
+			c.Header("Content-Range", fmt.Sprintf("%s */%d", rangeUnit, fileMeta.GetSize()))
+			loggermiddleware.LogError(
+				r.logger,
+				apierror.AbortWithMessage(c, http.StatusRequestedRangeNotSatisfiable, err.Error()),
+			)
+
+			return
+		}
+	}
+
+	if isPreview {
+		loggermiddleware.LogError(r.logger, r.HandlePreview(c, fileMeta))
+
+		return
+	}
+
 	downloadRequest := &dpb.DownloadRequest{
 		Key:    fileMeta.GetKey(),
 		Bucket: fileMeta.GetBucket(),
@@ -646,22 +833,22 @@ func (r *Router) Download(c *gin.Context) {
 	stream, err := r.downloadClient().Download(spanCtx, downloadRequest)
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
 
-	preview, ok := c.GetQuery(QueryFileDownloadPreview)
-	if ok && preview != "false" {
-		loggermiddleware.LogError(r.logger, r.HandlePreview(c, fileMeta, stream))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+
+	if len(ranges) > 0 {
+		loggermiddleware.LogError(r.logger, handleRangeStream(c, stream, ranges, contentType, fileMeta.GetSize()))
 
 		return
 	}
 
 	c.Header("Content-Type", contentType)
 	c.Header("Content-Length", contentLength)
-	c.Header("X-Content-Type-Options", "nosniff")
-	c.Header("Content-Disposition", "attachment; filename="+filename)
 
 	loggermiddleware.LogError(r.logger, HandleStream(c, stream))
 }
@@ -672,7 +859,7 @@ func (r *Router) Download(c *gin.Context) {
 func (r *Router) UpdateFile(c *gin.Context) {
 	fileID := c.Param(ParamFileID)
 	if fileID == "" {
-		c.String(http.StatusBadRequest, fileIDIsRequiredMessage)
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fileIDIsRequiredMessage)
 		return
 	}
 
@@ -686,26 +873,37 @@ func (r *Router) UpdateFile(c *gin.Context) {
 		return
 	}
 
+	if r.isTrashed(c.Request.Context(), fileID) {
+		apierror.AbortWithMessage(c, http.StatusNotFound, fileIsTrashedMessage)
+		return
+	}
+
 	var pf partialFile
 	if c.ShouldBindJSON(&pf) != nil {
 		loggermiddleware.LogError(
 			r.logger,
-			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("unexpected body format")),
+			apierror.AbortWithMessage(c, http.StatusBadRequest, "unexpected body format"),
 		)
 
 		return
 	}
 
-	// If the parent should be updated then check permissions for the new parent.
+	// If the parent should be updated then check permissions for the new parent, a file can't be
+	// moved into the trash since it would be deleted permanently along with the trashed folder.
 	if pf.Parent != nil {
 		if role, _ := r.HandleUserFilePermission(c, *pf.Parent, UpdateFileRole); role == "" {
 			return
 		}
+
+		if r.isTrashed(c.Request.Context(), *pf.Parent) {
+			apierror.AbortWithMessage(c, http.StatusNotFound, fileIsTrashedMessage)
+			return
+		}
 	}
 
 	if err := r.handleUpdate(c, []string{fileID}, pf); err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
@@ -717,7 +915,7 @@ func (r *Router) UpdateFile(c *gin.Context) {
 func (r *Router) GetFileAncestors(c *gin.Context) {
 	fileID := c.Param(ParamFileID)
 	if fileID == "" {
-		c.String(http.StatusBadRequest, fileIDIsRequiredMessage)
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fileIDIsRequiredMessage)
 		return
 	}
 
@@ -732,17 +930,22 @@ func (r *Router) GetFileAncestors(c *gin.Context) {
 		return
 	}
 
+	if r.isTrashed(c.Request.Context(), fileID) {
+		apierror.AbortWithMessage(c, http.StatusNotFound, fileIsTrashedMessage)
+		return
+	}
+
 	res, err := r.fileClient().GetAncestors(c.Request.Context(), &fpb.GetAncestorsRequest{Id: fileID})
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
 
 	reqUser := user.ExtractRequestUser(c)
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 
@@ -768,7 +971,7 @@ func (r *Router) GetFileAncestors(c *gin.Context) {
 
 		if err != nil {
 			httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-			loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+			loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 			return
 		}
@@ -794,7 +997,7 @@ func (r *Router) GetFileAncestors(c *gin.Context) {
 		)
 		if err != nil {
 			httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-			loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+			loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 			return
 		}
@@ -818,17 +1021,23 @@ func (r *Router) UpdateFiles(c *gin.Context) {
 	if c.ShouldBindJSON(&body) != nil {
 		loggermiddleware.LogError(
 			r.logger,
-			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("unexpected body format")),
+			apierror.AbortWithMessage(c, http.StatusBadRequest, "unexpected body format"),
 		)
 
 		return
 	}
 
-	// If the parent should be updated then check permissions for the new parent.
+	// If the parent should be updated then check permissions for the new parent, files can't be
+	// moved into the trash since they would be deleted permanently along with the trashed folder.
 	if body.PartialFile.Parent != nil {
 		if role, _ := r.HandleUserFilePermission(c, *body.PartialFile.Parent, UpdateFilesRole); role == "" {
 			return
 		}
+
+		if r.isTrashed(c.Request.Context(), *body.PartialFile.Parent) {
+			apierror.AbortWithMessage(c, http.StatusNotFound, fileIsTrashedMessage)
+			return
+		}
 	}
 
 	allowedIds := make([]string, 0, len(body.IDList))
@@ -841,17 +1050,27 @@ func (r *Router) UpdateFiles(c *gin.Context) {
 			id,
 			UpdateFilesRole)
 		if err != nil {
-			loggermiddleware.LogError(r.logger, c.AbortWithError(int(status.Code(err)), err))
+			httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+			loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+			return
 		}
 
-		if userFilePermission != "" {
-			allowedIds = append(allowedIds, id)
+		if userFilePermission == "" {
+			continue
 		}
+
+		if r.isTrashed(c.Request.Context(), id) {
+			apierror.AbortWithMessage(c, http.StatusNotFound, fileIsTrashedMessage)
+			return
+		}
+
+		allowedIds = append(allowedIds, id)
 	}
 
 	if err := r.handleUpdate(c, allowedIds, body.PartialFile); err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
@@ -906,6 +1125,11 @@ func (r *Router) handleUpdate(c *gin.Context, ids []string, pf partialFile) erro
 		return err
 	}
 
+	// The moved files inherit the permissions of their new parent.
+	if pf.Parent != nil {
+		r.permissionCache.Invalidate()
+	}
+
 	for _, id := range ids {
 		sUpdatedData.Id = id
 		if _, err := r.searchClient().Update(c.Request.Context(), sUpdatedData); err != nil {
@@ -933,7 +1157,7 @@ func HandleStream(c *gin.Context, stream dpb.Download_DownloadClient) error {
 
 		if err != nil {
 			httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-			if err := c.AbortWithError(httpStatusCode, err); err != nil {
+			if err := apierror.Abort(c, httpStatusCode, err); err != nil {
 				return err
 			}
 
@@ -945,7 +1169,9 @@ func HandleStream(c *gin.Context, stream dpb.Download_DownloadClient) error {
 		}
 
 		part := chunk.GetFile()
-		if _, err := c.Writer.Write(part); err != nil {
+		written, err := c.Writer.Write(part)
+		metrics.AddStreamedBytes(metrics.DirectionDownload, written)
+		if err != nil {
 			return err
 		}
 
@@ -953,6 +1179,35 @@ func HandleStream(c *gin.Context, stream dpb.Download_DownloadClient) error {
 	}
 }
 
+// DownloadReader is an io.Reader of the content of a download stream, that can be read in parts of any size.
+type DownloadReader struct {
+	stream dpb.Download_DownloadClient
+	chunk  []byte
+}
+
+// NewDownloadReader creates a DownloadReader of stream.
+func NewDownloadReader(stream dpb.Download_DownloadClient) *DownloadReader {
+	return &DownloadReader{stream: stream}
+}
+
+// Read reads the next bytes of the download, receiving the next chunk when the current one was read.
+func (d *DownloadReader) Read(p []byte) (int, error) {
+	for len(d.chunk) == 0 {
+		chunk, err := d.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+
+		d.chunk = chunk.GetFile()
+	}
+
+	n := copy(p, d.chunk)
+	d.chunk = d.chunk[n:]
+	metrics.AddStreamedBytes(metrics.DirectionDownload, n)
+
+	return n, nil
+}
+
 // CheckUserFilePermission checks if userID is permitted to fileID with the wanted role.
 // The function returns the role name if the user is permitted to the file,
 // the permission if the user was shared, and non-nil err if any encountered.
@@ -1115,7 +1370,7 @@ func (r *Router) HandleUserFilePermission(
 	reqUser := user.ExtractRequestUser(c)
 
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 
 		return "", nil
 	}
@@ -1129,13 +1384,13 @@ func (r *Router) HandleUserFilePermission(
 
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return "", nil
 	}
 
 	if userStringRole == "" {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 	}
 
 	return userStringRole, foundPermission
@@ -1153,7 +1408,7 @@ func (r *Router) HandleUserFilePermit(
 	reqUser := user.ExtractRequestUser(c)
 
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 
 		return false
 	}
@@ -1166,13 +1421,13 @@ func (r *Router) HandleUserFilePermit(
 
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return false
 	}
 
 	if !isPermitted {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 	}
 
 	return isPermitted
@@ -1222,75 +1477,42 @@ func CreateGetFileResponse(file *fpb.File, role string, permission *ppb.Permissi
 	return responseFile
 }
 
-// HandlePreview writes a PDF of the file to the response, if the file isn't a PDF already
-// and can be converted to a PDF, then the file would be converted to a PDF and
-// the converted file will be written to the response, instead of the raw file.
-func (r *Router) HandlePreview(c *gin.Context, file *fpb.File, stream dpb.Download_DownloadClient) error {
-	filename := file.GetName()
+// HandlePreview writes a preview of file to the response. Files that have a Converter in Converters are
+// converted to a PDF once per version of the file and the PDF is written to the response, PDFs and other
+// text files are written as is.
+func (r *Router) HandlePreview(c *gin.Context, file *fpb.File) error {
 	contentType := file.GetType()
-	contentLength := strconv.FormatInt(file.GetSize(), 10)
-
-	// File is already a PDF, no need to convert it.
-	if contentType == PdfMimeType || strings.HasPrefix(contentType, TextMimeType) {
-		c.Header("Content-Type", contentType)
-		c.Header("Content-Length", contentLength)
-
-		return HandleStream(c, stream)
+	if IsFileConvertableToPdf(contentType) {
+		return r.handleConvertedPreview(c, file)
 	}
 
-	// Convert the file to PDF.
-	streamReader := download.NewStreamReadCloser(stream)
-
-	// IMPORTANT: Must use a buffer that its size is at least download.PartSize, otherwise data loss
-	// would occur.
-	buf := make([]byte, download.PartSize)
-	convertRequest, err := gotenberg.NewOfficeRequestWithBuffer(filename, streamReader, buf)
-	if err != nil {
-		return err
+	if contentType != PdfMimeType && !strings.HasPrefix(contentType, TextMimeType) {
+		return apierror.AbortWithMessage(c, http.StatusUnsupportedMediaType, fileCannotBePreviewedMessage)
 	}
 
-	convertRequest.ResultFilename(filename)
-
-	defer streamReader.Close()
+	span, spanCtx := loggermiddleware.StartSpan(c.Request.Context(), "/download.Download/Download")
+	defer span.End()
 
-	// Send the file for PDF conversion.
-	resp, err := r.gotenbergClient.Post(convertRequest)
+	stream, err := r.downloadClient().Download(spanCtx, &dpb.DownloadRequest{
+		Key:    file.GetKey(),
+		Bucket: file.GetBucket(),
+	})
 	if err != nil {
-		return err
-	}
-
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		err := fmt.Errorf("failed converting file with gotenberg with status: %v", resp.Status)
-		loggermiddleware.LogError(r.logger, c.AbortWithError(resp.StatusCode, err))
-
-		return err
-	}
-
-	c.Header("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
-	c.Header("Content-Type", resp.Header.Get("Content-Type"))
-
-	if _, err = io.Copy(c.Writer, resp.Body); err != nil {
-		loggermiddleware.LogError(r.logger, c.AbortWithError(http.StatusInternalServerError, err))
-
-		return err
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		return apierror.Abort(c, httpStatusCode, err)
 	}
 
-	c.Status(http.StatusOK)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Length", strconv.FormatInt(file.GetSize(), 10))
 
-	return nil
+	return HandleStream(c, stream)
 }
 
 // IsFileConvertableToPdf returns true if contentType can be converted to a PDF file, false otherwise.
 func IsFileConvertableToPdf(contentType string) bool {
-	for _, v := range TypesConvertableToPdf {
-		if contentType == v {
-			return true
-		}
-	}
+	_, ok := Converters.Lookup(contentType)
 
-	return false
+	return ok
 }
 
 // validateAppID returns an error if the app cannot do an operation on the file, otherwise, nil.
@@ -1311,10 +1533,10 @@ func validateAppID(ctx *gin.Context, fileID string, fileClient fpb.FileServiceCl
 	// Get the file's metadata.
 	file, err := fileClient.GetFileByID(ctx, &fpb.GetByFileByIDRequest{Id: fileID})
 	if err != nil {
-		return ctx.AbortWithError(http.StatusForbidden, err)
+		return apierror.Abort(ctx, http.StatusForbidden, err)
 	}
 	if file.GetAppID() != appID {
-		return ctx.AbortWithError(http.StatusForbidden, fmt.Errorf("application not permitted"))
+		return apierror.AbortWithMessage(ctx, http.StatusForbidden, "application not permitted")
 	}
 
 	return nil
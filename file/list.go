@@ -0,0 +1,270 @@
+package file
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	fpb "github.com/meateam/file-service/proto/file"
+)
+
+const (
+	// ParamSortBy is a constant for the field to sort files by in a request,
+	// one of ParamFileName, ParamFileSize, ParamFileType, ParamFileCreatedAt or ParamFileUpdatedAt.
+	ParamSortBy = "sortBy"
+
+	// ParamSortOrder is a constant for the sort order in a request, SortOrderAsc or SortOrderDesc.
+	ParamSortOrder = "sortOrder"
+
+	// ParamFoldersFirst is a constant for listing folders before files in a request.
+	ParamFoldersFirst = "foldersFirst"
+
+	// ParamNamePrefix is a constant for the prefix of the names of the files in a request.
+	ParamNamePrefix = "namePrefix"
+
+	// ParamSizeMin is a constant for the minimum file size in a request.
+	ParamSizeMin = "sizeMin"
+
+	// ParamSizeMax is a constant for the maximum file size in a request.
+	ParamSizeMax = "sizeMax"
+
+	// ParamCreatedFrom is a constant for the earliest file creation time in a request, in milliseconds.
+	ParamCreatedFrom = "createdFrom"
+
+	// ParamCreatedTo is a constant for the latest file creation time in a request, in milliseconds.
+	ParamCreatedTo = "createdTo"
+
+	// ParamUpdatedFrom is a constant for the earliest file update time in a request, in milliseconds.
+	ParamUpdatedFrom = "updatedFrom"
+
+	// ParamUpdatedTo is a constant for the latest file update time in a request, in milliseconds.
+	ParamUpdatedTo = "updatedTo"
+
+	// SortOrderAsc is the ascending sort order.
+	SortOrderAsc = "asc"
+
+	// SortOrderDesc is the descending sort order.
+	SortOrderDesc = "desc"
+
+	// DefaultPageSize is the page size of a paginated request that doesn't specify it.
+	DefaultPageSize = 100
+
+	// MaxPageSize is the maximum page size of a paginated request.
+	MaxPageSize = 1000
+)
+
+// int64Range is an inclusive range of int64 values, a nil bound is unbounded.
+type int64Range struct {
+	min *int64
+	max *int64
+}
+
+// contains reports whether n is in the range.
+func (r int64Range) contains(n int64) bool {
+	return (r.min == nil || n >= *r.min) && (r.max == nil || n <= *r.max)
+}
+
+// folderQuery is the sorting, filtering and pagination of the files in a folder.
+type folderQuery struct {
+	sortBy       string
+	descending   bool
+	foldersFirst bool
+	namePrefix   string
+	size         int64Range
+	createdAt    int64Range
+	updatedAt    int64Range
+
+	// paginated is true if the request specified a page, otherwise all of the files are listed.
+	paginated bool
+	pageNum   int64
+	pageSize  int64
+}
+
+// getFilesByFolderResponse is a page of the files in a folder, with the count of all of the matching files.
+// The files are paginated before their permissions are resolved, so ItemCount is an upper bound of the
+// files that the requester is permitted to, and a page is short by the files that the requester isn't.
+type getFilesByFolderResponse struct {
+	Files     []*GetFileByIDResponse `json:"files"`
+	PageNum   int64                  `json:"pageNum"`
+	ItemCount int64                  `json:"itemCount"`
+}
+
+// parseFolderQuery parses the folder query of c, returns a non-nil error if any parameter is invalid.
+// pageNum starts at 0, as in GetSharedFiles.
+func parseFolderQuery(c *gin.Context) (*folderQuery, error) {
+	q := &folderQuery{
+		sortBy:     c.Query(ParamSortBy),
+		namePrefix: strings.ToLower(c.Query(ParamNamePrefix)),
+		pageSize:   DefaultPageSize,
+	}
+
+	switch q.sortBy {
+	case "", ParamFileName, ParamFileSize, ParamFileType, ParamFileCreatedAt, ParamFileUpdatedAt:
+	default:
+		return nil, fmt.Errorf("%s must be one of %s, %s, %s, %s or %s", ParamSortBy,
+			ParamFileName, ParamFileSize, ParamFileType, ParamFileCreatedAt, ParamFileUpdatedAt)
+	}
+
+	switch order := c.Query(ParamSortOrder); order {
+	case "", SortOrderAsc:
+	case SortOrderDesc:
+		q.descending = true
+	default:
+		return nil, fmt.Errorf("%s must be %s or %s", ParamSortOrder, SortOrderAsc, SortOrderDesc)
+	}
+
+	if foldersFirst, exists := c.GetQuery(ParamFoldersFirst); exists {
+		parsed, err := strconv.ParseBool(foldersFirst)
+		if err != nil && foldersFirst != "" {
+			return nil, fmt.Errorf("%s must be a boolean", ParamFoldersFirst)
+		}
+
+		q.foldersFirst = parsed || foldersFirst == ""
+	}
+
+	ranges := []struct {
+		r        *int64Range
+		min, max string
+	}{
+		{&q.size, ParamSizeMin, ParamSizeMax},
+		{&q.createdAt, ParamCreatedFrom, ParamCreatedTo},
+		{&q.updatedAt, ParamUpdatedFrom, ParamUpdatedTo},
+	}
+
+	for _, rng := range ranges {
+		var err error
+		if rng.r.min, err = queryInt64(c, rng.min); err != nil {
+			return nil, err
+		}
+
+		if rng.r.max, err = queryInt64(c, rng.max); err != nil {
+			return nil, err
+		}
+	}
+
+	pageNum, err := queryInt64(c, ParamPageNum)
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize, err := queryInt64(c, ParamPageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	q.paginated = pageNum != nil || pageSize != nil
+	if pageNum != nil {
+		if *pageNum < 0 {
+			return nil, fmt.Errorf("%s must not be negative", ParamPageNum)
+		}
+
+		q.pageNum = *pageNum
+	}
+
+	if pageSize != nil {
+		if *pageSize <= 0 || *pageSize > MaxPageSize {
+			return nil, fmt.Errorf("%s must be between 1 and %d", ParamPageSize, MaxPageSize)
+		}
+
+		q.pageSize = *pageSize
+	}
+
+	return q, nil
+}
+
+// queryInt64 returns the int64 value of the query parameter name, or nil if it's missing.
+func queryInt64(c *gin.Context, name string) (*int64, error) {
+	value, exists := c.GetQuery(name)
+	if !exists {
+		return nil, nil
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an integer", name)
+	}
+
+	return &n, nil
+}
+
+// apply returns the files that match q, sorted, and the page of them if q is paginated.
+// The returned count is the number of matching files in all of the pages.
+func (q *folderQuery) apply(files []*fpb.File) ([]*fpb.File, int64) {
+	matching := make([]*fpb.File, 0, len(files))
+	for _, file := range files {
+		if q.match(file) {
+			matching = append(matching, file)
+		}
+	}
+
+	sort.SliceStable(matching, func(i, j int) bool {
+		return q.less(matching[i], matching[j])
+	})
+
+	count := int64(len(matching))
+	if !q.paginated {
+		return matching, count
+	}
+
+	start := q.pageNum * q.pageSize
+	if start >= count {
+		return []*fpb.File{}, count
+	}
+
+	end := start + q.pageSize
+	if end > count {
+		end = count
+	}
+
+	return matching[start:end], count
+}
+
+// match reports whether file passes the filters of q.
+func (q *folderQuery) match(file *fpb.File) bool {
+	return strings.HasPrefix(strings.ToLower(file.GetName()), q.namePrefix) &&
+		q.size.contains(file.GetSize()) &&
+		q.createdAt.contains(file.GetCreatedAt()) &&
+		q.updatedAt.contains(file.GetUpdatedAt())
+}
+
+// less reports whether a is listed before b. Folders are listed first if requested regardless of
+// the sort order, and files that are equal by the sorted field keep the order of file service.
+func (q *folderQuery) less(a *fpb.File, b *fpb.File) bool {
+	if q.foldersFirst && isFolder(a) != isFolder(b) {
+		return isFolder(a)
+	}
+
+	cmp := 0
+	switch q.sortBy {
+	case ParamFileName:
+		cmp = strings.Compare(strings.ToLower(a.GetName()), strings.ToLower(b.GetName()))
+	case ParamFileType:
+		cmp = strings.Compare(a.GetType(), b.GetType())
+	case ParamFileSize:
+		cmp = compareInt64(a.GetSize(), b.GetSize())
+	case ParamFileCreatedAt:
+		cmp = compareInt64(a.GetCreatedAt(), b.GetCreatedAt())
+	case ParamFileUpdatedAt:
+		cmp = compareInt64(a.GetUpdatedAt(), b.GetUpdatedAt())
+	}
+
+	if q.descending {
+		return cmp > 0
+	}
+
+	return cmp < 0
+}
+
+// compareInt64 returns -1 if a < b, 1 if a > b and 0 if they're equal.
+func compareInt64(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,147 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meateam/api-gateway/oauth"
+	"github.com/meateam/api-gateway/user"
+	fpb "github.com/meateam/file-service/proto/file"
+	ppb "github.com/meateam/permission-service/proto"
+	"github.com/sirupsen/logrus"
+)
+
+// folderQueryOf parses the folder query of rawQuery.
+func folderQueryOf(rawQuery string) (*folderQuery, error) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/files?"+rawQuery, nil)
+
+	return parseFolderQuery(c)
+}
+
+func TestParseFolderQuery_Invalid(t *testing.T) {
+	tests := []string{
+		"sortBy=owner",
+		"sortOrder=up",
+		"foldersFirst=maybe",
+		"sizeMin=big",
+		"createdTo=yesterday",
+		"pageNum=-1",
+		"pageSize=0",
+		"pageSize=1001",
+	}
+
+	for _, rawQuery := range tests {
+		if _, err := folderQueryOf(rawQuery); err == nil {
+			t.Errorf("expected an error parsing %q", rawQuery)
+		}
+	}
+}
+
+func TestFolderQuery_Apply(t *testing.T) {
+	files := []*fpb.File{
+		{Id: "b", Name: "beta.txt", Size: 20, CreatedAt: 2, UpdatedAt: 30},
+		{Id: "docs", Name: "Docs", Type: FolderMimeType, CreatedAt: 5, UpdatedAt: 10},
+		{Id: "a", Name: "alpha.txt", Size: 30, CreatedAt: 1, UpdatedAt: 20},
+		{Id: "c", Name: "Beta.pdf", Size: 10, CreatedAt: 3, UpdatedAt: 40},
+		{Id: "src", Name: "src", Type: FolderMimeType, CreatedAt: 4, UpdatedAt: 50},
+	}
+
+	tests := []struct {
+		rawQuery  string
+		want      []string
+		wantCount int64
+	}{
+		{rawQuery: "", want: []string{"b", "docs", "a", "c", "src"}, wantCount: 5},
+		{rawQuery: "sortBy=name", want: []string{"a", "c", "b", "docs", "src"}, wantCount: 5},
+		{rawQuery: "sortBy=size&sortOrder=desc", want: []string{"a", "b", "c", "docs", "src"}, wantCount: 5},
+		{rawQuery: "sortBy=name&foldersFirst", want: []string{"docs", "src", "a", "c", "b"}, wantCount: 5},
+		{
+			rawQuery:  "sortBy=updatedAt&sortOrder=desc&foldersFirst=true",
+			want:      []string{"src", "docs", "c", "b", "a"},
+			wantCount: 5,
+		},
+		{rawQuery: "namePrefix=be&sortBy=createdAt", want: []string{"b", "c"}, wantCount: 2},
+		{rawQuery: "sizeMin=15&sizeMax=30", want: []string{"b", "a"}, wantCount: 2},
+		{rawQuery: "createdFrom=2&createdTo=4", want: []string{"b", "c", "src"}, wantCount: 3},
+		{rawQuery: "updatedFrom=30", want: []string{"b", "c", "src"}, wantCount: 3},
+		{rawQuery: "sortBy=createdAt&pageSize=2", want: []string{"a", "b"}, wantCount: 5},
+		{rawQuery: "sortBy=createdAt&pageSize=2&pageNum=2", want: []string{"docs"}, wantCount: 5},
+		{rawQuery: "sortBy=createdAt&pageSize=2&pageNum=3", want: []string{}, wantCount: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rawQuery, func(t *testing.T) {
+			q, err := folderQueryOf(tt.rawQuery)
+			if err != nil {
+				t.Fatalf("failed parsing query: %v", err)
+			}
+
+			page, count := q.apply(files)
+			got := make([]string, 0, len(page))
+			for _, file := range page {
+				got = append(got, file.GetId())
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected files %v, got %v", tt.want, got)
+			}
+
+			if count != tt.wantCount {
+				t.Errorf("expected count %d, got %d", tt.wantCount, count)
+			}
+		})
+	}
+}
+
+func TestRouter_GetFilesByFolderItemCount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// The user is permitted to the folder c, except for a file in it.
+	tree := newFakeTree(5, 0)
+	tree.permissions[permissionKey("child-0", "user")] = ppb.Role_NONE
+	for id, file := range tree.files {
+		file.Name = id
+	}
+
+	r := &Router{logger: logrus.New()}
+	r.fileClient = func() fpb.FileServiceClient { return fakeTreeFileClient{fakeTree: tree} }
+	r.permissionClient = func() ppb.PermissionClient { return fakeTreePermissionClient{fakeTree: tree} }
+
+	listed := 0
+	for pageNum := 0; pageNum < 3; pageNum++ {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(
+			http.MethodGet,
+			fmt.Sprintf("/api/files?parent=c&sortBy=name&pageSize=2&pageNum=%d", pageNum),
+			nil,
+		)
+		c.Set(user.ContextUserKey, user.User{ID: "user"})
+		c.Set(oauth.ContextAppKey, oauth.DriveAppID)
+
+		r.GetFilesByFolder(c)
+
+		var page getFilesByFolderResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("failed parsing page %d: %v", pageNum, err)
+		}
+
+		// The count is of the matching files, before the files the user isn't permitted to are omitted.
+		if page.ItemCount != 5 {
+			t.Errorf("expected an item count of 5 files, got %d", page.ItemCount)
+		}
+
+		listed += len(page.Files)
+	}
+
+	if listed != 4 {
+		t.Errorf("expected the 4 permitted files to be listed, got %d", listed)
+	}
+}
@@ -0,0 +1,252 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
+	"github.com/meateam/api-gateway/cache"
+	"github.com/meateam/api-gateway/metrics"
+	dpb "github.com/meateam/download-service/proto"
+	fpb "github.com/meateam/file-service/proto/file"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// previewCacheName is the name of the preview cache in its metrics.
+	previewCacheName = "preview"
+
+	// conversionTimeout is the timeout of converting a file to a PDF preview. A file is converted once
+	// for its concurrent previews, so its conversion isn't canceled when one of the requests is canceled.
+	conversionTimeout = 2 * time.Minute
+)
+
+var (
+	// errPreviewUnsupported is returned when files of a type can't be converted to a PDF preview.
+	errPreviewUnsupported = errors.New(fileCannotBePreviewedMessage)
+
+	// errPreviewTooLarge is returned by a conversion whose PDF is too large to be cached,
+	// it's streamed by the request that converted it.
+	errPreviewTooLarge = errors.New("preview is too large to be cached")
+)
+
+// conversionError is the error of a conversion that gotenberg responded with a failure status.
+type conversionError struct {
+	statusCode int
+	status     string
+}
+
+func (e *conversionError) Error() string {
+	return fmt.Sprintf("failed converting file with gotenberg with status: %v", e.status)
+}
+
+// CacheKeyPrefix returns the prefix of the cache keys of the previews and thumbnails of fileID.
+// They're invalidated by deleting the keys with the prefix when the file's content is replaced.
+func CacheKeyPrefix(fileID string) string {
+	return fileID + "/"
+}
+
+// InvalidateContentCaches deletes the cached previews and thumbnails of fileID from contentCaches after
+// its content was replaced. Nil caches are skipped.
+func InvalidateContentCaches(fileID string, contentCaches ...cache.Cache) {
+	for _, contentCache := range contentCaches {
+		if contentCache != nil {
+			contentCache.DeletePrefix(CacheKeyPrefix(fileID))
+		}
+	}
+}
+
+// previewKey returns the cache key of the PDF preview of file's version.
+func previewKey(file *fpb.File) string {
+	return CacheKeyPrefix(file.GetId()) + strconv.FormatInt(file.GetUpdatedAt(), 10)
+}
+
+// loadCached returns the value of key from c, or loads it once for the concurrent requests
+// of key with g and caches it, and counts whether it was cached in the metrics of name.
+// Requests that waited for a concurrent load are counted as hits, since they didn't load.
+func loadCached(
+	name string,
+	c cache.Cache,
+	g *cache.Group,
+	key string,
+	load func() ([]byte, error)) ([]byte, error) {
+	hit := true
+	value, err := cache.Load(c, g, key, func() ([]byte, error) {
+		hit = false
+		return load()
+	})
+
+	if err == nil {
+		metrics.ObserveCacheLookup(name, hit)
+	}
+
+	return value, err
+}
+
+// handleConvertedPreview writes the PDF preview of file to the response. A PDF that fits in the preview cache
+// is converted once for the concurrent previews of file and cached, other PDFs are streamed to the response
+// as they're converted, so only the PDFs that are cached are buffered in memory.
+func (r *Router) handleConvertedPreview(c *gin.Context, file *fpb.File) error {
+	if r.previewCache == nil {
+		return r.streamConvertedPreview(c, file)
+	}
+
+	// uncached is the response of the conversion of this request if its PDF is too large to be cached.
+	var uncached *http.Response
+	maxSize := r.previewCache.MaxValueSize()
+	pdf, err := loadCached(previewCacheName, r.previewCache, &r.previewGroup, previewKey(file), func() ([]byte, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), conversionTimeout)
+		defer cancel()
+
+		resp, err := r.convertToPdf(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+
+		var pdf []byte
+		if resp.ContentLength <= maxSize {
+			pdf, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+			if err != nil || int64(len(pdf)) <= maxSize {
+				resp.Body.Close()
+				return pdf, err
+			}
+		}
+
+		// The PDF is streamed from the bytes that were already read.
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(pdf), resp.Body), resp.Body}
+		uncached = resp
+
+		return nil, errPreviewTooLarge
+	})
+
+	if uncached != nil {
+		return writePdf(c, uncached)
+	}
+
+	// The request waited for a conversion whose PDF was too large to be cached, so it converts on its own.
+	if err == errPreviewTooLarge {
+		return r.streamConvertedPreview(c, file)
+	}
+
+	if err != nil {
+		return apierror.Abort(c, previewErrorStatus(err), err)
+	}
+
+	c.Header("Content-Length", strconv.Itoa(len(pdf)))
+	c.Data(http.StatusOK, PdfMimeType, pdf)
+
+	return nil
+}
+
+// streamConvertedPreview converts file to a PDF and streams it to the response without caching it.
+func (r *Router) streamConvertedPreview(c *gin.Context, file *fpb.File) error {
+	resp, err := r.convertToPdf(c.Request.Context(), file)
+	if err != nil {
+		return apierror.Abort(c, previewErrorStatus(err), err)
+	}
+
+	return writePdf(c, resp)
+}
+
+// writePdf streams the PDF of the successful conversion response resp to the response, and closes it.
+func writePdf(c *gin.Context, resp *http.Response) error {
+	defer resp.Body.Close()
+
+	c.DataFromReader(http.StatusOK, resp.ContentLength, PdfMimeType, resp.Body, map[string]string{})
+
+	return nil
+}
+
+// previewErrorStatus returns the HTTP status code of an error of converting a preview.
+func previewErrorStatus(err error) int {
+	if err, ok := err.(*conversionError); ok {
+		return err.statusCode
+	}
+
+	if err == errPreviewUnsupported {
+		return http.StatusUnsupportedMediaType
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return gwruntime.HTTPStatusFromCode(status.Code(err))
+	}
+
+	return http.StatusBadGateway
+}
+
+// convertedPreview returns the PDF preview of file from the preview cache,
+// or converts it once for the concurrent requests of it and caches it.
+func (r *Router) convertedPreview(file *fpb.File) ([]byte, error) {
+	return loadCached(previewCacheName, r.previewCache, &r.previewGroup, previewKey(file), func() ([]byte, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), conversionTimeout)
+		defer cancel()
+
+		resp, err := r.convertToPdf(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+
+		defer resp.Body.Close()
+
+		return ioutil.ReadAll(resp.Body)
+	})
+}
+
+// convertToPdf downloads file and converts it to PDF with gotenberg, using its Converter in Converters.
+// Returns the successful response of gotenberg, whose body is the PDF and must be closed.
+func (r *Router) convertToPdf(ctx context.Context, file *fpb.File) (*http.Response, error) {
+	converter, ok := Converters.Lookup(file.GetType())
+	if !ok {
+		return nil, errPreviewUnsupported
+	}
+
+	stream, err := r.downloadClient().Download(ctx, &dpb.DownloadRequest{
+		Key:    file.GetKey(),
+		Bucket: file.GetBucket(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "preview")
+	if err != nil {
+		return nil, err
+	}
+
+	// The converted document was sent to gotenberg once it responded.
+	defer os.RemoveAll(dir)
+
+	convertRequest, err := converter(dir, file, NewDownloadReader(stream))
+	if err != nil {
+		return nil, err
+	}
+
+	conversionStart := time.Now()
+	resp, err := r.gotenbergClient.Post(convertRequest)
+	if err != nil {
+		metrics.ObserveConversion(conversionStart, http.StatusBadGateway)
+		return nil, err
+	}
+
+	metrics.ObserveConversion(conversionStart, resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &conversionError{statusCode: resp.StatusCode, status: resp.Status}
+	}
+
+	return resp, nil
+}
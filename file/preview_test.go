@@ -0,0 +1,168 @@
+package file
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meateam/api-gateway/cache"
+	dpb "github.com/meateam/download-service/proto"
+	fpb "github.com/meateam/file-service/proto/file"
+	"github.com/meateam/gotenberg-go-client/v6"
+)
+
+// newFakeGotenberg starts a gotenberg server that converts a document to "PDF of <document>",
+// or fails with 500 if the document is "corrupt", and counts the conversions. The document of
+// HTML and markdown conversions is their index, unless a markdown document is sent.
+func newFakeGotenberg(t *testing.T, conversions *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(conversions, 1)
+
+		// Take long enough for concurrent requests to wait for the conversion.
+		time.Sleep(50 * time.Millisecond)
+
+		if err := req.ParseMultipartForm(1 << 20); err != nil || len(req.MultipartForm.File["files"]) == 0 {
+			t.Errorf("expected a document to convert: %v", err)
+			return
+		}
+
+		files := req.MultipartForm.File["files"]
+		document := files[0]
+		for _, file := range files {
+			if file.Filename != htmlIndexFilename {
+				document = file
+			}
+		}
+
+		f, err := document.Open()
+		if err != nil {
+			t.Errorf("failed opening document: %v", err)
+			return
+		}
+		defer f.Close()
+
+		content, _ := ioutil.ReadAll(f)
+		if string(content) == "corrupt" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(append([]byte("PDF of "), content...))
+	}))
+}
+
+func TestRouter_ConvertedPreview(t *testing.T) {
+	var conversions int64
+	gotenbergServer := newFakeGotenberg(t, &conversions)
+	defer gotenbergServer.Close()
+
+	downloadClient := &countingDownloadClient{fakeDownloadClient: fakeDownloadClient{contents: map[string]string{
+		"v1":      "presentation",
+		"v2":      "updated presentation",
+		"corrupt": "corrupt",
+	}}}
+
+	r := &Router{
+		previewCache:    cache.NewLRU(1 << 20),
+		gotenbergClient: &gotenberg.Client{Hostname: gotenbergServer.URL},
+	}
+	r.downloadClient = func() dpb.DownloadClient { return downloadClient }
+
+	file := &fpb.File{Id: "pptx", Key: "v1", Name: "a.pptx", Type: PptxMimeType, UpdatedAt: 1}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if pdf, err := r.convertedPreview(file); err != nil || string(pdf) != "PDF of presentation" {
+				t.Errorf("expected the converted presentation, got %q with error %v", pdf, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if _, err := r.convertedPreview(file); err != nil || conversions != 1 || downloadClient.downloads != 1 {
+		t.Errorf("expected a single conversion, got %d conversions of %d downloads with error %v",
+			conversions, downloadClient.downloads, err)
+	}
+
+	updated := &fpb.File{Id: "pptx", Key: "v2", Name: "a.pptx", Type: PptxMimeType, UpdatedAt: 2}
+	if pdf, err := r.convertedPreview(updated); err != nil || string(pdf) != "PDF of updated presentation" {
+		t.Errorf("expected the updated presentation to be converted, got %q with error %v", pdf, err)
+	}
+
+	r.previewCache.DeletePrefix(CacheKeyPrefix("pptx"))
+	if _, err := r.convertedPreview(updated); err != nil || conversions != 3 {
+		t.Errorf("expected the invalidated preview to be converted again, got %d conversions", conversions)
+	}
+
+	corrupt := &fpb.File{Id: "corrupt", Key: "corrupt", Name: "c.docx", Type: DocxMimeType, UpdatedAt: 1}
+	if _, err := r.convertedPreview(corrupt); previewErrorStatus(err) != http.StatusInternalServerError {
+		t.Errorf("expected the failed conversion's status, got %v", err)
+	}
+
+	if _, ok := r.previewCache.Get(previewKey(corrupt)); ok {
+		t.Error("expected a failed conversion not to be cached")
+	}
+}
+
+func TestRouter_HandleConvertedPreview(t *testing.T) {
+	var conversions int64
+	gotenbergServer := newFakeGotenberg(t, &conversions)
+	defer gotenbergServer.Close()
+
+	downloadClient := &countingDownloadClient{fakeDownloadClient: fakeDownloadClient{contents: map[string]string{
+		"short": "memo",
+		"long":  "presentation",
+	}}}
+
+	r := &Router{gotenbergClient: &gotenberg.Client{Hostname: gotenbergServer.URL}}
+	r.downloadClient = func() dpb.DownloadClient { return downloadClient }
+
+	preview := func(file *fpb.File) string {
+		gin.SetMode(gin.TestMode)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/files/1?alt=media&preview=true", nil)
+
+		if err := r.handleConvertedPreview(c, file); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("failed previewing %s with status %d: %v", file.GetKey(), w.Code, err)
+		}
+
+		return w.Body.String()
+	}
+
+	short := &fpb.File{Id: "short", Key: "short", Name: "a.docx", Type: DocxMimeType, UpdatedAt: 1}
+	long := &fpb.File{Id: "long", Key: "long", Name: "b.pptx", Type: PptxMimeType, UpdatedAt: 1}
+
+	if got := preview(long); got != "PDF of presentation" {
+		t.Errorf("expected the preview to be streamed without a cache, got %q", got)
+	}
+
+	// "PDF of memo" fits in the cache, "PDF of presentation" doesn't.
+	r.previewCache = cache.NewLRU(int64(len("PDF of memo")))
+	for i := 0; i < 2; i++ {
+		if got := preview(short); got != "PDF of memo" {
+			t.Errorf("expected the cached preview, got %q", got)
+		}
+
+		if got := preview(long); got != "PDF of presentation" {
+			t.Errorf("expected the preview that's too large to be cached to be streamed, got %q", got)
+		}
+	}
+
+	if _, ok := r.previewCache.Get(previewKey(long)); ok {
+		t.Error("expected a preview that's too large not to be cached")
+	}
+
+	if conversions != 4 {
+		t.Errorf("expected the small preview to be converted once and the large one every time, got %d", conversions)
+	}
+}
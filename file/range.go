@@ -0,0 +1,400 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
+	"github.com/meateam/api-gateway/metrics"
+	dpb "github.com/meateam/download-service/proto"
+	fpb "github.com/meateam/file-service/proto/file"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// rangeUnit is the only range unit supported by downloads.
+	rangeUnit = "bytes"
+
+	// multipartByteRanges is the content type of a response of multiple ranges.
+	multipartByteRanges = "multipart/byteranges; boundary="
+
+	// maxMultiRangeRead is the maximum number of bytes read from the download stream to serve multiple ranges.
+	// The download service has no offset, so ranges are served by streaming the file from its first byte and
+	// discarding the bytes before and between the ranges.
+	maxMultiRangeRead = 256 << 20
+)
+
+var (
+	// errInvalidRange is returned when the Range header is malformed.
+	errInvalidRange = errors.New("invalid range")
+
+	// errUnsatisfiableRange is returned when none of the requested ranges overlap the file.
+	errUnsatisfiableRange = errors.New("none of the requested ranges overlap the file")
+
+	// errRangeReadExceeded is returned when serving multiple ranges requires reading more than
+	// maxMultiRangeRead bytes of the file.
+	errRangeReadExceeded = fmt.Errorf("multiple ranges may not end past byte %d of the file", maxMultiRangeRead)
+)
+
+// byteRange is a range of length bytes of a file, starting at start.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+// contentRange returns the Content-Range header value of r in a file of size bytes.
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("%s %d-%d/%d", rangeUnit, r.start, r.start+r.length-1, size)
+}
+
+// mimeHeader returns the header of the part of r in a multipart/byteranges response.
+func (r byteRange) mimeHeader(contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {r.contentRange(size)},
+		"Content-Type":  {contentType},
+	}
+}
+
+// parseRanges parses the Range header value of a file of size bytes.
+// Ranges that don't overlap the file are ignored. It returns nil if header is empty,
+// errInvalidRange if it's malformed, and errUnsatisfiableRange if none of its ranges overlap the file.
+func parseRanges(header string, size int64) ([]byteRange, error) {
+	if header == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(header, rangeUnit+"=") {
+		return nil, errInvalidRange
+	}
+
+	var ranges []byteRange
+	noOverlap := false
+	for _, spec := range strings.Split(header[len(rangeUnit)+1:], ",") {
+		spec = textproto.TrimString(spec)
+		if spec == "" {
+			continue
+		}
+
+		i := strings.Index(spec, "-")
+		if i < 0 {
+			return nil, errInvalidRange
+		}
+
+		start, end := textproto.TrimString(spec[:i]), textproto.TrimString(spec[i+1:])
+		var r byteRange
+		if start == "" {
+			// A suffix range of the last end bytes of the file.
+			length, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || length < 0 {
+				return nil, errInvalidRange
+			}
+
+			if length == 0 || size == 0 {
+				noOverlap = true
+				continue
+			}
+
+			if length > size {
+				length = size
+			}
+
+			r.start = size - length
+			r.length = length
+		} else {
+			first, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || first < 0 {
+				return nil, errInvalidRange
+			}
+
+			if first >= size {
+				noOverlap = true
+				continue
+			}
+
+			r.start = first
+			r.length = size - first
+			if end != "" {
+				last, err := strconv.ParseInt(end, 10, 64)
+				if err != nil || last < first {
+					return nil, errInvalidRange
+				}
+
+				if last < size-1 {
+					r.length = last - first + 1
+				}
+			}
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 && noOverlap {
+		return nil, errUnsatisfiableRange
+	}
+
+	return ranges, nil
+}
+
+// ascending reports whether ranges are in ascending order and don't overlap each other.
+// The download service streams files from their start, so only such ranges can be served from a single stream.
+func ascending(ranges []byteRange) bool {
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i].start < ranges[i-1].start+ranges[i-1].length {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fileETag returns the strong entity tag of the content of file, derived from its update time and size.
+func fileETag(file *fpb.File) string {
+	return fmt.Sprintf(`"%s-%s"`,
+		strconv.FormatInt(file.GetUpdatedAt(), 36),
+		strconv.FormatInt(file.GetSize(), 36),
+	)
+}
+
+// fileLastModified returns the last modification time of file, its update time is in milliseconds.
+func fileLastModified(file *fpb.File) time.Time {
+	return time.Unix(0, file.GetUpdatedAt()*int64(time.Millisecond)).UTC()
+}
+
+// etagMatches reports whether etag matches one of the entity tags listed in header,
+// using the weak comparison unless strong is true.
+func etagMatches(header string, etag string, strong bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = textproto.TrimString(tag)
+		if tag == "*" && !strong {
+			return true
+		}
+
+		if strong {
+			if tag == etag && !strings.HasPrefix(tag, "W/") {
+				return true
+			}
+
+			continue
+		}
+
+		if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified reports whether the conditional request req can be answered with 304 Not Modified
+// for content of etag that was last modified at lastModified.
+func notModified(req *http.Request, etag string, lastModified time.Time) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, etag, false)
+	}
+
+	ifModifiedSince, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.Truncate(time.Second).After(ifModifiedSince)
+}
+
+// requestRanges returns the ranges requested by req of content of size bytes, of etag
+// that was last modified at lastModified. It returns nil if the whole content should be served,
+// either because no ranges were requested, the If-Range precondition failed, or the ranges
+// can't be served from a single stream. Multiple ranges that end past maxMultiRangeRead bytes
+// are rejected with errRangeReadExceeded, since the whole file up to their end is read to serve them.
+func requestRanges(req *http.Request, size int64, etag string, lastModified time.Time) ([]byteRange, error) {
+	if req.Method != http.MethodGet {
+		return nil, nil
+	}
+
+	if ifRange := textproto.TrimString(req.Header.Get("If-Range")); ifRange != "" {
+		if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+			if !etagMatches(ifRange, etag, true) {
+				return nil, nil
+			}
+		} else if t, err := http.ParseTime(ifRange); err != nil || !t.Equal(lastModified.Truncate(time.Second)) {
+			return nil, nil
+		}
+	}
+
+	ranges, err := parseRanges(req.Header.Get("Range"), size)
+	if err != nil || !ascending(ranges) {
+		return nil, err
+	}
+
+	if last := len(ranges) - 1; last > 0 && ranges[last].start+ranges[last].length > maxMultiRangeRead {
+		return nil, errRangeReadExceeded
+	}
+
+	return ranges, nil
+}
+
+// rangeStreamer writes ranges of a file from its download stream, discarding the bytes between them.
+// The stream starts at the first byte of the file, so serving a range reads every byte before its end.
+type rangeStreamer struct {
+	stream dpb.Download_DownloadClient
+	flush  func()
+
+	// chunk is the received part of the file that's not consumed yet, starting at offset.
+	chunk  []byte
+	offset int64
+}
+
+// receive receives the next chunk of the file from the stream.
+func (s *rangeStreamer) receive() error {
+	s.offset += int64(len(s.chunk))
+	s.chunk = nil
+	res, err := s.stream.Recv()
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.chunk = res.GetFile()
+
+	return nil
+}
+
+// copyRange writes the bytes of r to w. r must not start before the bytes that were already consumed.
+func (s *rangeStreamer) copyRange(w io.Writer, r byteRange) error {
+	end := r.start + r.length
+	for s.offset < end {
+		chunkEnd := s.offset + int64(len(s.chunk))
+		if len(s.chunk) == 0 || chunkEnd <= r.start {
+			if err := s.receive(); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		from := int64(0)
+		if r.start > s.offset {
+			from = r.start - s.offset
+		}
+
+		to := int64(len(s.chunk))
+		if end < chunkEnd {
+			to = end - s.offset
+		}
+
+		written, err := w.Write(s.chunk[from:to])
+		metrics.AddStreamedBytes(metrics.DirectionDownload, written)
+		if err != nil {
+			return err
+		}
+
+		s.flush()
+		s.offset += to
+		s.chunk = s.chunk[to:]
+	}
+
+	return nil
+}
+
+// handleRangeStream streams ranges of the file from stream to c, with a 206 Partial Content status.
+// A single range is written as is, multiple ranges are written as a multipart/byteranges body.
+// ranges must be in ascending order and not overlap each other.
+func handleRangeStream(
+	c *gin.Context,
+	stream dpb.Download_DownloadClient,
+	ranges []byteRange,
+	contentType string,
+	size int64) error {
+	s := &rangeStreamer{stream: stream, flush: c.Writer.Flush}
+
+	// Receive the first chunk before writing any header, so a failing stream can still be responded with an error.
+	if err := s.receive(); err != nil {
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		if err == io.ErrUnexpectedEOF {
+			httpStatusCode = http.StatusBadGateway
+		}
+
+		if err := apierror.Abort(c, httpStatusCode, err); err != nil {
+			return err
+		}
+
+		return stream.CloseSend()
+	}
+
+	if len(ranges) == 1 {
+		c.Header("Content-Range", ranges[0].contentRange(size))
+		c.Header("Content-Length", strconv.FormatInt(ranges[0].length, 10))
+		c.Header("Content-Type", contentType)
+		c.Status(http.StatusPartialContent)
+
+		if err := s.copyRange(c.Writer, ranges[0]); err != nil {
+			return err
+		}
+
+		return stream.CloseSend()
+	}
+
+	mw := multipart.NewWriter(c.Writer)
+	c.Header("Content-Length", strconv.FormatInt(multipartLength(ranges, contentType, size, mw.Boundary()), 10))
+	c.Header("Content-Type", multipartByteRanges+mw.Boundary())
+	c.Status(http.StatusPartialContent)
+
+	for _, r := range ranges {
+		part, err := mw.CreatePart(r.mimeHeader(contentType, size))
+		if err != nil {
+			return err
+		}
+
+		if err := s.copyRange(part, r); err != nil {
+			return err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	return stream.CloseSend()
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter int64
+
+// Write counts the bytes of p.
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+
+	return len(p), nil
+}
+
+// multipartLength returns the length of the multipart/byteranges body of ranges with boundary.
+func multipartLength(ranges []byteRange, contentType string, size int64, boundary string) int64 {
+	var w countingWriter
+	mw := multipart.NewWriter(&w)
+	_ = mw.SetBoundary(boundary)
+
+	length := int64(0)
+	for _, r := range ranges {
+		_, _ = mw.CreatePart(r.mimeHeader(contentType, size))
+		length += r.length
+	}
+
+	_ = mw.Close()
+
+	return length + int64(w)
+}
@@ -0,0 +1,343 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dpb "github.com/meateam/download-service/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeDownloadStream streams content in chunks of chunkSize bytes.
+type fakeDownloadStream struct {
+	grpc.ClientStream
+	content   string
+	chunkSize int
+	err       error
+}
+
+func (s *fakeDownloadStream) Recv() (*dpb.DownloadResponse, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	if s.content == "" {
+		return nil, io.EOF
+	}
+
+	n := s.chunkSize
+	if n > len(s.content) {
+		n = len(s.content)
+	}
+
+	chunk := s.content[:n]
+	s.content = s.content[n:]
+
+	return &dpb.DownloadResponse{File: []byte(chunk)}, nil
+}
+
+func (s *fakeDownloadStream) CloseSend() error {
+	return nil
+}
+
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []byteRange
+		err    error
+	}{
+		{header: "", want: nil},
+		{header: "bytes=0-4", want: []byteRange{{0, 5}}},
+		{header: "bytes=5-", want: []byteRange{{5, 5}}},
+		{header: "bytes=-3", want: []byteRange{{7, 3}}},
+		{header: "bytes=-20", want: []byteRange{{0, 10}}},
+		{header: "bytes=8-20", want: []byteRange{{8, 2}}},
+		{header: "bytes=0-1, 4-5", want: []byteRange{{0, 2}, {4, 2}}},
+		{header: "bytes=0-1,20-30", want: []byteRange{{0, 2}}},
+		{header: "bytes=10-", err: errUnsatisfiableRange},
+		{header: "bytes=-0", err: errUnsatisfiableRange},
+		{header: "bytes=5-4", err: errInvalidRange},
+		{header: "bytes=a-4", err: errInvalidRange},
+		{header: "bytes=4", err: errInvalidRange},
+		{header: "items=0-4", err: errInvalidRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got, err := parseRanges(tt.header, 10)
+			if err != tt.err {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected ranges %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRequestRanges(t *testing.T) {
+	const etag = `"abc-a"`
+	lastModified := time.Date(2021, 7, 25, 10, 0, 0, 500, time.UTC)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    []byteRange
+	}{
+		{
+			name:    "range",
+			headers: map[string]string{"Range": "bytes=0-4"},
+			want:    []byteRange{{0, 5}},
+		},
+		{
+			name:    "matching If-Range entity tag",
+			headers: map[string]string{"Range": "bytes=0-4", "If-Range": etag},
+			want:    []byteRange{{0, 5}},
+		},
+		{
+			name:    "weak If-Range entity tag",
+			headers: map[string]string{"Range": "bytes=0-4", "If-Range": "W/" + etag},
+		},
+		{
+			name:    "stale If-Range entity tag",
+			headers: map[string]string{"Range": "bytes=0-4", "If-Range": `"old"`},
+		},
+		{
+			name:    "matching If-Range date",
+			headers: map[string]string{"Range": "bytes=0-4", "If-Range": lastModified.Format(http.TimeFormat)},
+			want:    []byteRange{{0, 5}},
+		},
+		{
+			name: "stale If-Range date",
+			headers: map[string]string{
+				"Range":    "bytes=0-4",
+				"If-Range": lastModified.Add(-time.Hour).Format(http.TimeFormat),
+			},
+		},
+		{
+			name:    "descending ranges",
+			headers: map[string]string{"Range": "bytes=5-6,0-1"},
+		},
+		{
+			name:    "overlapping ranges",
+			headers: map[string]string{"Range": "bytes=0-5,4-6"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/files/1?alt=media", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			got, err := requestRanges(req, 10, etag, lastModified)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected ranges %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRequestRanges_ReadExceeded(t *testing.T) {
+	const size = 2 * maxMultiRangeRead
+	req := httptest.NewRequest(http.MethodGet, "/api/files/1?alt=media", nil)
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", maxMultiRangeRead))
+	if _, err := requestRanges(req, size, `"abc"`, time.Time{}); err != nil {
+		t.Errorf("expected a single range past the bound to be served, got %v", err)
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-1,%d-%d", maxMultiRangeRead-2, maxMultiRangeRead-1))
+	if _, err := requestRanges(req, size, `"abc"`, time.Time{}); err != nil {
+		t.Errorf("expected multiple ranges within the bound to be served, got %v", err)
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-1,%d-%d", maxMultiRangeRead-1, maxMultiRangeRead))
+	if _, err := requestRanges(req, size, `"abc"`, time.Time{}); err != errRangeReadExceeded {
+		t.Errorf("expected multiple ranges past the bound to be rejected, got %v", err)
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	const etag = `"abc-a"`
+	lastModified := time.Date(2021, 7, 25, 10, 0, 0, 500, time.UTC)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{name: "unconditional", want: false},
+		{name: "matching entity tag", headers: map[string]string{"If-None-Match": `"x", ` + etag}, want: true},
+		{name: "weak entity tag", headers: map[string]string{"If-None-Match": "W/" + etag}, want: true},
+		{name: "any entity tag", headers: map[string]string{"If-None-Match": "*"}, want: true},
+		{name: "stale entity tag", headers: map[string]string{"If-None-Match": `"old"`}, want: false},
+		{
+			name: "entity tag takes precedence over date",
+			headers: map[string]string{
+				"If-None-Match":     `"old"`,
+				"If-Modified-Since": lastModified.Format(http.TimeFormat),
+			},
+			want: false,
+		},
+		{
+			name:    "not modified since",
+			headers: map[string]string{"If-Modified-Since": lastModified.Format(http.TimeFormat)},
+			want:    true,
+		},
+		{
+			name:    "modified since",
+			headers: map[string]string{"If-Modified-Since": lastModified.Add(-time.Hour).Format(http.TimeFormat)},
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/files/1?alt=media", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			if got := notModified(req, etag, lastModified); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// serveRanges serves ranges of the content of stream, and returns the response and the streaming error.
+func serveRanges(stream *fakeDownloadStream, ranges []byteRange) (*httptest.ResponseRecorder, error) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/files/1?alt=media", nil)
+
+	err := handleRangeStream(c, stream, ranges, "text/plain", int64(len(stream.content)))
+	c.Writer.WriteHeaderNow()
+
+	return w, err
+}
+
+func TestHandleRangeStream_SingleRange(t *testing.T) {
+	stream := &fakeDownloadStream{content: "0123456789abcdefghij", chunkSize: 3}
+	w, err := serveRanges(stream, []byteRange{{5, 8}})
+	if err != nil {
+		t.Fatalf("failed streaming ranges: %v", err)
+	}
+
+	if w.Code != http.StatusPartialContent {
+		t.Errorf("expected status %d, got %d", http.StatusPartialContent, w.Code)
+	}
+
+	if got := w.Header().Get("Content-Range"); got != "bytes 5-12/20" {
+		t.Errorf("expected Content-Range bytes 5-12/20, got %s", got)
+	}
+
+	if got := w.Header().Get("Content-Length"); got != "8" {
+		t.Errorf("expected Content-Length 8, got %s", got)
+	}
+
+	if got := w.Body.String(); got != "56789abc" {
+		t.Errorf("expected body 56789abc, got %s", got)
+	}
+}
+
+func TestHandleRangeStream_MultipleRanges(t *testing.T) {
+	stream := &fakeDownloadStream{content: "0123456789abcdefghij", chunkSize: 4}
+	w, err := serveRanges(stream, []byteRange{{1, 2}, {6, 7}, {18, 2}})
+	if err != nil {
+		t.Fatalf("failed streaming ranges: %v", err)
+	}
+
+	if w.Code != http.StatusPartialContent {
+		t.Errorf("expected status %d, got %d", http.StatusPartialContent, w.Code)
+	}
+
+	if got, want := w.Header().Get("Content-Length"), w.Body.Len(); got != strconv.Itoa(want) {
+		t.Errorf("expected Content-Length %d, got %s", want, got)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("expected multipart/byteranges content type, got %s", w.Header().Get("Content-Type"))
+	}
+
+	want := []struct {
+		contentRange string
+		body         string
+	}{
+		{"bytes 1-2/20", "12"},
+		{"bytes 6-12/20", "6789abc"},
+		{"bytes 18-19/20", "ij"},
+	}
+
+	reader := multipart.NewReader(strings.NewReader(w.Body.String()), params["boundary"])
+	for _, part := range want {
+		p, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("failed reading part: %v", err)
+		}
+
+		if got := p.Header.Get("Content-Range"); got != part.contentRange {
+			t.Errorf("expected Content-Range %s, got %s", part.contentRange, got)
+		}
+
+		body, _ := ioutil.ReadAll(p)
+		if string(body) != part.body {
+			t.Errorf("expected part %s, got %s", part.body, body)
+		}
+	}
+
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("expected %d parts, got error %v", len(want), err)
+	}
+}
+
+func TestHandleRangeStream_StreamError(t *testing.T) {
+	stream := &fakeDownloadStream{content: "0123456789", chunkSize: 4, err: status.Error(codes.NotFound, "not found")}
+	w, err := serveRanges(stream, []byteRange{{1, 2}})
+	if err == nil {
+		t.Error("expected the stream's error")
+	}
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	if w.Header().Get("Content-Range") != "" {
+		t.Error("expected no Content-Range header on error")
+	}
+}
+
+func TestDownloadReader(t *testing.T) {
+	content := "the quick brown fox"
+	got := &bytes.Buffer{}
+	if _, err := io.Copy(got, NewDownloadReader(&fakeDownloadStream{content: content, chunkSize: 4})); err != nil {
+		t.Fatalf("failed reading download: %v", err)
+	}
+
+	if got.String() != content {
+		t.Errorf("expected content %q, got %q", content, got.String())
+	}
+}
@@ -0,0 +1,389 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	fpb "github.com/meateam/file-service/proto/file"
+	ppb "github.com/meateam/permission-service/proto"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ConfigPermissionConcurrency is the name of the environment variable containing
+	// the maximum number of files that a PermissionResolver resolves concurrently.
+	ConfigPermissionConcurrency = "permission_resolver_concurrency"
+
+	// ConfigPermissionCacheTTL is the name of the environment variable containing
+	// the seconds that resolved permissions are cached for, permissions aren't cached if it's 0.
+	ConfigPermissionCacheTTL = "permission_cache_ttl"
+)
+
+// ResolvedPermission is the role of a user to a file, as resolved by a PermissionResolver.
+type ResolvedPermission struct {
+	// File is the resolved file, nil if it couldn't be fetched.
+	File *fpb.File
+
+	// Role is the role of the user to the file, "" if the user isn't permitted to it.
+	Role string
+
+	// Permission is the permission that permits the user to the file, nil if the user owns it.
+	Permission *ppb.PermissionObject
+
+	// Err is the error encountered resolving the permission, if any.
+	Err error
+}
+
+// permissionWalk is the result of searching for a permission to a file and up its ancestors,
+// done is closed once the result is set.
+type permissionWalk struct {
+	done       chan struct{}
+	role       string
+	permission *ppb.PermissionObject
+	err        error
+}
+
+// PermissionResolver resolves the roles of a user to many files, with the same result as calling
+// CheckUserFilePermission for each of them. The search for a permission up the ancestors of each file
+// is done once per ancestor, so the files of a folder share the lookups of the folder's ancestors.
+// A PermissionResolver lives for a single request, since it doesn't see permissions changed after a lookup.
+type PermissionResolver struct {
+	fileClient       fpb.FileServiceClient
+	permissionClient ppb.PermissionClient
+	cache            *PermissionCache
+	userID           string
+	role             ppb.Role
+	concurrency      int
+
+	mu    sync.Mutex
+	files map[string]*fpb.File
+	walks map[string]*permissionWalk
+}
+
+// NewPermissionResolver creates a PermissionResolver of the roles of userID with role to files.
+// If cache is non-nil then resolved permissions are read from and written to it.
+func NewPermissionResolver(
+	fileClient fpb.FileServiceClient,
+	permissionClient ppb.PermissionClient,
+	cache *PermissionCache,
+	userID string,
+	role ppb.Role) *PermissionResolver {
+	concurrency := viper.GetInt(ConfigPermissionConcurrency)
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	return &PermissionResolver{
+		fileClient:       fileClient,
+		permissionClient: permissionClient,
+		cache:            cache,
+		userID:           userID,
+		role:             role,
+		concurrency:      concurrency,
+		files:            make(map[string]*fpb.File),
+		walks:            make(map[string]*permissionWalk),
+	}
+}
+
+// ResolveFiles resolves the roles to files, returning the results in the order of files.
+func (r *PermissionResolver) ResolveFiles(ctx context.Context, files []*fpb.File) []ResolvedPermission {
+	r.mu.Lock()
+	for _, file := range files {
+		r.files[file.GetId()] = file
+	}
+	r.mu.Unlock()
+
+	ids := make([]string, 0, len(files))
+	for _, file := range files {
+		ids = append(ids, file.GetId())
+	}
+
+	return r.ResolveIDs(ctx, ids)
+}
+
+// ResolveIDs resolves the roles to the files of fileIDs, fetching the files that weren't
+// given to ResolveFiles, and returns the results in the order of fileIDs.
+// At most the configured concurrency of files are resolved at once, the files that weren't resolved
+// before ctx is done are resolved with its error.
+func (r *PermissionResolver) ResolveIDs(ctx context.Context, fileIDs []string) []ResolvedPermission {
+	resolved := make([]ResolvedPermission, len(fileIDs))
+	sem := make(chan struct{}, r.concurrency)
+
+	var wg sync.WaitGroup
+	for i, fileID := range fileIDs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			for j := i; j < len(fileIDs); j++ {
+				resolved[j] = ResolvedPermission{Err: ctx.Err()}
+			}
+
+			wg.Wait()
+
+			return resolved
+		}
+
+		wg.Add(1)
+		go func(i int, fileID string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			resolved[i] = r.resolve(ctx, fileID)
+		}(i, fileID)
+	}
+
+	wg.Wait()
+
+	return resolved
+}
+
+// resolve resolves the role to fileID.
+func (r *PermissionResolver) resolve(ctx context.Context, fileID string) ResolvedPermission {
+	if r.userID == "" {
+		return ResolvedPermission{Err: fmt.Errorf("userID is required")}
+	}
+
+	// Everyone is permitted to their root, as in CheckUserFilePermission.
+	if fileID == "" {
+		return ResolvedPermission{Role: OwnerRole}
+	}
+
+	file, err := r.file(ctx, fileID)
+	if err != nil {
+		return ResolvedPermission{Err: err}
+	}
+
+	if file.GetOwnerID() == r.userID {
+		return ResolvedPermission{File: file, Role: OwnerRole}
+	}
+
+	if role, permission, ok := r.cache.get(r.userID, fileID, r.role); ok {
+		return ResolvedPermission{File: file, Role: role, Permission: permission}
+	}
+
+	walk := r.walk(ctx, fileID)
+	if walk.err == nil {
+		r.cache.set(r.userID, fileID, r.role, walk.role, walk.permission)
+	}
+
+	return ResolvedPermission{File: file, Role: walk.role, Permission: walk.permission, Err: walk.err}
+}
+
+// file returns the file of fileID, fetching it if it wasn't already.
+func (r *PermissionResolver) file(ctx context.Context, fileID string) (*fpb.File, error) {
+	r.mu.Lock()
+	file, ok := r.files[fileID]
+	r.mu.Unlock()
+	if ok {
+		return file, nil
+	}
+
+	file, err := r.fileClient.GetFileByID(ctx, &fpb.GetByFileByIDRequest{Id: fileID})
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.files[fileID] = file
+	r.mu.Unlock()
+
+	return file, nil
+}
+
+// walk returns the result of searching for a permission of the user to fileID and then up its ancestors.
+// Each fileID is searched once, concurrent walks to the same fileID wait for the first one.
+func (r *PermissionResolver) walk(ctx context.Context, fileID string) *permissionWalk {
+	r.mu.Lock()
+	if walk, ok := r.walks[fileID]; ok {
+		r.mu.Unlock()
+		<-walk.done
+
+		return walk
+	}
+
+	walk := &permissionWalk{done: make(chan struct{})}
+	r.walks[fileID] = walk
+	r.mu.Unlock()
+
+	walk.role, walk.permission, walk.err = r.lookup(ctx, fileID)
+	close(walk.done)
+
+	return walk
+}
+
+// lookup searches for a permission of the user to fileID, and walks to its parent if there's none.
+// If reached the root without finding a permission then the user isn't permitted.
+func (r *PermissionResolver) lookup(ctx context.Context, fileID string) (string, *ppb.PermissionObject, error) {
+	if fileID == "" {
+		return "", nil, nil
+	}
+
+	isPermitted, err := r.permissionClient.IsPermitted(ctx,
+		&ppb.IsPermittedRequest{FileID: fileID, UserID: r.userID, Role: r.role})
+	if err != nil && status.Code(err) != codes.NotFound {
+		return "", nil, err
+	}
+
+	if !isPermitted.GetPermitted() && err == nil {
+		return "", nil, nil
+	}
+
+	if isPermitted.GetPermitted() {
+		permission, err := r.permissionClient.GetPermission(
+			ctx,
+			&ppb.GetPermissionRequest{FileID: fileID, UserID: r.userID},
+		)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return permission.GetRole().String(), permission, nil
+	}
+
+	file, err := r.file(ctx, fileID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	walk := r.walk(ctx, file.GetParent())
+
+	return walk.role, walk.permission, walk.err
+}
+
+// permissionCacheKey is the key of a resolved permission of a user in a PermissionCache.
+type permissionCacheKey struct {
+	fileID string
+	role   ppb.Role
+}
+
+// permissionCacheEntry is a resolved permission in a PermissionCache.
+type permissionCacheEntry struct {
+	role       string
+	permission *ppb.PermissionObject
+	expires    time.Time
+}
+
+// PermissionCache caches the resolved permissions of users to files for a short time.
+// The permissions of a user should be invalidated whenever a permission of the user is changed.
+// The cache is in the memory of each gateway instance, so a permission that's changed through another
+// instance is only seen once the cached permissions expire.
+// A nil *PermissionCache caches nothing.
+type PermissionCache struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	users     map[string]map[permissionCacheKey]permissionCacheEntry
+	lastSweep time.Time
+}
+
+// NewPermissionCache creates a PermissionCache that caches permissions for ttl.
+// Returns nil if ttl isn't positive.
+func NewPermissionCache(ttl time.Duration) *PermissionCache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	return &PermissionCache{
+		ttl:       ttl,
+		users:     make(map[string]map[permissionCacheKey]permissionCacheEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// get returns the cached resolved permission of userID to fileID with role, ok is false if it isn't cached.
+func (c *PermissionCache) get(userID string, fileID string, role ppb.Role) (string, *ppb.PermissionObject, bool) {
+	if c == nil {
+		return "", nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.users[userID][permissionCacheKey{fileID: fileID, role: role}]
+	if !ok || time.Now().After(entry.expires) {
+		return "", nil, false
+	}
+
+	return entry.role, entry.permission, true
+}
+
+// set caches the resolved permission of userID to fileID with role.
+// Expired permissions of all of the users are removed once every ttl.
+func (c *PermissionCache) set(
+	userID string,
+	fileID string,
+	role ppb.Role,
+	resolvedRole string,
+	permission *ppb.PermissionObject) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) > c.ttl {
+		c.sweep(now)
+	}
+
+	entries, ok := c.users[userID]
+	if !ok {
+		entries = make(map[permissionCacheKey]permissionCacheEntry)
+		c.users[userID] = entries
+	}
+
+	entries[permissionCacheKey{fileID: fileID, role: role}] = permissionCacheEntry{
+		role:       resolvedRole,
+		permission: permission,
+		expires:    now.Add(c.ttl),
+	}
+}
+
+// sweep removes the permissions that expired before now, c.mu must be held.
+func (c *PermissionCache) sweep(now time.Time) {
+	for userID, entries := range c.users {
+		for key, entry := range entries {
+			if now.After(entry.expires) {
+				delete(entries, key)
+			}
+		}
+
+		if len(entries) == 0 {
+			delete(c.users, userID)
+		}
+	}
+
+	c.lastSweep = now
+}
+
+// InvalidateUser removes the cached permissions of userID, since a permission of userID
+// to a folder changes the resolved permissions to all of the folder's descendants.
+func (c *PermissionCache) InvalidateUser(userID string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.users, userID)
+}
+
+// Invalidate removes all of the cached permissions, since moving a file changes the resolved
+// permissions of all of the users to the file and its descendants.
+func (c *PermissionCache) Invalidate() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.users = make(map[string]map[permissionCacheKey]permissionCacheEntry)
+}
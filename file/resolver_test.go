@@ -0,0 +1,382 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fpb "github.com/meateam/file-service/proto/file"
+	ppb "github.com/meateam/permission-service/proto"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeTree is a file service and a permission service of a tree of files,
+// that take latency to respond to each call.
+type fakeTree struct {
+	files       map[string]*fpb.File
+	permissions map[string]ppb.Role
+	latency     time.Duration
+	calls       int64
+}
+
+func (t *fakeTree) call() {
+	atomic.AddInt64(&t.calls, 1)
+	time.Sleep(t.latency)
+}
+
+func permissionKey(fileID string, userID string) string {
+	return fileID + "/" + userID
+}
+
+// fakeTreeFileClient is the file service of a fakeTree.
+type fakeTreeFileClient struct {
+	fpb.FileServiceClient
+	*fakeTree
+}
+
+func (f fakeTreeFileClient) GetFileByID(
+	_ context.Context,
+	in *fpb.GetByFileByIDRequest,
+	_ ...grpc.CallOption) (*fpb.File, error) {
+	f.call()
+	file, ok := f.files[in.GetId()]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "file not found")
+	}
+
+	return file, nil
+}
+
+// fakeTreePermissionClient is the permission service of a fakeTree, a WRITE permission permits READ.
+type fakeTreePermissionClient struct {
+	ppb.PermissionClient
+	*fakeTree
+}
+
+func (p fakeTreePermissionClient) IsPermitted(
+	_ context.Context,
+	in *ppb.IsPermittedRequest,
+	_ ...grpc.CallOption) (*ppb.IsPermittedResponse, error) {
+	p.call()
+	role, ok := p.permissions[permissionKey(in.GetFileID(), in.GetUserID())]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "permission not found")
+	}
+
+	return &ppb.IsPermittedResponse{Permitted: role == in.GetRole() || role == ppb.Role_WRITE}, nil
+}
+
+func (p fakeTreePermissionClient) GetPermission(
+	_ context.Context,
+	in *ppb.GetPermissionRequest,
+	_ ...grpc.CallOption) (*ppb.PermissionObject, error) {
+	p.call()
+	role, ok := p.permissions[permissionKey(in.GetFileID(), in.GetUserID())]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "permission not found")
+	}
+
+	return &ppb.PermissionObject{FileID: in.GetFileID(), UserID: in.GetUserID(), Role: role}, nil
+}
+
+func (f fakeTreeFileClient) GetDescendantsByID(
+	_ context.Context,
+	_ *fpb.GetDescendantsByIDRequest,
+	_ ...grpc.CallOption) (*fpb.GetDescendantsByIDResponse, error) {
+	return &fpb.GetDescendantsByIDResponse{}, nil
+}
+
+func (f fakeTreeFileClient) GetFilesByFolder(
+	_ context.Context,
+	in *fpb.GetFilesByFolderRequest,
+	_ ...grpc.CallOption) (*fpb.GetFilesByFolderResponse, error) {
+	var files []*fpb.File
+	for _, file := range f.files {
+		if file.GetParent() == in.GetFolderID() {
+			files = append(files, file)
+		}
+	}
+
+	return &fpb.GetFilesByFolderResponse{Files: files}, nil
+}
+
+func (f fakeTreeFileClient) UpdateFiles(
+	_ context.Context,
+	_ *fpb.UpdateFilesRequest,
+	_ ...grpc.CallOption) (*fpb.UpdateFilesResponse, error) {
+	return &fpb.UpdateFilesResponse{}, nil
+}
+
+func (p fakeTreePermissionClient) DeletePermission(
+	_ context.Context,
+	in *ppb.DeletePermissionRequest,
+	_ ...grpc.CallOption) (*ppb.PermissionObject, error) {
+	delete(p.permissions, permissionKey(in.GetFileID(), in.GetUserID()))
+
+	return &ppb.PermissionObject{FileID: in.GetFileID(), UserID: in.GetUserID()}, nil
+}
+
+// newFakeTree creates a fakeTree of the folders a/b/c owned by "owner", with a READ permission of "user" to a,
+// and children files in c.
+func newFakeTree(children int, latency time.Duration) *fakeTree {
+	tree := &fakeTree{
+		files: map[string]*fpb.File{
+			"a": {Id: "a", OwnerID: "owner", Type: FolderMimeType},
+			"b": {Id: "b", OwnerID: "owner", Type: FolderMimeType, FileOrId: &fpb.File_Parent{Parent: "a"}},
+			"c": {Id: "c", OwnerID: "owner", Type: FolderMimeType, FileOrId: &fpb.File_Parent{Parent: "b"}},
+		},
+		permissions: map[string]ppb.Role{permissionKey("a", "user"): ppb.Role_READ},
+		latency:     latency,
+	}
+
+	for i := 0; i < children; i++ {
+		id := fmt.Sprintf("child-%d", i)
+		tree.files[id] = &fpb.File{Id: id, OwnerID: "owner", FileOrId: &fpb.File_Parent{Parent: "c"}}
+	}
+
+	return tree
+}
+
+// children returns the files in c.
+func (t *fakeTree) children() []*fpb.File {
+	files := make([]*fpb.File, 0, len(t.files))
+	for _, file := range t.files {
+		if file.GetParent() == "c" {
+			files = append(files, file)
+		}
+	}
+
+	return files
+}
+
+func TestPermissionResolver_MatchesCheckUserFilePermission(t *testing.T) {
+	tree := newFakeTree(2, 0)
+	tree.files["mine"] = &fpb.File{Id: "mine", OwnerID: "user", FileOrId: &fpb.File_Parent{Parent: "c"}}
+	tree.files["direct"] = &fpb.File{Id: "direct", OwnerID: "owner", FileOrId: &fpb.File_Parent{Parent: "c"}}
+	tree.files["private"] = &fpb.File{Id: "private", OwnerID: "owner"}
+	tree.permissions[permissionKey("direct", "user")] = ppb.Role_WRITE
+
+	fileIDs := []string{"", "a", "c", "child-0", "child-1", "mine", "direct", "private", "missing"}
+	fileClient := fakeTreeFileClient{fakeTree: tree}
+	permissionClient := fakeTreePermissionClient{fakeTree: tree}
+
+	for _, role := range []ppb.Role{ppb.Role_READ, ppb.Role_WRITE} {
+		resolved := NewPermissionResolver(fileClient, permissionClient, nil, "user", role).
+			ResolveIDs(context.Background(), fileIDs)
+
+		for i, fileID := range fileIDs {
+			wantRole, wantPermission, wantErr := CheckUserFilePermission(
+				context.Background(),
+				fileClient,
+				permissionClient,
+				"user",
+				fileID,
+				role,
+			)
+
+			got := resolved[i]
+			if got.Role != wantRole || !reflect.DeepEqual(got.Permission, wantPermission) ||
+				(got.Err == nil) != (wantErr == nil) {
+				t.Errorf("%s with %s: expected (%q, %v, %v), got (%q, %v, %v)",
+					fileID, role, wantRole, wantPermission, wantErr, got.Role, got.Permission, got.Err)
+			}
+		}
+	}
+}
+
+func TestPermissionResolver_SharesAncestorLookups(t *testing.T) {
+	tree := newFakeTree(50, 0)
+	resolver := NewPermissionResolver(
+		fakeTreeFileClient{fakeTree: tree},
+		fakeTreePermissionClient{fakeTree: tree},
+		nil,
+		"user",
+		ppb.Role_READ,
+	)
+
+	for _, resolved := range resolver.ResolveFiles(context.Background(), tree.children()) {
+		if resolved.Err != nil || resolved.Role != ppb.Role_READ.String() {
+			t.Fatalf("expected role %s, got %q with error %v", ppb.Role_READ, resolved.Role, resolved.Err)
+		}
+	}
+
+	// A lookup of each child, then the lookups of c, b and a and the fetches of c and b once.
+	if want := int64(50 + 3 + 1 + 2); tree.calls != want {
+		t.Errorf("expected %d calls, got %d", want, tree.calls)
+	}
+}
+
+func TestPermissionCache(t *testing.T) {
+	tree := newFakeTree(10, 0)
+	cache := NewPermissionCache(time.Minute)
+	resolve := func() []ResolvedPermission {
+		return NewPermissionResolver(
+			fakeTreeFileClient{fakeTree: tree},
+			fakeTreePermissionClient{fakeTree: tree},
+			cache,
+			"user",
+			ppb.Role_READ,
+		).ResolveFiles(context.Background(), tree.children())
+	}
+
+	resolve()
+	calls := tree.calls
+	resolve()
+	if tree.calls != calls {
+		t.Errorf("expected cached permissions not to be looked up, got %d calls", tree.calls-calls)
+	}
+
+	delete(tree.permissions, permissionKey("a", "user"))
+	cache.InvalidateUser("user")
+	for _, resolved := range resolve() {
+		if resolved.Role != "" {
+			t.Fatalf("expected the deleted permission not to permit, got role %q", resolved.Role)
+		}
+	}
+
+	tree.permissions[permissionKey("a", "user")] = ppb.Role_READ
+	cache.Invalidate()
+	for _, resolved := range resolve() {
+		if resolved.Role != ppb.Role_READ.String() {
+			t.Fatalf("expected the permissions to be resolved again once invalidated, got role %q", resolved.Role)
+		}
+	}
+
+	if NewPermissionCache(0) != nil {
+		t.Error("expected no cache when ttl is 0")
+	}
+}
+
+func TestDeleteFile_InvalidatesPermissionCache(t *testing.T) {
+	tree := newFakeTree(10, 0)
+	cache := NewPermissionCache(time.Minute)
+	resolve := func() []ResolvedPermission {
+		return NewPermissionResolver(
+			fakeTreeFileClient{fakeTree: tree},
+			fakeTreePermissionClient{fakeTree: tree},
+			cache,
+			"user",
+			ppb.Role_READ,
+		).ResolveFiles(context.Background(), tree.children())
+	}
+
+	resolve()
+
+	_, err := DeleteFile(
+		context.Background(),
+		logrus.New(),
+		fakeTreeFileClient{fakeTree: tree},
+		nil,
+		nil,
+		fakeTreePermissionClient{fakeTree: tree},
+		cache,
+		"a",
+		"user",
+	)
+	if err != nil {
+		t.Fatalf("failed deleting the shared folder: %v", err)
+	}
+
+	for _, resolved := range resolve() {
+		if resolved.Role != "" {
+			t.Fatalf("expected the removed shared folder not to permit its descendants, got role %q", resolved.Role)
+		}
+	}
+}
+
+func TestPermissionResolver_ResolveIDsCanceled(t *testing.T) {
+	concurrency := viper.Get(ConfigPermissionConcurrency)
+	defer viper.Set(ConfigPermissionConcurrency, concurrency)
+	viper.Set(ConfigPermissionConcurrency, 1)
+
+	tree := newFakeTree(20, 10*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Millisecond)
+	defer cancel()
+
+	ids := make([]string, 0, 20)
+	for _, file := range tree.children() {
+		ids = append(ids, file.GetId())
+	}
+
+	start := time.Now()
+	resolved := NewPermissionResolver(
+		fakeTreeFileClient{fakeTree: tree},
+		fakeTreePermissionClient{fakeTree: tree},
+		nil,
+		"user",
+		ppb.Role_READ,
+	).ResolveIDs(ctx, ids)
+
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("expected resolving to stop once the context is done, took %v", elapsed)
+	}
+
+	if err := resolved[len(resolved)-1].Err; err != context.DeadlineExceeded {
+		t.Errorf("expected the unresolved files to fail with the context's error, got %v", err)
+	}
+}
+
+// benchmarkListing benchmarks resolving the permissions of a listing of 200 files,
+// where each call to the fake backends takes 50µs.
+func benchmarkListing(b *testing.B, resolve func(tree *fakeTree, files []*fpb.File)) {
+	concurrency := viper.Get(ConfigPermissionConcurrency)
+	defer viper.Set(ConfigPermissionConcurrency, concurrency)
+	viper.Set(ConfigPermissionConcurrency, 16)
+
+	tree := newFakeTree(200, 50*time.Microsecond)
+	files := tree.children()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resolve(tree, files)
+	}
+
+	b.ReportMetric(float64(tree.calls)/float64(b.N), "calls/op")
+}
+
+func BenchmarkListing_CheckUserFilePermission(b *testing.B) {
+	benchmarkListing(b, func(tree *fakeTree, files []*fpb.File) {
+		for _, file := range files {
+			_, _, _ = CheckUserFilePermission(
+				context.Background(),
+				fakeTreeFileClient{fakeTree: tree},
+				fakeTreePermissionClient{fakeTree: tree},
+				"user",
+				file.GetId(),
+				ppb.Role_READ,
+			)
+		}
+	})
+}
+
+func BenchmarkListing_PermissionResolver(b *testing.B) {
+	benchmarkListing(b, func(tree *fakeTree, files []*fpb.File) {
+		NewPermissionResolver(
+			fakeTreeFileClient{fakeTree: tree},
+			fakeTreePermissionClient{fakeTree: tree},
+			nil,
+			"user",
+			ppb.Role_READ,
+		).ResolveFiles(context.Background(), files)
+	})
+}
+
+func BenchmarkListing_PermissionResolverCached(b *testing.B) {
+	cache := NewPermissionCache(time.Minute)
+	benchmarkListing(b, func(tree *fakeTree, files []*fpb.File) {
+		NewPermissionResolver(
+			fakeTreeFileClient{fakeTree: tree},
+			fakeTreePermissionClient{fakeTree: tree},
+			cache,
+			"user",
+			ppb.Role_READ,
+		).ResolveFiles(context.Background(), files)
+	})
+}
@@ -1,23 +1,22 @@
 package file
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
-	"github.com/gin-gonic/gin"
-	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
 	loggermiddleware "github.com/meateam/api-gateway/logger"
 	ppb "github.com/meateam/permission-service/proto"
 	"github.com/sirupsen/logrus"
-	"google.golang.org/grpc/status"
 )
 
-// AddPermissionsOnError add the deleted permissions when the file is failed to delete
-func AddPermissionsOnError(c *gin.Context,
-	err error,
+// AddPermissionsOnError add the deleted permissions when the file is failed to delete,
+// and invalidates the cached permissions of their users in cache.
+func AddPermissionsOnError(ctx context.Context,
 	fileID string,
 	permissions []*ppb.GetFilePermissionsResponse_UserRole,
 	permissionClient ppb.PermissionClient,
+	cache *PermissionCache,
 	logger *logrus.Logger) {
 
 	var wg sync.WaitGroup
@@ -25,6 +24,8 @@ func AddPermissionsOnError(c *gin.Context,
 	for _, permission := range permissions {
 		wg.Add(1)
 		go func(permission *ppb.GetFilePermissionsResponse_UserRole) {
+			defer wg.Done()
+
 			permissionRequest := &ppb.CreatePermissionRequest{
 				FileID:  fileID,
 				UserID:  permission.GetUserID(),
@@ -32,19 +33,12 @@ func AddPermissionsOnError(c *gin.Context,
 				Creator: permission.GetCreator(),
 			}
 
-			_, err := permissionClient.CreatePermission(c, permissionRequest)
-			if err != nil {
-				httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-				loggermiddleware.LogError(logger, c.AbortWithError(httpStatusCode,
-					fmt.Errorf("failed rollback and recreate permissions for file: %s", fileID)))
-
-				return
+			if _, err := permissionClient.CreatePermission(ctx, permissionRequest); err != nil {
+				loggermiddleware.LogError(logger,
+					fmt.Errorf("failed rollback and recreate permissions for file: %s: %v", fileID, err))
 			}
 
-			wg.Done()
+			cache.InvalidateUser(permission.GetUserID())
 		}(permission)
 	}
-
-	httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-	loggermiddleware.LogError(logger, c.AbortWithError(httpStatusCode, err))
 }
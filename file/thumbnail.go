@@ -0,0 +1,455 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // Registers the GIF decoder.
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
+	loggermiddleware "github.com/meateam/api-gateway/logger"
+	oauth "github.com/meateam/api-gateway/oauth"
+	dpb "github.com/meateam/download-service/proto"
+	fpb "github.com/meateam/file-service/proto/file"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ConfigThumbnailMaxSourceSize is the name of the environment variable containing
+	// the maximum size in bytes of a file that a thumbnail is generated of.
+	ConfigThumbnailMaxSourceSize = "thumbnail_max_source_size"
+
+	// ConfigThumbnailPdfRenderer is the name of the environment variable containing
+	// the name or path of the pdftoppm executable that renders the thumbnails of PDFs.
+	ConfigThumbnailPdfRenderer = "thumbnail_pdf_renderer"
+
+	// QueryThumbnailSize is the querystring key of the size in pixels of the longest side of a thumbnail.
+	QueryThumbnailSize = "size"
+
+	// DefaultThumbnailSize is the size of a thumbnail when the requested size isn't specified.
+	DefaultThumbnailSize = 256
+
+	// MinThumbnailSize is the minimal size of a thumbnail.
+	MinThumbnailSize = 16
+
+	// MaxThumbnailSize is the maximal size of a thumbnail.
+	MaxThumbnailSize = 1024
+
+	// PngMimeType is the mime type of a .png file.
+	PngMimeType = "image/png"
+
+	// JpegMimeType is the mime type of a .jpeg file.
+	JpegMimeType = "image/jpeg"
+
+	// GifMimeType is the mime type of a .gif file.
+	GifMimeType = "image/gif"
+
+	// maxThumbnailPixels is the maximal number of pixels of an image that a thumbnail is generated of,
+	// so a small image file of huge dimensions doesn't exhaust the memory when decoded.
+	maxThumbnailPixels = 50000000
+
+	// thumbnailCacheName is the name of the thumbnail cache in its metrics.
+	thumbnailCacheName = "thumbnail"
+
+	// thumbnailJpegQuality is the quality of the thumbnails that are encoded as JPEG.
+	thumbnailJpegQuality = 85
+
+	// thumbnailTimeout is the timeout of generating a thumbnail. A thumbnail is generated once for
+	// its concurrent requests, so its generation isn't canceled when one of the requests is canceled.
+	thumbnailTimeout = 2 * time.Minute
+)
+
+var (
+	// errThumbnailUnsupported is returned when a thumbnail can't be generated for a type of files.
+	errThumbnailUnsupported = errors.New("thumbnails are not supported for the type of the file")
+
+	// errThumbnailSourceTooLarge is returned when a file is too large to generate a thumbnail of.
+	errThumbnailSourceTooLarge = errors.New("file is too large to generate a thumbnail of")
+)
+
+// PDFRenderer renders the first page of a PDF to an image whose longest side is size pixels.
+type PDFRenderer interface {
+	RenderFirstPage(ctx context.Context, pdf []byte, size int) (image.Image, error)
+}
+
+// pdftoppmRenderer is a PDFRenderer that runs the pdftoppm executable of poppler.
+type pdftoppmRenderer struct {
+	path string
+}
+
+// newPdftoppmRenderer returns a PDFRenderer that runs the pdftoppm executable name,
+// or nil if it isn't found.
+func newPdftoppmRenderer(name string) PDFRenderer {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil
+	}
+
+	return pdftoppmRenderer{path: path}
+}
+
+// RenderFirstPage renders the first page of pdf to a PNG in a temporary directory and decodes it.
+func (p pdftoppmRenderer) RenderFirstPage(ctx context.Context, pdf []byte, size int) (image.Image, error) {
+	dir, err := ioutil.TempDir("", "thumbnail")
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input.pdf")
+	if err := ioutil.WriteFile(input, pdf, 0600); err != nil {
+		return nil, err
+	}
+
+	// With -singlefile the page is written to <output>.png.
+	output := filepath.Join(dir, "page")
+	cmd := exec.CommandContext(ctx, p.path,
+		"-png", "-f", "1", "-l", "1", "-singlefile", "-scale-to", strconv.Itoa(size), input, output)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed rendering pdf: %v: %s", err, bytes.TrimSpace(out))
+	}
+
+	page, err := os.Open(output + ".png")
+	if err != nil {
+		return nil, err
+	}
+
+	defer page.Close()
+
+	return png.Decode(page)
+}
+
+// GetThumbnail is the request handler for GET /files/:id/thumbnail?size=<pixels>.
+// It responds with a PNG or JPEG thumbnail of an image, of the first page of a PDF,
+// or of the first page of an office document converted to PDF. Thumbnails are cached
+// by the file's id and update time, and generated once for concurrent requests.
+func (r *Router) GetThumbnail(c *gin.Context) {
+	fileID := c.Param(ParamFileID)
+
+	size, err := thumbnailSize(c)
+	if err != nil {
+		loggermiddleware.LogError(r.logger, apierror.AbortWithMessage(c, http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	if !r.oAuthMiddleware.ValidateRequiredScope(c, oauth.DownloadScope) {
+		loggermiddleware.LogError(r.logger, apierror.AbortWithMessage(
+			c,
+			http.StatusForbidden,
+			fmt.Sprintf("required scope '%s' is not supplied", oauth.DownloadScope),
+		))
+
+		return
+	}
+
+	if err := validateAppID(c, fileID, r.fileClient(), AllowedDownloadApps); err != nil {
+		loggermiddleware.LogError(r.logger, err)
+		return
+	}
+
+	if role, _ := r.HandleUserFilePermission(c, fileID, DownloadRole); role == "" {
+		if !r.HandleUserFilePermit(c, fileID, DownloadRole) {
+			apierror.AbortWithStatus(c, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if r.isTrashed(c.Request.Context(), fileID) {
+		apierror.AbortWithMessage(c, http.StatusNotFound, fileIsTrashedMessage)
+		return
+	}
+
+	file, err := r.fileClient().GetFileByID(c.Request.Context(), &fpb.GetByFileByIDRequest{Id: fileID})
+	if err != nil {
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+		return
+	}
+
+	if !r.canThumbnail(file.GetType()) {
+		loggermiddleware.LogError(
+			r.logger,
+			apierror.AbortWithMessage(c, http.StatusUnsupportedMediaType, errThumbnailUnsupported.Error()),
+		)
+
+		return
+	}
+
+	etag := thumbnailETag(file, size)
+	lastModified := fileLastModified(file)
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", lastModified.Format(http.TimeFormat))
+
+	if notModified(c.Request, etag, lastModified) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	thumbnail, err := r.thumbnail(file, size)
+	if err != nil {
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, thumbnailErrorStatus(err), err))
+		return
+	}
+
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(http.StatusOK, thumbnailMimeType(file.GetType()), thumbnail)
+}
+
+// thumbnailSize returns the requested size of a thumbnail, or DefaultThumbnailSize if it isn't specified.
+func thumbnailSize(c *gin.Context) (int, error) {
+	value, ok := c.GetQuery(QueryThumbnailSize)
+	if !ok {
+		return DefaultThumbnailSize, nil
+	}
+
+	size, err := strconv.Atoi(value)
+	if err != nil || size < MinThumbnailSize || size > MaxThumbnailSize {
+		return 0, fmt.Errorf("%s must be an integer between %d and %d",
+			QueryThumbnailSize, MinThumbnailSize, MaxThumbnailSize)
+	}
+
+	return size, nil
+}
+
+// thumbnailETag returns the entity tag of the thumbnail of file in size.
+func thumbnailETag(file *fpb.File, size int) string {
+	return fmt.Sprintf(`"%s-%s-%d"`,
+		strconv.FormatInt(file.GetUpdatedAt(), 36),
+		strconv.FormatInt(file.GetSize(), 36),
+		size,
+	)
+}
+
+// thumbnailKey returns the cache key of the thumbnail of file in size, the thumbnails
+// of a file are keyed under its id so they can be invalidated together.
+func thumbnailKey(file *fpb.File, size int) string {
+	return fmt.Sprintf("%s%d/%d", CacheKeyPrefix(file.GetId()), file.GetUpdatedAt(), size)
+}
+
+// isImage reports whether contentType is an image type that can be decoded.
+func isImage(contentType string) bool {
+	return contentType == PngMimeType || contentType == JpegMimeType || contentType == GifMimeType
+}
+
+// canThumbnail reports whether a thumbnail can be generated of files of contentType.
+// The thumbnails of PDFs and files that are converted to PDF previews require a PDFRenderer.
+func (r *Router) canThumbnail(contentType string) bool {
+	if isImage(contentType) {
+		return true
+	}
+
+	if r.pdfRenderer == nil {
+		return false
+	}
+
+	return contentType == PdfMimeType || (r.gotenbergClient != nil && IsFileConvertableToPdf(contentType))
+}
+
+// thumbnailMimeType returns the mime type of the thumbnails of files of contentType,
+// JPEG images have JPEG thumbnails and all other files have PNG thumbnails.
+func thumbnailMimeType(contentType string) string {
+	if contentType == JpegMimeType {
+		return JpegMimeType
+	}
+
+	return PngMimeType
+}
+
+// thumbnailErrorStatus returns the HTTP status code of an error of generating a thumbnail.
+func thumbnailErrorStatus(err error) int {
+	switch err {
+	case errThumbnailUnsupported:
+		return http.StatusUnsupportedMediaType
+	case errThumbnailSourceTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case image.ErrFormat:
+		return http.StatusUnprocessableEntity
+	}
+
+	if _, ok := err.(*conversionError); ok {
+		return previewErrorStatus(err)
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return gwruntime.HTTPStatusFromCode(status.Code(err))
+	}
+
+	return http.StatusInternalServerError
+}
+
+// thumbnail returns the thumbnail of file in size from the thumbnail cache,
+// or generates it once for the concurrent requests of it and caches it.
+func (r *Router) thumbnail(file *fpb.File, size int) ([]byte, error) {
+	key := thumbnailKey(file, size)
+
+	return loadCached(thumbnailCacheName, r.thumbnailCache, &r.thumbnailGroup, key, func() ([]byte, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), thumbnailTimeout)
+		defer cancel()
+
+		return r.generateThumbnail(ctx, file, size)
+	})
+}
+
+// generateThumbnail downloads file and generates its thumbnail in size, encoded by thumbnailMimeType.
+func (r *Router) generateThumbnail(ctx context.Context, file *fpb.File, size int) ([]byte, error) {
+	if !r.canThumbnail(file.GetType()) {
+		return nil, errThumbnailUnsupported
+	}
+
+	if maxSize := viper.GetInt64(ConfigThumbnailMaxSourceSize); maxSize > 0 && file.GetSize() > maxSize {
+		return nil, errThumbnailSourceTooLarge
+	}
+
+	var img image.Image
+	var err error
+	if contentType := file.GetType(); isImage(contentType) || contentType == PdfMimeType {
+		var content []byte
+		if content, err = r.downloadContent(ctx, file); err != nil {
+			return nil, err
+		}
+
+		if isImage(contentType) {
+			img, err = decodeImage(content)
+		} else {
+			img, err = r.pdfRenderer.RenderFirstPage(ctx, content, size)
+		}
+	} else {
+		// Other files are rendered from their cached PDF preview.
+		var pdf []byte
+		if pdf, err = r.convertedPreview(file); err == nil {
+			img, err = r.pdfRenderer.RenderFirstPage(ctx, pdf, size)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeThumbnail(scaleToFit(img, size), thumbnailMimeType(file.GetType()))
+}
+
+// downloadContent downloads the content of file into memory.
+func (r *Router) downloadContent(ctx context.Context, file *fpb.File) ([]byte, error) {
+	stream, err := r.downloadClient().Download(ctx, &dpb.DownloadRequest{
+		Key:    file.GetKey(),
+		Bucket: file.GetBucket(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(NewDownloadReader(stream))
+}
+
+// decodeImage decodes a PNG, JPEG or GIF image, rejecting images of more than maxThumbnailPixels.
+func decodeImage(content []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(config.Width)*int64(config.Height) > maxThumbnailPixels {
+		return nil, errThumbnailSourceTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(content))
+
+	return img, err
+}
+
+// thumbnailDimensions returns the dimensions of a thumbnail of an image of width and height,
+// whose longest side is at most size, preserving the aspect ratio. Images aren't scaled up.
+func thumbnailDimensions(width int, height int, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+
+	if width >= height {
+		return size, maxInt(1, height*size/width)
+	}
+
+	return maxInt(1, width*size/height), size
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+// scaleToFit scales img down to fit in a square of size pixels, averaging
+// the pixels of img that are covered by each pixel of the thumbnail.
+func scaleToFit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := thumbnailDimensions(bounds.Dx(), bounds.Dy(), size)
+	if width == bounds.Dx() && height == bounds.Dy() {
+		return img
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*bounds.Dy()/height, maxInt((y+1)*bounds.Dy()/height, y*bounds.Dy()/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := x*bounds.Dx()/width, maxInt((x+1)*bounds.Dx()/width, x*bounds.Dx()/width+1)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+
+			count := (y1 - y0) * (x1 - x0)
+			offset := dst.PixOffset(x, y)
+			for i := range sum {
+				dst.Pix[offset+i] = uint8(sum[i] / count)
+			}
+		}
+	}
+
+	return dst
+}
+
+// encodeThumbnail encodes img as contentType, JPEG or PNG.
+func encodeThumbnail(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if contentType == JpegMimeType {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbnailJpegQuality})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
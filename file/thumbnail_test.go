@@ -0,0 +1,192 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meateam/api-gateway/cache"
+	dpb "github.com/meateam/download-service/proto"
+	fpb "github.com/meateam/file-service/proto/file"
+	"google.golang.org/grpc"
+)
+
+// countingDownloadClient is a fakeDownloadClient that counts the downloads.
+type countingDownloadClient struct {
+	fakeDownloadClient
+	downloads int64
+}
+
+func (d *countingDownloadClient) Download(
+	ctx context.Context,
+	in *dpb.DownloadRequest,
+	opts ...grpc.CallOption) (dpb.Download_DownloadClient, error) {
+	atomic.AddInt64(&d.downloads, 1)
+
+	return d.fakeDownloadClient.Download(ctx, in, opts...)
+}
+
+// fakePDFRenderer renders every PDF to an image of a page of A4 proportions.
+type fakePDFRenderer struct{}
+
+func (fakePDFRenderer) RenderFirstPage(_ context.Context, _ []byte, size int) (image.Image, error) {
+	return image.NewRGBA(image.Rect(0, 0, size*210/297, size)), nil
+}
+
+// encodePNG encodes a PNG of width and height whose left half is red and right half is blue.
+func encodePNG(t *testing.T, width int, height int) string {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed encoding png: %v", err)
+	}
+
+	return buf.String()
+}
+
+func TestThumbnailSize(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    int
+		wantErr bool
+	}{
+		{query: "", want: DefaultThumbnailSize},
+		{query: "?size=64", want: 64},
+		{query: "?size=15", wantErr: true},
+		{query: "?size=1025", wantErr: true},
+		{query: "?size=big", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/files/1/thumbnail"+tt.query, nil)
+
+		size, err := thumbnailSize(c)
+		if (err != nil) != tt.wantErr || size != tt.want {
+			t.Errorf("%q: expected %d with error %v, got %d with error %v", tt.query, tt.want, tt.wantErr, size, err)
+		}
+	}
+}
+
+func TestThumbnailDimensions(t *testing.T) {
+	tests := []struct {
+		width, height, size   int
+		wantWidth, wantHeight int
+	}{
+		{width: 100, height: 50, size: 256, wantWidth: 100, wantHeight: 50},
+		{width: 1000, height: 500, size: 256, wantWidth: 256, wantHeight: 128},
+		{width: 500, height: 1000, size: 256, wantWidth: 128, wantHeight: 256},
+		{width: 10000, height: 1, size: 16, wantWidth: 16, wantHeight: 1},
+	}
+
+	for _, tt := range tests {
+		width, height := thumbnailDimensions(tt.width, tt.height, tt.size)
+		if width != tt.wantWidth || height != tt.wantHeight {
+			t.Errorf("%dx%d in %d: expected %dx%d, got %dx%d",
+				tt.width, tt.height, tt.size, tt.wantWidth, tt.wantHeight, width, height)
+		}
+	}
+}
+
+func TestScaleToFit(t *testing.T) {
+	img, err := png.Decode(bytes.NewBufferString(encodePNG(t, 40, 20)))
+	if err != nil {
+		t.Fatalf("failed decoding png: %v", err)
+	}
+
+	thumbnail := scaleToFit(img, 4)
+	if bounds := thumbnail.Bounds(); bounds.Dx() != 4 || bounds.Dy() != 2 {
+		t.Fatalf("expected a 4x2 thumbnail, got %dx%d", bounds.Dx(), bounds.Dy())
+	}
+
+	if r, _, b, _ := thumbnail.At(0, 0).RGBA(); r>>8 != 255 || b != 0 {
+		t.Errorf("expected the left half to be red, got %v", thumbnail.At(0, 0))
+	}
+
+	if r, _, b, _ := thumbnail.At(3, 1).RGBA(); r != 0 || b>>8 != 255 {
+		t.Errorf("expected the right half to be blue, got %v", thumbnail.At(3, 1))
+	}
+}
+
+func TestRouter_Thumbnail(t *testing.T) {
+	downloadClient := &countingDownloadClient{fakeDownloadClient: fakeDownloadClient{contents: map[string]string{
+		"image": encodePNG(t, 64, 32),
+		"pdf":   "%PDF-1.4",
+	}}}
+
+	r := &Router{thumbnailCache: cache.NewLRU(1 << 20), pdfRenderer: fakePDFRenderer{}}
+	r.downloadClient = func() dpb.DownloadClient { return downloadClient }
+
+	tests := []struct {
+		file                  *fpb.File
+		wantWidth, wantHeight int
+	}{
+		{file: &fpb.File{Id: "image", Key: "image", Type: PngMimeType, UpdatedAt: 1}, wantWidth: 16, wantHeight: 8},
+		{file: &fpb.File{Id: "pdf", Key: "pdf", Type: PdfMimeType, UpdatedAt: 1}, wantWidth: 11, wantHeight: 16},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 2; i++ {
+			thumbnail, err := r.thumbnail(tt.file, 16)
+			if err != nil {
+				t.Fatalf("%s: failed generating thumbnail: %v", tt.file.GetId(), err)
+			}
+
+			config, err := png.DecodeConfig(bytes.NewReader(thumbnail))
+			if err != nil || config.Width != tt.wantWidth || config.Height != tt.wantHeight {
+				t.Errorf("%s: expected a %dx%d png, got %dx%d with error %v",
+					tt.file.GetId(), tt.wantWidth, tt.wantHeight, config.Width, config.Height, err)
+			}
+		}
+	}
+
+	if downloadClient.downloads != 2 {
+		t.Errorf("expected each file to be downloaded once, got %d downloads", downloadClient.downloads)
+	}
+
+	// An updated file has a new thumbnail.
+	if _, err := r.thumbnail(&fpb.File{Id: "image", Key: "image", Type: PngMimeType, UpdatedAt: 2}, 16); err != nil {
+		t.Fatalf("failed generating thumbnail: %v", err)
+	}
+
+	if downloadClient.downloads != 3 {
+		t.Errorf("expected the updated file to be downloaded, got %d downloads", downloadClient.downloads)
+	}
+}
+
+func TestRouter_ThumbnailUnsupported(t *testing.T) {
+	r := &Router{}
+	r.downloadClient = func() dpb.DownloadClient {
+		return &fakeDownloadClient{contents: map[string]string{"pdf": "%PDF-1.4", "corrupt": "not an image"}}
+	}
+
+	if r.canThumbnail(PdfMimeType) || r.canThumbnail(DocxMimeType) || r.canThumbnail(FolderMimeType) {
+		t.Error("expected no thumbnails of PDFs and documents without a renderer, and of folders")
+	}
+
+	if _, err := r.thumbnail(&fpb.File{Id: "pdf", Key: "pdf", Type: PdfMimeType}, 16); err != errThumbnailUnsupported {
+		t.Errorf("expected %v, got %v", errThumbnailUnsupported, err)
+	}
+
+	_, err := r.thumbnail(&fpb.File{Id: "corrupt", Key: "corrupt", Type: PngMimeType}, 16)
+	if err == nil || thumbnailErrorStatus(err) != http.StatusUnprocessableEntity {
+		t.Errorf("expected a corrupt image to be unprocessable, got %v", err)
+	}
+}
@@ -0,0 +1,520 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
+	loggermiddleware "github.com/meateam/api-gateway/logger"
+	"github.com/meateam/api-gateway/oauth"
+	"github.com/meateam/api-gateway/user"
+	fpb "github.com/meateam/file-service/proto/file"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ConfigTrashRetentionDays is the name of the environment variable containing
+	// the number of days after which trashed files are deleted permanently.
+	ConfigTrashRetentionDays = "trash_retention_days"
+
+	// trashPurgeBatchSize is the maximum number of trash items purged at once.
+	trashPurgeBatchSize = 1000
+
+	// trashItemNotFoundMessage is the message responded when a file isn't in the requester's trash.
+	trashItemNotFoundMessage = "file is not in the trash"
+)
+
+// ErrTrashItemNotFound is returned by a TrashStore when a file isn't in the trash.
+var ErrTrashItemNotFound = errors.New("trash item not found")
+
+// TrashItem is a file that was moved to the trash of the user that deleted it.
+// The file's metadata and permissions are kept as they were, so it can be restored to its parent.
+type TrashItem struct {
+	FileID    string    `json:"fileId"`
+	UserID    string    `json:"userId"`
+	Parent    string    `json:"parent"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Size      int64     `json:"size"`
+	TrashedAt time.Time `json:"trashedAt"`
+}
+
+// TrashStore stores the items in the users' trash.
+type TrashStore interface {
+	// Add adds item to the trash.
+	Add(ctx context.Context, item *TrashItem) error
+
+	// Get returns the trash item of fileID, or ErrTrashItemNotFound if it's not in the trash.
+	Get(ctx context.Context, fileID string) (*TrashItem, error)
+
+	// List returns the items in the trash of userID, the latest trashed first.
+	List(ctx context.Context, userID string) ([]*TrashItem, error)
+
+	// ListTrashedBefore returns at most limit items that were trashed before t.
+	ListTrashedBefore(ctx context.Context, t time.Time, limit int) ([]*TrashItem, error)
+
+	// Trashed returns which of fileIDs are in the trash.
+	Trashed(ctx context.Context, fileIDs []string) (map[string]bool, error)
+
+	// Remove removes the item of fileID from the trash, if it's in the trash.
+	Remove(ctx context.Context, fileID string) error
+}
+
+// setupTrash initializes the trash routes under rg.
+func (r *Router) setupTrash(rg *gin.RouterGroup) {
+	checkDeleteFileScope := r.oAuthMiddleware.AuthorizationScopeMiddleware(oauth.DeleteScope)
+
+	rg.GET("/trash", r.ListTrash)
+	rg.POST("/trash/:id/restore", r.RestoreFile)
+	rg.DELETE("/trash/:id", checkDeleteFileScope, r.DeleteTrashedFile)
+	rg.DELETE("/trash", checkDeleteFileScope, r.EmptyTrash)
+}
+
+// moveToTrash moves file to the trash of userID.
+func (r *Router) moveToTrash(ctx context.Context, file *fpb.File, userID string) error {
+	return r.trash.Add(ctx, &TrashItem{
+		FileID:    file.GetId(),
+		UserID:    userID,
+		Parent:    file.GetParent(),
+		Name:      file.GetName(),
+		Type:      file.GetType(),
+		Size:      file.GetSize(),
+		TrashedAt: time.Now(),
+	})
+}
+
+// InTrash reports whether the file of fileID or any of its ancestors is in trash,
+// since the descendants of a trashed folder are trashed along with it.
+func InTrash(
+	ctx context.Context,
+	fileClient fpb.FileServiceClient,
+	trash TrashStore,
+	fileID string) (bool, error) {
+	if trash == nil || fileID == "" {
+		return false, nil
+	}
+
+	res, err := fileClient.GetAncestors(ctx, &fpb.GetAncestorsRequest{Id: fileID})
+	if err != nil {
+		return false, err
+	}
+
+	ids := append([]string{fileID}, res.GetAncestors()...)
+	trashed, err := trash.Trashed(ctx, ids)
+	if err != nil {
+		return false, err
+	}
+
+	for _, id := range ids {
+		if trashed[id] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// TrashedFiles returns the ids of files that are in trash or whose ancestors are.
+// The ancestors of files that share a parent are fetched once.
+func TrashedFiles(
+	ctx context.Context,
+	fileClient fpb.FileServiceClient,
+	trash TrashStore,
+	files []*fpb.File) (map[string]bool, error) {
+	if trash == nil || len(files) == 0 {
+		return map[string]bool{}, nil
+	}
+
+	ids := make([]string, 0, len(files))
+	ancestors := make(map[string][]string)
+	for _, file := range files {
+		ids = append(ids, file.GetId())
+
+		parent := file.GetParent()
+		if _, ok := ancestors[parent]; ok || parent == "" {
+			continue
+		}
+
+		res, err := fileClient.GetAncestors(ctx, &fpb.GetAncestorsRequest{Id: parent})
+		if err != nil {
+			return nil, err
+		}
+
+		ancestors[parent] = append([]string{parent}, res.GetAncestors()...)
+		ids = append(ids, ancestors[parent]...)
+	}
+
+	trashed, err := trash.Trashed(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	trashedFiles := make(map[string]bool)
+	for _, file := range files {
+		if trashed[file.GetId()] {
+			trashedFiles[file.GetId()] = true
+			continue
+		}
+
+		for _, id := range ancestors[file.GetParent()] {
+			if trashed[id] {
+				trashedFiles[file.GetId()] = true
+				break
+			}
+		}
+	}
+
+	return trashedFiles, nil
+}
+
+// withoutTrashedDescendants returns descendants of a folder without the ones that are in trash,
+// or that are under a descendant that's in trash. The folder itself isn't checked.
+func withoutTrashedDescendants(
+	ctx context.Context,
+	trash TrashStore,
+	descendants []*fpb.GetDescendantsByIDResponse_Descendant) ([]*fpb.GetDescendantsByIDResponse_Descendant, error) {
+	if trash == nil || len(descendants) == 0 {
+		return descendants, nil
+	}
+
+	ids := make([]string, 0, len(descendants))
+	parents := make(map[string]string, len(descendants))
+	for _, descendant := range descendants {
+		ids = append(ids, descendant.GetFile().GetId())
+		parents[descendant.GetFile().GetId()] = descendant.GetParent().GetId()
+	}
+
+	trashed, err := trash.Trashed(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// hidden memoizes whether each descendant is trashed or under a trashed descendant.
+	hidden := make(map[string]bool, len(descendants))
+	var isHidden func(id string) bool
+	isHidden = func(id string) bool {
+		parent, ok := parents[id]
+		if !ok {
+			return false
+		}
+
+		if h, ok := hidden[id]; ok {
+			return h
+		}
+
+		hidden[id] = trashed[id] || isHidden(parent)
+
+		return hidden[id]
+	}
+
+	filtered := make([]*fpb.GetDescendantsByIDResponse_Descendant, 0, len(descendants))
+	for _, descendant := range descendants {
+		if !isHidden(descendant.GetFile().GetId()) {
+			filtered = append(filtered, descendant)
+		}
+	}
+
+	return filtered, nil
+}
+
+// isTrashed reports whether fileID or any of its ancestors is in the trash. If the trash couldn't be checked
+// then the error is logged and fileID is considered not trashed, so the trash doesn't block access to files.
+func (r *Router) isTrashed(ctx context.Context, fileID string) bool {
+	if r.trash == nil || fileID == "" {
+		return false
+	}
+
+	trashed, err := InTrash(ctx, r.fileClient(), r.trash, fileID)
+	if err != nil {
+		if status.Code(err) != codes.NotFound {
+			loggermiddleware.LogError(r.logger, fmt.Errorf("failed checking if file %s is trashed: %v", fileID, err))
+		}
+
+		return false
+	}
+
+	return trashed
+}
+
+// withoutTrashed returns files without the files that are in the trash, or whose ancestors are.
+// If the trash couldn't be checked then the error is logged and files are returned as is.
+func (r *Router) withoutTrashed(ctx context.Context, files []*fpb.File) []*fpb.File {
+	if r.trash == nil || len(files) == 0 {
+		return files
+	}
+
+	trashed, err := TrashedFiles(ctx, r.fileClient(), r.trash, files)
+	if err != nil {
+		loggermiddleware.LogError(r.logger, fmt.Errorf("failed checking trashed files: %v", err))
+		return files
+	}
+
+	filtered := make([]*fpb.File, 0, len(files))
+	for _, file := range files {
+		if !trashed[file.GetId()] {
+			filtered = append(filtered, file)
+		}
+	}
+
+	return filtered
+}
+
+// ListTrash is the request handler for GET /trash request.
+// It returns the items in the requester's trash.
+func (r *Router) ListTrash(c *gin.Context) {
+	reqUser := user.ExtractRequestUser(c)
+	if reqUser == nil {
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
+		return
+	}
+
+	if r.trash == nil {
+		c.JSON(http.StatusOK, []*TrashItem{})
+		return
+	}
+
+	items, err := r.trash.List(c.Request.Context(), reqUser.ID)
+	if err != nil {
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusInternalServerError, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, items)
+}
+
+// trashItem returns the item of the trash of the requester with the file id param.
+// If the file isn't in the requester's trash then the request is aborted and nil is returned.
+func (r *Router) trashItem(c *gin.Context) *TrashItem {
+	reqUser := user.ExtractRequestUser(c)
+	if reqUser == nil {
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
+		return nil
+	}
+
+	if r.trash == nil {
+		apierror.AbortWithMessage(c, http.StatusNotFound, trashItemNotFoundMessage)
+		return nil
+	}
+
+	item, err := r.trash.Get(c.Request.Context(), c.Param(ParamFileID))
+	if err == ErrTrashItemNotFound || (err == nil && item.UserID != reqUser.ID) {
+		apierror.AbortWithMessage(c, http.StatusNotFound, trashItemNotFoundMessage)
+		return nil
+	}
+
+	if err != nil {
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusInternalServerError, err))
+		return nil
+	}
+
+	return item
+}
+
+// RestoreFile is the request handler for POST /trash/:id/restore request.
+// It restores the file to its parent, or to the requester's root if its parent
+// no longer exists or is in the trash, and returns the restored item.
+func (r *Router) RestoreFile(c *gin.Context) {
+	item := r.trashItem(c)
+	if item == nil {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := r.fileClient().GetFileByID(ctx, &fpb.GetByFileByIDRequest{Id: item.FileID}); err != nil {
+		if status.Code(err) == codes.NotFound {
+			loggermiddleware.LogError(r.logger, r.trash.Remove(ctx, item.FileID))
+		}
+
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+		return
+	}
+
+	if item.Parent != "" && !r.parentExists(c, item.Parent) {
+		root := ""
+		failedFiles, err := HandleUpdate(
+			ctx,
+			[]string{item.FileID},
+			partialFile{Parent: &root},
+			r.fileClient(),
+			r.uploadClient(),
+			r.searchClient(),
+			r.logger,
+		)
+		if err == nil && len(failedFiles) > 0 {
+			err = fmt.Errorf("failed moving file %s to root: %s", item.FileID, failedFiles[0].GetError())
+		}
+
+		if err != nil {
+			httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+			loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+			return
+		}
+
+		// The file inherits the permissions of root instead of its parent's.
+		r.permissionCache.Invalidate()
+		item.Parent = root
+	}
+
+	if err := r.trash.Remove(ctx, item.FileID); err != nil {
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusInternalServerError, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, item)
+}
+
+// parentExists reports whether the folder parentID exists and isn't in the trash.
+func (r *Router) parentExists(c *gin.Context, parentID string) bool {
+	_, err := r.fileClient().GetFileByID(c.Request.Context(), &fpb.GetByFileByIDRequest{Id: parentID})
+	if err != nil {
+		if status.Code(err) != codes.NotFound {
+			loggermiddleware.LogError(r.logger, fmt.Errorf("failed getting parent %s of restored file: %v", parentID, err))
+		}
+
+		return false
+	}
+
+	return !r.isTrashed(c.Request.Context(), parentID)
+}
+
+// DeleteTrashedFile is the request handler for DELETE /trash/:id request.
+// It permanently deletes the trashed file, and returns the ids of the deleted files.
+func (r *Router) DeleteTrashedFile(c *gin.Context) {
+	item := r.trashItem(c)
+	if item == nil {
+		return
+	}
+
+	ids, err := r.deleteTrashItem(c.Request.Context(), item)
+	if err != nil {
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+		return
+	}
+
+	c.JSON(http.StatusOK, ids)
+}
+
+// EmptyTrash is the request handler for DELETE /trash request.
+// It starts permanently deleting the files in the requester's trash, and returns the ids of the trashed files.
+// The files are deleted in the background since a large trash can't be deleted within the request's deadline.
+func (r *Router) EmptyTrash(c *gin.Context) {
+	reqUser := user.ExtractRequestUser(c)
+	if reqUser == nil {
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
+		return
+	}
+
+	if r.trash == nil {
+		c.JSON(http.StatusAccepted, []string{})
+		return
+	}
+
+	items, err := r.trash.List(c.Request.Context(), reqUser.ID)
+	if err != nil {
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusInternalServerError, err))
+		return
+	}
+
+	trashedIDs := make([]string, 0, len(items))
+	for _, item := range items {
+		trashedIDs = append(trashedIDs, item.FileID)
+	}
+
+	go r.deleteTrashItems(items)
+
+	c.JSON(http.StatusAccepted, trashedIDs)
+}
+
+// deleteTrashItems permanently deletes the files of items, independently of any request.
+// Files that fail to be deleted are logged and left in the trash.
+func (r *Router) deleteTrashItems(items []*TrashItem) {
+	for _, item := range items {
+		if _, err := r.deleteTrashItem(context.Background(), item); err != nil {
+			loggermiddleware.LogError(r.logger, fmt.Errorf("failed deleting file %s from trash: %v", item.FileID, err))
+		}
+	}
+}
+
+// deleteTrashItem permanently deletes the file of item and removes it from the trash,
+// along with its descendants that were trashed on their own. Returns the ids of the deleted files.
+func (r *Router) deleteTrashItem(ctx context.Context, item *TrashItem) ([]string, error) {
+	ids, err := DeleteFile(
+		ctx,
+		r.logger,
+		r.fileClient(),
+		r.uploadClient(),
+		r.searchClient(),
+		r.permissionClient(),
+		r.permissionCache,
+		item.FileID,
+		item.UserID,
+	)
+	if status.Code(err) == codes.NotFound {
+		// The file was already deleted, e.g. with its trashed parent.
+		err = nil
+	}
+
+	r.deleteVersions(ctx, ids)
+
+	for _, id := range append(ids, item.FileID) {
+		if removeErr := r.trash.Remove(ctx, id); removeErr != nil {
+			loggermiddleware.LogError(r.logger, fmt.Errorf("failed removing file %s from trash: %v", id, removeErr))
+		}
+	}
+
+	return ids, err
+}
+
+// PurgeTrash permanently deletes up to trashPurgeBatchSize files that were trashed before t.
+// Files that fail to be deleted are logged and left in the trash for the next purge.
+// Returns the number of purged trash items.
+func (r *Router) PurgeTrash(ctx context.Context, t time.Time) (int, error) {
+	if r.trash == nil {
+		return 0, nil
+	}
+
+	items, err := r.trash.ListTrashedBefore(ctx, t, trashPurgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, item := range items {
+		if _, err := r.deleteTrashItem(ctx, item); err != nil {
+			loggermiddleware.LogError(r.logger, fmt.Errorf("failed purging file %s from trash: %v", item.FileID, err))
+			continue
+		}
+
+		purged++
+	}
+
+	return purged, nil
+}
+
+// PurgeTrashPeriodically purges the files that were trashed more than retention ago every interval.
+func (r *Router) PurgeTrashPeriodically(interval time.Duration, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purged, err := r.PurgeTrash(context.Background(), time.Now().Add(-retention))
+		if err != nil {
+			loggermiddleware.LogError(r.logger, fmt.Errorf("failed purging trash: %v", err))
+		}
+
+		if purged > 0 {
+			r.logger.Infof("purged %d files from trash", purged)
+		}
+	}
+}
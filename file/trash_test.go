@@ -0,0 +1,435 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meateam/api-gateway/oauth"
+	"github.com/meateam/api-gateway/user"
+	fpb "github.com/meateam/file-service/proto/file"
+	ppb "github.com/meateam/permission-service/proto"
+	spb "github.com/meateam/search-service/proto"
+	upb "github.com/meateam/upload-service/proto"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// memTrashStore is a TrashStore in memory.
+type memTrashStore struct {
+	mu    sync.Mutex
+	items map[string]*TrashItem
+}
+
+func newMemTrashStore() *memTrashStore {
+	return &memTrashStore{items: make(map[string]*TrashItem)}
+}
+
+func (s *memTrashStore) Add(_ context.Context, item *TrashItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[item.FileID] = item
+
+	return nil
+}
+
+func (s *memTrashStore) Get(_ context.Context, fileID string) (*TrashItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[fileID]
+	if !ok {
+		return nil, ErrTrashItemNotFound
+	}
+
+	copied := *item
+
+	return &copied, nil
+}
+
+func (s *memTrashStore) list(match func(*TrashItem) bool) []*TrashItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]*TrashItem, 0, len(s.items))
+	for _, item := range s.items {
+		if match(item) {
+			items = append(items, item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].TrashedAt.After(items[j].TrashedAt) })
+
+	return items
+}
+
+func (s *memTrashStore) List(_ context.Context, userID string) ([]*TrashItem, error) {
+	return s.list(func(item *TrashItem) bool { return item.UserID == userID }), nil
+}
+
+func (s *memTrashStore) ListTrashedBefore(_ context.Context, t time.Time, limit int) ([]*TrashItem, error) {
+	items := s.list(func(item *TrashItem) bool { return item.TrashedAt.Before(t) })
+	if len(items) > limit {
+		items = items[:limit]
+	}
+
+	return items, nil
+}
+
+func (s *memTrashStore) Trashed(_ context.Context, fileIDs []string) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trashed := make(map[string]bool)
+	for _, id := range fileIDs {
+		if _, ok := s.items[id]; ok {
+			trashed[id] = true
+		}
+	}
+
+	return trashed, nil
+}
+
+func (s *memTrashStore) Remove(_ context.Context, fileID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, fileID)
+
+	return nil
+}
+
+// fakeFileClient is a file service with files, that records the parents files were moved to.
+type fakeFileClient struct {
+	fpb.FileServiceClient
+	files map[string]*fpb.File
+	moved map[string]string
+}
+
+func (f *fakeFileClient) GetFileByID(
+	_ context.Context,
+	in *fpb.GetByFileByIDRequest,
+	_ ...grpc.CallOption) (*fpb.File, error) {
+	file, ok := f.files[in.GetId()]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "file not found")
+	}
+
+	return file, nil
+}
+
+func (f *fakeFileClient) GetAncestors(
+	_ context.Context,
+	in *fpb.GetAncestorsRequest,
+	_ ...grpc.CallOption) (*fpb.GetAncestorsResponse, error) {
+	file, ok := f.files[in.GetId()]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "file not found")
+	}
+
+	var ancestors []string
+	for parent := file.GetParent(); parent != ""; parent = f.files[parent].GetParent() {
+		ancestors = append([]string{parent}, ancestors...)
+	}
+
+	return &fpb.GetAncestorsResponse{Ancestors: ancestors}, nil
+}
+
+func (f *fakeFileClient) UpdateFiles(
+	_ context.Context,
+	in *fpb.UpdateFilesRequest,
+	_ ...grpc.CallOption) (*fpb.UpdateFilesResponse, error) {
+	for _, id := range in.GetIdList() {
+		f.moved[id] = in.GetPartialFile().GetParent()
+	}
+
+	return &fpb.UpdateFilesResponse{}, nil
+}
+
+// fakeSearchClient is a search service that accepts any update.
+type fakeSearchClient struct {
+	spb.SearchClient
+}
+
+func (fakeSearchClient) Update(context.Context, *spb.File, ...grpc.CallOption) (*spb.UpdateResponse, error) {
+	return &spb.UpdateResponse{}, nil
+}
+
+// newTrashRouter creates a Router with the trash and files, serving the trash routes to userID.
+func newTrashRouter(trash TrashStore, fileClient *fakeFileClient, userID string) *gin.Engine {
+	r := &Router{logger: logrus.New(), trash: trash}
+	r.fileClient = func() fpb.FileServiceClient { return fileClient }
+	r.searchClient = func() spb.SearchClient { return fakeSearchClient{} }
+	r.uploadClient = func() upb.UploadClient { return nil }
+	r.permissionClient = func() ppb.PermissionClient { return nil }
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(func(c *gin.Context) {
+		c.Set(user.ContextUserKey, user.User{ID: userID})
+		c.Set(oauth.ContextAppKey, oauth.DriveAppID)
+	})
+	engine.GET("/trash", r.ListTrash)
+	engine.POST("/trash/:id/restore", r.RestoreFile)
+	engine.PUT("/files/:id", r.UpdateFile)
+	engine.PUT("/files", r.UpdateFiles)
+
+	return engine
+}
+
+func TestRouter_WithoutTrashed(t *testing.T) {
+	trash := newMemTrashStore()
+	r := &Router{logger: logrus.New(), trash: trash}
+	files := []*fpb.File{{Id: "a"}, {Id: "b"}, {Id: "c"}}
+	fileClient := &fakeFileClient{files: map[string]*fpb.File{"a": files[0], "b": files[1], "c": files[2]}}
+	r.fileClient = func() fpb.FileServiceClient { return fileClient }
+
+	if err := r.moveToTrash(context.Background(), files[1], "user"); err != nil {
+		t.Fatalf("failed moving file to trash: %v", err)
+	}
+
+	var got []string
+	for _, file := range r.withoutTrashed(context.Background(), files) {
+		got = append(got, file.GetId())
+	}
+
+	if want := []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected files %v, got %v", want, got)
+	}
+
+	if !r.isTrashed(context.Background(), "b") || r.isTrashed(context.Background(), "a") {
+		t.Error("expected only the trashed file to be trashed")
+	}
+}
+
+func TestRouter_WithoutTrashedAncestors(t *testing.T) {
+	trash := newMemTrashStore()
+	r := &Router{logger: logrus.New(), trash: trash}
+	fileClient := &fakeFileClient{files: map[string]*fpb.File{
+		"folder":    {Id: "folder"},
+		"subfolder": {Id: "subfolder", FileOrId: &fpb.File_Parent{Parent: "folder"}},
+		"a":         {Id: "a", FileOrId: &fpb.File_Parent{Parent: "subfolder"}},
+		"b":         {Id: "b"},
+	}}
+	r.fileClient = func() fpb.FileServiceClient { return fileClient }
+
+	if err := r.moveToTrash(context.Background(), fileClient.files["folder"], "user"); err != nil {
+		t.Fatalf("failed moving folder to trash: %v", err)
+	}
+
+	if !r.isTrashed(context.Background(), "a") {
+		t.Error("expected a file under a trashed folder to be trashed")
+	}
+
+	if r.isTrashed(context.Background(), "b") {
+		t.Error("expected a file outside of the trashed folder not to be trashed")
+	}
+
+	files := []*fpb.File{fileClient.files["a"], fileClient.files["b"]}
+
+	var got []string
+	for _, file := range r.withoutTrashed(context.Background(), files) {
+		got = append(got, file.GetId())
+	}
+
+	if want := []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected files %v, got %v", want, got)
+	}
+}
+
+func TestWithoutTrashedDescendants(t *testing.T) {
+	trash := newMemTrashStore()
+	descendant := func(id string, parent string) *fpb.GetDescendantsByIDResponse_Descendant {
+		return &fpb.GetDescendantsByIDResponse_Descendant{File: &fpb.File{Id: id}, Parent: &fpb.File{Id: parent}}
+	}
+
+	// The descendants of root aren't ordered by depth.
+	descendants := []*fpb.GetDescendantsByIDResponse_Descendant{
+		descendant("c", "b"),
+		descendant("a", "root"),
+		descendant("b", "a"),
+		descendant("d", "root"),
+	}
+
+	if err := trash.Add(context.Background(), &TrashItem{FileID: "a"}); err != nil {
+		t.Fatalf("failed moving file to trash: %v", err)
+	}
+
+	filtered, err := withoutTrashedDescendants(context.Background(), trash, descendants)
+	if err != nil {
+		t.Fatalf("failed filtering descendants: %v", err)
+	}
+
+	var got []string
+	for _, descendant := range filtered {
+		got = append(got, descendant.GetFile().GetId())
+	}
+
+	if want := []string{"d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected descendants %v, got %v", want, got)
+	}
+}
+
+func TestRouter_ListTrash(t *testing.T) {
+	trash := newMemTrashStore()
+	now := time.Now()
+	_ = trash.Add(context.Background(), &TrashItem{FileID: "old", UserID: "user", TrashedAt: now.Add(-time.Hour)})
+	_ = trash.Add(context.Background(), &TrashItem{FileID: "new", UserID: "user", TrashedAt: now})
+	_ = trash.Add(context.Background(), &TrashItem{FileID: "other", UserID: "other", TrashedAt: now})
+
+	w := httptest.NewRecorder()
+	newTrashRouter(trash, &fakeFileClient{}, "user").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/trash", nil))
+
+	var items []*TrashItem
+	if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil {
+		t.Fatalf("failed parsing response: %v", err)
+	}
+
+	var got []string
+	for _, item := range items {
+		got = append(got, item.FileID)
+	}
+
+	if want := []string{"new", "old"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected trash %v, got %v", want, got)
+	}
+}
+
+func TestRouter_RestoreFile(t *testing.T) {
+	tests := []struct {
+		name          string
+		parent        string
+		parentTrashed bool
+		userID        string
+		wantStatus    int
+		wantParent    string
+		wantMoved     bool
+	}{
+		{name: "to existing parent", parent: "folder", userID: "user", wantStatus: http.StatusOK, wantParent: "folder"},
+		{name: "from root", parent: "", userID: "user", wantStatus: http.StatusOK, wantParent: ""},
+		{name: "to root when parent is gone", parent: "gone", userID: "user", wantStatus: http.StatusOK, wantMoved: true},
+		{
+			name:          "to root when parent is trashed",
+			parent:        "folder",
+			parentTrashed: true,
+			userID:        "user",
+			wantStatus:    http.StatusOK,
+			wantMoved:     true,
+		},
+		{name: "from another user's trash", parent: "folder", userID: "other", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileClient := &fakeFileClient{
+				files: map[string]*fpb.File{
+					"file":   {Id: "file", OwnerID: "user"},
+					"folder": {Id: "folder", OwnerID: "user", Type: FolderMimeType},
+				},
+				moved: make(map[string]string),
+			}
+
+			trash := newMemTrashStore()
+			_ = trash.Add(context.Background(), &TrashItem{FileID: "file", UserID: "user", Parent: tt.parent})
+			if tt.parentTrashed {
+				_ = trash.Add(context.Background(), &TrashItem{FileID: tt.parent, UserID: "user"})
+			}
+
+			w := httptest.NewRecorder()
+			newTrashRouter(trash, fileClient, tt.userID).
+				ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/trash/file/restore", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+
+			trashed, _ := trash.Trashed(context.Background(), []string{"file"})
+			if restored := !trashed["file"]; restored != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("expected file to be restored: %v", tt.wantStatus == http.StatusOK)
+			}
+
+			if _, moved := fileClient.moved["file"]; moved != tt.wantMoved {
+				t.Errorf("expected file to be moved to root: %v", tt.wantMoved)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var item TrashItem
+			if err := json.Unmarshal(w.Body.Bytes(), &item); err != nil {
+				t.Fatalf("failed parsing response: %v", err)
+			}
+
+			if item.Parent != tt.wantParent {
+				t.Errorf("expected file to be restored to %q, got %q", tt.wantParent, item.Parent)
+			}
+		})
+	}
+}
+
+func TestRouter_UpdateFileTrashed(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{name: "move a file", path: "/files/file", body: `{"parent":"folder"}`, wantStatus: http.StatusOK},
+		{name: "move a trashed file", path: "/files/trashed", body: `{"parent":"folder"}`, wantStatus: http.StatusNotFound},
+		{
+			name:       "move a file into a trashed folder",
+			path:       "/files/file",
+			body:       `{"parent":"trashedFolder"}`,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "move files into a trashed folder",
+			path:       "/files",
+			body:       `{"idList":["file"],"partialFile":{"parent":"trashedFolder"}}`,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "move trashed files",
+			path:       "/files",
+			body:       `{"idList":["file","trashed"],"partialFile":{"parent":"folder"}}`,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileClient := &fakeFileClient{
+				files: map[string]*fpb.File{
+					"file":          {Id: "file", OwnerID: "user"},
+					"trashed":       {Id: "trashed", OwnerID: "user"},
+					"folder":        {Id: "folder", OwnerID: "user", Type: FolderMimeType},
+					"trashedFolder": {Id: "trashedFolder", OwnerID: "user", Type: FolderMimeType},
+				},
+				moved: make(map[string]string),
+			}
+
+			trash := newMemTrashStore()
+			_ = trash.Add(context.Background(), &TrashItem{FileID: "trashed", UserID: "user"})
+			_ = trash.Add(context.Background(), &TrashItem{FileID: "trashedFolder", UserID: "user"})
+
+			w := httptest.NewRecorder()
+			newTrashRouter(trash, fileClient, "user").
+				ServeHTTP(w, httptest.NewRequest(http.MethodPut, tt.path, strings.NewReader(tt.body)))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+
+			if moved := len(fileClient.moved) > 0; moved != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("expected files to be moved: %v, got moved %v", tt.wantStatus == http.StatusOK, fileClient.moved)
+			}
+		})
+	}
+}
@@ -0,0 +1,574 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
+	"github.com/meateam/api-gateway/factory"
+	loggermiddleware "github.com/meateam/api-gateway/logger"
+	"github.com/meateam/api-gateway/oauth"
+	"github.com/meateam/api-gateway/user"
+	fpb "github.com/meateam/file-service/proto/file"
+	qpb "github.com/meateam/file-service/proto/quota"
+	grpcPoolTypes "github.com/meateam/grpc-go-conn-pool/grpc/types"
+	ppb "github.com/meateam/permission-service/proto"
+	upb "github.com/meateam/upload-service/proto"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ConfigVersionMaxCount is the name of the environment variable containing
+	// the maximal number of versions kept of each file.
+	ConfigVersionMaxCount = "version_max_count"
+
+	// ConfigVersionRetentionDays is the name of the environment variable containing
+	// the number of days after which the versions of files are deleted.
+	ConfigVersionRetentionDays = "version_retention_days"
+
+	// ParamVersionID is the name of the version id param in URL.
+	ParamVersionID = "versionId"
+
+	// versionPruneBatchSize is the maximum number of expired versions pruned at once.
+	versionPruneBatchSize = 1000
+
+	// versionNotFoundMessage is the message responded when a file has no version with the requested id.
+	versionNotFoundMessage = "version not found"
+)
+
+// ErrVersionNotFound is returned by a VersionStore when a version doesn't exist.
+var ErrVersionNotFound = errors.New(versionNotFoundMessage)
+
+// Version is a previous content of a file, kept when the content was replaced by an update.
+// The object of the content is kept in its bucket until the version is restored or pruned.
+type Version struct {
+	ID      string `json:"id"`
+	FileID  string `json:"fileId"`
+	OwnerID string `json:"ownerId"`
+	Key     string `json:"key"`
+	Bucket  string `json:"bucket"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Size    int64  `json:"size"`
+
+	// AuthorID is the id of the user that uploaded the content.
+	AuthorID string `json:"authorId"`
+
+	// ReplacedBy is the id of the user that replaced the content, who authored the next version.
+	ReplacedBy string `json:"replacedBy"`
+
+	// CreatedAt is the time the content was uploaded.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// ArchivedAt is the time the content was replaced and kept as a version.
+	ArchivedAt time.Time `json:"archivedAt"`
+}
+
+// VersionResponse is a structure used for parsing a Version to a json version metadata response.
+type VersionResponse struct {
+	ID         string    `json:"id"`
+	FileID     string    `json:"fileId"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Size       int64     `json:"size"`
+	AuthorID   string    `json:"authorId"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ArchivedAt time.Time `json:"archivedAt"`
+}
+
+// VersionStore stores the versions of the files.
+type VersionStore interface {
+	// Add adds version to the store.
+	Add(ctx context.Context, version *Version) error
+
+	// Get returns the version of versionID, or ErrVersionNotFound if it doesn't exist.
+	Get(ctx context.Context, versionID string) (*Version, error)
+
+	// List returns the versions of fileID, the latest archived first.
+	List(ctx context.Context, fileID string) ([]*Version, error)
+
+	// ListArchivedBefore returns at most limit versions that were archived before t.
+	ListArchivedBefore(ctx context.Context, t time.Time, limit int) ([]*Version, error)
+
+	// Remove removes the version of versionID, if it exists.
+	// Returns whether the version was removed by this call.
+	Remove(ctx context.Context, versionID string) (bool, error)
+}
+
+// Versions keeps the replaced contents of files as versions in a VersionStore, and prunes them
+// by its retention policy. Versions count toward the quota of the owner of their file.
+type Versions struct {
+	store  VersionStore
+	logger *logrus.Logger
+
+	// maxCount is the maximal number of versions kept of each file, 0 is unlimited.
+	maxCount int
+
+	// maxAge is the duration after which versions are deleted, 0 keeps them until they're pruned by count.
+	maxAge time.Duration
+
+	// UploadClientFactory
+	uploadClient factory.UploadClientFactory
+
+	// QuotaClientFactory
+	quotaClient factory.QuotaClientFactory
+}
+
+// NewVersions creates a Versions that keeps the versions in store, and deletes their objects and
+// updates their owners' quota with the upload and file services of the given connections.
+// At most maxCount versions are kept of each file and versions are kept for maxAge,
+// a zero maxCount or maxAge is unlimited. If logger is nil then it defaults to logrus.New().
+func NewVersions(
+	store VersionStore,
+	uploadConn *grpcPoolTypes.ConnPool,
+	fileConn *grpcPoolTypes.ConnPool,
+	maxCount int,
+	maxAge time.Duration,
+	logger *logrus.Logger,
+) *Versions {
+	// If no logger is given, use a default logger.
+	if logger == nil {
+		logger = logrus.New()
+	}
+
+	v := &Versions{store: store, logger: logger, maxCount: maxCount, maxAge: maxAge}
+
+	v.uploadClient = func() upb.UploadClient {
+		return upb.NewUploadClient((*uploadConn).Conn())
+	}
+
+	v.quotaClient = func() qpb.QuotaServiceClient {
+		return qpb.NewQuotaServiceClient((*fileConn).Conn())
+	}
+
+	return v
+}
+
+// Archive keeps the current content of file as a version that was replaced by replacedBy, and prunes
+// the versions of file by the retention policy. The content's author is the user that replaced the
+// previous version, or the file's owner if there's none. If an error is returned then the version
+// wasn't kept and its object should be deleted by the caller.
+func (v *Versions) Archive(ctx context.Context, file *fpb.File, replacedBy string) (*Version, error) {
+	previous, err := v.store.List(ctx, file.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	authorID := file.GetOwnerID()
+	if len(previous) > 0 && previous[0].ReplacedBy != "" {
+		authorID = previous[0].ReplacedBy
+	}
+
+	version := &Version{
+		ID:         uuid.NewV4().String(),
+		FileID:     file.GetId(),
+		OwnerID:    file.GetOwnerID(),
+		Key:        file.GetKey(),
+		Bucket:     file.GetBucket(),
+		Name:       file.GetName(),
+		Type:       file.GetType(),
+		Size:       file.GetSize(),
+		AuthorID:   authorID,
+		ReplacedBy: replacedBy,
+		CreatedAt:  fileLastModified(file),
+		ArchivedAt: time.Now(),
+	}
+
+	if err := v.store.Add(ctx, version); err != nil {
+		return nil, err
+	}
+
+	if err := v.updateQuota(ctx, version.OwnerID, version.Size); err != nil {
+		_, removeErr := v.store.Remove(ctx, version.ID)
+		loggermiddleware.LogError(v.logger, removeErr)
+
+		return nil, err
+	}
+
+	if err := v.prune(ctx, append([]*Version{version}, previous...)); err != nil {
+		loggermiddleware.LogError(v.logger, fmt.Errorf("failed pruning versions of file %s: %v", file.GetId(), err))
+	}
+
+	return version, nil
+}
+
+// get returns the version of fileID with versionID, or ErrVersionNotFound if fileID has no such version.
+func (v *Versions) get(ctx context.Context, fileID string, versionID string) (*Version, error) {
+	version, err := v.store.Get(ctx, versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if version.FileID != fileID {
+		return nil, ErrVersionNotFound
+	}
+
+	return version, nil
+}
+
+// prune deletes the versions of a file, the latest archived first,
+// that are beyond the maximal count or older than the maximal age.
+func (v *Versions) prune(ctx context.Context, versions []*Version) error {
+	expiry := time.Now().Add(-v.maxAge)
+	for i, version := range versions {
+		if (v.maxCount > 0 && i >= v.maxCount) || (v.maxAge > 0 && version.ArchivedAt.Before(expiry)) {
+			if err := v.delete(ctx, version); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// delete deletes the object of version and removes it from the store and from its owner's quota.
+func (v *Versions) delete(ctx context.Context, version *Version) error {
+	deleteObjectsResponse, err := v.uploadClient().DeleteObjects(ctx, &upb.DeleteObjectsRequest{
+		Bucket: version.Bucket,
+		Keys:   []string{version.Key},
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(deleteObjectsResponse.GetFailed()) != 0 {
+		return fmt.Errorf("failed deleting object of version %s", version.ID)
+	}
+
+	return v.release(ctx, version)
+}
+
+// release removes version from the store and from its owner's quota, keeping its object.
+// The quota is released only if version was removed by this call, so a version that's
+// released concurrently, e.g. by the prunes of several gateways, is released once.
+func (v *Versions) release(ctx context.Context, version *Version) error {
+	removed, err := v.store.Remove(ctx, version.ID)
+	if err != nil || !removed {
+		return err
+	}
+
+	if err := v.updateQuota(ctx, version.OwnerID, -version.Size); err != nil {
+		loggermiddleware.LogError(v.logger, fmt.Errorf("failed releasing quota of version %s: %v", version.ID, err))
+	}
+
+	return nil
+}
+
+// updateQuota adds size bytes to the used quota of ownerID, size is negative to release quota.
+func (v *Versions) updateQuota(ctx context.Context, ownerID string, size int64) error {
+	res, err := v.quotaClient().UpdateQuota(ctx, &qpb.UpdateQuotaRequest{OwnerID: ownerID, Size: size})
+	if err != nil {
+		return err
+	}
+
+	if !res.GetSuccess() {
+		return fmt.Errorf("failed updating quota of %s by %d bytes", ownerID, size)
+	}
+
+	return nil
+}
+
+// deleteFiles deletes the versions of fileIDs, after the files were deleted permanently.
+// Versions that fail to be deleted are logged and left for the retention policy.
+func (v *Versions) deleteFiles(ctx context.Context, fileIDs []string) {
+	for _, fileID := range fileIDs {
+		versions, err := v.store.List(ctx, fileID)
+		if err != nil {
+			loggermiddleware.LogError(v.logger, fmt.Errorf("failed listing versions of file %s: %v", fileID, err))
+			continue
+		}
+
+		for _, version := range versions {
+			if err := v.delete(ctx, version); err != nil {
+				loggermiddleware.LogError(v.logger, fmt.Errorf("failed deleting version %s: %v", version.ID, err))
+			}
+		}
+	}
+}
+
+// PruneExpired deletes up to versionPruneBatchSize versions that were archived before t.
+// Versions that fail to be deleted are logged and left for the next prune.
+// Returns the number of pruned versions.
+func (v *Versions) PruneExpired(ctx context.Context, t time.Time) (int, error) {
+	versions, err := v.store.ListArchivedBefore(ctx, t, versionPruneBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for _, version := range versions {
+		if err := v.delete(ctx, version); err != nil {
+			loggermiddleware.LogError(v.logger, fmt.Errorf("failed pruning version %s: %v", version.ID, err))
+			continue
+		}
+
+		pruned++
+	}
+
+	return pruned, nil
+}
+
+// PruneExpiredPeriodically prunes the versions that are older than the maximal age every interval.
+// It returns immediately if versions are kept regardless of their age.
+func (v *Versions) PruneExpiredPeriodically(interval time.Duration) {
+	if v.maxAge <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		pruned, err := v.PruneExpired(context.Background(), time.Now().Add(-v.maxAge))
+		if err != nil {
+			loggermiddleware.LogError(v.logger, fmt.Errorf("failed pruning versions: %v", err))
+		}
+
+		if pruned > 0 {
+			v.logger.Infof("pruned %d file versions", pruned)
+		}
+	}
+}
+
+// file returns the file of the content of version.
+func (version *Version) file() *fpb.File {
+	return &fpb.File{
+		Id:        version.FileID,
+		Key:       version.Key,
+		Bucket:    version.Bucket,
+		Name:      version.Name,
+		Type:      version.Type,
+		Size:      version.Size,
+		OwnerID:   version.OwnerID,
+		UpdatedAt: version.CreatedAt.UnixNano() / int64(time.Millisecond),
+	}
+}
+
+// newVersionResponse creates the json response of version.
+func newVersionResponse(version *Version) *VersionResponse {
+	return &VersionResponse{
+		ID:         version.ID,
+		FileID:     version.FileID,
+		Name:       version.Name,
+		Type:       version.Type,
+		Size:       version.Size,
+		AuthorID:   version.AuthorID,
+		CreatedAt:  version.CreatedAt,
+		ArchivedAt: version.ArchivedAt,
+	}
+}
+
+// setupVersions initializes the version routes under rg.
+func (r *Router) setupVersions(rg *gin.RouterGroup) {
+	checkGetFileScope := r.oAuthMiddleware.AuthorizationScopeMiddleware(oauth.GetFileScope)
+
+	rg.GET("/files/:id/versions", checkGetFileScope, r.ListVersions)
+	rg.GET("/files/:id/versions/:"+ParamVersionID, checkGetFileScope, r.GetVersion)
+	rg.POST("/files/:id/versions/:"+ParamVersionID+"/restore", r.RestoreVersion)
+}
+
+// authorizeVersions checks that the requester has role to the file id param, that the requesting app
+// is one of allowedApps and that the file isn't trashed. Returns the file id, or "" if the request was aborted.
+func (r *Router) authorizeVersions(c *gin.Context, role ppb.Role, allowedApps []string) string {
+	fileID := c.Param(ParamFileID)
+	if fileID == "" {
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fileIDIsRequiredMessage)
+		return ""
+	}
+
+	if err := validateAppID(c, fileID, r.fileClient(), allowedApps); err != nil {
+		loggermiddleware.LogError(r.logger, err)
+		return ""
+	}
+
+	if userRole, _ := r.HandleUserFilePermission(c, fileID, role); userRole == "" {
+		return ""
+	}
+
+	if r.isTrashed(c.Request.Context(), fileID) {
+		apierror.AbortWithMessage(c, http.StatusNotFound, fileIsTrashedMessage)
+		return ""
+	}
+
+	return fileID
+}
+
+// version returns the version with the version id param of the file of fileID.
+// If the version doesn't exist then the request is aborted and nil is returned.
+func (r *Router) version(c *gin.Context, fileID string) *Version {
+	if r.versions == nil {
+		apierror.AbortWithMessage(c, http.StatusNotFound, versionNotFoundMessage)
+		return nil
+	}
+
+	version, err := r.versions.get(c.Request.Context(), fileID, c.Param(ParamVersionID))
+	if err == ErrVersionNotFound {
+		apierror.AbortWithMessage(c, http.StatusNotFound, versionNotFoundMessage)
+		return nil
+	}
+
+	if err != nil {
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusInternalServerError, err))
+		return nil
+	}
+
+	return version
+}
+
+// ListVersions is the request handler for GET /files/:id/versions request.
+// It returns the versions of the file, the latest first.
+func (r *Router) ListVersions(c *gin.Context) {
+	fileID := r.authorizeVersions(c, GetFileByIDRole, AllowedDownloadApps)
+	if fileID == "" {
+		return
+	}
+
+	responses := make([]*VersionResponse, 0)
+	if r.versions == nil {
+		c.JSON(http.StatusOK, responses)
+		return
+	}
+
+	versions, err := r.versions.store.List(c.Request.Context(), fileID)
+	if err != nil {
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusInternalServerError, err))
+		return
+	}
+
+	for _, version := range versions {
+		responses = append(responses, newVersionResponse(version))
+	}
+
+	c.JSON(http.StatusOK, responses)
+}
+
+// GetVersion is the request handler for GET /files/:id/versions/:versionId request.
+// It returns the version's metadata, or downloads or previews its content if alt=media is requested,
+// the same as the file's current content.
+func (r *Router) GetVersion(c *gin.Context) {
+	fileID := r.authorizeVersions(c, DownloadRole, AllowedDownloadApps)
+	if fileID == "" {
+		return
+	}
+
+	version := r.version(c, fileID)
+	if version == nil {
+		return
+	}
+
+	if c.Query("alt") != "media" {
+		c.JSON(http.StatusOK, newVersionResponse(version))
+		return
+	}
+
+	if !r.oAuthMiddleware.ValidateRequiredScope(c, oauth.DownloadScope) {
+		loggermiddleware.LogError(r.logger, apierror.AbortWithMessage(
+			c,
+			http.StatusForbidden,
+			fmt.Sprintf("required scope '%s' is not supplied", oauth.DownloadScope),
+		))
+
+		return
+	}
+
+	r.serveContent(c, version.file())
+}
+
+// RestoreVersion is the request handler for POST /files/:id/versions/:versionId/restore request.
+// The version's content becomes the file's current content, and the replaced content is kept as
+// a version so the restore can be undone. Returns the restored file.
+func (r *Router) RestoreVersion(c *gin.Context) {
+	reqUser := user.ExtractRequestUser(c)
+	if reqUser == nil {
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
+		return
+	}
+
+	fileID := r.authorizeVersions(c, UpdateFileRole, AllowedAllOperationsApps)
+	if fileID == "" {
+		return
+	}
+
+	version := r.version(c, fileID)
+	if version == nil {
+		return
+	}
+
+	ctx := c.Request.Context()
+	current, err := r.fileClient().GetFileByID(ctx, &fpb.GetByFileByIDRequest{Id: fileID})
+	if err != nil {
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+		return
+	}
+
+	updateFilesResponse, err := r.fileClient().UpdateFiles(ctx, &fpb.UpdateFilesRequest{
+		IdList: []string{fileID},
+		PartialFile: &fpb.File{
+			Key:  version.Key,
+			Type: version.Type,
+			Name: version.Name,
+			Size: version.Size,
+		},
+	})
+	if err == nil && len(updateFilesResponse.GetFailedFiles()) != 0 {
+		err = fmt.Errorf("failed restoring version %s of file %s", version.ID, fileID)
+	}
+
+	if err != nil {
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+		return
+	}
+
+	InvalidateContentCaches(fileID, r.previewCache, r.thumbnailCache)
+
+	// The restored content is the file's content now, so its version is released without deleting its object.
+	if err := r.versions.release(ctx, version); err != nil {
+		loggermiddleware.LogError(r.logger, fmt.Errorf("failed releasing restored version %s: %v", version.ID, err))
+	}
+
+	if _, err := r.versions.Archive(ctx, current, reqUser.ID); err != nil {
+		loggermiddleware.LogError(r.logger, fmt.Errorf("failed keeping version of file %s: %v", fileID, err))
+		r.deleteObject(ctx, current.GetBucket(), current.GetKey())
+	}
+
+	restored, err := r.fileClient().GetFileByID(ctx, &fpb.GetByFileByIDRequest{Id: fileID})
+	if err != nil {
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+		return
+	}
+
+	c.JSON(http.StatusOK, CreateGetFileResponse(restored, "", nil))
+}
+
+// deleteObject deletes the object of key in bucket, logging the error if it failed.
+func (r *Router) deleteObject(ctx context.Context, bucket string, key string) {
+	deleteObjectsResponse, err := r.uploadClient().DeleteObjects(ctx, &upb.DeleteObjectsRequest{
+		Bucket: bucket,
+		Keys:   []string{key},
+	})
+	if err == nil && len(deleteObjectsResponse.GetFailed()) != 0 {
+		err = fmt.Errorf("failed deleting key %s", key)
+	}
+
+	loggermiddleware.LogError(r.logger, err)
+}
+
+// deleteVersions deletes the versions of fileIDs after they were deleted permanently.
+func (r *Router) deleteVersions(ctx context.Context, fileIDs []string) {
+	if r.versions != nil {
+		r.versions.deleteFiles(ctx, fileIDs)
+	}
+}
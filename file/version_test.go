@@ -0,0 +1,226 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	fpb "github.com/meateam/file-service/proto/file"
+	qpb "github.com/meateam/file-service/proto/quota"
+	upb "github.com/meateam/upload-service/proto"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+// memVersionStore is a VersionStore in memory.
+type memVersionStore struct {
+	mu       sync.Mutex
+	versions map[string]*Version
+}
+
+func newMemVersionStore() *memVersionStore {
+	return &memVersionStore{versions: make(map[string]*Version)}
+}
+
+func (s *memVersionStore) Add(_ context.Context, version *Version) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versions[version.ID] = version
+
+	return nil
+}
+
+func (s *memVersionStore) Get(_ context.Context, versionID string) (*Version, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	version, ok := s.versions[versionID]
+	if !ok {
+		return nil, ErrVersionNotFound
+	}
+
+	copied := *version
+
+	return &copied, nil
+}
+
+func (s *memVersionStore) list(match func(*Version) bool) []*Version {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions := make([]*Version, 0, len(s.versions))
+	for _, version := range s.versions {
+		if match(version) {
+			versions = append(versions, version)
+		}
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].ArchivedAt.After(versions[j].ArchivedAt) })
+
+	return versions
+}
+
+func (s *memVersionStore) List(_ context.Context, fileID string) ([]*Version, error) {
+	return s.list(func(version *Version) bool { return version.FileID == fileID }), nil
+}
+
+func (s *memVersionStore) ListArchivedBefore(_ context.Context, t time.Time, limit int) ([]*Version, error) {
+	versions := s.list(func(version *Version) bool { return version.ArchivedAt.Before(t) })
+	if len(versions) > limit {
+		versions = versions[:limit]
+	}
+
+	return versions, nil
+}
+
+func (s *memVersionStore) Remove(_ context.Context, versionID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.versions[versionID]
+	delete(s.versions, versionID)
+
+	return ok, nil
+}
+
+// fakeObjectClient is an upload service that records the deleted keys.
+type fakeObjectClient struct {
+	upb.UploadClient
+	deleted []string
+}
+
+func (f *fakeObjectClient) DeleteObjects(
+	_ context.Context,
+	in *upb.DeleteObjectsRequest,
+	_ ...grpc.CallOption) (*upb.DeleteObjectsResponse, error) {
+	f.deleted = append(f.deleted, in.GetKeys()...)
+
+	return &upb.DeleteObjectsResponse{Deleted: in.GetKeys()}, nil
+}
+
+// fakeQuotaClient is a quota service that sums the used quota of each owner.
+type fakeQuotaClient struct {
+	qpb.QuotaServiceClient
+	used map[string]int64
+}
+
+func (f *fakeQuotaClient) UpdateQuota(
+	_ context.Context,
+	in *qpb.UpdateQuotaRequest,
+	_ ...grpc.CallOption) (*qpb.UpdateQuotaResponse, error) {
+	f.used[in.GetOwnerID()] += in.GetSize()
+
+	return &qpb.UpdateQuotaResponse{Success: true}, nil
+}
+
+// newTestVersions creates Versions in store that keeps maxCount versions for maxAge.
+func newTestVersions(
+	store VersionStore,
+	maxCount int,
+	maxAge time.Duration) (*Versions, *fakeObjectClient, *fakeQuotaClient) {
+	objects := &fakeObjectClient{}
+	quota := &fakeQuotaClient{used: make(map[string]int64)}
+
+	v := &Versions{store: store, logger: logrus.New(), maxCount: maxCount, maxAge: maxAge}
+	v.uploadClient = func() upb.UploadClient { return objects }
+	v.quotaClient = func() qpb.QuotaServiceClient { return quota }
+
+	return v, objects, quota
+}
+
+func TestVersions_Archive(t *testing.T) {
+	store := newMemVersionStore()
+	versions, objects, quota := newTestVersions(store, 2, 0)
+	ctx := context.Background()
+
+	// Each content is replaced by the next user, the owner uploaded the first content.
+	for i, replacedBy := range []string{"editor", "reviewer", "owner"} {
+		key := fmt.Sprintf("k%d", i+1)
+		file := &fpb.File{Id: "file", OwnerID: "owner", Key: key, Size: 10, UpdatedAt: int64(i+1) * 1000}
+		if _, err := versions.Archive(ctx, file, replacedBy); err != nil {
+			t.Fatalf("failed archiving version %d: %v", i, err)
+		}
+
+		// Keep the versions ordered by their archive time.
+		time.Sleep(time.Millisecond)
+	}
+
+	list, _ := store.List(ctx, "file")
+	if len(list) != 2 {
+		t.Fatalf("expected the versions to be pruned to 2, got %d", len(list))
+	}
+
+	if list[0].Key != "k3" || list[0].AuthorID != "reviewer" ||
+		list[1].Key != "k2" || list[1].AuthorID != "editor" {
+		t.Errorf("expected the latest versions authored by the users that replaced their previous ones, got %+v %+v",
+			list[0], list[1])
+	}
+
+	if len(objects.deleted) != 1 || objects.deleted[0] != "k1" {
+		t.Errorf("expected the oldest version's object to be deleted, got %v", objects.deleted)
+	}
+
+	if quota.used["owner"] != 20 {
+		t.Errorf("expected the kept versions to use 20 bytes of the owner's quota, got %d", quota.used["owner"])
+	}
+
+	if list[0].file().GetUpdatedAt() != 3000 {
+		t.Errorf("expected the version's file to be updated at its upload time, got %d", list[0].file().GetUpdatedAt())
+	}
+}
+
+func TestVersions_PruneExpired(t *testing.T) {
+	store := newMemVersionStore()
+	versions, objects, quota := newTestVersions(store, 0, 24*time.Hour)
+	ctx := context.Background()
+
+	now := time.Now()
+	store.Add(ctx, &Version{ID: "old", FileID: "file", OwnerID: "owner", Key: "old", Size: 5,
+		ArchivedAt: now.Add(-48 * time.Hour)})
+	store.Add(ctx, &Version{ID: "new", FileID: "file", OwnerID: "owner", Key: "new", Size: 7, ArchivedAt: now})
+	quota.used["owner"] = 12
+
+	pruned, err := versions.PruneExpired(ctx, now.Add(-versions.maxAge))
+	if err != nil || pruned != 1 {
+		t.Fatalf("expected a single version to be pruned, got %d with error %v", pruned, err)
+	}
+
+	if _, err := store.Get(ctx, "old"); err != ErrVersionNotFound {
+		t.Errorf("expected the expired version to be removed, got %v", err)
+	}
+
+	if len(objects.deleted) != 1 || objects.deleted[0] != "old" || quota.used["owner"] != 7 {
+		t.Errorf("expected the expired version's object and quota to be released, got %v and %d bytes",
+			objects.deleted, quota.used["owner"])
+	}
+
+	if _, err := versions.get(ctx, "other", "new"); err != ErrVersionNotFound {
+		t.Errorf("expected a version not to be found by another file, got %v", err)
+	}
+
+	versions.deleteFiles(ctx, []string{"file"})
+	if list, _ := store.List(ctx, "file"); len(list) != 0 || quota.used["owner"] != 0 {
+		t.Errorf("expected the versions of a deleted file to be deleted, got %d versions", len(list))
+	}
+}
+
+func TestVersions_ReleaseOnce(t *testing.T) {
+	store := newMemVersionStore()
+	versions, _, quota := newTestVersions(store, 0, 0)
+	ctx := context.Background()
+
+	version := &Version{ID: "version", FileID: "file", OwnerID: "owner", Key: "version", Size: 5}
+	store.Add(ctx, version)
+	quota.used["owner"] = 5
+
+	// The version is pruned twice, as by two gateways, but its quota is released once.
+	for i := 0; i < 2; i++ {
+		if err := versions.delete(ctx, version); err != nil {
+			t.Fatalf("failed deleting version: %v", err)
+		}
+	}
+
+	if quota.used["owner"] != 0 {
+		t.Errorf("expected the version's quota to be released once, got %d bytes used", quota.used["owner"])
+	}
+}
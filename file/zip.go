@@ -0,0 +1,366 @@
+package file
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
+	loggermiddleware "github.com/meateam/api-gateway/logger"
+	"github.com/meateam/api-gateway/metrics"
+	"github.com/meateam/api-gateway/oauth"
+	"github.com/meateam/api-gateway/user"
+	dpb "github.com/meateam/download-service/proto"
+	fpb "github.com/meateam/file-service/proto/file"
+	ppb "github.com/meateam/permission-service/proto"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ConfigZipMaxSize is the name of the environment variable containing
+	// the maximum total bytes of the files in a zip download.
+	ConfigZipMaxSize = "zip_max_size"
+
+	// ConfigZipMaxEntries is the name of the environment variable containing
+	// the maximum number of files and folders in a zip download.
+	ConfigZipMaxEntries = "zip_max_entries"
+
+	// QueryZipFileID is the querystring key of the ids of the files and folders to download as a zip,
+	// may be repeated.
+	QueryZipFileID = "id"
+
+	// ZipMimeType is the mime type of a .zip file.
+	ZipMimeType = "application/zip"
+
+	// defaultZipName is the name of a zip download of multiple files.
+	defaultZipName = "download"
+)
+
+// zipEntry is a file or a folder in a zip download, at path in the archive.
+type zipEntry struct {
+	file *fpb.File
+	path string
+}
+
+// zipNames allocates unique paths to the entries of a zip download.
+type zipNames map[string]bool
+
+// unique returns a path in dir for name that isn't used by another entry, and marks it used.
+// Paths are compared case-insensitively, so the archive extracts well on case-insensitive file systems.
+// On collision a counter is added before the extension of name, e.g. "report (1).pdf".
+func (n zipNames) unique(dir string, name string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	if name == "" || name == "." || name == ".." {
+		name = "_"
+	}
+
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if base == "" {
+		base, ext = name, ""
+	}
+
+	candidate := path.Join(dir, name)
+	for i := 1; n[strings.ToLower(candidate)]; i++ {
+		candidate = path.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+	}
+
+	n[strings.ToLower(candidate)] = true
+
+	return candidate
+}
+
+// isFolder reports whether file is a folder.
+func isFolder(file *fpb.File) bool {
+	return file.GetType() == FolderMimeType
+}
+
+// DownloadZip is the request handler for GET /zip?id=<id>&id=<id>.
+// It streams a zip of the requested files and folders, with the folders' descendants
+// that the requester is permitted to download, preserving their structure.
+func (r *Router) DownloadZip(c *gin.Context) {
+	fileIDs := c.QueryArray(QueryZipFileID)
+	if len(fileIDs) == 0 {
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fileIDIsRequiredMessage)
+		return
+	}
+
+	if !r.oAuthMiddleware.ValidateRequiredScope(c, oauth.DownloadScope) {
+		loggermiddleware.LogError(r.logger, apierror.AbortWithMessage(
+			c,
+			http.StatusForbidden,
+			fmt.Sprintf("required scope '%s' is not supplied", oauth.DownloadScope),
+		))
+
+		return
+	}
+
+	entries, err := r.zipEntries(c, fileIDs)
+	if err != nil {
+		loggermiddleware.LogError(r.logger, err)
+		return
+	}
+
+	if entries == nil {
+		return
+	}
+
+	if err := checkZipLimits(entries); err != nil {
+		loggermiddleware.LogError(
+			r.logger,
+			apierror.AbortWithMessage(c, http.StatusRequestEntityTooLarge, err.Error()),
+		)
+
+		return
+	}
+
+	zipName := defaultZipName
+	if len(fileIDs) == 1 {
+		zipName = entries[0].path
+	}
+
+	loggermiddleware.LogError(r.logger, r.streamZip(c, zipName+".zip", entries))
+}
+
+// zipEntries returns the entries of a zip of fileIDs and the descendants of the folders among them.
+// The requester must be permitted to download each of fileIDs, descendants that the requester
+// isn't permitted to download are skipped. If the request was aborted then it returns nil entries.
+func (r *Router) zipEntries(c *gin.Context, fileIDs []string) ([]zipEntry, error) {
+	reqUser := user.ExtractRequestUser(c)
+	if reqUser == nil {
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
+		return nil, nil
+	}
+
+	names := make(zipNames)
+	entries := make([]zipEntry, 0, len(fileIDs))
+	for _, fileID := range fileIDs {
+		if err := validateAppID(c, fileID, r.fileClient(), AllowedDownloadApps); err != nil {
+			return nil, err
+		}
+
+		if role, _ := r.HandleUserFilePermission(c, fileID, DownloadRole); role == "" {
+			return nil, nil
+		}
+
+		if r.isTrashed(c.Request.Context(), fileID) {
+			return nil, apierror.AbortWithMessage(c, http.StatusNotFound, fileIsTrashedMessage)
+		}
+
+		file, err := r.fileClient().GetFileByID(c.Request.Context(), &fpb.GetByFileByIDRequest{Id: fileID})
+		if err != nil {
+			httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+			return nil, apierror.Abort(c, httpStatusCode, err)
+		}
+
+		entries = append(entries, zipEntry{file: file, path: names.unique("", file.GetName())})
+		if !isFolder(file) {
+			continue
+		}
+
+		descendants, err := PermittedDescendants(
+			c.Request.Context(),
+			r.fileClient(),
+			r.permissionClient(),
+			r.permissionCache,
+			r.trash,
+			reqUser.ID,
+			file.GetId(),
+			DownloadRole,
+		)
+		if err != nil {
+			httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+			return nil, apierror.Abort(c, httpStatusCode, err)
+		}
+
+		entries = append(entries, descendantEntries(entries[len(entries)-1], descendants, names)...)
+	}
+
+	return entries, nil
+}
+
+// PermittedDescendants returns the descendants of folderID that userID is permitted to with role.
+// The permissions are resolved by a PermissionResolver, reading and writing cache if it's non-nil.
+// If trash is non-nil then the descendants that are in it, or under a descendant that is, are skipped.
+func PermittedDescendants(
+	ctx context.Context,
+	fileClient fpb.FileServiceClient,
+	permissionClient ppb.PermissionClient,
+	cache *PermissionCache,
+	trash TrashStore,
+	userID string,
+	folderID string,
+	role ppb.Role) ([]*fpb.GetDescendantsByIDResponse_Descendant, error) {
+	res, err := fileClient.GetDescendantsByID(ctx, &fpb.GetDescendantsByIDRequest{Id: folderID})
+	if err != nil {
+		return nil, err
+	}
+
+	untrashed, err := withoutTrashedDescendants(ctx, trash, res.GetDescendants())
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]*fpb.File, 0, len(untrashed))
+	for _, descendant := range untrashed {
+		files = append(files, descendant.GetFile())
+	}
+
+	resolver := NewPermissionResolver(fileClient, permissionClient, cache, userID, role)
+	descendants := make([]*fpb.GetDescendantsByIDResponse_Descendant, 0, len(untrashed))
+	for i, resolved := range resolver.ResolveFiles(ctx, files) {
+		if resolved.Err != nil {
+			return nil, resolved.Err
+		}
+
+		if resolved.Role != "" {
+			descendants = append(descendants, untrashed[i])
+		}
+	}
+
+	return descendants, nil
+}
+
+// descendantEntries returns the entries of descendants of the folder of root, placed under their parents.
+// Descendants whose parent isn't in the zip, because the requester isn't permitted to it, are skipped.
+func descendantEntries(
+	root zipEntry,
+	descendants []*fpb.GetDescendantsByIDResponse_Descendant,
+	names zipNames) []zipEntry {
+	children := make(map[string][]*fpb.File, len(descendants))
+	for _, descendant := range descendants {
+		parentID := descendant.GetParent().GetId()
+		children[parentID] = append(children[parentID], descendant.GetFile())
+	}
+
+	entries := make([]zipEntry, 0, len(descendants))
+	folders := []zipEntry{root}
+	for len(folders) > 0 {
+		folder := folders[0]
+		folders = folders[1:]
+
+		for _, file := range children[folder.file.GetId()] {
+			entry := zipEntry{file: file, path: names.unique(folder.path, file.GetName())}
+			entries = append(entries, entry)
+			if isFolder(file) {
+				folders = append(folders, entry)
+			}
+		}
+	}
+
+	return entries
+}
+
+// checkZipLimits returns an error if entries exceed the configured zip size or entry count limits.
+func checkZipLimits(entries []zipEntry) error {
+	if maxEntries := viper.GetInt(ConfigZipMaxEntries); maxEntries > 0 && len(entries) > maxEntries {
+		return fmt.Errorf("zip has %d files and folders, more than the limit of %d", len(entries), maxEntries)
+	}
+
+	size := int64(0)
+	for _, entry := range entries {
+		size += entry.file.GetSize()
+	}
+
+	if maxSize := viper.GetInt64(ConfigZipMaxSize); maxSize > 0 && size > maxSize {
+		return fmt.Errorf("zip has %d bytes of files, more than the limit of %d bytes", size, maxSize)
+	}
+
+	return nil
+}
+
+// streamZip streams a zip named zipName of entries to c, downloading each file only when it's written.
+// If a download fails before anything was written then an error is responded,
+// otherwise the zip is cut off since its status was already sent.
+func (r *Router) streamZip(c *gin.Context, zipName string, entries []zipEntry) error {
+	c.Header("Content-Type", ZipMimeType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Disposition", "attachment; filename="+zipName)
+
+	zw := zip.NewWriter(c.Writer)
+	for _, entry := range entries {
+		header := &zip.FileHeader{
+			Name:     entry.path,
+			Method:   zip.Deflate,
+			Modified: fileLastModified(entry.file),
+		}
+
+		if isFolder(entry.file) {
+			header.Name += "/"
+			header.Method = zip.Store
+			if _, err := zw.CreateHeader(header); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		stream, err := r.downloadClient().Download(c.Request.Context(), &dpb.DownloadRequest{
+			Key:    entry.file.GetKey(),
+			Bucket: entry.file.GetBucket(),
+		})
+		if err == nil {
+			err = r.writeZipFile(c, zw, header, stream)
+		}
+
+		if err != nil {
+			if c.Writer.Written() {
+				return fmt.Errorf("failed writing %s to zip: %v", entry.path, err)
+			}
+
+			httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+			return apierror.Abort(c, httpStatusCode, err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	c.Writer.Flush()
+
+	return nil
+}
+
+// writeZipFile writes the file of header to zw from its download stream.
+// The first chunk is received before the file's header is written, so a failing download of
+// the first file in the zip can still be responded with an error.
+func (r *Router) writeZipFile(
+	c *gin.Context,
+	zw *zip.Writer,
+	header *zip.FileHeader,
+	stream dpb.Download_DownloadClient) error {
+	chunk, err := stream.Recv()
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	w, createErr := zw.CreateHeader(header)
+	if createErr != nil {
+		return createErr
+	}
+
+	for err != io.EOF {
+		written, writeErr := w.Write(chunk.GetFile())
+		metrics.AddStreamedBytes(metrics.DirectionDownload, written)
+		if writeErr != nil {
+			return writeErr
+		}
+
+		c.Writer.Flush()
+
+		chunk, err = stream.Recv()
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+
+	return stream.CloseSend()
+}
@@ -0,0 +1,199 @@
+package file
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	dpb "github.com/meateam/download-service/proto"
+	fpb "github.com/meateam/file-service/proto/file"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeDownloadClient downloads the content of keys in chunks of 3 bytes.
+type fakeDownloadClient struct {
+	contents map[string]string
+}
+
+func (d *fakeDownloadClient) Download(
+	_ context.Context,
+	in *dpb.DownloadRequest,
+	_ ...grpc.CallOption) (dpb.Download_DownloadClient, error) {
+	content, ok := d.contents[in.GetKey()]
+	if !ok {
+		return &fakeDownloadStream{err: status.Error(codes.NotFound, "not found")}, nil
+	}
+
+	return &fakeDownloadStream{content: content, chunkSize: 3}, nil
+}
+
+func TestZipNames_Unique(t *testing.T) {
+	names := make(zipNames)
+	got := []string{
+		names.unique("", "docs"),
+		names.unique("docs", "report.pdf"),
+		names.unique("docs", "Report.pdf"),
+		names.unique("docs", "report.pdf"),
+		names.unique("docs", ".env"),
+		names.unique("docs", ".env"),
+		names.unique("docs", "a/b"),
+		names.unique("docs", ".."),
+	}
+
+	want := []string{
+		"docs",
+		"docs/report.pdf",
+		"docs/Report (1).pdf",
+		"docs/report (2).pdf",
+		"docs/.env",
+		"docs/.env (1)",
+		"docs/a_b",
+		"docs/_",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected names %v, got %v", want, got)
+	}
+}
+
+func TestDescendantEntries(t *testing.T) {
+	root := &fpb.File{Id: "root", Name: "root", Type: FolderMimeType}
+	sub := &fpb.File{Id: "sub", Name: "sub", Type: FolderMimeType}
+	hidden := &fpb.File{Id: "hidden", Name: "hidden", Type: FolderMimeType}
+	descendants := []*fpb.GetDescendantsByIDResponse_Descendant{
+		{File: &fpb.File{Id: "a", Name: "a.txt"}, Parent: sub},
+		{File: sub, Parent: root},
+		{File: &fpb.File{Id: "b", Name: "a.txt"}, Parent: sub},
+		{File: &fpb.File{Id: "c", Name: "c.txt"}, Parent: root},
+		{File: &fpb.File{Id: "d", Name: "d.txt"}, Parent: hidden},
+	}
+
+	names := make(zipNames)
+	rootEntry := zipEntry{file: root, path: names.unique("", root.GetName())}
+	var got []string
+	for _, entry := range descendantEntries(rootEntry, descendants, names) {
+		got = append(got, entry.path)
+	}
+
+	want := []string{"root/sub", "root/c.txt", "root/sub/a.txt", "root/sub/a (1).txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected paths %v, got %v", want, got)
+	}
+}
+
+func TestCheckZipLimits(t *testing.T) {
+	maxEntries, maxSize := viper.Get(ConfigZipMaxEntries), viper.Get(ConfigZipMaxSize)
+	defer func() {
+		viper.Set(ConfigZipMaxEntries, maxEntries)
+		viper.Set(ConfigZipMaxSize, maxSize)
+	}()
+
+	entries := []zipEntry{{file: &fpb.File{Size: 6}}, {file: &fpb.File{Size: 5}}}
+	viper.Set(ConfigZipMaxEntries, 0)
+	viper.Set(ConfigZipMaxSize, 0)
+	if err := checkZipLimits(entries); err != nil {
+		t.Errorf("expected disabled limits not to fail, got %v", err)
+	}
+
+	viper.Set(ConfigZipMaxEntries, 1)
+	if err := checkZipLimits(entries); err == nil {
+		t.Error("expected an error when exceeding the entries limit")
+	}
+
+	viper.Set(ConfigZipMaxEntries, 2)
+	viper.Set(ConfigZipMaxSize, 10)
+	if err := checkZipLimits(entries); err == nil {
+		t.Error("expected an error when exceeding the size limit")
+	}
+}
+
+// serveZip streams a zip of entries with files downloaded from contents.
+func serveZip(entries []zipEntry, contents map[string]string) (*httptest.ResponseRecorder, error) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/zip", nil)
+
+	r := &Router{logger: logrus.New()}
+	r.downloadClient = func() dpb.DownloadClient {
+		return &fakeDownloadClient{contents: contents}
+	}
+
+	err := r.streamZip(c, "docs.zip", entries)
+	c.Writer.WriteHeaderNow()
+
+	return w, err
+}
+
+func TestStreamZip(t *testing.T) {
+	entries := []zipEntry{
+		{file: &fpb.File{Name: "docs", Type: FolderMimeType}, path: "docs"},
+		{file: &fpb.File{Key: "a", Name: "a.txt", UpdatedAt: 1627207200000}, path: "docs/a.txt"},
+		{file: &fpb.File{Key: "empty", Name: "empty.txt"}, path: "docs/empty.txt"},
+	}
+
+	w, err := serveZip(entries, map[string]string{"a": "hello world", "empty": ""})
+	if err != nil {
+		t.Fatalf("failed streaming zip: %v", err)
+	}
+
+	if got := w.Header().Get("Content-Type"); got != ZipMimeType {
+		t.Errorf("expected content type %s, got %s", ZipMimeType, got)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("failed reading zip: %v", err)
+	}
+
+	want := map[string]string{"docs/": "", "docs/a.txt": "hello world", "docs/empty.txt": ""}
+	if len(zr.File) != len(want) {
+		t.Fatalf("expected %d entries, got %d", len(want), len(zr.File))
+	}
+
+	for _, f := range zr.File {
+		content, ok := want[f.Name]
+		if !ok {
+			t.Errorf("unexpected entry %s", f.Name)
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("failed opening %s: %v", f.Name, err)
+		}
+
+		got, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if string(got) != content {
+			t.Errorf("expected %s to contain %q, got %q", f.Name, content, got)
+		}
+	}
+
+	if got := zr.File[1].Modified.Unix(); got != 1627207200 {
+		t.Errorf("expected modification time 1627207200, got %d", got)
+	}
+}
+
+func TestStreamZip_FirstDownloadFails(t *testing.T) {
+	entries := []zipEntry{{file: &fpb.File{Key: "missing", Name: "a.txt"}, path: "a.txt"}}
+
+	w, err := serveZip(entries, map[string]string{})
+	if err == nil {
+		t.Error("expected the download's error")
+	}
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
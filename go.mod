@@ -10,7 +10,6 @@ require (
 	github.com/go-openapi/runtime v0.19.26
 	github.com/go-openapi/validate v0.20.2 // indirect
 	github.com/go-swagger/go-swagger v0.26.1 // indirect
-	github.com/google/go-cmp v0.5.4 // indirect
 	github.com/grpc-ecosystem/grpc-gateway v1.12.1
 	github.com/mailru/easyjson v0.7.7 // indirect
 	github.com/meateam/download-service v0.0.0-20191216103739-80620a5c7311
@@ -26,6 +25,7 @@ require (
 	github.com/meateam/upload-service v0.0.0-20190829065259-6265a6168676
 	github.com/meateam/user-service v3.1.1-0.20210518081330-33212ed397cb+incompatible
 	github.com/olivere/elastic/v7 v7.0.22
+	github.com/prometheus/client_golang v1.11.0
 	github.com/satori/go.uuid v1.2.0
 	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/afero v1.5.1 // indirect
@@ -37,9 +37,8 @@ require (
 	go.mongodb.org/mongo-driver v1.4.6
 	golang.org/x/mod v0.4.1 // indirect
 	golang.org/x/net v0.0.0-20210220033124-5f55cee0dc0d // indirect
-	golang.org/x/sys v0.0.0-20210220050731-9a76102bfb43 // indirect
 	golang.org/x/tools v0.1.0 // indirect
-	google.golang.org/genproto v0.0.0-20201211151036-40ec1c210f7a // indirect
+	google.golang.org/genproto v0.0.0-20201211151036-40ec1c210f7a
 	google.golang.org/grpc v1.34.0
 	google.golang.org/grpc/examples v0.0.0-20201212000604-81b95b1854d7 // indirect
 )
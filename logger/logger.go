@@ -103,7 +103,7 @@ func SetLogger(config *Config) gin.HandlerFunc {
 			msg = c.Errors.String()
 		}
 
-		traceID := extractTraceID(c)
+		traceID := ExtractTraceID(c)
 		sanitizeHeaders(c.Writer.Header(), defaultSanitizedFieldNames)
 
 		logger := config.Logger.WithFields(
@@ -205,8 +205,8 @@ func sanitizeHeaders(headers http.Header, matchers []string) {
 	}
 }
 
-// extractTraceID extracts the traceparent header value and returns its trace id.
-func extractTraceID(c *gin.Context) string {
+// ExtractTraceID extracts the traceparent header value and returns its trace id.
+func ExtractTraceID(c *gin.Context) string {
 	// If apmhttp.TraceparentHeader is present in request's headers
 	// then parse the trace id and return it.
 	if values := c.Request.Header[apmhttp.TraceparentHeader]; len(values) == 1 && values[0] != "" {
@@ -0,0 +1,7 @@
+/*
+Package metrics is used to instrument the api-gateway with prometheus metrics.
+Use Middleware to instrument the HTTP routes, UnaryClientInterceptor and StreamClientInterceptor
+to instrument the gRPC calls to the backend services, and Handler to expose the collected
+metrics to a prometheus scraper.
+*/
+package metrics
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	grpcClientHandledTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "grpc_client",
+			Name:      "handled_total",
+			Help:      "Total number of gRPC calls to the backends by backend, method and code.",
+		},
+		[]string{"backend", "method", "code"},
+	)
+
+	grpcClientHandlingSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "grpc_client",
+			Name:      "handling_seconds",
+			Help:      "Latency of gRPC calls to the backends by backend and method.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"backend", "method"},
+	)
+)
+
+// UnaryClientInterceptor returns a grpc.UnaryClientInterceptor that observes the gRPC calls
+// made to backend, the name of the service that the connection is to, e.g. "file".
+func UnaryClientInterceptor(backend string) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		fullMethod string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		start := time.Now()
+		err := invoker(ctx, fullMethod, req, reply, cc, opts...)
+		observeCall(backend, fullMethod, start, err)
+
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a grpc.StreamClientInterceptor that observes the gRPC
+// streams opened to backend, the name of the service that the connection is to.
+// A stream is observed once it ends.
+func StreamClientInterceptor(backend string) grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		fullMethod string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		start := time.Now()
+		stream, err := streamer(ctx, desc, cc, fullMethod, opts...)
+		if err != nil {
+			observeCall(backend, fullMethod, start, err)
+			return nil, err
+		}
+
+		return &monitoredClientStream{
+			ClientStream: stream,
+			backend:      backend,
+			fullMethod:   fullMethod,
+			start:        start,
+		}, nil
+	}
+}
+
+// monitoredClientStream wraps grpc.ClientStream to observe the stream when it ends.
+type monitoredClientStream struct {
+	grpc.ClientStream
+	backend    string
+	fullMethod string
+	start      time.Time
+	done       bool
+}
+
+// RecvMsg receives a message from the stream, observing the stream if it ended.
+func (s *monitoredClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil && !s.done {
+		s.done = true
+		if err == io.EOF {
+			observeCall(s.backend, s.fullMethod, s.start, nil)
+		} else {
+			observeCall(s.backend, s.fullMethod, s.start, err)
+		}
+	}
+
+	return err
+}
+
+// observeCall observes a gRPC call to fullMethod that started at start and ended with err.
+func observeCall(backend string, fullMethod string, start time.Time, err error) {
+	method := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	grpcClientHandledTotal.WithLabelValues(backend, method, status.Code(err).String()).Inc()
+	grpcClientHandlingSeconds.WithLabelValues(backend, method).Observe(time.Since(start).Seconds())
+}
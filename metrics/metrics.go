@@ -0,0 +1,187 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meateam/api-gateway/oauth"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// Namespace is the namespace of the api-gateway metrics.
+	Namespace = "api_gateway"
+
+	// UnmatchedRoute is the route label of requests that didn't match any route.
+	UnmatchedRoute = "unmatched"
+
+	// UnknownApp is the app label of requests that weren't authenticated.
+	UnknownApp = "unknown"
+
+	// DirectionUpload is the direction label of bytes streamed to upload-service.
+	DirectionUpload = "upload"
+
+	// DirectionDownload is the direction label of bytes streamed from download-service.
+	DirectionDownload = "download"
+
+	// CacheHit is the result label of a cache lookup that was served from the cache.
+	CacheHit = "hit"
+
+	// CacheMiss is the result label of a cache lookup whose value was loaded.
+	CacheMiss = "miss"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	httpRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Total number of HTTP requests by route, method, status and app.",
+		},
+		[]string{"route", "method", "status", "app"},
+	)
+
+	httpRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of HTTP requests by route, method, status and app.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"route", "method", "status", "app"},
+	)
+
+	httpRequestsInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "requests_in_flight",
+			Help:      "Number of HTTP requests currently being served by route and method.",
+		},
+		[]string{"route", "method"},
+	)
+
+	bytesStreamed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "streamed_bytes_total",
+			Help:      "Total number of file bytes streamed by direction (upload or download).",
+		},
+		[]string{"direction"},
+	)
+
+	conversionDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "gotenberg",
+			Name:      "conversion_duration_seconds",
+			Help:      "Duration of file conversions to PDF with gotenberg by result.",
+			Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+		},
+		[]string{"result"},
+	)
+
+	cacheLookupsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "cache",
+			Name:      "lookups_total",
+			Help:      "Total number of cache lookups by cache and result (hit or miss).",
+		},
+		[]string{"cache", "result"},
+	)
+)
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		httpRequestsTotal,
+		httpRequestDuration,
+		httpRequestsInFlight,
+		bytesStreamed,
+		conversionDuration,
+		cacheLookupsTotal,
+		grpcClientHandledTotal,
+		grpcClientHandlingSeconds,
+	)
+}
+
+// Register registers collectors to the registry exposed by Handler.
+func Register(collectors ...prometheus.Collector) error {
+	for _, collector := range collectors {
+		if err := registry.Register(collector); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Handler returns a gin handler that exposes the collected metrics in the prometheus format.
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+}
+
+// Middleware returns a gin middleware that counts the HTTP requests, observes their latency and
+// tracks the requests in flight, labeled by the gin route template. The app label is taken
+// from oauth.ContextAppKey after the request is handled.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = UnmatchedRoute
+		}
+
+		method := c.Request.Method
+		inFlight := httpRequestsInFlight.WithLabelValues(route, method)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		c.Next()
+
+		app := c.GetString(oauth.ContextAppKey)
+		if app == "" {
+			app = UnknownApp
+		}
+
+		statusCode := c.Writer.Status()
+		if statusCode == 0 {
+			statusCode = http.StatusOK
+		}
+
+		status := strconv.Itoa(statusCode)
+		httpRequestsTotal.WithLabelValues(route, method, status, app).Inc()
+		httpRequestDuration.WithLabelValues(route, method, status, app).Observe(time.Since(start).Seconds())
+	}
+}
+
+// AddStreamedBytes adds n to the count of bytes streamed in direction,
+// DirectionUpload or DirectionDownload.
+func AddStreamedBytes(direction string, n int) {
+	bytesStreamed.WithLabelValues(direction).Add(float64(n))
+}
+
+// ObserveConversion observes the duration of a gotenberg conversion that started at start,
+// with the status code of the conversion's response.
+func ObserveConversion(start time.Time, statusCode int) {
+	conversionDuration.WithLabelValues(strconv.Itoa(statusCode)).Observe(time.Since(start).Seconds())
+}
+
+// ObserveCacheLookup counts a lookup in the cache name, hit is false if the value was loaded.
+func ObserveCacheLookup(name string, hit bool) {
+	result := CacheMiss
+	if hit {
+		result = CacheHit
+	}
+
+	cacheLookupsTotal.WithLabelValues(name, result).Inc()
+}
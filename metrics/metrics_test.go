@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meateam/api-gateway/oauth"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/files/:id", func(c *gin.Context) {
+		c.Set(oauth.ContextAppKey, oauth.DriveAppID)
+		c.Status(http.StatusNoContent)
+	})
+	r.GET("/metrics", Handler())
+
+	for _, path := range []string{"/files/1", "/files/2", "/not-found"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if got := testutil.ToFloat64(
+		httpRequestsTotal.WithLabelValues("/files/:id", http.MethodGet, "204", oauth.DriveAppID),
+	); got != 2 {
+		t.Errorf("expected 2 requests for the route template, got %v", got)
+	}
+
+	if got := testutil.ToFloat64(
+		httpRequestsTotal.WithLabelValues(UnmatchedRoute, http.MethodGet, "404", UnknownApp),
+	); got != 1 {
+		t.Errorf("expected 1 unmatched request, got %v", got)
+	}
+
+	if got := testutil.ToFloat64(httpRequestsInFlight.WithLabelValues("/files/:id", http.MethodGet)); got != 0 {
+		t.Errorf("expected no requests in flight, got %v", got)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(w.Body.String(), "api_gateway_http_request_duration_seconds") {
+		t.Error("expected the metrics handler to expose the request duration histogram")
+	}
+}
+
+func TestAddStreamedBytes(t *testing.T) {
+	before := testutil.ToFloat64(bytesStreamed.WithLabelValues(DirectionDownload))
+	AddStreamedBytes(DirectionDownload, 1024)
+
+	if got := testutil.ToFloat64(bytesStreamed.WithLabelValues(DirectionDownload)) - before; got != 1024 {
+		t.Errorf("expected 1024 streamed bytes, got %v", got)
+	}
+}
+
+func TestObserveCacheLookup(t *testing.T) {
+	hits := testutil.ToFloat64(cacheLookupsTotal.WithLabelValues("test", CacheHit))
+	misses := testutil.ToFloat64(cacheLookupsTotal.WithLabelValues("test", CacheMiss))
+	ObserveCacheLookup("test", false)
+	ObserveCacheLookup("test", true)
+	ObserveCacheLookup("test", true)
+
+	if got := testutil.ToFloat64(cacheLookupsTotal.WithLabelValues("test", CacheHit)) - hits; got != 2 {
+		t.Errorf("expected 2 hits, got %v", got)
+	}
+
+	if got := testutil.ToFloat64(cacheLookupsTotal.WithLabelValues("test", CacheMiss)) - misses; got != 1 {
+		t.Errorf("expected 1 miss, got %v", got)
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	handled := grpcClientHandledTotal.WithLabelValues("file", "GetFileByID", "OK")
+	before := testutil.ToFloat64(handled)
+
+	invoker := func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+		return nil
+	}
+
+	// The backend label is the service's name, whatever the target of the connection is.
+	err := UnaryClientInterceptor("file")(
+		context.Background(), "/file.FileService/GetFileByID", nil, nil, &grpc.ClientConn{}, invoker)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := testutil.ToFloat64(handled) - before; got != 1 {
+		t.Errorf("expected 1 call to the file backend, got %v", got)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/meateam/api-gateway/apierror"
 	"github.com/meateam/api-gateway/factory"
 	loggermiddleware "github.com/meateam/api-gateway/logger"
 	"github.com/meateam/api-gateway/user"
@@ -194,9 +195,10 @@ func (m *Middleware) dropboxAuthorization(ctx *gin.Context, requiredScope string
 		}
 	}
 
-	return ctx.AbortWithError(
+	return apierror.AbortWithMessage(
+		ctx,
 		http.StatusForbidden,
-		fmt.Errorf("required scope '%s' is not supplied - dropbox authorization", requiredScope),
+		fmt.Sprintf("required scope '%s' is not supplied - dropbox authorization", requiredScope),
 	)
 }
 
@@ -229,9 +231,10 @@ func (m *Middleware) authCodeAuthorization(ctx *gin.Context, requiredScope strin
 		}
 	}
 
-	return ctx.AbortWithError(
+	return apierror.AbortWithMessage(
+		ctx,
 		http.StatusForbidden,
-		fmt.Errorf("required scope '%s' is not supplied - authorization code", requiredScope),
+		fmt.Sprintf("required scope '%s' is not supplied - authorization code", requiredScope),
 	)
 }
 
@@ -249,13 +252,13 @@ func (m *Middleware) extractAuthCodeToken(ctx *gin.Context) (*spb.ValidateAuthCo
 
 	spikeResponse, err := m.spikeClient().ValidateAuthCodeToken(ctx, validateAuthCodeTokenRequest)
 	if err != nil {
-		return nil, ctx.AbortWithError(http.StatusInternalServerError,
+		return nil, apierror.Abort(ctx, http.StatusInternalServerError,
 			fmt.Errorf("internal error while authenticating the auth-code token: %v", err))
 	}
 
 	if !spikeResponse.Valid {
 		message := spikeResponse.GetMessage()
-		return nil, ctx.AbortWithError(http.StatusUnauthorized, fmt.Errorf("invalid token: %s", message))
+		return nil, apierror.AbortWithMessage(ctx, http.StatusUnauthorized, fmt.Sprintf("invalid token: %s", message))
 	}
 
 	return spikeResponse, nil
@@ -275,13 +278,13 @@ func (m *Middleware) extractClientCredentialsToken(ctx *gin.Context) (*spb.Valid
 
 	spikeResponse, err := m.spikeClient().ValidateToken(ctx, validateSpikeTokenRequest)
 	if err != nil {
-		return nil, ctx.AbortWithError(http.StatusInternalServerError,
+		return nil, apierror.Abort(ctx, http.StatusInternalServerError,
 			fmt.Errorf("internal error while authenticating the client-credentias token: %v", err))
 	}
 
 	if !spikeResponse.Valid {
 		message := spikeResponse.GetMessage()
-		return nil, ctx.AbortWithError(http.StatusUnauthorized, fmt.Errorf("invalid token: %s", message))
+		return nil, apierror.AbortWithMessage(ctx, http.StatusUnauthorized, fmt.Sprintf("invalid token: %s", message))
 	}
 
 	return spikeResponse, nil
@@ -312,11 +315,11 @@ func (m *Middleware) storeDelegator(ctx *gin.Context) error {
 		delegatorObj, err := m.userClient().GetUserByID(ctx.Request.Context(), getUserByIDRequest)
 		if err != nil {
 			if status.Code(err) == codes.NotFound {
-				return ctx.AbortWithError(http.StatusUnauthorized,
+				return apierror.Abort(ctx, http.StatusUnauthorized,
 					fmt.Errorf("delegator: %v is not found", delegatorID))
 			}
 
-			return ctx.AbortWithError(http.StatusUnauthorized,
+			return apierror.Abort(ctx, http.StatusUnauthorized,
 				fmt.Errorf("internal error while authenticating the delegator: %v", err))
 		}
 
@@ -356,18 +359,17 @@ func (m *Middleware) extractTokenFromHeader(ctx *gin.Context) (string, error) {
 
 	// No authorization header sent
 	if len(authArr) == 0 {
-		return "", ctx.AbortWithError(http.StatusUnauthorized, fmt.Errorf("no authorization header sent"))
+		return "", apierror.AbortWithMessage(ctx, http.StatusUnauthorized, "no authorization header sent")
 	}
 
 	// The header value missing the correct prefix
 	if authArr[0] != AuthHeaderBearer {
-		return "", ctx.AbortWithError(http.StatusUnauthorized, fmt.Errorf(
-			"authorization header is invalid. Value should start with 'Bearer'"))
+		return "", apierror.AbortWithMessage(ctx, http.StatusUnauthorized, "authorization header is invalid. Value should start with 'Bearer'")
 	}
 
 	// The value of the header doesn't contain the token
 	if len(authArr) < 2 {
-		return "", ctx.AbortWithError(http.StatusUnauthorized, fmt.Errorf("no token sent in header %v", authArr))
+		return "", apierror.AbortWithMessage(ctx, http.StatusUnauthorized, fmt.Sprintf("no token sent in header %v", authArr))
 
 	}
 
@@ -8,6 +8,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
 	"github.com/meateam/api-gateway/factory"
 	"github.com/meateam/api-gateway/file"
 	loggermiddleware "github.com/meateam/api-gateway/logger"
@@ -41,6 +42,9 @@ const (
 	// DeleteFilePermissionRole is the role that is required of the authenticated requester to have to be
 	// permitted to make the DeleteFilePermission action.
 	DeleteFilePermissionRole = ppb.Role_WRITE
+
+	// fileIsTrashedMessage is the error message for a file that's in the trash.
+	fileIsTrashedMessage = "file is in the trash"
 )
 
 type createPermissionRequest struct {
@@ -68,18 +72,28 @@ type Router struct {
 	// UserClientFactory
 	userClient factory.UserClientFactory
 
+	// permissionCache is invalidated for a user when a permission of the user is changed.
+	permissionCache *file.PermissionCache
+
+	// trash is checked so that the permissions of trashed files aren't read or changed.
+	trash file.TrashStore
+
 	oAuthMiddleware *oauth.Middleware
 	logger          *logrus.Logger
 }
 
 // NewRouter creates a new Router, and initializes clients of the quota Service
 // with the given connection. If logger is non-nil then it will
-// be set as-is, otherwise logger would default to logrus.New().
+// be set as-is, otherwise logger would default to logrus.New(). If permissionCache is non-nil
+// then it's invalidated for the users whose permissions are created or deleted.
+// If trash is non-nil then the permissions of trashed files can't be read or created.
 func NewRouter(
 	permissionConn *grpcPoolTypes.ConnPool,
 	fileConn *grpcPoolTypes.ConnPool,
 	userConnection *grpcPoolTypes.ConnPool,
 	oAuthMiddleware *oauth.Middleware,
+	permissionCache *file.PermissionCache,
+	trash file.TrashStore,
 	logger *logrus.Logger,
 ) *Router {
 	// If no logger is given, use a default logger.
@@ -103,6 +117,10 @@ func NewRouter(
 
 	r.oAuthMiddleware = oAuthMiddleware
 
+	r.permissionCache = permissionCache
+
+	r.trash = trash
+
 	return r
 }
 
@@ -120,13 +138,13 @@ func (r *Router) Setup(rg *gin.RouterGroup) {
 func (r *Router) GetFilePermissions(c *gin.Context) {
 	reqUser := user.ExtractRequestUser(c)
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 
 	fileID := c.Param(ParamFileID)
 	if fileID == "" {
-		c.AbortWithStatus(http.StatusBadRequest)
+		apierror.AbortWithStatus(c, http.StatusBadRequest)
 		return
 	}
 
@@ -134,10 +152,15 @@ func (r *Router) GetFilePermissions(c *gin.Context) {
 		return
 	}
 
+	if r.isTrashed(c.Request.Context(), fileID) {
+		apierror.AbortWithMessage(c, http.StatusNotFound, fileIsTrashedMessage)
+		return
+	}
+
 	permissions, err := GetFilePermissions(c.Request.Context(), fileID, r.permissionClient(), r.fileClient())
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
@@ -146,7 +169,7 @@ func (r *Router) GetFilePermissions(c *gin.Context) {
 	file, err := r.fileClient().GetFileByID(c.Request.Context(), &fpb.GetByFileByIDRequest{Id: fileID})
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
@@ -168,14 +191,14 @@ func (r *Router) GetFilePermissions(c *gin.Context) {
 func (r *Router) CreateFilePermission(c *gin.Context) {
 	reqUser := user.ExtractRequestUser(c)
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 
 	permission := &createPermissionRequest{}
 	if err := c.ShouldBindJSON(permission); err != nil {
 		loggermiddleware.LogError(r.logger,
-			c.AbortWithError(http.StatusBadRequest,
+			apierror.Abort(c, http.StatusBadRequest,
 				fmt.Errorf("request has wrong format")))
 		return
 	}
@@ -184,7 +207,7 @@ func (r *Router) CreateFilePermission(c *gin.Context) {
 	switch ppb.Role(ppb.Role_value[permission.Role]) {
 	case ppb.Role_NONE:
 		loggermiddleware.LogError(r.logger,
-			c.AbortWithError(http.StatusBadRequest,
+			apierror.Abort(c, http.StatusBadRequest,
 				fmt.Errorf("permission type %s is not valid! ", permission.Role)))
 		return
 	default:
@@ -193,14 +216,14 @@ func (r *Router) CreateFilePermission(c *gin.Context) {
 
 	fileID := c.Param(ParamFileID)
 	if fileID == "" {
-		c.AbortWithStatus(http.StatusBadRequest)
+		apierror.AbortWithStatus(c, http.StatusBadRequest)
 		return
 	}
 
 	file, err := r.fileClient().GetFileByID(c.Request.Context(), &fpb.GetByFileByIDRequest{Id: fileID})
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return
 	}
 
@@ -208,7 +231,7 @@ func (r *Router) CreateFilePermission(c *gin.Context) {
 	// Unless the app is Drive.
 	ctxAppID := c.Value(oauth.ContextAppKey).(string)
 	if (ctxAppID != file.GetAppID()) && (ctxAppID != oauth.DriveAppID) {
-		loggermiddleware.LogError(r.logger, c.AbortWithError(http.StatusForbidden, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusForbidden, err))
 		return
 	}
 	var dest string
@@ -220,6 +243,11 @@ func (r *Router) CreateFilePermission(c *gin.Context) {
 		return
 	}
 
+	if r.isTrashed(c.Request.Context(), fileID) {
+		apierror.AbortWithMessage(c, http.StatusNotFound, fileIsTrashedMessage)
+		return
+	}
+
 	userID := permission.UserID
 	if IsDomainUserID(permission.UserID) {
 		findUserByMailRequest := &upb.GetByMailOrTRequest{MailOrT: permission.UserID}
@@ -227,12 +255,12 @@ func (r *Router) CreateFilePermission(c *gin.Context) {
 
 		if err != nil {
 			httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-			loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+			loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 			return
 		}
 
 		if userRes.GetUser() == nil {
-			c.AbortWithStatus(http.StatusBadRequest)
+			apierror.AbortWithStatus(c, http.StatusBadRequest)
 			return
 		}
 		// userID is now the Kartoffel ID
@@ -242,12 +270,12 @@ func (r *Router) CreateFilePermission(c *gin.Context) {
 
 		if err != nil {
 			httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-			loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+			loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 			return
 		}
 
 		if userExists.GetUser() == nil || userExists.GetUser().GetId() != permission.UserID {
-			c.AbortWithStatus(http.StatusBadRequest)
+			apierror.AbortWithStatus(c, http.StatusBadRequest)
 			return
 		}
 	}
@@ -256,7 +284,7 @@ func (r *Router) CreateFilePermission(c *gin.Context) {
 	// Only an external user can give himself one (comparing reqUser.ID to the Kartoffel ID)
 	if userID == reqUser.ID {
 		loggermiddleware.LogError(r.logger,
-			c.AbortWithError(http.StatusBadRequest,
+			apierror.Abort(c, http.StatusBadRequest,
 				fmt.Errorf("a user cannot give himself permissions")))
 		return
 	}
@@ -264,7 +292,7 @@ func (r *Router) CreateFilePermission(c *gin.Context) {
 	// Forbid changing the file owner's permission.
 	// Only an external user can give himself a Kartoffel permission
 	if file.GetOwnerID() == userID {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 	
@@ -278,10 +306,12 @@ func (r *Router) CreateFilePermission(c *gin.Context) {
 	}, appID, permission.Override)
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return
 	}
 
+	r.permissionCache.InvalidateUser(userID)
+
 	c.JSON(http.StatusOK, Permission{
 		UserID:  createdPermission.GetUserID(),
 		FileID:  createdPermission.GetFileID(),
@@ -295,13 +325,13 @@ func (r *Router) CreateFilePermission(c *gin.Context) {
 func (r *Router) DeleteFilePermission(c *gin.Context) {
 	fileID := c.Param(ParamFileID)
 	if fileID == "" {
-		c.AbortWithStatus(http.StatusBadRequest)
+		apierror.AbortWithStatus(c, http.StatusBadRequest)
 		return
 	}
 
 	reqUser := user.ExtractRequestUser(c)
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 
@@ -315,13 +345,13 @@ func (r *Router) DeleteFilePermission(c *gin.Context) {
 	file, err := r.fileClient().GetFileByID(c.Request.Context(), &fpb.GetByFileByIDRequest{Id: fileID})
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
 
 	if userID == file.GetOwnerID() {
-		c.AbortWithStatus(http.StatusBadRequest)
+		apierror.AbortWithStatus(c, http.StatusBadRequest)
 		return
 	}
 
@@ -330,7 +360,7 @@ func (r *Router) DeleteFilePermission(c *gin.Context) {
 	// to himself.
 	if userID != reqUser.ID {
 		if role, _ := r.HandleUserFilePermission(c, fileID, DeleteFilePermissionRole); role == "" {
-			c.AbortWithStatus(http.StatusUnauthorized)
+			apierror.AbortWithStatus(c, http.StatusUnauthorized)
 			return
 		}
 	}
@@ -339,11 +369,13 @@ func (r *Router) DeleteFilePermission(c *gin.Context) {
 	permission, err := r.permissionClient().DeletePermission(c.Request.Context(), deleteRequest)
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
 
+	r.permissionCache.InvalidateUser(userID)
+
 	c.JSON(http.StatusOK, Permission{
 		UserID:  permission.GetUserID(),
 		FileID:  permission.GetFileID(),
@@ -360,7 +392,7 @@ func (r *Router) HandleUserFilePermission(
 	role ppb.Role) (string, *ppb.PermissionObject) {
 	reqUser := user.ExtractRequestUser(c)
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 
 		return "", nil
 	}
@@ -373,18 +405,34 @@ func (r *Router) HandleUserFilePermission(
 		role)
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return "", nil
 	}
 
 	if userFilePermission == "" {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 	}
 
 	return userFilePermission, foundPermission
 }
 
+// isTrashed reports whether fileID or any of its ancestors is in the trash. If the trash couldn't be checked
+// then the error is logged and fileID is considered not trashed, so the trash doesn't block sharing.
+func (r *Router) isTrashed(ctx context.Context, fileID string) bool {
+	if r.trash == nil || fileID == "" {
+		return false
+	}
+
+	trashed, err := file.InTrash(ctx, r.fileClient(), r.trash, fileID)
+	if err != nil {
+		loggermiddleware.LogError(r.logger, fmt.Errorf("failed checking if file %s is trashed: %v", fileID, err))
+		return false
+	}
+
+	return trashed
+}
+
 // IsDomainUserID checks if the userID is domainuser
 func IsDomainUserID(userID string) bool {
 	return strings.Contains(userID, "@")
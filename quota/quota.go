@@ -5,6 +5,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
 	"github.com/meateam/api-gateway/factory"
 	loggermiddleware "github.com/meateam/api-gateway/logger"
 	"github.com/meateam/api-gateway/user"
@@ -53,7 +54,7 @@ func (r *Router) Setup(rg *gin.RouterGroup) {
 func (r *Router) GetOwnerQuota(c *gin.Context) {
 	reqUser := user.ExtractRequestUser(c)
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 
@@ -65,13 +66,13 @@ func (r *Router) GetQuotaByID(c *gin.Context) {
 	ownerID := c.Param("id")
 
 	if ownerID == "" {
-		c.AbortWithStatus(http.StatusBadRequest)
+		apierror.AbortWithStatus(c, http.StatusBadRequest)
 		return
 	}
 
 	reqUser := user.ExtractRequestUser(c)
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 
@@ -82,7 +83,7 @@ func (r *Router) GetQuotaByID(c *gin.Context) {
 func (r *Router) handleGetQuota(c *gin.Context, requesterID string, ownerID string) {
 	allowed := r.isAllowedToGetQuota(c, requesterID, ownerID)
 	if !allowed {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 
@@ -92,7 +93,7 @@ func (r *Router) handleGetQuota(c *gin.Context, requesterID string, ownerID stri
 	)
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return
 	}
 
@@ -106,7 +107,7 @@ func (r *Router) isAllowedToGetQuota(c *gin.Context, reqUserID string, ownerID s
 		&qpb.IsAllowedToGetQuotaRequest{RequestingUser: reqUserID, OwnerID: ownerID},
 	)
 	if err != nil {
-		loggermiddleware.LogError(r.logger, c.AbortWithError(http.StatusInternalServerError, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusInternalServerError, err))
 		return false
 	}
 	return res.GetAllowed()
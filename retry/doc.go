@@ -0,0 +1,6 @@
+/*
+Package retry implements retrying of failed gRPC calls to the backend services.
+Use UnaryClientInterceptor with a Policy for each of the idempotent methods,
+calls to methods without a Policy are never retried.
+*/
+package retry
@@ -0,0 +1,92 @@
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Policy is the retry policy of a gRPC method.
+type Policy struct {
+	// MaxAttempts is the maximum number of attempts of a call, including the first one.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry, it's doubled on each retry.
+	InitialBackoff time.Duration
+
+	// MaxBackoff is the maximum delay between retries.
+	MaxBackoff time.Duration
+
+	// Codes are the codes of the errors that are retried.
+	Codes []codes.Code
+}
+
+// retryable returns whether err should be retried by p.
+func (p Policy) retryable(err error) bool {
+	code := status.Code(err)
+	for _, c := range p.Codes {
+		if code == c {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff returns the delay before the given retry, which is counted from 1.
+// The delay is jittered to a random duration between half of it and all of it.
+func (p Policy) backoff(retry int) time.Duration {
+	delay := p.MaxBackoff
+	if retry <= 32 {
+		if d := p.InitialBackoff << uint(retry-1); d > 0 && d < p.MaxBackoff {
+			delay = d
+		}
+	}
+
+	half := delay / 2
+
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// UnaryClientInterceptor returns a grpc.UnaryClientInterceptor that retries failed calls by
+// the Policy of their full method name in policies, e.g. "/file.FileService/GetFileByID".
+// A call isn't retried if its context is done, or if its deadline would pass before the retry.
+func UnaryClientInterceptor(policies map[string]Policy) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		fullMethod string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		policy, ok := policies[fullMethod]
+		if !ok {
+			return invoker(ctx, fullMethod, req, reply, cc, opts...)
+		}
+
+		err := invoker(ctx, fullMethod, req, reply, cc, opts...)
+		for attempt := 1; attempt < policy.MaxAttempts && err != nil && policy.retryable(err); attempt++ {
+			delay := policy.backoff(attempt)
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+				return err
+			}
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+
+			err = invoker(ctx, fullMethod, req, reply, cc, opts...)
+		}
+
+		return err
+	}
+}
@@ -0,0 +1,95 @@
+package retry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testMethod = "/file.FileService/GetFileByID"
+
+// failingInvoker returns an invoker that fails with code the first failures calls,
+// and counts the calls in calls.
+func failingInvoker(failures int, code codes.Code, calls *int) grpc.UnaryInvoker {
+	return func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+		*calls++
+		if *calls <= failures {
+			return status.Error(code, "failed")
+		}
+
+		return nil
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	policy := Policy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+		Codes:          []codes.Code{codes.Unavailable},
+	}
+	interceptor := UnaryClientInterceptor(map[string]Policy{testMethod: policy})
+
+	tests := []struct {
+		name      string
+		method    string
+		failures  int
+		code      codes.Code
+		timeout   time.Duration
+		wantCalls int
+		wantCode  codes.Code
+	}{
+		{name: "recovers", method: testMethod, failures: 2, code: codes.Unavailable,
+			wantCalls: 3, wantCode: codes.OK},
+		{name: "bounded attempts", method: testMethod, failures: 5, code: codes.Unavailable,
+			wantCalls: 3, wantCode: codes.Unavailable},
+		{name: "non retryable code", method: testMethod, failures: 1, code: codes.NotFound,
+			wantCalls: 1, wantCode: codes.NotFound},
+		{name: "method without policy", method: "/file.FileService/CreateFile", failures: 1,
+			code: codes.Unavailable, wantCalls: 1, wantCode: codes.Unavailable},
+		{name: "deadline before retry", method: testMethod, failures: 1, code: codes.Unavailable,
+			timeout: time.Microsecond, wantCalls: 1, wantCode: codes.Unavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			calls := 0
+			err := interceptor(ctx, tt.method, nil, nil, nil, failingInvoker(tt.failures, tt.code, &calls))
+
+			if calls != tt.wantCalls {
+				t.Errorf("expected %d calls, got %d", tt.wantCalls, calls)
+			}
+
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("expected code %v, got %v", tt.wantCode, code)
+			}
+		})
+	}
+}
+
+func TestPolicy_Backoff(t *testing.T) {
+	policy := Policy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	for retry, max := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		4:  800 * time.Millisecond,
+		5:  time.Second,
+		50: time.Second,
+	} {
+		if got := policy.backoff(retry); got < max/2 || got > max {
+			t.Errorf("expected backoff of retry %d to be in [%v, %v], got %v", retry, max/2, max, got)
+		}
+	}
+}
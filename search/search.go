@@ -1,11 +1,11 @@
 package search
 
 import (
-	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
 	"github.com/meateam/api-gateway/factory"
 	"github.com/meateam/api-gateway/file"
 	loggermiddleware "github.com/meateam/api-gateway/logger"
@@ -35,16 +35,26 @@ type Router struct {
 	// PermissionClientFactory
 	permissionClient factory.PermissionClientFactory
 
+	// permissionCache caches the resolved permissions of the results, nothing is cached if it's nil.
+	permissionCache *file.PermissionCache
+
+	// trash is the trash of the deleted files, which are hidden from the results.
+	trash file.TrashStore
+
 	logger *logrus.Logger
 }
 
 // NewRouter creates a new Router, and initializes clients of search Service
 // and File Service with the given connections. If logger is non-nil then it will
 // be set as-is, otherwise logger would default to logrus.New().
+// If permissionCache is nil then the permissions resolved for the results aren't cached.
+// If trash is non-nil then the files in it, and their descendants, are hidden from the results.
 func NewRouter(
 	searchConn *grpcPoolTypes.ConnPool,
 	fileConn *grpcPoolTypes.ConnPool,
 	permissionConn *grpcPoolTypes.ConnPool,
+	permissionCache *file.PermissionCache,
+	trash file.TrashStore,
 	logger *logrus.Logger,
 ) *Router {
 	// If no logger is given, use a default logger.
@@ -52,7 +62,7 @@ func NewRouter(
 		logger = logrus.New()
 	}
 
-	r := &Router{logger: logger}
+	r := &Router{logger: logger, permissionCache: permissionCache, trash: trash}
 
 	r.searchClient = func() spb.SearchClient {
 		return spb.NewSearchClient((*searchConn).Conn())
@@ -80,7 +90,7 @@ func (r *Router) Search(c *gin.Context) {
 	if reqUser == nil {
 		loggermiddleware.LogError(
 			r.logger,
-			c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("error extracting user from request")),
+			apierror.AbortWithMessage(c, http.StatusUnauthorized, "error extracting user from request"),
 		)
 
 		return
@@ -90,7 +100,7 @@ func (r *Router) Search(c *gin.Context) {
 	if !exists {
 		loggermiddleware.LogError(
 			r.logger,
-			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("missing search term")),
+			apierror.AbortWithMessage(c, http.StatusBadRequest, "missing search term"),
 		)
 
 		return
@@ -99,37 +109,47 @@ func (r *Router) Search(c *gin.Context) {
 	searchResponse, err := r.searchClient().Search(c.Request.Context(), &spb.SearchRequest{Term: term})
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
 
-	var responseFiles []*file.GetFileByIDResponse
+	var permittedFiles []file.ResolvedPermission
 
-	for _, id := range searchResponse.GetIds() {
-		userFilePermission, foundPermission, err := file.CheckUserFilePermission(
-			c.Request.Context(),
-			r.fileClient(),
-			r.permissionClient(),
-			reqUser.ID,
-			id,
-			ppb.Role_READ,
-		)
-		if err != nil && status.Code(err) != codes.NotFound {
-			r.logger.Errorf("failed get permission with fileId %s, error: %v", id, err)
+	resolver := file.NewPermissionResolver(
+		r.fileClient(),
+		r.permissionClient(),
+		r.permissionCache,
+		reqUser.ID,
+		ppb.Role_READ,
+	)
+
+	for i, resolved := range resolver.ResolveIDs(c.Request.Context(), searchResponse.GetIds()) {
+		if resolved.Err != nil && status.Code(resolved.Err) != codes.NotFound {
+			r.logger.Errorf("failed get permission with fileId %s, error: %v", searchResponse.GetIds()[i], resolved.Err)
+		}
+
+		if resolved.Role != "" {
+			permittedFiles = append(permittedFiles, resolved)
 		}
+	}
 
-		if userFilePermission != "" {
-			res, err := r.fileClient().GetFileByID(c.Request.Context(), &fpb.GetByFileByIDRequest{Id: id})
-			if err != nil {
-				httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-				loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+	files := make([]*fpb.File, 0, len(permittedFiles))
+	for _, resolved := range permittedFiles {
+		files = append(files, resolved.File)
+	}
 
-				return
-			}
+	// If the trash couldn't be checked then the results are returned as is.
+	trashed, err := file.TrashedFiles(c.Request.Context(), r.fileClient(), r.trash, files)
+	if err != nil {
+		r.logger.Errorf("failed checking trashed search results: %v", err)
+	}
 
+	var responseFiles []*file.GetFileByIDResponse
+	for _, resolved := range permittedFiles {
+		if !trashed[resolved.File.GetId()] {
 			responseFiles = append(
-				responseFiles, file.CreateGetFileResponse(res, userFilePermission, foundPermission))
+				responseFiles, file.CreateGetFileResponse(resolved.File, resolved.Role, resolved.Permission))
 		}
 	}
 
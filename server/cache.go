@@ -0,0 +1,63 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/meateam/api-gateway/cache"
+)
+
+const (
+	// cacheMemory is the kind of a cache in memory.
+	cacheMemory = "memory"
+
+	// cacheDisk is the kind of a cache in a local directory.
+	cacheDisk = "disk"
+
+	// cacheNone is the kind of no cache.
+	cacheNone = "none"
+)
+
+// newCache creates a cache.Cache of kind of at most maxBytes of values, in dir if it's
+// a disk cache. Returns a nil cache.Cache if kind is cacheNone.
+func newCache(kind string, maxBytes int64, dir string) (cache.Cache, error) {
+	switch kind {
+	case cacheMemory:
+		return cache.NewLRU(maxBytes), nil
+	case cacheDisk:
+		disk, err := cache.NewDisk(dir, maxBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		return disk, nil
+	case cacheNone:
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("unknown cache %q, expected one of %s, %s or %s", kind, cacheMemory, cacheDisk, cacheNone)
+}
+
+// newTieredCache creates a cache.Cache of a memory tier of at most memoryBytes of values,
+// and a disk tier in dir of at most diskBytes of values. A tier of 0 bytes is disabled,
+// returns a nil cache.Cache if both tiers are disabled. If the disk tier couldn't be
+// created then it returns the memory tier with the error.
+func newTieredCache(memoryBytes int64, diskBytes int64, dir string) (cache.Cache, error) {
+	var tiers cache.Tiered
+	if memoryBytes > 0 {
+		tiers = append(tiers, cache.NewLRU(memoryBytes))
+	}
+
+	var err error
+	if diskBytes > 0 {
+		var disk *cache.Disk
+		if disk, err = cache.NewDisk(dir, diskBytes); err == nil {
+			tiers = append(tiers, disk)
+		}
+	}
+
+	if len(tiers) == 0 {
+		return nil, err
+	}
+
+	return tiers, err
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/meateam/api-gateway/cache"
+)
+
+func TestNewCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatalf("failed creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if c, err := newCache(cacheMemory, 10, ""); err != nil || c == nil {
+		t.Errorf("expected a memory cache, got %v with error %v", c, err)
+	}
+
+	if c, err := newCache(cacheDisk, 10, dir); err != nil || c == nil {
+		t.Errorf("expected a disk cache, got %v with error %v", c, err)
+	}
+
+	if c, err := newCache(cacheNone, 10, dir); err != nil || c != nil {
+		t.Errorf("expected no cache, got %v with error %v", c, err)
+	}
+
+	if c, err := newCache("redis", 10, dir); err == nil || c != nil {
+		t.Errorf("expected an unknown cache to fail, got %v", c)
+	}
+
+	// A file can't be the directory of a disk cache.
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatalf("failed writing file: %v", err)
+	}
+
+	if c, err := newCache(cacheDisk, 10, file); err == nil || c != nil {
+		t.Errorf("expected no cache when the disk cache fails, got %v", c)
+	}
+}
+
+func TestNewTieredCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatalf("failed creating dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := newTieredCache(10, 100, dir)
+	if tiers, ok := c.(cache.Tiered); err != nil || !ok || len(tiers) != 2 {
+		t.Errorf("expected memory and disk tiers, got %v with error %v", c, err)
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatalf("failed writing file: %v", err)
+	}
+
+	c, err = newTieredCache(10, 100, file)
+	if tiers, ok := c.(cache.Tiered); err == nil || !ok || len(tiers) != 1 {
+		t.Errorf("expected only the memory tier when the disk tier fails, got %v with error %v", c, err)
+	}
+
+	if c, err := newTieredCache(0, 0, dir); err != nil || c != nil {
+		t.Errorf("expected no cache when both tiers are disabled, got %v with error %v", c, err)
+	}
+}
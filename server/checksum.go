@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/meateam/api-gateway/file"
+	es "github.com/olivere/elastic/v7"
+	"github.com/spf13/viper"
+)
+
+// checksumIndexMapping is the mapping of the checksum index, checksums are only fetched by their key.
+const checksumIndexMapping = `{
+	"mappings": {
+		"properties": {
+			"key":       {"type": "keyword"},
+			"md5":       {"type": "keyword", "index": false},
+			"sha256":    {"type": "keyword", "index": false},
+			"createdAt": {"type": "date"}
+		}
+	}
+}`
+
+// esChecksumStore is a file.ChecksumStore that stores the checksums in an elasticsearch index,
+// with the object key as the document id.
+type esChecksumStore struct {
+	client *es.Client
+	index  string
+}
+
+// NewElasticsearchChecksumStore creates a file.ChecksumStore that stores the checksums in elasticsearch,
+// and creates its index if it doesn't exist. Returns a non-nil error if the elasticsearch client
+// couldn't be created or the index couldn't be created.
+func NewElasticsearchChecksumStore() (file.ChecksumStore, error) {
+	index := viper.GetString(configChecksumIndex)
+	client, err := newESStore(index, checksumIndexMapping)
+	if err != nil {
+		return nil, err
+	}
+
+	return &esChecksumStore{client: client, index: index}, nil
+}
+
+// Set indexes checksum, refreshing the index so the checksum is immediately fetched.
+func (s *esChecksumStore) Set(ctx context.Context, checksum *file.Checksum) error {
+	_, err := s.client.Index().Index(s.index).Id(checksum.Key).BodyJson(checksum).Refresh("wait_for").Do(ctx)
+
+	return err
+}
+
+// Get returns the checksum of key.
+func (s *esChecksumStore) Get(ctx context.Context, key string) (*file.Checksum, error) {
+	res, err := s.client.Get().Index(s.index).Id(key).Do(ctx)
+	if es.IsNotFound(err) {
+		return nil, file.ErrChecksumNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	checksum := &file.Checksum{}
+	if err := json.Unmarshal(res.Source, checksum); err != nil {
+		return nil, err
+	}
+
+	return checksum, nil
+}
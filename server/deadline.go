@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meateam/api-gateway/apierror"
+)
+
+const (
+	// deadlineExceededMessage is the message of the error of requests that exceeded their deadline.
+	deadlineExceededMessage = "request deadline exceeded"
+)
+
+// requestTimeouts are the timeouts of the requests of each route group, a zero timeout is unlimited.
+type requestTimeouts struct {
+	// metadata is the timeout of all requests that aren't uploads or downloads.
+	metadata time.Duration
+
+	// upload is the timeout of requests to the upload routes and of copy requests.
+	upload time.Duration
+
+	// download is the timeout of file download, preview, thumbnail and zip requests.
+	download time.Duration
+}
+
+// timeout returns the timeout of the request of c by its route group.
+func (t requestTimeouts) timeout(c *gin.Context) time.Duration {
+	if strings.HasPrefix(c.Request.URL.Path, "/api/upload") ||
+		(c.Request.Method == http.MethodPost && strings.HasSuffix(c.Request.URL.Path, "/copy")) {
+		return t.upload
+	}
+
+	if c.Request.Method == http.MethodGet && (c.Query("alt") != "" ||
+		strings.HasSuffix(c.Request.URL.Path, "/thumbnail") || c.Request.URL.Path == "/api/zip") {
+		return t.download
+	}
+
+	return t.metadata
+}
+
+// deadlineMiddleware returns a middleware that sets a deadline on the context of each request by
+// the timeout of its route group in timeouts, which is propagated to the gRPC calls of the request.
+// Requests that exceed their deadline and fail are aborted with 504, which is rendered by apierror.Middleware.
+func deadlineMiddleware(timeouts requestTimeouts) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := timeouts.timeout(c)
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		// Client errors are kept, server errors and requests that weren't responded are replaced.
+		status := c.Writer.Status()
+		if ctx.Err() == context.DeadlineExceeded && !c.Writer.Written() &&
+			(status >= http.StatusInternalServerError || status < http.StatusBadRequest) {
+			apierror.AbortWithMessage(c, http.StatusGatewayTimeout, deadlineExceededMessage)
+		}
+	}
+}
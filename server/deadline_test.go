@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// hangingHealthServer is a health server whose Check hangs until the call's deadline.
+type hangingHealthServer struct {
+	healthpb.UnimplementedHealthServer
+	hadDeadline chan bool
+}
+
+func (s *hangingHealthServer) Check(
+	ctx context.Context,
+	_ *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	_, ok := ctx.Deadline()
+	s.hadDeadline <- ok
+	<-ctx.Done()
+
+	return nil, ctx.Err()
+}
+
+func TestDeadlineMiddleware(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listening: %v", err)
+	}
+
+	healthServer := &hangingHealthServer{hadDeadline: make(chan bool, 1)}
+	grpcServer := grpc.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	pool, err := initServiceConn(serviceFile, listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("failed dialing: %v", err)
+	}
+	defer (*pool).Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(apierror.Middleware(), deadlineMiddleware(requestTimeouts{metadata: 100 * time.Millisecond}))
+
+	r.GET("/api/files/:id", func(c *gin.Context) {
+		_, err := healthpb.NewHealthClient((*pool).Conn()).Check(c.Request.Context(), &healthpb.HealthCheckRequest{})
+		if err != nil {
+			apierror.Abort(c, gwruntime.HTTPStatusFromCode(status.Code(err)), err)
+		}
+	})
+	r.GET("/api/files", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+	r.GET("/api/zip", func(c *gin.Context) {
+		// Streams for longer than the metadata timeout.
+		for i := 0; i < 3; i++ {
+			time.Sleep(60 * time.Millisecond)
+			if c.Request.Context().Err() != nil {
+				apierror.AbortWithStatus(c, http.StatusInternalServerError)
+				return
+			}
+
+			c.Writer.WriteString("chunk")
+			c.Writer.Flush()
+		}
+	})
+	r.POST("/api/upload", func(c *gin.Context) {
+		if _, ok := c.Request.Context().Deadline(); ok {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantError  bool
+	}{
+		{name: "gRPC call exceeded deadline", method: http.MethodGet, path: "/api/files/1",
+			wantStatus: http.StatusGatewayTimeout, wantError: true},
+		{name: "handler didn't respond", method: http.MethodGet, path: "/api/files",
+			wantStatus: http.StatusGatewayTimeout, wantError: true},
+		{name: "upload is unlimited", method: http.MethodPost, path: "/api/upload", wantStatus: http.StatusOK},
+		{name: "zip streams past the metadata timeout", method: http.MethodGet, path: "/api/zip?id=1",
+			wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+
+			if !tt.wantError {
+				return
+			}
+
+			var apiErr apierror.Error
+			if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil {
+				t.Fatalf("failed decoding error: %v", err)
+			}
+
+			if apiErr.Code != http.StatusGatewayTimeout || apiErr.Message != deadlineExceededMessage {
+				t.Errorf("expected a deadline exceeded error, got %+v", apiErr)
+			}
+		})
+	}
+
+	select {
+	case hadDeadline := <-healthServer.hadDeadline:
+		if !hadDeadline {
+			t.Error("expected the deadline to be propagated to the gRPC call")
+		}
+	default:
+		t.Error("expected the gRPC call to reach the server")
+	}
+}
+
+func TestRequestTimeouts(t *testing.T) {
+	timeouts := requestTimeouts{metadata: time.Second, upload: 2 * time.Second, download: 3 * time.Second}
+
+	tests := []struct {
+		method string
+		target string
+		want   time.Duration
+	}{
+		{method: http.MethodGet, target: "/api/files/1", want: time.Second},
+		{method: http.MethodGet, target: "/api/files/1?alt=media", want: 3 * time.Second},
+		{method: http.MethodGet, target: "/api/files/1/thumbnail?size=64", want: 3 * time.Second},
+		{method: http.MethodGet, target: "/api/zip?id=1&id=2", want: 3 * time.Second},
+		{method: http.MethodPost, target: "/api/upload?uploadType=resumable", want: 2 * time.Second},
+		{method: http.MethodPut, target: "/api/upload/1", want: 2 * time.Second},
+		{method: http.MethodPost, target: "/api/files/1/copy", want: 2 * time.Second},
+	}
+
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(tt.method, tt.target, nil)
+
+		if got := timeouts.timeout(c); got != tt.want {
+			t.Errorf("expected timeout of %s %s to be %v, got %v", tt.method, tt.target, tt.want, got)
+		}
+	}
+}
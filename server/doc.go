@@ -21,6 +21,120 @@ GW_EXTERNAL_APM_URL: External server's client APM url.
 	default: "http://localhost:8200"
 GW_ALLOW_ORIGINS: List of allowed origins for the Access-Control-Allow-Origin header.
 	default: "http://localhost*", can be a list of urls.
+GW_SHUTDOWN_GRACE_PERIOD: Seconds to wait for active requests to finish when shutting down.
+	default: 30
+GW_SHUTDOWN_DRAIN_DELAY: Seconds to report unhealthy before closing the listener when shutting down.
+	default: 5
+GW_METRICS_QUEUE_SIZE: Maximum number of request metrics documents queued for shipping, further documents are dropped.
+	default: 10000
+GW_METRICS_FLUSH_SIZE: Number of queued request metrics documents that triggers shipping them to elasticsearch.
+	default: 500
+GW_METRICS_FLUSH_INTERVAL: Seconds between shipping the queued request metrics documents to elasticsearch.
+	default: 5
+GW_FATAL_SERVICES: Comma separated services that fail the healthcheck when unhealthy,
+	of file, download, permission, upload, search, dropbox, user, spike and gotenberg.
+	default: file,download,permission,upload,search
+GW_RECONNECT_MIN_BACKOFF: Seconds to wait before the first attempt to reconnect to an unhealthy service.
+	default: 1
+GW_RECONNECT_MAX_BACKOFF: Maximum seconds to wait between attempts to reconnect to an unhealthy service.
+	default: 60
+GW_RECONNECT_DRAIN_TIMEOUT: Maximum seconds to wait for the calls of a replaced connection pool to finish
+before closing it, 0 waits until they finish.
+	default: 600
+GW_BREAKER_FAILURE_THRESHOLD: Consecutive failed calls to a service that open its circuit breaker, 0 disables the breakers.
+	default: 5
+GW_BREAKER_OPEN_TIMEOUT: Seconds an open circuit breaker fails calls with 503 before probing the service.
+	default: 30
+GW_BREAKER_HALF_OPEN_PROBES: Number of successful probe calls that close a half-open circuit breaker.
+	default: 1
+GW_REQUEST_TIMEOUT: Seconds until the deadline of requests that aren't uploads or downloads, 0 is unlimited.
+	default: 30
+GW_UPLOAD_REQUEST_TIMEOUT: Seconds until the deadline of upload and copy requests, 0 is unlimited.
+	default: 0
+GW_DOWNLOAD_REQUEST_TIMEOUT: Seconds until the deadline of download, preview, thumbnail and zip requests, 0 is unlimited.
+	default: 0
+GW_RETRY_MAX_ATTEMPTS: Maximum attempts of read-only gRPC calls that failed as unavailable, 1 disables retries.
+	default: 3
+GW_RETRY_INITIAL_BACKOFF: Milliseconds to wait before the first retry of a gRPC call.
+	default: 100
+GW_RETRY_MAX_BACKOFF: Maximum milliseconds to wait between retries of a gRPC call.
+	default: 1000
+GW_TLS_CERT_FILE: Path of the server's TLS certificate, serves HTTPS and HTTP/2 when set with GW_TLS_KEY_FILE.
+	default: ""
+GW_TLS_KEY_FILE: Path of the key of the server's TLS certificate.
+	default: ""
+GW_TLS_CLIENT_CA_FILE: Path of a CA bundle, requires clients to present a certificate signed by it when set.
+	default: ""
+GW_<SERVICE>_SERVICE_TLS_CA_FILE: Path of a CA bundle to verify the service with, e.g. GW_FILE_SERVICE_TLS_CA_FILE.
+Connections to the service use TLS if any of its TLS variables is set, verified with the system's CAs if not set.
+	default: ""
+GW_<SERVICE>_SERVICE_TLS_CERT_FILE: Path of a client certificate to present to the service for mTLS.
+	default: ""
+GW_<SERVICE>_SERVICE_TLS_KEY_FILE: Path of the key of the client certificate presented to the service.
+	default: ""
+GW_<SERVICE>_SERVICE_TLS_SERVER_NAME: Name to verify the service's certificate with, instead of its host.
+	default: ""
+Certificate and CA files are reloaded when modified.
+GW_ZIP_MAX_SIZE: Maximum total bytes of the files in a zip download, 0 disables the limit.
+	default: 10737418240
+GW_ZIP_MAX_ENTRIES: Maximum number of files and folders in a zip download, 0 disables the limit.
+	default: 10000
+GW_TRASH_INDEX: Elasticsearch index of the files in the users' trash.
+Deleted files are deleted permanently if elasticsearch is unavailable when the gateway starts.
+The descendants of a trashed folder are hidden with it, and files can't be uploaded, copied or moved into it.
+	default: trash
+GW_TRASH_RETENTION_DAYS: Days after which trashed files are deleted permanently, 0 keeps them until the trash is emptied.
+	default: 30
+GW_PERMISSION_RESOLVER_CONCURRENCY: Maximum number of files whose permissions are resolved concurrently in a listing.
+	default: 16
+GW_PERMISSION_CACHE_TTL: Seconds that the permissions resolved for listings are cached per user, 0 disables the cache.
+The cache is per gateway instance, so permissions changed through another instance are seen once they expire.
+	default: 0
+GW_PREVIEW_CACHE_SIZE: Maximum total bytes of the PDF previews of office documents cached in memory, 0 disables the memory tier.
+	default: 268435456
+GW_PREVIEW_CACHE_DISK_SIZE: Maximum total bytes of the PDF previews cached on disk, 0 disables the disk tier.
+Previews larger than both tiers, or converted while both tiers are disabled, are streamed without being cached.
+	default: 2147483648
+GW_PREVIEW_CACHE_DIR: Directory of the PDF previews cached on disk.
+	default: /tmp/api-gateway/previews
+GW_THUMBNAIL_CACHE: Where generated thumbnails are cached, one of memory, disk or none.
+	default: memory
+GW_THUMBNAIL_CACHE_SIZE: Maximum total bytes of the cached thumbnails.
+	default: 67108864
+GW_THUMBNAIL_CACHE_DIR: Directory of the cached thumbnails when GW_THUMBNAIL_CACHE is disk.
+	default: /tmp/api-gateway/thumbnails
+GW_THUMBNAIL_MAX_SOURCE_SIZE: Maximum bytes of a file that a thumbnail is generated of, 0 disables the limit.
+	default: 52428800
+GW_THUMBNAIL_PDF_RENDERER: Name or path of the pdftoppm executable that renders the thumbnails of PDFs and office documents.
+PDFs and office documents have no thumbnails if it isn't found.
+	default: pdftoppm
+GW_VERSION_INDEX: Elasticsearch index of the versions of updated files.
+The replaced contents of updated files are deleted if elasticsearch is unavailable when the gateway starts.
+	default: versions
+GW_VERSION_MAX_COUNT: Maximum number of versions kept of each file, the oldest are deleted first, 0 disables the limit.
+	default: 10
+GW_VERSION_RETENTION_DAYS: Days after which versions of files are deleted, 0 keeps them until they exceed GW_VERSION_MAX_COUNT.
+	default: 90
+GW_TUS_INDEX: Elasticsearch index of the state of the tus resumable uploads.
+The tus upload routes are disabled if elasticsearch is unavailable when the gateway starts.
+	default: tus_uploads
+GW_UPLOAD_PART_INDEX: Elasticsearch index of the ranges committed to resumable uploads.
+The ranges are kept in the memory of each gateway instance if elasticsearch is unavailable when the gateway starts.
+	default: upload_parts
+GW_CHECKSUM_INDEX: Elasticsearch index of the checksums of the uploaded files' contents.
+Uploads are still verified, but the files have no Digest header if elasticsearch is unavailable when the gateway starts.
+The checksums are kept by object key beside file service, whose files have no field for them, so they follow versions and copies.
+	default: checksums
+GW_MAX_UPLOADED_FILES: Maximum number of files each user uploads within GW_UPLOAD_LIMIT_WINDOW
+and within each batch of uploads with the same X-Batch-Id header, 0 disables the limit.
+	default: 100
+GW_MAX_UPLOADED_FOLDERS: Maximum number of folders each user uploads within GW_UPLOAD_LIMIT_WINDOW
+and within each batch of uploads with the same X-Batch-Id header, 0 disables the limit.
+	default: 100
+GW_UPLOAD_LIMIT_WINDOW: Seconds of the sliding window in which the uploads of each user are counted, 0 counts them
+only within their batches. The uploads are counted in the memory of each gateway instance, a copy is counted
+as a single upload of the copied file or folder, and failed uploads are uncounted.
+	default: 60
 
 For configuring the APM agent see https://www.elastic.co/guide/en/apm/agent/go/current/configuration.html
 For configuring the logger of the server see Package logger doc.go
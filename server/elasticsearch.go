@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+
+	es "github.com/olivere/elastic/v7"
+)
+
+// esPageSize is the number of documents fetched in each page of a search of all of the matching documents.
+const esPageSize = 1000
+
+// newESStore creates a client of the configured elasticsearch for a store in index, and creates index
+// with mapping if it doesn't exist. Returns a non-nil error if the client or the index couldn't be created.
+func newESStore(index string, mapping string) (*es.Client, error) {
+	config, _ := initESConfig()
+
+	client, err := es.NewClient(config...)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.IndexExists(index).Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		if _, err := client.CreateIndex(index).Body(mapping).Do(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+// searchAll calls hit with each document in index that matches query, in the order of sorters.
+// The documents are fetched in pages of esPageSize after the sort values of the previous page,
+// so the last of sorters must be of a field that's unique to each document.
+func searchAll(
+	ctx context.Context,
+	client *es.Client,
+	index string,
+	query es.Query,
+	sorters []es.Sorter,
+	hit func(hit *es.SearchHit) error) error {
+	var after []interface{}
+	for {
+		search := client.Search(index).Query(query).SortBy(sorters...).Size(esPageSize)
+		if after != nil {
+			search = search.SearchAfter(after...)
+		}
+
+		res, err := search.Do(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, h := range res.Hits.Hits {
+			if err := hit(h); err != nil {
+				return err
+			}
+		}
+
+		if len(res.Hits.Hits) < esPageSize {
+			return nil
+		}
+
+		after = res.Hits.Hits[len(res.Hits.Hits)-1].Sort
+	}
+}
@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/meateam/api-gateway/breaker"
 	"github.com/meateam/gotenberg-go-client/v6"
 	grpcPoolTypes "github.com/meateam/grpc-go-conn-pool/grpc/types"
 	"github.com/sirupsen/logrus"
@@ -19,138 +22,303 @@ import (
 
 // NewHealthChecker creates a new Health with default to false
 func NewHealthChecker() *Health {
-	return new(Health)
+	return &Health{dependencies: make(map[string]*DependencyStatus)}
 }
 
-// Health is an atomic Boolean
-// Its methods are all atomic, thus safe to be called by
-// multiple goroutines simultaneously
+// Health is the health of the api-gateway and of the dependencies it checks.
+// The healthiness of the api-gateway is an atomic Boolean, its methods
+// are all safe to be called by multiple goroutines simultaneously.
 // Note: When embedding into a struct, one should always use
 // *Health to avoid copy
-type Health int32
-
-// Check checks healthiness of conns once in interval seconds.
-func (h *Health) Check(
-	interval int,
-	rpcTimeout int,
-	logger *logrus.Logger,
-	gotenberg *gotenberg.Client,
-	badConns chan<- *grpcPoolTypes.ConnPool,
-	nonFatalConns []*grpcPoolTypes.ConnPool,
-	fatalConns ...*grpcPoolTypes.ConnPool) {
-	rpcTimeoutDuration := time.Duration(rpcTimeout) * time.Second
-	for {
-		// Check if the fatal connections are healthy.
-		// If one is not healthy, it will fail the entire system.
-		isHealthy := h.checkConnections(logger, fatalConns, badConns, true, rpcTimeout, rpcTimeoutDuration)
+type Health struct {
+	state int32
 
-		// Check the non-fatal connections' health
-		h.checkConnections(logger, nonFatalConns, badConns, false, rpcTimeout, rpcTimeoutDuration)
+	mu           sync.RWMutex
+	dependencies map[string]*DependencyStatus
+}
 
-		if !gotenberg.Healthy() {
-			logger.Printf("error: gotenberg at %s unhealthy", gotenberg.Hostname)
-		}
+const (
+	unhealthy int32 = iota
+	healthy
+	// draining marks the server as shutting down, once set it is never
+	// changed back so the health check loop can't revive it.
+	draining
+)
 
-		if isHealthy {
-			h.SetHealthy()
-		}
+// DependencyStatus is the result of the last health checks of a dependency.
+type DependencyStatus struct {
+	Name                string    `json:"name"`
+	Target              string    `json:"target"`
+	Fatal               bool      `json:"fatal"`
+	Healthy             bool      `json:"healthy"`
+	Error               string    `json:"error,omitempty"`
+	LastCheck           time.Time `json:"lastCheck"`
+	LatencyMs           float64   `json:"latencyMs"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Reconnecting        bool      `json:"reconnecting"`
+	Reconnects          uint64    `json:"reconnects"`
+	Breaker             string    `json:"breaker,omitempty"`
+}
 
-		time.Sleep(time.Second * time.Duration(interval))
-	}
+// HealthReport is the health of the api-gateway and each of its dependencies.
+type HealthReport struct {
+	Status       string             `json:"status"`
+	Dependencies []DependencyStatus `json:"dependencies"`
 }
 
-// checkConnections goes over an array of connections.
-// If the array contains fatal connections and one of them failed,
-// it will fail the api-gateway's healthcheck.
-// Returns true iff all of the connections are healthy.
-func (h *Health) checkConnections(
-	logger *logrus.Logger,
-	conns []*grpcPoolTypes.ConnPool,
-	badConns chan<- *grpcPoolTypes.ConnPool,
-	isFatal bool,
-	rpcTimeout int,
-	rpcTimeoutDuration time.Duration) bool {
+// dependency is a service that the api-gateway depends on, checked by Health.Check.
+type dependency struct {
+	name  string
+	fatal bool
+
+	// target returns the address of the dependency.
+	target func() string
+
+	// check returns a non-nil error if the dependency is unhealthy.
+	check func(ctx context.Context, logger *logrus.Logger) error
+
+	// reconnectStatus returns whether the dependency is reconnecting and the number of times
+	// it was reconnected, it's nil if the dependency isn't reconnected.
+	reconnectStatus func() (bool, uint64)
+
+	// breakerState returns the state of the circuit breaker of the dependency,
+	// it's nil if the dependency has no circuit breaker.
+	breakerState func() breaker.State
+}
 
+// grpcDependency creates a dependency of the gRPC service named name that is served by pool,
+// pool is sent to badConns if its health rpc fails. breakers may be nil if there are no circuit breakers.
+func grpcDependency(
+	name string,
+	pool *grpcPoolTypes.ConnPool,
+	fatal bool,
+	badConns chan<- *grpcPoolTypes.ConnPool,
+	breakers *breaker.Set) dependency {
 	fatalString := "non-fatal"
-	if isFatal {
+	if fatal {
 		fatalString = "fatal"
 	}
 
-	isAllHealthy := true
-	for _, pool := range conns {
-		conn := (*pool).Conn()
-		rpcCtx, rpcCancel := context.WithTimeout(context.Background(), rpcTimeoutDuration)
-		defer rpcCancel()
-		resp, err := healthpb.NewHealthClient(conn).Check(
-			rpcCtx, &healthpb.HealthCheckRequest{Service: ""})
-		targetMsg := fmt.Sprintf("target server %s", conn.Target())
-		if err != nil {
-			if stat, ok := status.FromError(err); ok && stat.Code() == codes.Unimplemented {
-				logger.Printf(
-					"error: %s does not implement the grpc health protocol (grpc.health.v1.Health) : %s",
-					targetMsg, fatalString)
-			} else if stat, ok := status.FromError(err); ok && stat.Code() == codes.DeadlineExceeded {
-				logger.Printf("timeout: %s health rpc did not complete within %v : %s", targetMsg, rpcTimeout, fatalString)
-			} else {
-				logger.Printf("error: %s health rpc failed: %+v : %s", err, targetMsg, fatalString)
+	var reconnectStatus func() (bool, uint64)
+	if p, ok := (*pool).(*swappablePool); ok {
+		reconnectStatus = p.reconnectStatus
+	}
+
+	var breakerState func() breaker.State
+	if breakers != nil {
+		breakerState = func() breaker.State { return breakers.State((*pool).Conn().Target()) }
+	}
+
+	return dependency{
+		name:            name,
+		fatal:           fatal,
+		target:          func() string { return (*pool).Conn().Target() },
+		reconnectStatus: reconnectStatus,
+		breakerState:    breakerState,
+		check: func(ctx context.Context, logger *logrus.Logger) error {
+			conn := (*pool).Conn()
+			resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: ""})
+			targetMsg := fmt.Sprintf("target server %s", conn.Target())
+			if err != nil {
+				if stat, ok := status.FromError(err); ok && stat.Code() == codes.Unimplemented {
+					logger.Printf(
+						"error: %s does not implement the grpc health protocol (grpc.health.v1.Health) : %s",
+						targetMsg, fatalString)
+				} else if stat, ok := status.FromError(err); ok && stat.Code() == codes.DeadlineExceeded {
+					logger.Printf("timeout: %s health rpc did not complete in time : %s", targetMsg, fatalString)
+				} else {
+					logger.Printf("error: %s health rpc failed: %+v : %s", targetMsg, err, fatalString)
+				}
+
+				badConns <- pool
+
+				return err
 			}
-			if isFatal {
-				h.SetUnhealthy()
-				isAllHealthy = false
+
+			if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+				logger.Printf("%s service unhealthy (responded with %q) : %s",
+					targetMsg, resp.GetStatus().String(), fatalString)
+
+				return fmt.Errorf("service responded with %s", resp.GetStatus().String())
 			}
-			badConns <- pool
-		}
 
-		if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
-			logger.Printf("%s service unhealthy (responded with %q) : %s",
-				targetMsg, resp.GetStatus().String(), fatalString)
-			if isFatal {
-				h.SetUnhealthy()
-				isAllHealthy = false
+			return nil
+		},
+	}
+}
+
+// gotenbergDependency creates a dependency of the gotenberg service of client.
+func gotenbergDependency(name string, client *gotenberg.Client, fatal bool) dependency {
+	return dependency{
+		name:   name,
+		fatal:  fatal,
+		target: func() string { return client.Hostname },
+		check: func(ctx context.Context, logger *logrus.Logger) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, client.Hostname+"/ping", nil)
+			if err != nil {
+				return err
 			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					err = fmt.Errorf("ping responded with status %d", resp.StatusCode)
+				}
+			}
+
+			if err != nil {
+				logger.Printf("error: gotenberg at %s unhealthy: %v", client.Hostname, err)
+			}
+
+			return err
+		},
+	}
+}
+
+// Check checks healthiness of deps once in interval seconds.
+// If one of the fatal dependencies is not healthy, it will fail the entire system.
+func (h *Health) Check(interval int, rpcTimeout int, logger *logrus.Logger, deps ...dependency) {
+	rpcTimeoutDuration := time.Duration(rpcTimeout) * time.Second
+	for {
+		if h.checkDependencies(logger, deps, rpcTimeoutDuration) {
+			h.SetHealthy()
+		} else {
+			h.SetUnhealthy()
+		}
+
+		time.Sleep(time.Second * time.Duration(interval))
+	}
+}
+
+// checkDependencies checks each of deps and records the results.
+// Returns true iff all of the fatal dependencies are healthy.
+func (h *Health) checkDependencies(logger *logrus.Logger, deps []dependency, rpcTimeout time.Duration) bool {
+	isAllHealthy := true
+	for _, dep := range deps {
+		ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+		start := time.Now()
+		err := dep.check(ctx, logger)
+		latency := time.Since(start)
+		cancel()
+
+		h.record(dep, start, latency, err)
+		if err != nil && dep.fatal {
+			isAllHealthy = false
 		}
 	}
 
 	return isAllHealthy
 }
 
-// SetHealthy sets the Boolean to true
+// record records the result of a check of dep that started at checkTime.
+func (h *Health) record(dep dependency, checkTime time.Time, latency time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.dependencies == nil {
+		h.dependencies = make(map[string]*DependencyStatus)
+	}
+
+	depStatus, ok := h.dependencies[dep.name]
+	if !ok {
+		depStatus = &DependencyStatus{Name: dep.name}
+		h.dependencies[dep.name] = depStatus
+	}
+
+	depStatus.Target = dep.target()
+	depStatus.Fatal = dep.fatal
+	depStatus.Healthy = err == nil
+	depStatus.LastCheck = checkTime
+	depStatus.LatencyMs = float64(latency) / float64(time.Millisecond)
+	if dep.reconnectStatus != nil {
+		depStatus.Reconnecting, depStatus.Reconnects = dep.reconnectStatus()
+	}
+
+	if dep.breakerState != nil {
+		depStatus.Breaker = dep.breakerState().String()
+	}
+
+	if err != nil {
+		depStatus.Error = err.Error()
+		depStatus.ConsecutiveFailures++
+	} else {
+		depStatus.Error = ""
+		depStatus.ConsecutiveFailures = 0
+	}
+}
+
+// Report returns the health of the api-gateway and of each of its dependencies sorted by name.
+func (h *Health) Report() HealthReport {
+	report := HealthReport{Status: "unhealthy", Dependencies: []DependencyStatus{}}
+	if h.IsDraining() {
+		report.Status = "draining"
+	} else if h.Get() {
+		report.Status = "healthy"
+	}
+
+	h.mu.RLock()
+	for _, depStatus := range h.dependencies {
+		report.Dependencies = append(report.Dependencies, *depStatus)
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(report.Dependencies, func(i, j int) bool {
+		return report.Dependencies[i].Name < report.Dependencies[j].Name
+	})
+
+	return report
+}
+
+// SetHealthy sets the Boolean to true, unless h is draining.
 func (h *Health) SetHealthy() {
-	atomic.StoreInt32((*int32)(h), 1)
+	atomic.CompareAndSwapInt32(&h.state, unhealthy, healthy)
 }
 
-// SetUnhealthy sets the Boolean to false
+// SetUnhealthy sets the Boolean to false, unless h is draining.
 func (h *Health) SetUnhealthy() {
-	atomic.StoreInt32((*int32)(h), 0)
+	atomic.CompareAndSwapInt32(&h.state, healthy, unhealthy)
+}
+
+// Drain sets h to be unhealthy permanently, used when the server is shutting down.
+func (h *Health) Drain() {
+	atomic.StoreInt32(&h.state, draining)
+}
+
+// IsDraining returns whether Drain was called on h.
+func (h *Health) IsDraining() bool {
+	return atomic.LoadInt32(&h.state) == draining
 }
 
 // Get returns whether the Boolean is true
 func (h *Health) Get() bool {
-	return atomic.LoadInt32((*int32)(h)) == 1
+	return atomic.LoadInt32(&h.state) == healthy
 }
 
 // SetTo sets the boolean with given Boolean
 func (h *Health) SetTo(yes bool) {
 	if yes {
-		atomic.StoreInt32((*int32)(h), 1)
+		h.SetHealthy()
 	} else {
-		atomic.StoreInt32((*int32)(h), 0)
+		h.SetUnhealthy()
 	}
 }
 
 // SetToIf sets the Boolean to new only if the Boolean matches the old
 // Returns whether the set was done
 func (h *Health) SetToIf(old, new bool) (set bool) {
-	var o, n int32
+	o, n := unhealthy, unhealthy
 	if old {
-		o = 1
+		o = healthy
 	}
 	if new {
-		n = 1
+		n = healthy
 	}
-	return atomic.CompareAndSwapInt32((*int32)(h), o, n)
+	return atomic.CompareAndSwapInt32(&h.state, o, n)
 }
 
+// healthCheck is the readiness probe handler, responds with 200 if the api-gateway
+// is ready to serve requests and with 503 otherwise.
 func (h *Health) healthCheck(c *gin.Context) {
 	status := http.StatusServiceUnavailable
 	if h.Get() {
@@ -159,3 +327,19 @@ func (h *Health) healthCheck(c *gin.Context) {
 
 	c.Status(status)
 }
+
+// liveness is the liveness probe handler, responds with 200 as long as the server is serving.
+func (h *Health) liveness(c *gin.Context) {
+	c.Status(http.StatusOK)
+}
+
+// healthReport responds with the HealthReport of h, the response status is
+// 200 if the api-gateway is ready to serve requests and 503 otherwise.
+func (h *Health) healthReport(c *gin.Context) {
+	status := http.StatusServiceUnavailable
+	if h.Get() {
+		status = http.StatusOK
+	}
+
+	c.JSON(status, h.Report())
+}
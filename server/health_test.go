@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	grpcPoolTypes "github.com/meateam/grpc-go-conn-pool/grpc/types"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// fakeDependency creates a dependency whose check returns the error pointed by err.
+func fakeDependency(name string, fatal bool, err *error) dependency {
+	return dependency{
+		name:   name,
+		fatal:  fatal,
+		target: func() string { return name + ":8080" },
+		check:  func(context.Context, *logrus.Logger) error { return *err },
+	}
+}
+
+func TestHealth_CheckDependencies(t *testing.T) {
+	var fatalErr, nonFatalErr error
+	deps := []dependency{
+		fakeDependency("file", true, &fatalErr),
+		fakeDependency("spike", false, &nonFatalErr),
+	}
+
+	h := NewHealthChecker()
+	nonFatalErr = errors.New("unavailable")
+	for i := 0; i < 3; i++ {
+		if !h.checkDependencies(logrus.New(), deps, 0) {
+			t.Fatal("expected a non-fatal failure to keep the gateway healthy")
+		}
+	}
+
+	report := h.Report()
+	if len(report.Dependencies) != 2 {
+		t.Fatalf("expected 2 dependencies in the report, got %d", len(report.Dependencies))
+	}
+
+	spike := report.Dependencies[1]
+	if spike.Name != "spike" || spike.Healthy || spike.Fatal || spike.ConsecutiveFailures != 3 {
+		t.Errorf("unexpected non-fatal dependency status: %+v", spike)
+	}
+
+	if spike.Target != "spike:8080" || spike.Error != "unavailable" || spike.LastCheck.IsZero() {
+		t.Errorf("unexpected non-fatal dependency status: %+v", spike)
+	}
+
+	fatalErr = errors.New("unavailable")
+	if h.checkDependencies(logrus.New(), deps, 0) {
+		t.Error("expected a fatal failure to fail the gateway")
+	}
+
+	nonFatalErr = nil
+	h.checkDependencies(logrus.New(), deps, 0)
+	if spike := h.Report().Dependencies[1]; !spike.Healthy || spike.ConsecutiveFailures != 0 || spike.Error != "" {
+		t.Errorf("expected recovered dependency status to be reset, got %+v", spike)
+	}
+}
+
+func TestHealth_Routes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewHealthChecker()
+	r := gin.New()
+	r.GET(healthRoute, h.healthReport)
+	r.GET(livenessRoute, h.liveness)
+	r.GET(readinessRoute, h.healthCheck)
+
+	var err error
+	h.checkDependencies(logrus.New(), []dependency{fakeDependency("file", true, &err)}, 0)
+
+	tests := []struct {
+		name        string
+		setHealth   func()
+		route       string
+		wantStatus  int
+		wantOverall string
+	}{
+		{name: "live when unhealthy", setHealth: h.SetUnhealthy, route: livenessRoute, wantStatus: http.StatusOK},
+		{name: "not ready when unhealthy", setHealth: h.SetUnhealthy, route: readinessRoute,
+			wantStatus: http.StatusServiceUnavailable},
+		{name: "ready when healthy", setHealth: h.SetHealthy, route: readinessRoute, wantStatus: http.StatusOK},
+		{name: "report when healthy", setHealth: h.SetHealthy, route: healthRoute, wantStatus: http.StatusOK,
+			wantOverall: "healthy"},
+		{name: "report when draining", setHealth: h.Drain, route: healthRoute,
+			wantStatus: http.StatusServiceUnavailable, wantOverall: "draining"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setHealth()
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.route, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+
+			if tt.wantOverall == "" {
+				return
+			}
+
+			var report HealthReport
+			if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+				t.Fatalf("failed decoding report: %v", err)
+			}
+
+			if report.Status != tt.wantOverall {
+				t.Errorf("expected report status %q, got %q", tt.wantOverall, report.Status)
+			}
+
+			if len(report.Dependencies) != 1 || report.Dependencies[0].Name != "file" {
+				t.Errorf("expected the file dependency in the report, got %+v", report.Dependencies)
+			}
+		})
+	}
+}
+
+func TestGRPCDependency(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listening: %v", err)
+	}
+
+	healthServer := health.NewServer()
+	grpcServer := grpc.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	pool, err := initServiceConn(serviceFile, listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatalf("failed dialing: %v", err)
+	}
+	defer (*pool).Close()
+
+	badConns := make(chan *grpcPoolTypes.ConnPool, 1)
+	dep := grpcDependency("file", pool, true, badConns, nil)
+
+	if err := dep.check(context.Background(), logrus.New()); err != nil {
+		t.Errorf("expected serving dependency to be healthy, got %v", err)
+	}
+
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	if err := dep.check(context.Background(), logrus.New()); err == nil {
+		t.Error("expected not serving dependency to be unhealthy")
+	}
+
+	if len(badConns) != 0 {
+		t.Error("expected a responding dependency not to be revived")
+	}
+
+	grpcServer.Stop()
+	if err := dep.check(context.Background(), logrus.New()); err == nil {
+		t.Error("expected unreachable dependency to be unhealthy")
+	}
+
+	if len(badConns) != 1 {
+		t.Error("expected an unreachable dependency to be revived")
+	}
+}
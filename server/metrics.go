@@ -1,19 +1,30 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/meateam/api-gateway/metrics"
 	"github.com/meateam/api-gateway/user"
 	es "github.com/olivere/elastic/v7"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"go.elastic.co/apm"
 )
 
-type body struct {
+// defaultMetricsFlushInterval is the interval between flushes of a MetricsShipper
+// that's created with a non-positive flush interval.
+const defaultMetricsFlushInterval = 5 * time.Second
+
+// MetricsDocument is a metrics document of a single authenticated request.
+type MetricsDocument struct {
 	User      *user.User `json:"user,omitempty"`
 	Path      string     `json:"path,omitempty"`
 	Method    string     `json:"method,omitempty"`
@@ -22,33 +33,260 @@ type body struct {
 	TraceID   string     `json:"traceID,omitempty"`
 }
 
-// NewMetricsLogger initializes the metrics middleware.
-func NewMetricsLogger() gin.HandlerFunc {
+// MetricsSink is the destination of the metrics documents shipped by MetricsShipper.
+type MetricsSink interface {
+	// Write ships a batch of documents, it's never called concurrently.
+	Write(ctx context.Context, docs []*MetricsDocument) error
+
+	// Close flushes any buffered documents and releases the sink's resources.
+	Close() error
+}
+
+// MetricsShipper ships metrics documents to a MetricsSink in the background.
+// Documents are queued in a bounded queue and written in batches of flushSize documents,
+// or every flushInterval if there are less queued documents. Documents that are enqueued
+// while the queue is full are dropped and counted.
+type MetricsShipper struct {
+	sink          MetricsSink
+	queue         chan *MetricsDocument
+	flushSize     int
+	flushInterval time.Duration
+	logger        *logrus.Logger
+	dropped       uint64
+
+	mu     sync.RWMutex
+	closed bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewMetricsShipper creates a MetricsShipper that ships documents to sink and starts shipping.
+// If logger is nil then it would default to logrus.New(). A non-positive flushInterval
+// defaults to defaultMetricsFlushInterval, and a negative queueSize to an unbuffered queue.
+func NewMetricsShipper(
+	sink MetricsSink,
+	queueSize int,
+	flushSize int,
+	flushInterval time.Duration,
+	logger *logrus.Logger,
+) *MetricsShipper {
+	if logger == nil {
+		logger = logrus.New()
+	}
+
+	if flushSize < 1 {
+		flushSize = 1
+	}
+
+	if flushInterval <= 0 {
+		flushInterval = defaultMetricsFlushInterval
+	}
+
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	s := &MetricsShipper{
+		sink:          sink,
+		queue:         make(chan *MetricsDocument, queueSize),
+		flushSize:     flushSize,
+		flushInterval: flushInterval,
+		logger:        logger,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	go s.run()
+
+	return s
+}
+
+// Enqueue queues doc to be shipped without blocking.
+// Returns false if doc was dropped since the queue is full or s is closed.
+func (s *MetricsShipper) Enqueue(doc *MetricsDocument) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.closed {
+		select {
+		case s.queue <- doc:
+			return true
+		default:
+		}
+	}
+
+	atomic.AddUint64(&s.dropped, 1)
+
+	return false
+}
+
+// Dropped returns the number of documents that were dropped.
+func (s *MetricsShipper) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Collector returns a prometheus collector of the number of documents dropped by s.
+func (s *MetricsShipper) Collector() prometheus.Collector {
+	return prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "metrics_documents_dropped_total",
+		Help:      "Total number of request metrics documents dropped since the shipping queue was full.",
+	}, func() float64 { return float64(s.Dropped()) })
+}
+
+// Close stops accepting documents, flushes the queued documents to the sink and closes it.
+func (s *MetricsShipper) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stop)
+	<-s.done
+
+	return s.sink.Close()
+}
+
+// run batches the queued documents and writes them to the sink until s is closed.
+func (s *MetricsShipper) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*MetricsDocument, 0, s.flushSize)
+	for {
+		select {
+		case doc := <-s.queue:
+			batch = append(batch, doc)
+			if len(batch) >= s.flushSize {
+				batch = s.flush(batch)
+			}
+		case <-ticker.C:
+			batch = s.flush(batch)
+		case <-s.stop:
+			// No documents are enqueued after s.stop is closed, drain the remaining ones.
+			for {
+				select {
+				case doc := <-s.queue:
+					batch = append(batch, doc)
+					if len(batch) >= s.flushSize {
+						batch = s.flush(batch)
+					}
+				default:
+					s.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush writes batch to the sink and returns an empty batch to reuse.
+func (s *MetricsShipper) flush(batch []*MetricsDocument) []*MetricsDocument {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	if err := s.sink.Write(context.Background(), batch); err != nil {
+		s.logger.Errorf("failed shipping %d metrics documents: %v", len(batch), err)
+	}
+
+	return make([]*MetricsDocument, 0, s.flushSize)
+}
+
+// esMetricsSink is a MetricsSink that indexes the documents to elasticsearch
+// using the elastic client's bulk processor.
+type esMetricsSink struct {
+	processor *es.BulkProcessor
+	index     string
+}
+
+// newESMetricsSink creates a MetricsSink that indexes documents to index with client.
+func newESMetricsSink(client *es.Client, index string, logger *logrus.Logger) (*esMetricsSink, error) {
+	processor, err := client.BulkProcessor().
+		Name("metrics").
+		Workers(1).
+		// Commits are triggered by MetricsShipper.
+		BulkActions(-1).
+		BulkSize(-1).
+		After(func(_ int64, _ []es.BulkableRequest, resp *es.BulkResponse, err error) {
+			if err != nil {
+				logger.Errorf("failed indexing metrics: %v", err)
+				return
+			}
+
+			if failed := resp.Failed(); len(failed) > 0 {
+				logger.Errorf("failed indexing %d metrics documents: %v", len(failed), failed[0].Error)
+			}
+		}).
+		Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return &esMetricsSink{processor: processor, index: index}, nil
+}
+
+// Write adds docs to the bulk processor and commits them.
+func (s *esMetricsSink) Write(_ context.Context, docs []*MetricsDocument) error {
+	for _, doc := range docs {
+		s.processor.Add(es.NewBulkIndexRequest().Index(s.index).Doc(doc))
+	}
+
+	return s.processor.Flush()
+}
+
+// Close closes the bulk processor, committing any outstanding documents.
+func (s *esMetricsSink) Close() error {
+	return s.processor.Close()
+}
+
+// NewElasticsearchMetricsShipper creates a MetricsShipper that ships documents to elasticsearch.
+// Returns a non-nil error if the elasticsearch client couldn't be created.
+func NewElasticsearchMetricsShipper(logger *logrus.Logger) (*MetricsShipper, error) {
 	config, index := initESConfig()
 
 	client, err := es.NewClient(config...)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
-	return func(c *gin.Context) {
+	sink, err := newESMetricsSink(client, index, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMetricsShipper(
+		sink,
+		viper.GetInt(configMetricsQueueSize),
+		viper.GetInt(configMetricsFlushSize),
+		time.Duration(viper.GetInt(configMetricsFlushInterval))*time.Second,
+		logger,
+	), nil
+}
 
+// NewMetricsLogger initializes the metrics middleware, which enqueues a document
+// of each request to shipper.
+func NewMetricsLogger(shipper *MetricsShipper) gin.HandlerFunc {
+	return func(c *gin.Context) {
 		currentTransaction := apm.TransactionFromContext(c.Request.Context())
 
 		t := time.Now()
 		roundedDate := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()) // round the time to date (day-month-year)
 
-		_, _ = client.Index().
-			Index(index).
-			BodyJson(&body{
-				User:      user.ExtractRequestUser(c),
-				Path:      c.Request.URL.Path,
-				Method:    c.Request.Method,
-				TimeStamp: time.Now(),
-				Date:      roundedDate,
-				TraceID:   currentTransaction.TraceContext().Trace.String(),
-			}).
-			Do(c.Request.Context())
+		shipper.Enqueue(&MetricsDocument{
+			User:      user.ExtractRequestUser(c),
+			Path:      c.Request.URL.Path,
+			Method:    c.Request.Method,
+			TimeStamp: t,
+			Date:      roundedDate,
+			TraceID:   currentTransaction.TraceContext().Trace.String(),
+		})
 	}
 }
 
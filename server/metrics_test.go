@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMetricsSink is a MetricsSink that records the batches written to it.
+type fakeMetricsSink struct {
+	mu      sync.Mutex
+	batches [][]*MetricsDocument
+	written chan struct{}
+	block   chan struct{}
+	closed  bool
+}
+
+func newFakeMetricsSink() *fakeMetricsSink {
+	return &fakeMetricsSink{written: make(chan struct{}, 100)}
+}
+
+func (s *fakeMetricsSink) Write(_ context.Context, docs []*MetricsDocument) error {
+	if s.block != nil {
+		<-s.block
+	}
+
+	s.mu.Lock()
+	s.batches = append(s.batches, docs)
+	s.mu.Unlock()
+	s.written <- struct{}{}
+
+	return nil
+}
+
+func (s *fakeMetricsSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+
+	return nil
+}
+
+func (s *fakeMetricsSink) count() (batches int, docs int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, batch := range s.batches {
+		docs += len(batch)
+	}
+
+	return len(s.batches), docs
+}
+
+func (s *fakeMetricsSink) waitWrite(t *testing.T) {
+	t.Helper()
+	select {
+	case <-s.written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a batch to be written")
+	}
+}
+
+func TestMetricsShipper_FlushSize(t *testing.T) {
+	sink := newFakeMetricsSink()
+	shipper := NewMetricsShipper(sink, 10, 3, time.Hour, nil)
+	defer shipper.Close()
+
+	for i := 0; i < 3; i++ {
+		shipper.Enqueue(&MetricsDocument{Path: "/api/files"})
+	}
+
+	sink.waitWrite(t)
+	if batches, docs := sink.count(); batches != 1 || docs != 3 {
+		t.Errorf("expected 1 batch of 3 documents, got %d batches of %d documents", batches, docs)
+	}
+}
+
+func TestMetricsShipper_FlushInterval(t *testing.T) {
+	sink := newFakeMetricsSink()
+	shipper := NewMetricsShipper(sink, 10, 100, 50*time.Millisecond, nil)
+	defer shipper.Close()
+
+	shipper.Enqueue(&MetricsDocument{Path: "/api/files"})
+
+	sink.waitWrite(t)
+	if batches, docs := sink.count(); batches != 1 || docs != 1 {
+		t.Errorf("expected 1 batch of 1 document, got %d batches of %d documents", batches, docs)
+	}
+}
+
+func TestMetricsShipper_InvalidConfig(t *testing.T) {
+	sink := newFakeMetricsSink()
+	shipper := NewMetricsShipper(sink, -1, 0, 0, nil)
+
+	if shipper.flushInterval != defaultMetricsFlushInterval {
+		t.Errorf("expected flush interval %s, got %s", defaultMetricsFlushInterval, shipper.flushInterval)
+	}
+
+	if err := shipper.Close(); err != nil {
+		t.Fatalf("failed closing shipper: %v", err)
+	}
+}
+
+func TestMetricsShipper_DropsWhenFull(t *testing.T) {
+	sink := newFakeMetricsSink()
+	sink.block = make(chan struct{})
+	shipper := NewMetricsShipper(sink, 2, 1, time.Hour, nil)
+
+	// The first document is taken by the blocked sink, the next two fill the queue.
+	shipper.Enqueue(&MetricsDocument{})
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if !shipper.Enqueue(&MetricsDocument{}) {
+			t.Fatal("expected document to be queued")
+		}
+	}
+
+	if shipper.Enqueue(&MetricsDocument{}) {
+		t.Error("expected document to be dropped when the queue is full")
+	}
+
+	if dropped := shipper.Dropped(); dropped != 1 {
+		t.Errorf("expected 1 dropped document, got %d", dropped)
+	}
+
+	close(sink.block)
+	if err := shipper.Close(); err != nil {
+		t.Fatalf("failed closing shipper: %v", err)
+	}
+
+	if _, docs := sink.count(); docs != 3 {
+		t.Errorf("expected 3 shipped documents, got %d", docs)
+	}
+}
+
+func TestMetricsShipper_CloseFlushes(t *testing.T) {
+	sink := newFakeMetricsSink()
+	shipper := NewMetricsShipper(sink, 10, 100, time.Hour, nil)
+
+	for i := 0; i < 5; i++ {
+		shipper.Enqueue(&MetricsDocument{})
+	}
+
+	if err := shipper.Close(); err != nil {
+		t.Fatalf("failed closing shipper: %v", err)
+	}
+
+	if _, docs := sink.count(); docs != 5 {
+		t.Errorf("expected close to flush 5 documents, got %d", docs)
+	}
+
+	if !sink.closed {
+		t.Error("expected close to close the sink")
+	}
+
+	if shipper.Enqueue(&MetricsDocument{}) {
+		t.Error("expected closed shipper to drop documents")
+	}
+}
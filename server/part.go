@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/meateam/api-gateway/upload"
+	es "github.com/olivere/elastic/v7"
+	"github.com/spf13/viper"
+)
+
+// partIndexMapping is the mapping of the committed ranges index, ranges are listed by their upload id.
+const partIndexMapping = `{
+	"mappings": {
+		"properties": {
+			"uploadId":    {"type": "keyword"},
+			"start":       {"type": "long"},
+			"end":         {"type": "long"},
+			"size":        {"type": "long"},
+			"committedAt": {"type": "date"}
+		}
+	}
+}`
+
+// esPartStore is an upload.PartStore that stores the committed ranges in an elasticsearch index,
+// with the upload id and the start of a range as its document id, so a retried range replaces itself.
+type esPartStore struct {
+	client *es.Client
+	index  string
+}
+
+// NewElasticsearchPartStore creates an upload.PartStore that stores the committed ranges in elasticsearch,
+// and creates its index if it doesn't exist. Returns a non-nil error if the elasticsearch client
+// couldn't be created or the index couldn't be created.
+func NewElasticsearchPartStore() (upload.PartStore, error) {
+	index := viper.GetString(configUploadPartIndex)
+	client, err := newESStore(index, partIndexMapping)
+	if err != nil {
+		return nil, err
+	}
+
+	return &esPartStore{client: client, index: index}, nil
+}
+
+// Add indexes partRange, refreshing the index so the range is immediately listed.
+func (s *esPartStore) Add(ctx context.Context, partRange *upload.PartRange) error {
+	id := fmt.Sprintf("%s-%d", partRange.UploadID, partRange.Start)
+	_, err := s.client.Index().Index(s.index).Id(id).BodyJson(partRange).Refresh("wait_for").Do(ctx)
+
+	return err
+}
+
+// List returns all of the committed ranges of uploadID, ordered by their start.
+func (s *esPartStore) List(ctx context.Context, uploadID string) ([]*upload.PartRange, error) {
+	var ranges []*upload.PartRange
+	query := es.NewTermQuery("uploadId", uploadID)
+	sorters := []es.Sorter{es.NewFieldSort("start")}
+	err := searchAll(ctx, s.client, s.index, query, sorters, func(hit *es.SearchHit) error {
+		partRange := &upload.PartRange{}
+		if err := json.Unmarshal(hit.Source, partRange); err != nil {
+			return err
+		}
+
+		ranges = append(ranges, partRange)
+
+		return nil
+	})
+
+	return ranges, err
+}
+
+// Remove deletes the committed ranges of uploadID.
+func (s *esPartStore) Remove(ctx context.Context, uploadID string) error {
+	_, err := s.client.DeleteByQuery(s.index).
+		Query(es.NewTermQuery("uploadId", uploadID)).
+		Refresh("true").
+		Do(ctx)
+
+	return err
+}
@@ -0,0 +1,359 @@
+package server
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	grpcPoolTypes "github.com/meateam/grpc-go-conn-pool/grpc/types"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultReconnectProbeTimeout is the timeout of the health rpc of a revived pool
+	// if the reconnector has no probe timeout.
+	defaultReconnectProbeTimeout = 5 * time.Second
+
+	// drainCheckInterval is the interval in which a replaced pool is checked for calls in flight.
+	drainCheckInterval = 100 * time.Millisecond
+)
+
+// swappablePool is a grpcPoolTypes.ConnPool that delegates to a connection pool
+// which can be swapped atomically while it's being used.
+type swappablePool struct {
+	target  string
+	current atomic.Value
+
+	// dial dials a new pool to target whose calls are counted by calls, used to revive the pool.
+	dial func(calls *callTracker) (grpcPoolTypes.ConnPool, error)
+
+	// reconnecting is 1 while the pool is being revived, it's used to allow at most one revive at a time.
+	reconnecting int32
+	reconnects   uint64
+
+	// attempts is the number of attempts of the last revive and revivedAt is when it succeeded,
+	// they're only accessed by the single revive of the pool.
+	attempts  int
+	revivedAt time.Time
+}
+
+// poolHolder holds the current pool of a swappablePool and the tracker of its calls,
+// since atomic.Value must always hold the same concrete type.
+type poolHolder struct {
+	pool  grpcPoolTypes.ConnPool
+	calls *callTracker
+}
+
+// newSwappablePool dials a pool to target with dial and creates a swappablePool of it,
+// which is revived with dial. Returns a non-nil error if the pool couldn't be dialed.
+func newSwappablePool(
+	target string,
+	dial func(calls *callTracker) (grpcPoolTypes.ConnPool, error)) (*swappablePool, error) {
+	calls := &callTracker{}
+	pool, err := dial(calls)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &swappablePool{target: target, dial: dial}
+	p.current.Store(poolHolder{pool: pool, calls: calls})
+
+	return p, nil
+}
+
+// load returns the current pool of p.
+func (p *swappablePool) load() grpcPoolTypes.ConnPool {
+	return p.current.Load().(poolHolder).pool
+}
+
+// swap replaces the current pool of p with pool, whose calls are counted by calls,
+// and returns the replaced pool. swap must not be called concurrently, it's called only
+// by the single revive of p.
+func (p *swappablePool) swap(pool grpcPoolTypes.ConnPool, calls *callTracker) poolHolder {
+	old := p.current.Load().(poolHolder)
+	p.current.Store(poolHolder{pool: pool, calls: calls})
+
+	return old
+}
+
+// Conn returns a ClientConn from the current pool.
+func (p *swappablePool) Conn() *grpc.ClientConn {
+	return p.load().Conn()
+}
+
+// Num returns the number of connections in the current pool.
+func (p *swappablePool) Num() int {
+	return p.load().Num()
+}
+
+// Close closes the current pool.
+func (p *swappablePool) Close() error {
+	return p.load().Close()
+}
+
+// Invoke performs a unary RPC on the current pool.
+func (p *swappablePool) Invoke(
+	ctx context.Context,
+	method string,
+	args interface{},
+	reply interface{},
+	opts ...grpc.CallOption) error {
+	return p.load().Invoke(ctx, method, args, reply, opts...)
+}
+
+// NewStream begins a streaming RPC on the current pool.
+func (p *swappablePool) NewStream(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	method string,
+	opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return p.load().NewStream(ctx, desc, method, opts...)
+}
+
+// firstAttempt returns the attempt that a revive of p starts from, which is the number of attempts
+// of its last revive if it was revived within maxBackoff, or 0 otherwise.
+func (p *swappablePool) firstAttempt(maxBackoff time.Duration) int {
+	if time.Since(p.revivedAt) > maxBackoff {
+		return 0
+	}
+
+	return p.attempts
+}
+
+// reconnectStatus returns whether p is being revived and the number of times it was revived.
+func (p *swappablePool) reconnectStatus() (bool, uint64) {
+	return atomic.LoadInt32(&p.reconnecting) == 1, atomic.LoadUint64(&p.reconnects)
+}
+
+// callTracker counts the calls in flight on the connections of a pool,
+// so the pool is closed once it's idle after it's replaced.
+type callTracker struct {
+	inFlight int64
+}
+
+// dialOptions returns the dial options of the interceptors that count the calls of the connections.
+func (t *callTracker) dialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(t.unaryInterceptor),
+		grpc.WithChainStreamInterceptor(t.streamInterceptor),
+	}
+}
+
+// idle reports whether there are no calls in flight.
+func (t *callTracker) idle() bool {
+	return atomic.LoadInt64(&t.inFlight) == 0
+}
+
+// unaryInterceptor counts a unary call while it's in flight.
+func (t *callTracker) unaryInterceptor(
+	ctx context.Context,
+	method string,
+	req interface{},
+	reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption) error {
+	atomic.AddInt64(&t.inFlight, 1)
+	defer atomic.AddInt64(&t.inFlight, -1)
+
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// streamInterceptor counts a stream until it's received its last message or its context is done.
+func (t *callTracker) streamInterceptor(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	atomic.AddInt64(&t.inFlight, 1)
+
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		atomic.AddInt64(&t.inFlight, -1)
+		return nil, err
+	}
+
+	tracked := &trackedStream{
+		ClientStream:  stream,
+		serverStreams: desc.ServerStreams,
+		tracker:       t,
+		finished:      make(chan struct{}),
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			tracked.finish()
+		case <-tracked.finished:
+		}
+	}()
+
+	return tracked, nil
+}
+
+// trackedStream is a grpc.ClientStream that's counted by tracker until it's finished.
+type trackedStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	tracker       *callTracker
+	once          sync.Once
+	finished      chan struct{}
+}
+
+// RecvMsg receives a message of the stream, which is finished once it has no more messages.
+func (s *trackedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.serverStreams {
+		s.finish()
+	}
+
+	return err
+}
+
+// finish stops counting s.
+func (s *trackedStream) finish() {
+	s.once.Do(func() {
+		close(s.finished)
+		atomic.AddInt64(&s.tracker.inFlight, -1)
+	})
+}
+
+// reconnector revives bad connection pools by dialing their target again,
+// with a capped exponential backoff between failed attempts. A dialed pool replaces
+// the bad pool only once it responds to a health rpc within probeTimeout, and the
+// replaced pool is closed once its calls are done or after drainTimeout, if it's positive.
+type reconnector struct {
+	logger       *logrus.Logger
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	probeTimeout time.Duration
+	drainTimeout time.Duration
+}
+
+// run revives each of the pools received from badConns until badConns is closed.
+func (r *reconnector) run(badConns <-chan *grpcPoolTypes.ConnPool) {
+	for pool := range badConns {
+		r.revive(pool)
+	}
+}
+
+// revive starts reviving pool in the background, unless pool is already being revived.
+// Once a new pool is dialed and responds it replaces the current pool, which is then drained.
+// A pool that's revived again within maxBackoff of its last revive continues backing off
+// from the last revive's attempt, so a backend that keeps failing is revived less often.
+func (r *reconnector) revive(pool *grpcPoolTypes.ConnPool) {
+	p, ok := (*pool).(*swappablePool)
+	if !ok {
+		r.logger.Errorf("can't revive connection pool of type %T", *pool)
+		return
+	}
+
+	if !atomic.CompareAndSwapInt32(&p.reconnecting, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&p.reconnecting, 0)
+
+		for attempt := p.firstAttempt(r.maxBackoff); ; attempt++ {
+			time.Sleep(r.backoff(attempt))
+
+			calls := &callTracker{}
+			newPool, err := p.dial(calls)
+			if err == nil {
+				if err = r.probe(newPool); err != nil {
+					if closeErr := newPool.Close(); closeErr != nil {
+						r.logger.Errorf("failed closing unresponsive connection pool of %s: %v", p.target, closeErr)
+					}
+				}
+			}
+
+			if err != nil {
+				r.logger.Errorf("failed reconnecting to %s (attempt %d): %v", p.target, attempt+1, err)
+				continue
+			}
+
+			old := p.swap(newPool, calls)
+			p.attempts = attempt + 1
+			p.revivedAt = time.Now()
+
+			atomic.AddUint64(&p.reconnects, 1)
+			r.logger.Infof("reconnected to %s", p.target)
+
+			go r.drain(p.target, old)
+
+			return
+		}
+	}()
+}
+
+// probe checks that pool is connected to a server by calling its health rpc, which is waited for until
+// the connection is ready. A server that doesn't implement the health rpc is connected as well.
+func (r *reconnector) probe(pool grpcPoolTypes.ConnPool) error {
+	timeout := r.probeTimeout
+	if timeout <= 0 {
+		timeout = defaultReconnectProbeTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, err := healthpb.NewHealthClient(pool.Conn()).Check(
+		ctx,
+		&healthpb.HealthCheckRequest{Service: ""},
+		grpc.WaitForReady(true),
+	)
+	if status.Code(err) == codes.Unimplemented {
+		return nil
+	}
+
+	return err
+}
+
+// drain closes the replaced pool of target once it has no calls in flight,
+// or once r.drainTimeout passed if it's positive.
+func (r *reconnector) drain(target string, replaced poolHolder) {
+	deadline := time.Now().Add(r.drainTimeout)
+
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if replaced.calls.idle() {
+			break
+		}
+
+		if r.drainTimeout > 0 && time.Now().After(deadline) {
+			r.logger.Errorf("closing replaced connection pool of %s with calls in flight", target)
+			break
+		}
+	}
+
+	if err := replaced.pool.Close(); err != nil {
+		r.logger.Errorf("failed closing replaced connection pool of %s: %v", target, err)
+	}
+}
+
+// backoff returns the delay before the given reconnect attempt, which is counted from 0.
+// The delay grows exponentially from r.minBackoff up to r.maxBackoff, and is jittered
+// to a random duration between half of it and all of it.
+func (r *reconnector) backoff(attempt int) time.Duration {
+	delay := r.maxBackoff
+	if attempt < 32 {
+		if d := r.minBackoff << uint(attempt); d > 0 && d < r.maxBackoff {
+			delay = d
+		}
+	}
+
+	half := delay / 2
+
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	grpcPoolTypes "github.com/meateam/grpc-go-conn-pool/grpc/types"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// newHealthTestServer serves a gRPC server with a health service on a local listener.
+func newHealthTestServer(t *testing.T) (string, *health.Server) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listening: %v", err)
+	}
+
+	healthServer := health.NewServer()
+	grpcServer := grpc.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	return listener.Addr().String(), healthServer
+}
+
+// dialTestPool dials a connection pool to target whose calls are counted by calls.
+func dialTestPool(target string) func(calls *callTracker) (grpcPoolTypes.ConnPool, error) {
+	return func(calls *callTracker) (grpcPoolTypes.ConnPool, error) {
+		return dialServicePool(serviceFile, target, nil, calls.dialOptions()...)
+	}
+}
+
+// newTestPool creates a swappable pool of target that's dialed with dial.
+func newTestPool(
+	t *testing.T,
+	target string,
+	dial func(calls *callTracker) (grpcPoolTypes.ConnPool, error)) *grpcPoolTypes.ConnPool {
+	t.Helper()
+	swappable, err := newSwappablePool(target, dial)
+	if err != nil {
+		t.Fatalf("failed dialing: %v", err)
+	}
+
+	var pool grpcPoolTypes.ConnPool = swappable
+
+	return &pool
+}
+
+func TestReconnector_ConcurrentConnDuringSwap(t *testing.T) {
+	target, _ := newHealthTestServer(t)
+	pool := newTestPool(t, target, dialTestPool(target))
+
+	r := &reconnector{logger: logrus.New()}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					if conn := (*pool).Conn(); conn == nil || conn.Target() != target {
+						t.Errorf("expected a connection to %s", target)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for i := uint64(1); i <= 5; i++ {
+		r.revive(pool)
+		waitReconnects(t, (*pool).(*swappablePool), i)
+	}
+
+	close(stop)
+	wg.Wait()
+	(*pool).Close()
+}
+
+func TestReconnector_KeepsCallsAcrossSwap(t *testing.T) {
+	target, healthServer := newHealthTestServer(t)
+	pool := newTestPool(t, target, dialTestPool(target))
+	oldConn := (*pool).Conn()
+
+	r := &reconnector{logger: logrus.New()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watch, err := healthpb.NewHealthClient(oldConn).Watch(ctx, &healthpb.HealthCheckRequest{Service: ""})
+	if err != nil {
+		t.Fatalf("failed watching health: %v", err)
+	}
+
+	if _, err := watch.Recv(); err != nil {
+		t.Fatalf("failed receiving health: %v", err)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					client := healthpb.NewHealthClient((*pool).Conn())
+					if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+						t.Errorf("expected calls during swaps to succeed, got %v", err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for i := uint64(1); i <= 3; i++ {
+		r.revive(pool)
+		waitReconnects(t, (*pool).(*swappablePool), i)
+	}
+
+	close(stop)
+	wg.Wait()
+
+	time.Sleep(3 * drainCheckInterval)
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	if resp, err := watch.Recv(); err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("expected the stream of the replaced pool to keep receiving, got %v, %v", resp, err)
+	}
+
+	cancel()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for oldConn.GetState() != connectivity.Shutdown {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the replaced pool to be closed once its stream finished")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	(*pool).Close()
+}
+
+func TestReconnector_ProbesBeforeSwap(t *testing.T) {
+	// The listener accepts connections, but isn't served until the backend is up.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listening: %v", err)
+	}
+
+	target := listener.Addr().String()
+	var dials int32
+	pool := newTestPool(t, target, func(calls *callTracker) (grpcPoolTypes.ConnPool, error) {
+		atomic.AddInt32(&dials, 1)
+		return dialTestPool(target)(calls)
+	})
+
+	r := &reconnector{
+		logger:       logrus.New(),
+		minBackoff:   time.Millisecond,
+		maxBackoff:   10 * time.Millisecond,
+		probeTimeout: 20 * time.Millisecond,
+	}
+
+	r.revive(pool)
+	time.Sleep(200 * time.Millisecond)
+
+	swappable := (*pool).(*swappablePool)
+	if _, reconnects := swappable.reconnectStatus(); reconnects != 0 {
+		t.Fatal("expected an unresponsive pool not to replace the current pool")
+	}
+
+	if got := atomic.LoadInt32(&dials); got < 3 {
+		t.Fatalf("expected the revive to keep dialing, got %d dials", got)
+	}
+
+	grpcServer := grpc.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	waitReconnects(t, swappable, 1)
+	(*pool).Close()
+}
+
+func TestSwappablePool_FirstAttempt(t *testing.T) {
+	p := &swappablePool{}
+	if got := p.firstAttempt(time.Minute); got != 0 {
+		t.Errorf("expected a pool that wasn't revived to start from 0, got %d", got)
+	}
+
+	p.attempts = 3
+	p.revivedAt = time.Now()
+	if got := p.firstAttempt(time.Minute); got != 3 {
+		t.Errorf("expected a recently revived pool to continue backing off from 3, got %d", got)
+	}
+
+	p.revivedAt = time.Now().Add(-2 * time.Minute)
+	if got := p.firstAttempt(time.Minute); got != 0 {
+		t.Errorf("expected a pool revived long ago to start from 0, got %d", got)
+	}
+}
+
+func TestReconnector_SingleRevivePerPool(t *testing.T) {
+	target, _ := newHealthTestServer(t)
+	var dials int32
+	release := make(chan struct{})
+	pool := newTestPool(t, target, func(calls *callTracker) (grpcPoolTypes.ConnPool, error) {
+		// The first dial is of the initial pool, revives wait for release and the first of them fails.
+		switch atomic.AddInt32(&dials, 1) {
+		case 1:
+		case 2:
+			<-release
+			return nil, errors.New("unavailable")
+		default:
+			<-release
+		}
+
+		return dialTestPool(target)(calls)
+	})
+
+	r := &reconnector{logger: logrus.New()}
+
+	for i := 0; i < 10; i++ {
+		r.revive(pool)
+	}
+
+	swappable := (*pool).(*swappablePool)
+	if reconnecting, _ := swappable.reconnectStatus(); !reconnecting {
+		t.Error("expected pool to be reconnecting")
+	}
+
+	close(release)
+	waitReconnects(t, swappable, 1)
+
+	if got := atomic.LoadInt32(&dials); got != 3 {
+		t.Errorf("expected a single revive to dial twice, got %d dials", got-1)
+	}
+
+	(*pool).Close()
+}
+
+func TestReconnector_Backoff(t *testing.T) {
+	r := &reconnector{minBackoff: time.Second, maxBackoff: 10 * time.Second}
+
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 0, max: time.Second},
+		{attempt: 1, max: 2 * time.Second},
+		{attempt: 3, max: 8 * time.Second},
+		{attempt: 4, max: 10 * time.Second},
+		{attempt: 100, max: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if got := r.backoff(tt.attempt); got < tt.max/2 || got > tt.max {
+				t.Fatalf("expected backoff of attempt %d to be in [%v, %v], got %v",
+					tt.attempt, tt.max/2, tt.max, got)
+			}
+		}
+	}
+}
+
+// waitReconnects waits for pool to be reconnected reconnects times.
+func waitReconnects(t *testing.T, pool *swappablePool, reconnects uint64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if reconnecting, got := pool.reconnectStatus(); !reconnecting && got == reconnects {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("pool wasn't reconnected %d times", reconnects)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"time"
+
+	"github.com/meateam/api-gateway/retry"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/codes"
+)
+
+// idempotentMethods are the read-only gRPC methods of the backends, which are safe to retry.
+// Methods that create, update or delete, such as "/file.FileService/CreateFile", must never be retried.
+var idempotentMethods = []string{
+	"/file.FileService/GetUploadByID",
+	"/file.FileService/GetFileByID",
+	"/file.FileService/GetFileByKey",
+	"/file.FileService/GetFilesByFolder",
+	"/file.FileService/GetDescendantsByFolder",
+	"/file.FileService/IsAllowed",
+	"/file.FileService/GetAncestors",
+	"/file.FileService/GetDescendantsByID",
+	"/quota.QuotaService/IsAllowedToGetQuota",
+	"/quota.QuotaService/GetOwnerQuota",
+	"/permission.Permission/GetFilePermissions",
+	"/permission.Permission/GetUserPermissions",
+	"/permission.Permission/IsPermitted",
+	"/permission.Permission/GetPermission",
+	"/permission.Permission/GetPermissionByMongoID",
+	"/users.Users/GetUserByID",
+	"/users.Users/GetUserByMail",
+	"/users.Users/GetUserByMailOrT",
+	"/users.Users/FindUserByName",
+	"/search.search/Search",
+	"/dropbox.Dropbox/CanApproveToUser",
+	"/dropbox.Dropbox/GetApproverInfo",
+	"/dropbox.Dropbox/GetTransfersInfo",
+	"/dropbox.Dropbox/HasTransfer",
+	"/spike.Spike/ValidateToken",
+}
+
+// retryPolicies returns the retry policies of the idempotent methods from the config,
+// returns nil if retries are disabled.
+func retryPolicies() map[string]retry.Policy {
+	maxAttempts := viper.GetInt(configRetryMaxAttempts)
+	if maxAttempts <= 1 {
+		return nil
+	}
+
+	policy := retry.Policy{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: time.Duration(viper.GetInt(configRetryInitialBackoff)) * time.Millisecond,
+		MaxBackoff:     time.Duration(viper.GetInt(configRetryMaxBackoff)) * time.Millisecond,
+		Codes:          []codes.Code{codes.Unavailable},
+	}
+
+	policies := make(map[string]retry.Policy, len(idempotentMethods))
+	for _, method := range idempotentMethods {
+		policies[method] = policy
+	}
+
+	return policies
+}
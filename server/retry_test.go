@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/meateam/api-gateway/retry"
+	fpb "github.com/meateam/file-service/proto/file"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// flakyFileServer is a file service that fails the first failures calls of each method as unavailable.
+type flakyFileServer struct {
+	fpb.UnimplementedFileServiceServer
+	failures int
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+// call counts a call of method and returns the error of the call.
+func (s *flakyFileServer) call(method string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls[method]++
+	if s.calls[method] <= s.failures {
+		return status.Error(codes.Unavailable, "unavailable")
+	}
+
+	return nil
+}
+
+func (s *flakyFileServer) GetFileByID(context.Context, *fpb.GetByFileByIDRequest) (*fpb.File, error) {
+	if err := s.call("GetFileByID"); err != nil {
+		return nil, err
+	}
+
+	return &fpb.File{Id: "1"}, nil
+}
+
+func (s *flakyFileServer) CreateFile(context.Context, *fpb.CreateFileRequest) (*fpb.File, error) {
+	if err := s.call("CreateFile"); err != nil {
+		return nil, err
+	}
+
+	return &fpb.File{Id: "1"}, nil
+}
+
+func TestRetryPolicies(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listening: %v", err)
+	}
+
+	fileServer := &flakyFileServer{failures: 2, calls: make(map[string]int)}
+	grpcServer := grpc.NewServer()
+	fpb.RegisterFileServiceServer(grpcServer, fileServer)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	pool, err := initServiceConn(
+		serviceFile,
+		listener.Addr().String(),
+		nil,
+		grpc.WithChainUnaryInterceptor(retry.UnaryClientInterceptor(retryPolicies())),
+	)
+	if err != nil {
+		t.Fatalf("failed dialing: %v", err)
+	}
+	defer (*pool).Close()
+
+	client := fpb.NewFileServiceClient((*pool).Conn())
+
+	if _, err := client.GetFileByID(context.Background(), &fpb.GetByFileByIDRequest{Id: "1"}); err != nil {
+		t.Errorf("expected GetFileByID to be retried until it succeeds, got %v", err)
+	}
+
+	if _, err := client.CreateFile(context.Background(), &fpb.CreateFileRequest{}); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected CreateFile to fail as unavailable, got %v", err)
+	}
+
+	if calls := fileServer.calls["GetFileByID"]; calls != 3 {
+		t.Errorf("expected GetFileByID to be called 3 times, got %d", calls)
+	}
+
+	if calls := fileServer.calls["CreateFile"]; calls != 1 {
+		t.Errorf("expected CreateFile never to be retried, got %d calls", calls)
+	}
+}
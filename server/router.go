@@ -2,7 +2,6 @@ package server
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 	"os"
 	"regexp"
@@ -12,12 +11,17 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/go-openapi/runtime/middleware"
+	"github.com/meateam/api-gateway/apierror"
+	"github.com/meateam/api-gateway/breaker"
+	"github.com/meateam/api-gateway/cache"
 	"github.com/meateam/api-gateway/dropbox"
 	"github.com/meateam/api-gateway/file"
 	loggermiddleware "github.com/meateam/api-gateway/logger"
+	"github.com/meateam/api-gateway/metrics"
 	"github.com/meateam/api-gateway/oauth"
 	"github.com/meateam/api-gateway/permission"
 	"github.com/meateam/api-gateway/quota"
+	"github.com/meateam/api-gateway/retry"
 	"github.com/meateam/api-gateway/search"
 	"github.com/meateam/api-gateway/server/auth"
 	"github.com/meateam/api-gateway/upload"
@@ -32,13 +36,37 @@ import (
 	"go.elastic.co/apm/module/apmgrpc"
 	"go.elastic.co/apm/module/apmhttp"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 const (
 	healthcheckRoute  = "/api/healthcheck"
+	healthRoute       = "/api/health"
+	livenessRoute     = "/api/health/live"
+	readinessRoute    = "/api/health/ready"
+	metricsRoute      = "/api/metrics"
 	uploadRouteRegexp = "/api/upload.+"
 )
 
+// trashPurgeInterval is the interval between purges of the files that passed the trash retention period.
+const trashPurgeInterval = time.Hour
+
+// versionPruneInterval is the interval between prunes of the versions that passed the version retention period.
+const versionPruneInterval = time.Hour
+
+// Names of the services the api-gateway depends on, as configured in configFatalServices.
+const (
+	serviceFile       = "file"
+	serviceDownload   = "download"
+	servicePermission = "permission"
+	serviceUpload     = "upload"
+	serviceSearch     = "search"
+	serviceDropbox    = "dropbox"
+	serviceUser       = "user"
+	serviceSpike      = "spike"
+	serviceGotenberg  = "gotenberg"
+)
+
 // ExternalNetworkDest configuration of external network
 type ExternalNetworkDest struct {
 	Label          string `json:"label"`
@@ -53,6 +81,21 @@ type ExternalNetworkDest struct {
 
 // NewRouter creates new gin.Engine for the api-gateway server and sets it up.
 func NewRouter(logger *logrus.Logger) (*gin.Engine, []*grpcPoolTypes.ConnPool) {
+	r, resources := newRouter(logger)
+
+	return r, resources.conns
+}
+
+// routerResources are the resources of the router that are released when the server shuts down.
+type routerResources struct {
+	conns          []*grpcPoolTypes.ConnPool
+	health         *Health
+	metricsShipper *MetricsShipper
+}
+
+// newRouter creates new gin.Engine for the api-gateway server and sets it up,
+// it returns the created engine and its resources.
+func newRouter(logger *logrus.Logger) (*gin.Engine, *routerResources) {
 	// If no logger is given, use a default logger.
 	if logger == nil {
 		logger = logrus.New()
@@ -63,16 +106,27 @@ func NewRouter(logger *logrus.Logger) (*gin.Engine, []*grpcPoolTypes.ConnPool) {
 
 	// Setup logging, metrics, cors middlewares.
 	r.Use(
-		// Ignore logging healthcheck routes.
-		gin.LoggerWithWriter(gin.DefaultWriter, healthcheckRoute),
+		// Ignore logging healthcheck and metrics routes.
+		gin.LoggerWithWriter(gin.DefaultWriter, healthRoutes()...),
 		gin.Recovery(),
+		metrics.Middleware(),
 		apmgin.Middleware(r),
 		cors.New(corsRouterConfig()),
+		// Set Retry-After on requests rejected by an open circuit breaker.
+		breaker.Middleware(),
+		// Render the errors of failed requests.
+		apierror.Middleware(),
+		// Set the deadline of the requests by their route group.
+		deadlineMiddleware(requestTimeouts{
+			metadata: time.Duration(viper.GetInt(configRequestTimeout)) * time.Second,
+			upload:   time.Duration(viper.GetInt(configUploadRequestTimeout)) * time.Second,
+			download: time.Duration(viper.GetInt(configDownloadRequestTimeout)) * time.Second,
+		}),
 		// Elasticsearch logger middleware.
 		loggermiddleware.SetLogger(
 			&loggermiddleware.Config{
 				Logger:             logger,
-				SkipPath:           []string{healthcheckRoute},
+				SkipPath:           healthRoutes(),
 				SkipBodyPathRegexp: regexp.MustCompile(uploadRouteRegexp),
 			},
 		),
@@ -112,43 +166,57 @@ func NewRouter(logger *logrus.Logger) (*gin.Engine, []*grpcPoolTypes.ConnPool) {
 		)
 	})
 
-	// Initiate services gRPC connections.
-	fileConn, err := initServiceConn(viper.GetString(configFileService))
+	// Initiate services gRPC connections, guarded by per-backend circuit breakers.
+	// Retries of idempotent calls are made inside the breakers, so they count as a single call.
+	var dialOpts []grpc.DialOption
+	breakers := newBreakers()
+	if breakers != nil {
+		dialOpts = append(dialOpts,
+			grpc.WithChainUnaryInterceptor(breakers.UnaryClientInterceptor()),
+			grpc.WithChainStreamInterceptor(breakers.StreamClientInterceptor()),
+		)
+	}
+
+	if policies := retryPolicies(); policies != nil {
+		dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(retry.UnaryClientInterceptor(policies)))
+	}
+
+	fileConn, err := dialService(serviceFile, viper.GetString(configFileService), dialOpts...)
 	if err != nil {
 		logger.Fatalf("couldn't setup file service connection: %v", err)
 	}
 
-	userConn, err := initServiceConn(viper.GetString(configUserService))
+	userConn, err := dialService(serviceUser, viper.GetString(configUserService), dialOpts...)
 	if err != nil {
 		logger.Fatalf("couldn't setup user service connection: %v", err)
 	}
 
-	uploadConn, err := initServiceConn(viper.GetString(configUploadService))
+	uploadConn, err := dialService(serviceUpload, viper.GetString(configUploadService), dialOpts...)
 	if err != nil {
 		logger.Fatalf("couldn't setup upload service connection: %v", err)
 	}
 
-	downloadConn, err := initServiceConn(viper.GetString(configDownloadService))
+	downloadConn, err := dialService(serviceDownload, viper.GetString(configDownloadService), dialOpts...)
 	if err != nil {
 		logger.Fatalf("couldn't setup download service connection: %v", err)
 	}
 
-	permissionConn, err := initServiceConn(viper.GetString(configPermissionService))
+	permissionConn, err := dialService(servicePermission, viper.GetString(configPermissionService), dialOpts...)
 	if err != nil {
 		logger.Fatalf("couldn't setup permission service connection: %v", err)
 	}
 
-	dropboxConn, err := initServiceConn(viper.GetString(configDropboxService))
+	dropboxConn, err := dialService(serviceDropbox, viper.GetString(configDropboxService), dialOpts...)
 	if err != nil {
 		logger.Fatalf("couldn't setup dropbox service connection: %v", err)
 	}
 
-	searchConn, err := initServiceConn(viper.GetString(configSearchService))
+	searchConn, err := dialService(serviceSearch, viper.GetString(configSearchService), dialOpts...)
 	if err != nil {
 		logger.Fatalf("couldn't setup search service connection: %v", err)
 	}
 
-	spikeConn, err := initServiceConn(viper.GetString(configSpikeService))
+	spikeConn, err := dialService(serviceSpike, viper.GetString(configSpikeService), dialOpts...)
 	if err != nil {
 		logger.Fatalf("couldn't setup spike service connection: %v", err)
 	}
@@ -158,33 +226,52 @@ func NewRouter(logger *logrus.Logger) (*gin.Engine, []*grpcPoolTypes.ConnPool) {
 	// initiate middlewares
 	om := oauth.NewOAuthMiddleware(spikeConn, userConn, logger)
 
-	nonFatalConns := []*grpcPoolTypes.ConnPool{
-		dropboxConn,
-		userConn,
-		spikeConn,
-	}
-
-	fatalConns := []*grpcPoolTypes.ConnPool{
+	conns := []*grpcPoolTypes.ConnPool{
 		fileConn,
 		downloadConn,
 		permissionConn,
 		uploadConn,
 		searchConn,
+		dropboxConn,
+		userConn,
+		spikeConn,
 	}
 
-	conns := append(fatalConns, nonFatalConns...)
-
 	health := NewHealthChecker()
 	healthInterval := viper.GetInt(configHealthCheckInterval)
 	healthRPCTimeout := viper.GetInt(configHealthCheckRPCTimeout)
 
 	badConns := make(chan *grpcPoolTypes.ConnPool, len(conns))
 
-	go health.Check(healthInterval, healthRPCTimeout, logger, gotenbergClient, badConns, nonFatalConns, fatalConns...)
-	go reviveConns(badConns)
+	fatalServices := fatalServices()
+	go health.Check(healthInterval, healthRPCTimeout, logger,
+		grpcDependency(serviceFile, fileConn, fatalServices[serviceFile], badConns, breakers),
+		grpcDependency(serviceDownload, downloadConn, fatalServices[serviceDownload], badConns, breakers),
+		grpcDependency(servicePermission, permissionConn, fatalServices[servicePermission], badConns, breakers),
+		grpcDependency(serviceUpload, uploadConn, fatalServices[serviceUpload], badConns, breakers),
+		grpcDependency(serviceSearch, searchConn, fatalServices[serviceSearch], badConns, breakers),
+		grpcDependency(serviceDropbox, dropboxConn, fatalServices[serviceDropbox], badConns, breakers),
+		grpcDependency(serviceUser, userConn, fatalServices[serviceUser], badConns, breakers),
+		grpcDependency(serviceSpike, spikeConn, fatalServices[serviceSpike], badConns, breakers),
+		gotenbergDependency(serviceGotenberg, gotenbergClient, fatalServices[serviceGotenberg]),
+	)
+	reconnector := &reconnector{
+		logger:       logger,
+		minBackoff:   time.Duration(viper.GetInt(configReconnectMinBackoff)) * time.Second,
+		maxBackoff:   time.Duration(viper.GetInt(configReconnectMaxBackoff)) * time.Second,
+		probeTimeout: time.Duration(healthRPCTimeout) * time.Second,
+		drainTimeout: time.Duration(viper.GetInt(configReconnectDrainTimeout)) * time.Second,
+	}
+	go reconnector.run(badConns)
 
-	// Health Check route.
+	// Health Check routes.
 	apiRoutesGroup.GET("/healthcheck", health.healthCheck)
+	apiRoutesGroup.GET("/health", health.healthReport)
+	apiRoutesGroup.GET("/health/live", health.liveness)
+	apiRoutesGroup.GET("/health/ready", health.healthCheck)
+
+	// Prometheus metrics route.
+	apiRoutesGroup.GET("/metrics", metrics.Handler())
 
 	// handler for swagger documentation
 	apiRoutesGroup.StaticFile("/swagger", viper.GetString(configSwaggerPathFile)+"/swagger.json")
@@ -211,16 +298,93 @@ func NewRouter(logger *logrus.Logger) (*gin.Engine, []*grpcPoolTypes.ConnPool) {
 		apiRoutesGroup.GET("/docs", gin.WrapH(sh))
 	}
 
+	trash, err := NewElasticsearchTrashStore()
+	if err != nil {
+		logger.Errorf("failed creating trash store, deleted files would be deleted permanently: %v", err)
+	}
+
+	var versions *file.Versions
+	versionStore, err := NewElasticsearchVersionStore()
+	if err != nil {
+		logger.Errorf("failed creating version store, replaced contents of updated files would be deleted: %v", err)
+	} else {
+		versions = file.NewVersions(
+			versionStore,
+			uploadConn,
+			fileConn,
+			viper.GetInt(configVersionMaxCount),
+			time.Duration(viper.GetInt(configVersionRetentionDays))*24*time.Hour,
+			logger,
+		)
+
+		go versions.PruneExpiredPeriodically(versionPruneInterval)
+	}
+
+	checksums, err := NewElasticsearchChecksumStore()
+	if err != nil {
+		logger.Errorf("failed creating checksum store, files would have no digests: %v", err)
+	}
+
+	permissionCache := file.NewPermissionCache(time.Duration(viper.GetInt(configPermissionCacheTTL)) * time.Second)
+
+	previewCache, err := newTieredCache(
+		viper.GetInt64(configPreviewCacheSize),
+		viper.GetInt64(configPreviewCacheDiskSize),
+		viper.GetString(configPreviewCacheDir),
+	)
+	if err != nil {
+		logger.Errorf("failed creating preview disk cache, previews would only be cached in memory: %v", err)
+	}
+
+	thumbnailCache, err := newCache(
+		viper.GetString(configThumbnailCache),
+		viper.GetInt64(configThumbnailCacheSize),
+		viper.GetString(configThumbnailCacheDir),
+	)
+	if err != nil {
+		logger.Errorf("failed creating thumbnail cache, thumbnails would not be cached: %v", err)
+	}
+
 	// Initiate routers.
 	fr := file.NewRouter(fileConn, downloadConn, uploadConn, permissionConn, dropboxConn,
-		searchConn, gotenbergClient, om, logger)
-	ur := upload.NewRouter(uploadConn, fileConn, permissionConn, searchConn, om, logger)
+		searchConn, gotenbergClient, om, trash, versions, checksums, permissionCache, previewCache, thumbnailCache,
+		logger)
+
+	if retentionDays := viper.GetInt(configTrashRetentionDays); trash != nil && retentionDays > 0 {
+		go fr.PurgeTrashPeriodically(trashPurgeInterval, time.Duration(retentionDays)*24*time.Hour)
+	}
+	var contentCaches []cache.Cache
+	for _, contentCache := range []cache.Cache{previewCache, thumbnailCache} {
+		if contentCache != nil {
+			contentCaches = append(contentCaches, contentCache)
+		}
+	}
+
+	tusStore, err := NewElasticsearchTusStore()
+	if err != nil {
+		logger.Errorf("failed creating tus store, tus uploads would be disabled: %v", err)
+	}
+
+	partStore, err := NewElasticsearchPartStore()
+	if err != nil {
+		logger.Errorf("failed creating part store, resumable uploads would only resume on the same instance: %v", err)
+	}
+
+	limiter := upload.NewUploadLimiter(
+		nil,
+		viper.GetInt(configMaxUploadedFiles),
+		viper.GetInt(configMaxUploadedFolders),
+		time.Duration(viper.GetInt(configUploadLimitWindow))*time.Second,
+	)
+
+	ur := upload.NewRouter(uploadConn, fileConn, downloadConn, permissionConn, searchConn, om, contentCaches,
+		versions, checksums, tusStore, partStore, limiter, trash, logger)
 	usr := user.NewRouter(userConn, logger)
 	ar := auth.NewRouter(logger)
 	qr := quota.NewRouter(fileConn, logger)
-	pr := permission.NewRouter(permissionConn, fileConn, userConn, om, logger)
+	pr := permission.NewRouter(permissionConn, fileConn, userConn, om, permissionCache, trash, logger)
 	drp := dropbox.NewRouter(dropboxConn, permissionConn, fileConn, om, logger)
-	sr := search.NewRouter(searchConn, fileConn, permissionConn, logger)
+	sr := search.NewRouter(searchConn, fileConn, permissionConn, permissionCache, trash, logger)
 
 	middlewares := make([]gin.HandlerFunc, 0, 2)
 
@@ -232,9 +396,16 @@ func NewRouter(logger *logrus.Logger) (*gin.Engine, []*grpcPoolTypes.ConnPool) {
 	authRequiredMiddleware := ar.Middleware(secrets, viper.GetString(configAuthURL))
 	middlewares = append(middlewares, authRequiredMiddleware)
 
-	if metricsLogger := NewMetricsLogger(); metricsLogger != nil {
-		middlewares = append(middlewares, metricsLogger)
+	metricsShipper, err := NewElasticsearchMetricsShipper(logger)
+	if err != nil {
+		logger.Errorf("failed creating metrics shipper: %v", err)
+	} else {
+		middlewares = append(middlewares, NewMetricsLogger(metricsShipper))
+		if err := metrics.Register(metricsShipper.Collector()); err != nil {
+			logger.Errorf("failed registering dropped metrics documents counter: %v", err)
+		}
 	}
+
 	// Authentication middleware on routes group.
 	authRequiredRoutesGroup := apiRoutesGroup.Group("/", middlewares...)
 
@@ -259,20 +430,65 @@ func NewRouter(logger *logrus.Logger) (*gin.Engine, []*grpcPoolTypes.ConnPool) {
 	// Initiate client connection to search service.
 	sr.Setup(authRequiredRoutesGroup)
 
-	// Create a slice to manage connections and return it.
-	return r, conns
+	return r, &routerResources{conns: conns, health: health, metricsShipper: metricsShipper}
+}
+
+// healthRoutes returns the routes that are polled periodically and shouldn't be logged.
+func healthRoutes() []string {
+	return []string{healthcheckRoute, healthRoute, livenessRoute, readinessRoute, metricsRoute}
+}
+
+// newBreakers creates the circuit breakers of the backends from the config,
+// returns nil if the circuit breakers are disabled.
+func newBreakers() *breaker.Set {
+	threshold := viper.GetInt(configBreakerFailureThreshold)
+	if threshold <= 0 {
+		return nil
+	}
+
+	return breaker.NewSet(breaker.Config{
+		FailureThreshold: threshold,
+		OpenTimeout:      time.Duration(viper.GetInt(configBreakerOpenTimeout)) * time.Second,
+		HalfOpenProbes:   viper.GetInt(configBreakerHalfOpenProbes),
+	})
+}
+
+// fatalServices returns the set of the services configured in configFatalServices,
+// a failure of a fatal service fails the healthcheck of the api-gateway.
+func fatalServices() map[string]bool {
+	services := make(map[string]bool)
+	for _, service := range strings.Split(viper.GetString(configFatalServices), ",") {
+		if service = strings.TrimSpace(service); service != "" {
+			services[service] = true
+		}
+	}
+
+	return services
 }
 
 // corsRouterConfig configures cors policy for cors.New gin middleware.
 func corsRouterConfig() cors.Config {
 	corsConfig := cors.DefaultConfig()
-	corsConfig.AddExposeHeaders("x-uploadid")
+	corsConfig.AddExposeHeaders(
+		"x-uploadid",
+		"x-file-id",
+		"location",
+		"tus-resumable",
+		"tus-version",
+		"tus-extension",
+		"tus-checksum-algorithm",
+		"upload-offset",
+		"upload-length",
+		"upload-metadata",
+		"digest",
+	)
 	corsConfig.AllowAllOrigins = false
 	corsConfig.AllowWildcard = true
 	corsConfig.AllowOrigins = strings.Split(viper.GetString(configAllowOrigins), ",")
 	corsConfig.AllowCredentials = true
 	corsConfig.AddAllowHeaders(
 		"x-content-length",
+		"x-batch-id",
 		"authorization",
 		"cache-control",
 		"x-requested-with",
@@ -280,51 +496,93 @@ func corsRouterConfig() cors.Config {
 		"content-range",
 		"destination",
 		"fileID",
+		"tus-resumable",
+		"upload-offset",
+		"upload-length",
+		"upload-defer-length",
+		"upload-metadata",
+		"upload-checksum",
+		"content-md5",
+		"digest",
 		apmhttp.TraceparentHeader,
 	)
 
 	return corsConfig
 }
 
-// initServiceConn creates a gRPC connection pool to url, returns the created connection pool
-// and nil err on success. Returns non-nil error if any error occurred while
-// creating the connection pool.
-func initServiceConn(url string) (*grpcPoolTypes.ConnPool, error) {
+// dialService creates a gRPC connection pool to the service named name at url,
+// with the client TLS credentials configured for the service.
+func dialService(name string, url string, opts ...grpc.DialOption) (*grpcPoolTypes.ConnPool, error) {
+	creds, err := clientTLSCredentials(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return initServiceConn(name, url, creds, opts...)
+}
+
+// initServiceConn creates a gRPC connection pool to the service named name at url, returns
+// the created connection pool and nil err on success. Returns non-nil error if any error
+// occurred while creating the connection pool. The returned pool can be revived by reconnector.
+// The connections are secured with creds, or insecure if creds is nil,
+// and opts are appended to the default dial options of the connections.
+func initServiceConn(
+	name string,
+	url string,
+	creds credentials.TransportCredentials,
+	opts ...grpc.DialOption) (*grpcPoolTypes.ConnPool, error) {
+	dial := func(calls *callTracker) (grpcPoolTypes.ConnPool, error) {
+		dialOpts := append(append([]grpc.DialOption{}, opts...), calls.dialOptions()...)
+
+		return dialServicePool(name, url, creds, dialOpts...)
+	}
+
+	swappable, err := newSwappablePool(url, dial)
+	if err != nil {
+		return nil, err
+	}
+
+	var pool grpcPoolTypes.ConnPool = swappable
+
+	return &pool, nil
+}
+
+// dialServicePool dials a gRPC connection pool to the service named name at url, secured with creds
+// or insecure if creds is nil. opts are appended to the default dial options of the connections.
+// The calls of the connections are observed by the metrics labeled with name.
+func dialServicePool(
+	name string,
+	url string,
+	creds credentials.TransportCredentials,
+	opts ...grpc.DialOption) (grpcPoolTypes.ConnPool, error) {
+	transportOpt := grpc.WithInsecure()
+	if creds != nil {
+		transportOpt = grpc.WithTransportCredentials(creds)
+	}
+
 	ctx := context.Background()
-	connPool, err := grpcPool.DialPool(ctx,
-		grpcPoolOptions.WithGRPCDialOption(grpc.WithUnaryInterceptor(apmgrpc.NewUnaryClientInterceptor())),
-		grpcPoolOptions.WithGRPCDialOption(grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(10<<20))),
-		grpcPoolOptions.WithGRPCDialOption(grpc.WithInsecure()),
+	poolOpts := []grpcPoolOptions.ClientOption{
+		grpcPoolOptions.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(
+			apmgrpc.NewUnaryClientInterceptor(),
+			metrics.UnaryClientInterceptor(name),
+		)),
+		grpcPoolOptions.WithGRPCDialOption(grpc.WithChainStreamInterceptor(metrics.StreamClientInterceptor(name))),
+		grpcPoolOptions.WithGRPCDialOption(grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(10 << 20))),
+		grpcPoolOptions.WithGRPCDialOption(transportOpt),
 		grpcPoolOptions.WithEndpoint(url),
 		grpcPoolOptions.WithGRPCConnectionPool(viper.GetInt(configPoolSize)),
-	)
+	}
+
+	for _, opt := range opts {
+		poolOpts = append(poolOpts, grpcPoolOptions.WithGRPCDialOption(opt))
+	}
+
+	connPool, err := grpcPool.DialPool(ctx, poolOpts...)
 	if err != nil {
 		return nil, err
 	}
-	return &connPool, nil
-}
 
-// reviveConns is the function for reviving the connections in the badConns connection pool
-func reviveConns(badConns <-chan *grpcPoolTypes.ConnPool) {
-	for {
-		// Pull the pointer to the pool from the channel.
-		// Will run when the channel isn't empty
-		pool := <-badConns
-		go func(pool *grpcPoolTypes.ConnPool) {
-			// Get the target url
-			target := (*pool).Conn().Target()
-			var newPool *grpcPoolTypes.ConnPool
-			err := fmt.Errorf("temp")
-			for err != nil {
-				time.Sleep(time.Second * time.Duration(2))
-				err = nil
-				newPool, err = initServiceConn(target)
-			}
-			(*pool).Close()
-			// Replace the pointer to the new pool
-			*pool = *newPool
-		}(pool)
-	}
+	return connPool, nil
 }
 
 // GetExternalNetworksConfiguration get network object configuration
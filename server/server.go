@@ -1,7 +1,13 @@
 package server
 
 import (
+	"context"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/meateam/api-gateway/server/auth"
 	"github.com/meateam/api-gateway/user"
@@ -71,6 +77,48 @@ const (
 	configCTSSuffix                = "cts_suffix"
 	configMaxUploadedFiles         = "max_uploaded_files"
 	configMaxUploadedFolders       = "max_uploaded_folders"
+	configShutdownGracePeriod      = "shutdown_grace_period"
+	configShutdownDrainDelay       = "shutdown_drain_delay"
+	configMetricsQueueSize         = "metrics_queue_size"
+	configMetricsFlushSize         = "metrics_flush_size"
+	configMetricsFlushInterval     = "metrics_flush_interval"
+	configFatalServices            = "fatal_services"
+	configReconnectMinBackoff      = "reconnect_min_backoff"
+	configReconnectMaxBackoff      = "reconnect_max_backoff"
+	configReconnectDrainTimeout    = "reconnect_drain_timeout"
+	configBreakerFailureThreshold  = "breaker_failure_threshold"
+	configBreakerOpenTimeout       = "breaker_open_timeout"
+	configBreakerHalfOpenProbes    = "breaker_half_open_probes"
+	configRequestTimeout           = "request_timeout"
+	configUploadRequestTimeout     = "upload_request_timeout"
+	configDownloadRequestTimeout   = "download_request_timeout"
+	configRetryMaxAttempts         = "retry_max_attempts"
+	configRetryInitialBackoff      = "retry_initial_backoff"
+	configRetryMaxBackoff          = "retry_max_backoff"
+	configTLSCertFile              = "tls_cert_file"
+	configTLSKeyFile               = "tls_key_file"
+	configTLSClientCAFile          = "tls_client_ca_file"
+	configZipMaxSize               = "zip_max_size"
+	configZipMaxEntries            = "zip_max_entries"
+	configTrashIndex               = "trash_index"
+	configTrashRetentionDays       = "trash_retention_days"
+	configPermissionConcurrency    = "permission_resolver_concurrency"
+	configPermissionCacheTTL       = "permission_cache_ttl"
+	configPreviewCacheSize         = "preview_cache_size"
+	configPreviewCacheDiskSize     = "preview_cache_disk_size"
+	configPreviewCacheDir          = "preview_cache_dir"
+	configThumbnailCache           = "thumbnail_cache"
+	configThumbnailCacheSize       = "thumbnail_cache_size"
+	configThumbnailCacheDir        = "thumbnail_cache_dir"
+	configThumbnailMaxSourceSize   = "thumbnail_max_source_size"
+	configThumbnailPdfRenderer     = "thumbnail_pdf_renderer"
+	configVersionIndex             = "version_index"
+	configVersionMaxCount          = "version_max_count"
+	configVersionRetentionDays     = "version_retention_days"
+	configTusIndex                 = "tus_index"
+	configUploadPartIndex          = "upload_part_index"
+	configChecksumIndex            = "checksum_index"
+	configUploadLimitWindow        = "upload_limit_window"
 )
 
 var (
@@ -138,41 +186,189 @@ func init() {
 	viper.SetDefault(configCTSSuffix, "@gmail.com")
 	viper.SetDefault(configMaxUploadedFiles, 100)
 	viper.SetDefault(configMaxUploadedFolders, 100)
+	viper.SetDefault(configShutdownGracePeriod, 30)
+	viper.SetDefault(configShutdownDrainDelay, 5)
+	viper.SetDefault(configMetricsQueueSize, 10000)
+	viper.SetDefault(configMetricsFlushSize, 500)
+	viper.SetDefault(configMetricsFlushInterval, 5)
+	viper.SetDefault(configFatalServices, "file,download,permission,upload,search")
+	viper.SetDefault(configReconnectMinBackoff, 1)
+	viper.SetDefault(configReconnectMaxBackoff, 60)
+	viper.SetDefault(configReconnectDrainTimeout, 600)
+	viper.SetDefault(configBreakerFailureThreshold, 5)
+	viper.SetDefault(configBreakerOpenTimeout, 30)
+	viper.SetDefault(configBreakerHalfOpenProbes, 1)
+	viper.SetDefault(configRequestTimeout, 30)
+	viper.SetDefault(configUploadRequestTimeout, 0)
+	viper.SetDefault(configDownloadRequestTimeout, 0)
+	viper.SetDefault(configRetryMaxAttempts, 3)
+	viper.SetDefault(configRetryInitialBackoff, 100)
+	viper.SetDefault(configRetryMaxBackoff, 1000)
+	viper.SetDefault(configTLSCertFile, "")
+	viper.SetDefault(configTLSKeyFile, "")
+	viper.SetDefault(configTLSClientCAFile, "")
+	viper.SetDefault(configZipMaxSize, 10<<30)
+	viper.SetDefault(configZipMaxEntries, 10000)
+	viper.SetDefault(configTrashIndex, "trash")
+	viper.SetDefault(configTrashRetentionDays, 30)
+	viper.SetDefault(configPermissionConcurrency, 16)
+	viper.SetDefault(configPermissionCacheTTL, 0)
+	viper.SetDefault(configPreviewCacheSize, 256<<20)
+	viper.SetDefault(configPreviewCacheDiskSize, 2<<30)
+	viper.SetDefault(configPreviewCacheDir, "/tmp/api-gateway/previews")
+	viper.SetDefault(configThumbnailCache, cacheMemory)
+	viper.SetDefault(configThumbnailCacheSize, 64<<20)
+	viper.SetDefault(configThumbnailCacheDir, "/tmp/api-gateway/thumbnails")
+	viper.SetDefault(configThumbnailMaxSourceSize, 50<<20)
+	viper.SetDefault(configThumbnailPdfRenderer, "pdftoppm")
+	viper.SetDefault(configVersionIndex, "versions")
+	viper.SetDefault(configVersionMaxCount, 10)
+	viper.SetDefault(configVersionRetentionDays, 90)
+	viper.SetDefault(configTusIndex, "tus_uploads")
+	viper.SetDefault(configUploadPartIndex, "upload_parts")
+	viper.SetDefault(configChecksumIndex, "checksums")
+	viper.SetDefault(configUploadLimitWindow, 60)
 	viper.SetEnvPrefix(envPrefix)
 	viper.AutomaticEnv()
 }
 
 // Server is a structure that holds the http server of the api-gateway.
 type Server struct {
-	server *http.Server
-	conns  []*grpcPoolTypes.ConnPool
+	server         *http.Server
+	conns          []*grpcPoolTypes.ConnPool
+	health         *Health
+	metricsShipper *MetricsShipper
+
+	// gracePeriod is the maximum time to wait for active requests to finish on shutdown.
+	gracePeriod time.Duration
+
+	// drainDelay is the time to wait after reporting unhealthy and before
+	// closing the listener, to let the load balancer stop routing requests to the server.
+	drainDelay time.Duration
 }
 
 // NewServer creates a Server of the api-gateway.
 func NewServer() *Server {
-	router, conns := NewRouter(logger)
+	router, resources := newRouter(logger)
+
+	tlsConfig, err := serverTLSConfig()
+	if err != nil {
+		logger.Fatalf("couldn't setup server TLS: %v", err)
+	}
 
 	s := &http.Server{
 		Addr:           ":" + viper.GetString(configPort),
 		Handler:        router,
 		MaxHeaderBytes: 1 << 20,
+		TLSConfig:      tlsConfig,
 	}
 
-	return &Server{server: s, conns: conns}
+	return &Server{
+		server:         s,
+		conns:          resources.conns,
+		health:         resources.health,
+		metricsShipper: resources.metricsShipper,
+		gracePeriod:    time.Duration(viper.GetInt(configShutdownGracePeriod)) * time.Second,
+		drainDelay:     time.Duration(viper.GetInt(configShutdownDrainDelay)) * time.Second,
+	}
 }
 
-// Listen listens on configPort. Listen returns when listener is closed.
-// Listener will be closed when this method returns, if listener is closed with non-nil
-// error then it will be logged as fatal.
+// Listen listens on configPort. Listen returns when the server has shut down.
+// On SIGTERM or SIGINT the server is gracefully shut down, see Server.Shutdown.
+// If the listener is closed with a non-nil error then it will be logged as fatal.
 func (s *Server) Listen() {
-	defer func() {
-		for _, v := range s.conns {
-			(*v).Close()
-		}
-	}()
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(stop)
 
 	logger.Infof("server listening on port: %s", viper.GetString(configPort))
-	if err := s.server.ListenAndServe(); err != nil {
+	if err := s.serve(listener, stop); err != nil {
 		logger.Fatalf("%v", err)
 	}
 }
+
+// serve serves s on listener until a signal is received from stop, and then shuts s down.
+// If s has a TLS config then it's served over TLS, with HTTP/2 support.
+// Returns a non-nil error if serving failed or if the shutdown wasn't graceful.
+func (s *Server) serve(listener net.Listener, stop <-chan os.Signal) error {
+	errc := make(chan error, 1)
+	go func() {
+		if s.server.TLSConfig != nil {
+			errc <- s.server.ServeTLS(listener, "", "")
+			return
+		}
+
+		errc <- s.server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errc:
+		s.closeMetricsShipper()
+		s.closeConns()
+		return err
+	case sig := <-stop:
+		logger.Infof("received signal %v, shutting down", sig)
+	}
+
+	err := s.Shutdown()
+	if serveErr := <-errc; serveErr != http.ErrServerClosed && err == nil {
+		err = serveErr
+	}
+
+	return err
+}
+
+// Shutdown gracefully shuts down s. It first reports the server as unhealthy and waits
+// s.drainDelay so the load balancer stops routing to the server, then it stops accepting new
+// connections and waits up to s.gracePeriod for active requests, such as uploads and downloads,
+// to finish. Requests that are still active after the grace period are closed.
+// The queued request metrics are flushed and the gRPC connection pools are closed once no requests are left.
+func (s *Server) Shutdown() error {
+	defer s.closeConns()
+
+	if s.health != nil {
+		s.health.Drain()
+	}
+
+	time.Sleep(s.drainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.gracePeriod)
+	defer cancel()
+
+	err := s.server.Shutdown(ctx)
+	if err == context.DeadlineExceeded {
+		logger.Errorf("grace period of %v exceeded, closing active connections", s.gracePeriod)
+		if closeErr := s.server.Close(); closeErr != nil {
+			logger.Errorf("failed closing active connections: %v", closeErr)
+		}
+	}
+
+	s.closeMetricsShipper()
+
+	return err
+}
+
+// closeMetricsShipper flushes the queued metrics documents of s and closes its metrics shipper.
+func (s *Server) closeMetricsShipper() {
+	if s.metricsShipper == nil {
+		return
+	}
+
+	if err := s.metricsShipper.Close(); err != nil {
+		logger.Errorf("failed closing metrics shipper: %v", err)
+	}
+}
+
+// closeConns closes all of the gRPC connection pools of s.
+func (s *Server) closeConns() {
+	for _, v := range s.conns {
+		if err := (*v).Close(); err != nil {
+			logger.Errorf("failed closing connection pool: %v", err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	streamChunks     = 5
+	streamChunkDelay = 100 * time.Millisecond
+)
+
+// newTestServer creates a Server with a streaming download route and a healthcheck route.
+// started is closed once the download started streaming.
+func newTestServer(started chan<- struct{}) (*Server, *Health) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	health := NewHealthChecker()
+	health.SetHealthy()
+
+	r.GET("/api/healthcheck", health.healthCheck)
+	r.GET("/download", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		for i := 0; i < streamChunks; i++ {
+			if _, err := c.Writer.Write([]byte("chunk")); err != nil {
+				return
+			}
+
+			c.Writer.Flush()
+			if i == 0 {
+				close(started)
+			}
+
+			time.Sleep(streamChunkDelay)
+		}
+	})
+
+	s := &Server{
+		server:      &http.Server{Handler: r},
+		health:      health,
+		gracePeriod: 5 * time.Second,
+		drainDelay:  0,
+	}
+
+	return s, health
+}
+
+func TestServer_GracefulShutdown(t *testing.T) {
+	started := make(chan struct{})
+	s, health := newTestServer(started)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listening: %v", err)
+	}
+	addr := "http://" + listener.Addr().String()
+
+	stop := make(chan os.Signal, 1)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.serve(listener, stop)
+	}()
+
+	type downloadResult struct {
+		status int
+		body   string
+		err    error
+	}
+
+	downloaded := make(chan downloadResult, 1)
+	go func() {
+		resp, err := http.Get(addr + "/download")
+		if err != nil {
+			downloaded <- downloadResult{err: err}
+			return
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		downloaded <- downloadResult{status: resp.StatusCode, body: string(body), err: err}
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("download didn't start")
+	}
+
+	stop <- syscall.SIGTERM
+
+	// Wait for the listener to be closed.
+	time.Sleep(streamChunkDelay)
+
+	if health.Get() {
+		t.Error("expected server to be unhealthy while shutting down")
+	}
+
+	newRequestClient := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	if resp, err := newRequestClient.Get(addr + "/api/healthcheck"); err == nil {
+		resp.Body.Close()
+		t.Errorf("expected new request to be refused, got status %d", resp.StatusCode)
+	}
+
+	result := <-downloaded
+	if result.err != nil {
+		t.Fatalf("active download failed: %v", result.err)
+	}
+
+	if result.status != http.StatusOK {
+		t.Errorf("expected active download status %d, got %d", http.StatusOK, result.status)
+	}
+
+	if want := streamChunks * len("chunk"); len(result.body) != want {
+		t.Errorf("expected active download to receive %d bytes, got %d", want, len(result.body))
+	}
+
+	if err := <-serveErr; err != nil {
+		t.Errorf("expected graceful shutdown, got error: %v", err)
+	}
+}
+
+func TestServer_ShutdownGracePeriodExceeded(t *testing.T) {
+	started := make(chan struct{})
+	s, _ := newTestServer(started)
+	s.gracePeriod = streamChunkDelay
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listening: %v", err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.serve(listener, stop)
+	}()
+
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/download")
+		if err == nil {
+			_, _ = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+	}()
+
+	<-started
+	stop <- syscall.SIGTERM
+
+	select {
+	case err := <-serveErr:
+		if err == nil {
+			t.Error("expected an error when the grace period is exceeded")
+		}
+	case <-time.After(streamChunks * streamChunkDelay * 2):
+		t.Fatal("shutdown didn't respect the grace period")
+	}
+}
+
+func TestHealth_Drain(t *testing.T) {
+	health := NewHealthChecker()
+	health.SetHealthy()
+	health.Drain()
+	health.SetHealthy()
+
+	if health.Get() {
+		t.Error("expected a draining health to stay unhealthy")
+	}
+
+	if !health.IsDraining() {
+		t.Error("expected health to be draining")
+	}
+}
@@ -0,0 +1,319 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// Suffixes of the config keys of the client TLS of each service, e.g. "file_service_tls_ca_file".
+	configServiceTLSCAFile     = "_service_tls_ca_file"
+	configServiceTLSCertFile   = "_service_tls_cert_file"
+	configServiceTLSKeyFile    = "_service_tls_key_file"
+	configServiceTLSServerName = "_service_tls_server_name"
+)
+
+// filesModified returns the modification times of files, and whether they differ from modTimes.
+func filesModified(files []string, modTimes []time.Time) ([]time.Time, bool, error) {
+	newModTimes := make([]time.Time, len(files))
+	modified := len(modTimes) != len(files)
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, false, err
+		}
+
+		newModTimes[i] = info.ModTime()
+		if !modified && !newModTimes[i].Equal(modTimes[i]) {
+			modified = true
+		}
+	}
+
+	return newModTimes, modified, nil
+}
+
+// certReloader loads a certificate and its key from disk,
+// and reloads them when one of the files is modified.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.Mutex
+	modTimes []time.Time
+	cert     *tls.Certificate
+}
+
+// newCertReloader creates a certReloader of the certificate in certFile and its key in keyFile,
+// returns a non-nil error if the certificate couldn't be loaded.
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.certificate(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// certificate returns the certificate of r, reloading it if its files were modified.
+// If reloading fails then the previously loaded certificate is returned.
+func (r *certReloader) certificate() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTimes, modified, err := filesModified([]string{r.certFile, r.keyFile}, r.modTimes)
+	if err != nil || !modified {
+		return r.loaded(err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return r.loaded(err)
+	}
+
+	r.cert = &cert
+	r.modTimes = modTimes
+
+	return r.cert, nil
+}
+
+// loaded returns the loaded certificate of r, or err if no certificate was loaded.
+func (r *certReloader) loaded(err error) (*tls.Certificate, error) {
+	if r.cert == nil {
+		if err == nil {
+			err = fmt.Errorf("no certificate loaded from %s", r.certFile)
+		}
+
+		return nil, err
+	}
+
+	return r.cert, nil
+}
+
+// caReloader loads a bundle of CA certificates from disk, and reloads it when the file is modified.
+type caReloader struct {
+	caFile string
+
+	mu       sync.Mutex
+	modTimes []time.Time
+	pool     *x509.CertPool
+}
+
+// newCAReloader creates a caReloader of the CA bundle in caFile,
+// returns a non-nil error if the bundle couldn't be loaded.
+func newCAReloader(caFile string) (*caReloader, error) {
+	r := &caReloader{caFile: caFile}
+	if _, err := r.certPool(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// certPool returns the CA certificates of r, reloading them if the file was modified.
+// If reloading fails then the previously loaded certificates are returned.
+func (r *caReloader) certPool() (*x509.CertPool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTimes, modified, err := filesModified([]string{r.caFile}, r.modTimes)
+	if err == nil && modified {
+		var pem []byte
+		if pem, err = ioutil.ReadFile(r.caFile); err == nil {
+			pool := x509.NewCertPool()
+			if pool.AppendCertsFromPEM(pem) {
+				r.pool = pool
+				r.modTimes = modTimes
+			} else {
+				err = fmt.Errorf("no certificates found in %s", r.caFile)
+			}
+		}
+	}
+
+	if r.pool == nil {
+		return nil, err
+	}
+
+	return r.pool, nil
+}
+
+// serverTLSConfig returns the TLS config of the server from the config,
+// returns nil if the server's certificate isn't configured.
+func serverTLSConfig() (*tls.Config, error) {
+	certFile := viper.GetString(configTLSCertFile)
+	keyFile := viper.GetString(configTLSKeyFile)
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	return newServerTLSConfig(certFile, keyFile, viper.GetString(configTLSClientCAFile))
+}
+
+// newServerTLSConfig creates a TLS config that serves the certificate in certFile and its key in keyFile,
+// which are reloaded when modified. If clientCAFile isn't empty then clients are required to present
+// a certificate signed by one of the CAs in clientCAFile, which is reloaded when modified as well.
+func newServerTLSConfig(certFile string, keyFile string, clientCAFile string) (*tls.Config, error) {
+	cert, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading server certificate: %v", err)
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return cert.certificate()
+		},
+	}
+
+	if clientCAFile == "" {
+		return config, nil
+	}
+
+	clientCAs, err := newCAReloader(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading client CAs: %v", err)
+	}
+
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		pool, err := clientCAs.certPool()
+		if err != nil {
+			return nil, err
+		}
+
+		clientConfig := config.Clone()
+		clientConfig.GetConfigForClient = nil
+		clientConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		clientConfig.ClientCAs = pool
+
+		return clientConfig, nil
+	}
+
+	return config, nil
+}
+
+// clientTLSCredentials returns the TLS credentials of the connections to the service named name
+// from the config, returns nil if TLS isn't configured for the service.
+func clientTLSCredentials(name string) (credentials.TransportCredentials, error) {
+	caFile := viper.GetString(name + configServiceTLSCAFile)
+	certFile := viper.GetString(name + configServiceTLSCertFile)
+	keyFile := viper.GetString(name + configServiceTLSKeyFile)
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	creds, err := newClientTLSCredentials(caFile, certFile, keyFile, viper.GetString(name+configServiceTLSServerName))
+	if err != nil {
+		return nil, fmt.Errorf("failed loading %s service TLS: %v", name, err)
+	}
+
+	return creds, nil
+}
+
+// newClientTLSCredentials creates TLS credentials of client connections that verify the server
+// with the CAs in caFile, or with the system's CAs if caFile is empty. If certFile and keyFile
+// aren't empty then the client presents their certificate for mTLS. The files are reloaded when modified.
+func newClientTLSCredentials(
+	caFile string,
+	certFile string,
+	keyFile string,
+	serverName string) (credentials.TransportCredentials, error) {
+	creds := &reloadingClientCredentials{serverName: serverName}
+
+	if caFile != "" {
+		ca, err := newCAReloader(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		creds.ca = ca
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := newCertReloader(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		creds.cert = cert
+	}
+
+	return creds, nil
+}
+
+// reloadingClientCredentials are credentials.TransportCredentials of TLS client connections,
+// that build the TLS config on each handshake from the reloaded CAs and client certificate.
+type reloadingClientCredentials struct {
+	ca         *caReloader
+	cert       *certReloader
+	serverName string
+}
+
+// config returns the current TLS config of c.
+func (c *reloadingClientCredentials) config() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: c.serverName}
+
+	if c.ca != nil {
+		pool, err := c.ca.certPool()
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = pool
+	}
+
+	if c.cert != nil {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return c.cert.certificate()
+		}
+	}
+
+	return config, nil
+}
+
+// ClientHandshake does the TLS handshake with the server of rawConn.
+func (c *reloadingClientCredentials) ClientHandshake(
+	ctx context.Context,
+	authority string,
+	rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	config, err := c.config()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return credentials.NewTLS(config).ClientHandshake(ctx, authority, rawConn)
+}
+
+// ServerHandshake isn't supported, the credentials are only used by clients.
+func (c *reloadingClientCredentials) ServerHandshake(net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("server handshake is not supported by client credentials")
+}
+
+// Info returns the protocol info of c.
+func (c *reloadingClientCredentials) Info() credentials.ProtocolInfo {
+	return credentials.NewTLS(&tls.Config{ServerName: c.serverName}).Info()
+}
+
+// Clone returns a copy of c.
+func (c *reloadingClientCredentials) Clone() credentials.TransportCredentials {
+	clone := *c
+
+	return &clone
+}
+
+// OverrideServerName overrides the server name that's verified in the handshake.
+func (c *reloadingClientCredentials) OverrideServerName(serverName string) error {
+	c.serverName = serverName
+
+	return nil
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// testCA is a self-signed CA that issues certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+var testSerial int64
+
+// newTestCA creates a self-signed testCA.
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed generating key: %v", err)
+	}
+
+	testSerial++
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(testSerial),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed creating CA certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed parsing CA certificate: %v", err)
+	}
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue issues a certificate of localhost signed by ca, and returns its serial number
+// and its certificate and key PEMs.
+func (ca *testCA) issue(t *testing.T) (int64, []byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed generating key: %v", err)
+	}
+
+	testSerial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(testSerial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed creating certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed marshaling key: %v", err)
+	}
+
+	return testSerial,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeTestFile writes data to the file named name in dir, and sets its modification time
+// to modTime so reloaders notice the change regardless of the file system's time resolution.
+func writeTestFile(t *testing.T, dir string, name string, data []byte, modTime time.Time) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatalf("failed writing %s: %v", file, err)
+	}
+
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatalf("failed setting modification time of %s: %v", file, err)
+	}
+
+	return file
+}
+
+// writeTestCert issues a certificate signed by ca and writes it and its key to dir with the prefix name.
+func writeTestCert(t *testing.T, ca *testCA, dir string, name string, modTime time.Time) (int64, string, string) {
+	t.Helper()
+	serial, certPEM, keyPEM := ca.issue(t)
+
+	return serial,
+		writeTestFile(t, dir, name+".crt", certPEM, modTime),
+		writeTestFile(t, dir, name+".key", keyPEM, modTime)
+}
+
+// serveTestHealth serves a gRPC health server with the TLS config, and returns its address.
+func serveTestHealth(t *testing.T, config *tls.Config) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listening: %v", err)
+	}
+
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(config)))
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	return listener.Addr().String()
+}
+
+// checkTestHealth checks the health of the gRPC server at addr over a connection with creds.
+func checkTestHealth(t *testing.T, addr string, creds credentials.TransportCredentials) error {
+	t.Helper()
+	pool, err := initServiceConn(serviceFile, addr, creds)
+	if err != nil {
+		t.Fatalf("failed dialing: %v", err)
+	}
+	defer (*pool).Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err = healthpb.NewHealthClient((*pool).Conn()).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(false))
+
+	return err
+}
+
+func TestClientTLSCredentials_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	ca := newTestCA(t)
+	caFile := writeTestFile(t, dir, "ca.crt", ca.pem, now)
+	_, serverCert, serverKey := writeTestCert(t, ca, dir, "server", now)
+	_, clientCert, clientKey := writeTestCert(t, ca, dir, "client", now)
+
+	serverConfig, err := newServerTLSConfig(serverCert, serverKey, caFile)
+	if err != nil {
+		t.Fatalf("failed creating server TLS config: %v", err)
+	}
+	addr := serveTestHealth(t, serverConfig)
+
+	creds, err := newClientTLSCredentials(caFile, clientCert, clientKey, "localhost")
+	if err != nil {
+		t.Fatalf("failed creating client credentials: %v", err)
+	}
+
+	if err := checkTestHealth(t, addr, creds); err != nil {
+		t.Errorf("expected mTLS connection to succeed, got %v", err)
+	}
+
+	noClientCertCreds, err := newClientTLSCredentials(caFile, "", "", "localhost")
+	if err != nil {
+		t.Fatalf("failed creating client credentials: %v", err)
+	}
+
+	if err := checkTestHealth(t, addr, noClientCertCreds); err == nil {
+		t.Error("expected connection without a client certificate to be rejected")
+	}
+
+	untrustedCAFile := writeTestFile(t, dir, "untrusted-ca.crt", newTestCA(t).pem, now)
+	untrustedCreds, err := newClientTLSCredentials(untrustedCAFile, clientCert, clientKey, "localhost")
+	if err != nil {
+		t.Fatalf("failed creating client credentials: %v", err)
+	}
+
+	if err := checkTestHealth(t, addr, untrustedCreds); err == nil {
+		t.Error("expected connection to a server signed by an untrusted CA to fail")
+	}
+}
+
+func TestClientTLSCredentials_ReloadCA(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	oldCA := newTestCA(t)
+	newCA := newTestCA(t)
+	caFile := writeTestFile(t, dir, "ca.crt", oldCA.pem, now)
+	_, serverCert, serverKey := writeTestCert(t, newCA, dir, "server", now)
+
+	serverConfig, err := newServerTLSConfig(serverCert, serverKey, "")
+	if err != nil {
+		t.Fatalf("failed creating server TLS config: %v", err)
+	}
+	addr := serveTestHealth(t, serverConfig)
+
+	creds, err := newClientTLSCredentials(caFile, "", "", "localhost")
+	if err != nil {
+		t.Fatalf("failed creating client credentials: %v", err)
+	}
+
+	if err := checkTestHealth(t, addr, creds); err == nil {
+		t.Fatal("expected connection to a server signed by an untrusted CA to fail")
+	}
+
+	writeTestFile(t, dir, "ca.crt", newCA.pem, now.Add(time.Minute))
+	if err := checkTestHealth(t, addr, creds); err != nil {
+		t.Errorf("expected connection to succeed after reloading the CA, got %v", err)
+	}
+}
+
+func TestCertReloader_KeepsCertificateOnFailedReload(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	ca := newTestCA(t)
+	serial, certFile, keyFile := writeTestCert(t, ca, dir, "server", now)
+
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("failed loading certificate: %v", err)
+	}
+
+	writeTestFile(t, dir, "server.crt", []byte("not a certificate"), now.Add(time.Minute))
+	cert, err := r.certificate()
+	if err != nil {
+		t.Fatalf("expected the previous certificate, got %v", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("failed parsing certificate: %v", err)
+	}
+
+	if leaf.SerialNumber.Int64() != serial {
+		t.Errorf("expected certificate serial %d, got %d", serial, leaf.SerialNumber.Int64())
+	}
+}
+
+func TestServer_ServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	ca := newTestCA(t)
+	oldSerial, certFile, keyFile := writeTestCert(t, ca, dir, "server", now)
+
+	config, err := newServerTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("failed creating server TLS config: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/healthcheck", func(c *gin.Context) { c.Status(http.StatusOK) })
+	s := &Server{
+		server:      &http.Server{Handler: r, TLSConfig: config},
+		health:      NewHealthChecker(),
+		gracePeriod: 5 * time.Second,
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listening: %v", err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.serve(listener, stop)
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func() *http.Response {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots},
+			ForceAttemptHTTP2: true,
+		}}
+		defer client.CloseIdleConnections()
+
+		resp, err := client.Get("https://" + listener.Addr().String() + "/api/healthcheck")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+
+		return resp
+	}
+
+	resp := get()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2, got %s", resp.Proto)
+	}
+
+	if got := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); got != oldSerial {
+		t.Errorf("expected certificate serial %d, got %d", oldSerial, got)
+	}
+
+	newSerial, _, _ := writeTestCert(t, ca, dir, "server", now.Add(time.Minute))
+	if got := get().TLS.PeerCertificates[0].SerialNumber.Int64(); got != newSerial {
+		t.Errorf("expected reloaded certificate serial %d, got %d", newSerial, got)
+	}
+
+	stop <- os.Interrupt
+	if err := <-serveErr; err != nil {
+		t.Errorf("expected graceful shutdown, got %v", err)
+	}
+}
+
+func TestClientTLSCredentials_NotConfigured(t *testing.T) {
+	creds, err := clientTLSCredentials(serviceFile)
+	if err != nil || creds != nil {
+		t.Errorf("expected no credentials without TLS config, got %v, %v", creds, err)
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/meateam/api-gateway/file"
+	es "github.com/olivere/elastic/v7"
+	"github.com/spf13/viper"
+)
+
+// trashIndexMapping is the mapping of the trash index, ids are matched exactly.
+const trashIndexMapping = `{
+	"mappings": {
+		"properties": {
+			"fileId":    {"type": "keyword"},
+			"userId":    {"type": "keyword"},
+			"parent":    {"type": "keyword"},
+			"name":      {"type": "text"},
+			"type":      {"type": "keyword"},
+			"size":      {"type": "long"},
+			"trashedAt": {"type": "date"}
+		}
+	}
+}`
+
+// trashedBatchSize is the number of ids searched at a time by Trashed,
+// the default index.max_result_window of elasticsearch.
+const trashedBatchSize = 10000
+
+// esTrashStore is a file.TrashStore that stores the trash items in an elasticsearch index,
+// with the file id as the document id.
+type esTrashStore struct {
+	client *es.Client
+	index  string
+}
+
+// NewElasticsearchTrashStore creates a file.TrashStore that stores the trash in elasticsearch,
+// and creates its index if it doesn't exist. Returns a non-nil error if the elasticsearch client
+// couldn't be created or the index couldn't be created.
+func NewElasticsearchTrashStore() (file.TrashStore, error) {
+	index := viper.GetString(configTrashIndex)
+	client, err := newESStore(index, trashIndexMapping)
+	if err != nil {
+		return nil, err
+	}
+
+	return &esTrashStore{client: client, index: index}, nil
+}
+
+// Add indexes item, refreshing the index so the item is immediately hidden from listings.
+func (s *esTrashStore) Add(ctx context.Context, item *file.TrashItem) error {
+	_, err := s.client.Index().Index(s.index).Id(item.FileID).BodyJson(item).Refresh("wait_for").Do(ctx)
+
+	return err
+}
+
+// Get returns the trash item of fileID.
+func (s *esTrashStore) Get(ctx context.Context, fileID string) (*file.TrashItem, error) {
+	res, err := s.client.Get().Index(s.index).Id(fileID).Do(ctx)
+	if es.IsNotFound(err) {
+		return nil, file.ErrTrashItemNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	item := &file.TrashItem{}
+	if err := json.Unmarshal(res.Source, item); err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// List returns all of the items in the trash of userID, the latest trashed first.
+func (s *esTrashStore) List(ctx context.Context, userID string) ([]*file.TrashItem, error) {
+	var items []*file.TrashItem
+	query := es.NewTermQuery("userId", userID)
+	sorters := []es.Sorter{es.NewFieldSort("trashedAt").Desc(), es.NewFieldSort("fileId")}
+	err := searchAll(ctx, s.client, s.index, query, sorters, func(hit *es.SearchHit) error {
+		item := &file.TrashItem{}
+		if err := json.Unmarshal(hit.Source, item); err != nil {
+			return err
+		}
+
+		items = append(items, item)
+
+		return nil
+	})
+
+	return items, err
+}
+
+// ListTrashedBefore returns at most limit items that were trashed before t, the earliest trashed first.
+func (s *esTrashStore) ListTrashedBefore(ctx context.Context, t time.Time, limit int) ([]*file.TrashItem, error) {
+	return s.search(ctx, es.NewRangeQuery("trashedAt").Lt(t), limit, true)
+}
+
+// search returns at most limit items matching query, sorted by their trash time.
+func (s *esTrashStore) search(
+	ctx context.Context,
+	query es.Query,
+	limit int,
+	ascending bool) ([]*file.TrashItem, error) {
+	res, err := s.client.Search(s.index).Query(query).Sort("trashedAt", ascending).Size(limit).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*file.TrashItem, 0, len(res.Hits.Hits))
+	for _, hit := range res.Hits.Hits {
+		item := &file.TrashItem{}
+		if err := json.Unmarshal(hit.Source, item); err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// Trashed returns which of fileIDs are in the trash. The ids are searched in batches of
+// trashedBatchSize, since a search can't return more than index.max_result_window documents.
+func (s *esTrashStore) Trashed(ctx context.Context, fileIDs []string) (map[string]bool, error) {
+	trashed := make(map[string]bool)
+	for start := 0; start < len(fileIDs); start += trashedBatchSize {
+		end := start + trashedBatchSize
+		if end > len(fileIDs) {
+			end = len(fileIDs)
+		}
+
+		res, err := s.client.Search(s.index).
+			Query(es.NewIdsQuery().Ids(fileIDs[start:end]...)).
+			FetchSource(false).
+			Size(end - start).
+			Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, hit := range res.Hits.Hits {
+			trashed[hit.Id] = true
+		}
+	}
+
+	return trashed, nil
+}
+
+// Remove deletes the item of fileID, refreshing the index so the file is immediately listed again.
+func (s *esTrashStore) Remove(ctx context.Context, fileID string) error {
+	_, err := s.client.Delete().Index(s.index).Id(fileID).Refresh("wait_for").Do(ctx)
+	if es.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
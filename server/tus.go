@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/meateam/api-gateway/upload"
+	es "github.com/olivere/elastic/v7"
+	"github.com/spf13/viper"
+)
+
+// tusIndexMapping is the mapping of the tus upload index, the state of an upload is only fetched by its id.
+const tusIndexMapping = `{
+	"mappings": {
+		"properties": {
+			"id":        {"type": "keyword"},
+			"userId":    {"type": "keyword"},
+			"uploadId":  {"type": "keyword"},
+			"key":       {"type": "keyword"},
+			"bucket":    {"type": "keyword"},
+			"name":      {"type": "text"},
+			"parent":    {"type": "keyword"},
+			"metadata":  {"type": "keyword", "index": false},
+			"length":    {"type": "long"},
+			"offset":    {"type": "long"},
+			"staged":    {"type": "long"},
+			"partSize":  {"type": "long"},
+			"createdAt": {"type": "date"}
+		}
+	}
+}`
+
+// esTusStore is an upload.TusStore that stores the tus uploads in an elasticsearch index,
+// with the tus upload id as the document id.
+type esTusStore struct {
+	client *es.Client
+	index  string
+}
+
+// NewElasticsearchTusStore creates an upload.TusStore that stores the tus uploads in elasticsearch,
+// and creates its index if it doesn't exist. Returns a non-nil error if the elasticsearch client
+// couldn't be created or the index couldn't be created.
+func NewElasticsearchTusStore() (upload.TusStore, error) {
+	index := viper.GetString(configTusIndex)
+	client, err := newESStore(index, tusIndexMapping)
+	if err != nil {
+		return nil, err
+	}
+
+	return &esTusStore{client: client, index: index}, nil
+}
+
+// Create indexes tusUpload.
+func (s *esTusStore) Create(ctx context.Context, tusUpload *upload.TusUpload) error {
+	_, err := s.client.Index().Index(s.index).Id(tusUpload.ID).BodyJson(tusUpload).Do(ctx)
+
+	return err
+}
+
+// Get returns the tus upload of id.
+func (s *esTusStore) Get(ctx context.Context, id string) (*upload.TusUpload, error) {
+	res, err := s.client.Get().Index(s.index).Id(id).Do(ctx)
+	if es.IsNotFound(err) {
+		return nil, upload.ErrTusUploadNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	tusUpload := &upload.TusUpload{}
+	if err := json.Unmarshal(res.Source, tusUpload); err != nil {
+		return nil, err
+	}
+
+	return tusUpload, nil
+}
+
+// SetOffset updates the offset of the tus upload of id and its staged bytes.
+func (s *esTusStore) SetOffset(ctx context.Context, id string, offset int64, staged int64) error {
+	doc := map[string]int64{"offset": offset, "staged": staged}
+	_, err := s.client.Update().Index(s.index).Id(id).Doc(doc).Do(ctx)
+	if es.IsNotFound(err) {
+		return upload.ErrTusUploadNotFound
+	}
+
+	return err
+}
+
+// Remove deletes the tus upload of id.
+func (s *esTusStore) Remove(ctx context.Context, id string) error {
+	_, err := s.client.Delete().Index(s.index).Id(id).Do(ctx)
+	if es.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
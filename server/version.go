@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/meateam/api-gateway/file"
+	es "github.com/olivere/elastic/v7"
+	"github.com/spf13/viper"
+)
+
+// versionIndexMapping is the mapping of the version index, ids and keys are matched exactly.
+const versionIndexMapping = `{
+	"mappings": {
+		"properties": {
+			"id":         {"type": "keyword"},
+			"fileId":     {"type": "keyword"},
+			"ownerId":    {"type": "keyword"},
+			"key":        {"type": "keyword"},
+			"bucket":     {"type": "keyword"},
+			"name":       {"type": "text"},
+			"type":       {"type": "keyword"},
+			"size":       {"type": "long"},
+			"authorId":   {"type": "keyword"},
+			"replacedBy": {"type": "keyword"},
+			"createdAt":  {"type": "date"},
+			"archivedAt": {"type": "date"}
+		}
+	}
+}`
+
+// esVersionStore is a file.VersionStore that stores the versions in an elasticsearch index,
+// with the version id as the document id.
+type esVersionStore struct {
+	client *es.Client
+	index  string
+}
+
+// NewElasticsearchVersionStore creates a file.VersionStore that stores the versions in elasticsearch,
+// and creates its index if it doesn't exist. Returns a non-nil error if the elasticsearch client
+// couldn't be created or the index couldn't be created.
+func NewElasticsearchVersionStore() (file.VersionStore, error) {
+	index := viper.GetString(configVersionIndex)
+	client, err := newESStore(index, versionIndexMapping)
+	if err != nil {
+		return nil, err
+	}
+
+	return &esVersionStore{client: client, index: index}, nil
+}
+
+// Add indexes version, refreshing the index so the version is immediately listed.
+func (s *esVersionStore) Add(ctx context.Context, version *file.Version) error {
+	_, err := s.client.Index().Index(s.index).Id(version.ID).BodyJson(version).Refresh("wait_for").Do(ctx)
+
+	return err
+}
+
+// Get returns the version of versionID.
+func (s *esVersionStore) Get(ctx context.Context, versionID string) (*file.Version, error) {
+	res, err := s.client.Get().Index(s.index).Id(versionID).Do(ctx)
+	if es.IsNotFound(err) {
+		return nil, file.ErrVersionNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	version := &file.Version{}
+	if err := json.Unmarshal(res.Source, version); err != nil {
+		return nil, err
+	}
+
+	return version, nil
+}
+
+// List returns all of the versions of fileID, the latest archived first.
+func (s *esVersionStore) List(ctx context.Context, fileID string) ([]*file.Version, error) {
+	var versions []*file.Version
+	query := es.NewTermQuery("fileId", fileID)
+	sorters := []es.Sorter{es.NewFieldSort("archivedAt").Desc(), es.NewFieldSort("id")}
+	err := searchAll(ctx, s.client, s.index, query, sorters, func(hit *es.SearchHit) error {
+		version := &file.Version{}
+		if err := json.Unmarshal(hit.Source, version); err != nil {
+			return err
+		}
+
+		versions = append(versions, version)
+
+		return nil
+	})
+
+	return versions, err
+}
+
+// ListArchivedBefore returns at most limit versions that were archived before t, the earliest archived first.
+func (s *esVersionStore) ListArchivedBefore(ctx context.Context, t time.Time, limit int) ([]*file.Version, error) {
+	return s.search(ctx, es.NewRangeQuery("archivedAt").Lt(t), limit, true)
+}
+
+// search returns at most limit versions matching query, sorted by their archive time.
+func (s *esVersionStore) search(
+	ctx context.Context,
+	query es.Query,
+	limit int,
+	ascending bool) ([]*file.Version, error) {
+	res, err := s.client.Search(s.index).Query(query).Sort("archivedAt", ascending).Size(limit).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]*file.Version, 0, len(res.Hits.Hits))
+	for _, hit := range res.Hits.Hits {
+		version := &file.Version{}
+		if err := json.Unmarshal(hit.Source, version); err != nil {
+			return nil, err
+		}
+
+		versions = append(versions, version)
+	}
+
+	return versions, nil
+}
+
+// Remove deletes the version of versionID, refreshing the index so it's immediately unlisted.
+// Returns whether the version was deleted by this call.
+func (s *esVersionStore) Remove(ctx context.Context, versionID string) (bool, error) {
+	res, err := s.client.Delete().Index(s.index).Id(versionID).Refresh("wait_for").Do(ctx)
+	if es.IsNotFound(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return res.Result == "deleted", nil
+}
@@ -18,7 +18,7 @@ import (
 //
 // List of files
 //
-// This returns all files according to the requested folder
+// This returns all files according to the requested folder, or a page of them
 //
 // Schemes: http
 // Responses:
@@ -31,6 +31,52 @@ type filesRequest struct {
 	// in:url
 	Parent string
 
+	// The field to sort the files by
+	// enum:name,size,type,createdAt,updatedAt
+	// in:query
+	SortBy string `json:"sortBy"`
+
+	// The sort order
+	// enum:asc,desc
+	// in:query
+	SortOrder string `json:"sortOrder"`
+
+	// List folders before files
+	// in:query
+	FoldersFirst bool `json:"foldersFirst"`
+
+	// The prefix of the names of the files, case-insensitive
+	// in:query
+	NamePrefix string `json:"namePrefix"`
+
+	// The minimum and maximum size of the files
+	// in:query
+	SizeMin int64 `json:"sizeMin"`
+	// in:query
+	SizeMax int64 `json:"sizeMax"`
+
+	// The earliest and latest creation and update times of the files, in milliseconds
+	// in:query
+	CreatedFrom int64 `json:"createdFrom"`
+	// in:query
+	CreatedTo int64 `json:"createdTo"`
+	// in:query
+	UpdatedFrom int64 `json:"updatedFrom"`
+	// in:query
+	UpdatedTo int64 `json:"updatedTo"`
+
+	// The page of files, starting at 0. If pageNum or pageSize is given then
+	// the response is a page of files with the count of the matching files.
+	// The count is an upper bound, since files the user isn't permitted to are omitted from the page
+	// in:query
+	PageNum int64 `json:"pageNum"`
+
+	// The number of files in a page
+	// default:100
+	// maximum:1000
+	// in:query
+	PageSize int64 `json:"pageSize"`
+
 	// The jwt key
 	// example:Bearer &{jwt}
 	// in:header
@@ -41,7 +87,8 @@ type filesRequest struct {
 //
 // Single file
 //
-// This returns a single file according to the requested folder
+// This returns a single file according to the requested folder, or its content if alt=media is requested.
+// The digests of the file's content are returned in the Digest header, if they're known
 //
 // Schemes: http
 // Responses:
@@ -55,6 +102,11 @@ type fileRequest struct {
 	// in:path
 	ID string `json:"id"`
 
+	// Download the file's content
+	// example:media
+	// in:query
+	Alt string `json:"alt"`
+
 	// The jwt key
 	// example:Bearer &{jwt}
 	// in:header
@@ -72,6 +124,11 @@ type filesResponse struct {
 // Single file
 // swagger:response fileResponse
 type fileResponse struct {
+	// The MD5 and SHA-256 digests of the file's content
+	// example:MD5=XUFAKrxLKna5cZ2REBfFkg==,SHA-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=
+	// in:header
+	Digest string `json:"Digest"`
+
 	// in:body
 	Body file.GetFileByIDResponse
 }
@@ -204,3 +261,228 @@ type UpdateResponse struct {
 	// in:body
 	FailedFilesID []string
 }
+
+// swagger:route GET /zip files downloadZip
+//
+// Download files as a zip
+//
+// This streams a zip of the files and folders, with the folders' descendants
+//
+// Produces:
+// - application/zip
+//
+// Schemes: http
+// responses:
+//	200: ZipResponse
+
+// swagger:parameters downloadZip
+type downloadZipRequest struct {
+	// The ids of the files and folders to download, may be repeated
+	// in:query
+	// required:true
+	ID []string `json:"id"`
+
+	// The jwt key
+	// example:Bearer &{jwt}
+	// in:header
+	// required:true
+	Authorization string
+}
+
+// The zip file
+// swagger:response ZipResponse
+type ZipResponse struct {
+	// in:body
+	Body []byte
+}
+
+// swagger:route GET /files/{id}/thumbnail files thumbnail
+//
+// Get a thumbnail of a file
+//
+// This returns a thumbnail of an image, or of the first page of a PDF or an office document
+//
+// Produces:
+// - image/png
+// - image/jpeg
+//
+// Schemes: http
+// responses:
+//	200: ThumbnailResponse
+
+// swagger:parameters thumbnail
+type thumbnailRequest struct {
+	// The file id
+	// in:path
+	// required:true
+	ID string `json:"id"`
+
+	// The size in pixels of the longest side of the thumbnail, between 16 and 1024
+	// in:query
+	// default:256
+	Size int `json:"size"`
+
+	// The jwt key
+	// example:Bearer &{jwt}
+	// in:header
+	// required:true
+	Authorization string
+}
+
+// The thumbnail
+// swagger:response ThumbnailResponse
+type ThumbnailResponse struct {
+	// in:body
+	Body []byte
+}
+
+// swagger:route GET /trash files listTrash
+//
+// List the trash
+//
+// This returns the files in the user's trash, the latest trashed first
+//
+// Schemes: http
+// responses:
+//	200: TrashResponse
+
+// swagger:route POST /trash/{id}/restore files restoreFile
+//
+// Restore a file from the trash
+//
+// This restores the file to its parent, or to the root if its parent is gone
+//
+// Schemes: http
+// responses:
+//	200: TrashItemResponse
+
+// swagger:route DELETE /trash/{id} files deleteTrashedFile
+//
+// Delete a file from the trash
+//
+// This permanently deletes the trashed file and its descendants
+//
+// Schemes: http
+// responses:
+//	200: DeleteResponse
+
+// swagger:route DELETE /trash files emptyTrash
+//
+// Empty the trash
+//
+// This starts permanently deleting the files in the user's trash in the background,
+// and returns the ids of the trashed files
+//
+// Schemes: http
+// responses:
+//	202: DeleteResponse
+
+// swagger:parameters restoreFile deleteTrashedFile
+type trashItemRequest struct {
+	// The trashed file id
+	// unique:true
+	// in:path
+	// required:true
+	ID string `json:"id"`
+
+	// The jwt key
+	// example:Bearer &{jwt}
+	// in:header
+	// required:true
+	Authorization string
+}
+
+// The files in the trash
+// swagger:response TrashResponse
+type TrashResponse struct {
+	// in:body
+	Body []file.TrashItem
+}
+
+// A file in the trash
+// swagger:response TrashItemResponse
+type TrashItemResponse struct {
+	// in:body
+	Body file.TrashItem
+}
+
+// swagger:route GET /files/{id}/versions files listVersions
+//
+// List the versions of a file
+//
+// This returns the previous contents of the file that were kept when it was updated, the latest first
+//
+// Schemes: http
+// responses:
+//	200: VersionsResponse
+
+// swagger:route GET /files/{id}/versions/{versionId} files version
+//
+// Get a version of a file
+//
+// This returns the version's metadata, or its content if alt=media is requested
+//
+// Schemes: http
+// responses:
+//	200: VersionResponse
+
+// swagger:route POST /files/{id}/versions/{versionId}/restore files restoreVersion
+//
+// Restore a version of a file
+//
+// This makes the version the file's current content, keeping the replaced content as a version
+//
+// Schemes: http
+// responses:
+//	200: fileResponse
+
+// swagger:parameters listVersions
+type listVersionsRequest struct {
+	// The file id
+	// in:path
+	// required:true
+	ID string `json:"id"`
+
+	// The jwt key
+	// example:Bearer &{jwt}
+	// in:header
+	// required:true
+	Authorization string
+}
+
+// swagger:parameters version restoreVersion
+type versionRequest struct {
+	// The file id
+	// in:path
+	// required:true
+	ID string `json:"id"`
+
+	// The version id
+	// in:path
+	// required:true
+	VersionID string `json:"versionId"`
+
+	// Download the version's content, with preview=true to preview it
+	// in:query
+	Alt string `json:"alt"`
+
+	// The jwt key
+	// example:Bearer &{jwt}
+	// in:header
+	// required:true
+	Authorization string
+}
+
+// The versions of a file
+// swagger:response VersionsResponse
+type VersionsResponse struct {
+	// in:body
+	Body []file.VersionResponse
+}
+
+// A version of a file
+// swagger:response VersionResponse
+type VersionResponse struct {
+	// in:body
+	Body file.VersionResponse
+}
@@ -24,6 +24,7 @@ import (
 // Schemes: http
 // responses:
 //	200: UploadResponse
+//	429: uploadLimitExceededResponse
 
 // swagger:parameters uploadfolder
 type uploadFolderRequest struct {
@@ -56,6 +57,8 @@ type uploadFolderRequest struct {
 // Schemes: http
 // responses:
 //	200: UploadResponse
+//	507: quotaExceededResponse
+//	429: uploadLimitExceededResponse
 
 // swagger:parameters uploadmultipart
 type uploadMultipartRequest struct {
@@ -73,6 +76,15 @@ type uploadMultipartRequest struct {
 	// swagger:file
 	File *bytes.Buffer `json:"file"`
 
+	// The base64 encoded MD5 of the file, the upload is rejected if it doesn't match
+	// in:header
+	ContentMD5 string `json:"Content-MD5"`
+
+	// The base64 encoded MD5 or SHA-256 digests of the file, the upload is rejected if they don't match
+	// example:SHA-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=
+	// in:header
+	Digest string `json:"Digest"`
+
 	// The jwt key
 	// example:Bearer &{jwt}
 	// in:header
@@ -89,6 +101,8 @@ type uploadMultipartRequest struct {
 // Schemes: http
 // responses:
 //	200: initResumableResponse
+//	507: quotaExceededResponse
+//	429: uploadLimitExceededResponse
 
 // swagger:parameters initresumable
 type InitResumableRequest struct {
@@ -130,10 +144,15 @@ type InitResumableResponse struct {
 //
 // Upload a big file over 5MB and up to 5TB .
 // Runs after the init resumable upload
+// Uploads the range in Content-Range, the file is created once all of its ranges are uploaded.
+// Failed ranges are retried, and interrupted uploads are resumed from their status.
 //
 // Schemes: http
 // responses:
 //	200: UploadResponse
+//	202: uploadStatusResponse
+//	400: description:Invalid range or content, the upload is kept unless the file doesn't match X-File-Digest
+//	410: description:The upload no longer exists and must be restarted
 
 // swagger:parameters uploadresumable
 type uploadResumableRequest struct {
@@ -151,6 +170,28 @@ type uploadResumableRequest struct {
 	// in:query
 	Parent string
 
+	// The range of the file that is uploaded, it starts at a multiple of the upload's part size
+	// and ends at a multiple of the part size or at the end of the file
+	// example:bytes 0-5242879/15728640
+	// in:header
+	ContentRange string `json:"Content-Range"`
+
+	// The base64 encoded MD5 of the range, the range is rejected if it doesn't match
+	// in:header
+	ContentMD5 string `json:"Content-MD5"`
+
+	// The base64 encoded MD5 or SHA-256 digests of the range, the range is rejected if they don't match
+	// example:SHA-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=
+	// in:header
+	Digest string `json:"Digest"`
+
+	// The base64 encoded MD5 or SHA-256 digests of the whole file, in the syntax of the Digest header.
+	// The file is verified against them once the range completes the upload, and if it doesn't match
+	// then the upload is deleted and must be restarted
+	// example:SHA-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=
+	// in:header
+	FileDigest string `json:"X-File-Digest"`
+
 	// The new file
 	// in:formData
 	// swagger:file
@@ -163,6 +204,53 @@ type uploadResumableRequest struct {
 	Authorization string
 }
 
+// swagger:route GET /upload/{uploadId}/status upload uploadStatus
+//
+// Get a resumable upload's status
+//
+// Gets the ranges of a resumable upload that were committed, the upload is resumed by uploading the rest.
+// The size and part size are 0 until a range is committed
+//
+// Schemes: http
+// responses:
+//	200: uploadStatusResponse
+
+// swagger:parameters uploadStatus
+type uploadStatusRequest struct {
+	// Upload id from init resumable upload.
+	// example:5e23e4a5-027a-431b-bd67-39e46b59595a
+	// in:path
+	// required:true
+	UploadID string `json:"uploadId"`
+
+	// The jwt key
+	// example:Bearer &{jwt}
+	// in:header
+	// required:true
+	Authorization string
+}
+
+// The committed ranges of a resumable upload
+// swagger:response uploadStatusResponse
+type uploadStatusResponse struct {
+	// in:body
+	Body struct {
+		UploadID string `json:"uploadId"`
+
+		// The size of the file
+		Size int64 `json:"size"`
+
+		// The size of the upload's parts, which ranges are aligned to
+		PartSize int64 `json:"partSize"`
+
+		// The committed ranges, their start and end are inclusive
+		Committed []struct {
+			Start int64 `json:"start"`
+			End   int64 `json:"end"`
+		} `json:"committed"`
+	}
+}
+
 // swagger:route PUT /upload/{id} upload updateFileContent
 //
 // Update file content
@@ -172,6 +260,7 @@ type uploadResumableRequest struct {
 // Schemes: http
 // responses:
 //	200: UploadResponse
+//	507: quotaExceededResponse
 
 // swagger:parameters updateFileContent
 type updateContentRequest struct {
@@ -197,3 +286,207 @@ type UploadResponse struct {
 	// in:body
 	ID string
 }
+
+// The user uploaded the maximum number of files or folders in the batch or time window
+// swagger:response uploadLimitExceededResponse
+type uploadLimitExceededResponse struct {
+	// in:body
+	Body struct {
+		Code int `json:"code"`
+
+		// example:at most 100 files may be uploaded in a batch or time window
+		Message string `json:"message"`
+
+		// A google.rpc.QuotaFailure of the user, and a google.rpc.ErrorInfo with the UPLOAD_LIMIT_EXCEEDED reason
+		// and the remainingFiles, remainingFolders and batchId in its metadata
+		Details []map[string]interface{} `json:"details"`
+	}
+}
+
+// swagger:parameters uploadfolder uploadmultipart initresumable tusCreate copyFile
+type uploadBatchRequest struct {
+	// The id of the batch of the upload, the files and folders uploaded in each batch are limited
+	// example:5e23e4a5-027a-431b-bd67-39e46b59595a
+	// in:header
+	BatchID string `json:"X-Batch-Id"`
+}
+
+// The owner's remaining quota is smaller than the uploaded size, counting the owner's in-flight uploads
+// swagger:response quotaExceededResponse
+type quotaExceededResponse struct {
+	// in:body
+	Body struct {
+		Code int `json:"code"`
+
+		// example:upload requires 1048576 bytes, only 1024 bytes of quota remain
+		Message string `json:"message"`
+
+		// A google.rpc.QuotaFailure of the owner, and a google.rpc.ErrorInfo with the QUOTA_EXCEEDED reason
+		// and the required, remaining and limit bytes in its metadata
+		Details []map[string]interface{} `json:"details"`
+	}
+}
+
+// swagger:route POST /files/{id}/copy upload copyFile
+//
+// Copy a file
+//
+// This copies a file, or a folder with its descendants, to a parent folder.
+// A copy is limited as a single upload of the copied file or folder
+//
+// Schemes: http
+// responses:
+//	200: UploadResponse
+//	507: quotaExceededResponse
+//	429: uploadLimitExceededResponse
+
+// swagger:parameters copyFile
+type copyFileRequest struct {
+	// The id of the file to copy
+	// unique:true
+	// in:path
+	// required:true
+	ID string `json:"id"`
+
+	// in:body
+	Body struct {
+		// The id of the folder to copy to, the root folder if empty
+		Parent string `json:"parent"`
+
+		// The name of the copy, the name of the copied file if empty
+		Name string `json:"name"`
+	}
+
+	// The jwt key
+	// example:Bearer &{jwt}
+	// in:header
+	// required:true
+	Authorization string
+}
+
+// swagger:route POST /upload/tus upload tusCreate
+//
+// Create a tus upload
+//
+// Creates a tus 1.0 resumable upload of a file, its content is uploaded by PATCH requests to its location
+//
+// Schemes: http
+// responses:
+//	201: tusCreateResponse
+//	507: quotaExceededResponse
+//	429: uploadLimitExceededResponse
+
+// swagger:parameters tusCreate
+type tusCreateRequest struct {
+	// The parent of the new file, overrides the parent in Upload-Metadata
+	// in:query
+	Parent string
+
+	// The tus protocol version
+	// example:1.0.0
+	// in:header
+	// required:true
+	TusResumable string `json:"Tus-Resumable"`
+
+	// The file size
+	// in:header
+	// required:true
+	UploadLength string `json:"Upload-Length"`
+
+	// Comma separated keys and base64 encoded values of filename, filetype and parent
+	// example:filename cmVwb3J0LnBkZg==,filetype YXBwbGljYXRpb24vcGRm
+	// in:header
+	UploadMetadata string `json:"Upload-Metadata"`
+
+	// The jwt key
+	// example:Bearer &{jwt}
+	// in:header
+	// required:true
+	Authorization string
+}
+
+// The tus upload's location
+// swagger:response tusCreateResponse
+type tusCreateResponse struct {
+	// in:header
+	Location string `json:"Location"`
+}
+
+// swagger:route HEAD /upload/tus/{uploadId} upload tusHead
+//
+// Get a tus upload's offset
+//
+// Gets the offset of a tus upload, which its content is resumed from
+//
+// Schemes: http
+// responses:
+//	200: tusOffsetResponse
+
+// swagger:route PATCH /upload/tus/{uploadId} upload tusPatch
+//
+// Upload a tus upload's content
+//
+// Uploads the content of a tus upload at its offset. Content of any size is accepted, the content
+// that doesn't fill a part is staged until the next content, and the upload's file is created once it's complete
+//
+// Schemes: http
+// responses:
+//	204: tusOffsetResponse
+
+// swagger:route DELETE /upload/tus/{uploadId} upload tusTerminate
+//
+// Terminate a tus upload
+//
+// Aborts a tus upload and deletes its uploaded content
+//
+// Schemes: http
+// responses:
+//	204: description:Terminated
+
+// swagger:parameters tusHead tusPatch tusTerminate
+type tusUploadRequest struct {
+	// The tus upload id
+	// in:path
+	// required:true
+	UploadID string `json:"uploadId"`
+
+	// The tus protocol version
+	// example:1.0.0
+	// in:header
+	// required:true
+	TusResumable string `json:"Tus-Resumable"`
+
+	// The jwt key
+	// example:Bearer &{jwt}
+	// in:header
+	// required:true
+	Authorization string
+}
+
+// swagger:parameters tusPatch
+type tusPatchRequest struct {
+	// The offset of the content, must be the upload's offset
+	// in:header
+	// required:true
+	UploadOffset string `json:"Upload-Offset"`
+
+	// The checksum algorithm and base64 encoded checksum of the content
+	// example:sha256 47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=
+	// in:header
+	UploadChecksum string `json:"Upload-Checksum"`
+
+	// The content
+	// in:body
+	Content []byte
+}
+
+// The tus upload's offset
+// swagger:response tusOffsetResponse
+type tusOffsetResponse struct {
+	// in:header
+	UploadOffset string `json:"Upload-Offset"`
+
+	// The id of the created file, once the upload is complete
+	// in:header
+	FileID string `json:"x-file-id"`
+}
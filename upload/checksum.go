@@ -0,0 +1,127 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
+	"github.com/meateam/api-gateway/file"
+	loggermiddleware "github.com/meateam/api-gateway/logger"
+	dpb "github.com/meateam/download-service/proto"
+	fpb "github.com/meateam/file-service/proto/file"
+	"google.golang.org/grpc/status"
+)
+
+// FileDigestHeader is the header of the digests of the whole file of a range of a resumable upload,
+// in the syntax of the Digest header. The file is verified against them once the upload is completed.
+const FileDigestHeader = "X-File-Digest"
+
+// expectedChecksum returns the checksum of the uploaded content in header, which is nil if there's none.
+// If header is malformed then the request is aborted with 400 and false is returned.
+func expectedChecksum(c *gin.Context, header http.Header) (*file.Checksum, bool) {
+	checksum, err := file.ParseChecksum(header)
+	if err != nil {
+		apierror.AbortWithMessage(c, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	return checksum, true
+}
+
+// expectedFileChecksum returns the checksum of the whole file in the FileDigestHeader of the request, which is nil
+// if there's none. If the header is malformed then the request is aborted with 400 and false is returned.
+func expectedFileChecksum(c *gin.Context) (*file.Checksum, bool) {
+	checksum, err := file.ParseChecksum(http.Header{file.DigestHeader: []string{c.GetHeader(FileDigestHeader)}})
+	if err != nil {
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("%s is invalid", FileDigestHeader))
+		return nil, false
+	}
+
+	return checksum, true
+}
+
+// objectChecksum returns the checksum of the object of key in bucket, reading it from download service.
+func (r *Router) objectChecksum(ctx context.Context, key string, bucket string) (*file.Checksum, error) {
+	stream, err := r.downloadClient().Download(ctx, &dpb.DownloadRequest{Key: key, Bucket: bucket})
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := file.NewHasher()
+	if _, err := io.Copy(hasher, file.NewDownloadReader(stream)); err != nil {
+		return nil, err
+	}
+
+	return hasher.Checksum(key), nil
+}
+
+// verifyCompletedUpload verifies the object of upload, whose parts were completed, against expected
+// and returns the object's checksum, which is nil if expected is nil. The object is read back since its
+// ranges may have been uploaded in any order, through any gateway instance. If the object doesn't match
+// expected, or couldn't be read, then it's deleted along with upload and its committed ranges, and the
+// request is aborted and false is returned, the upload must be restarted then.
+func (r *Router) verifyCompletedUpload(
+	c *gin.Context,
+	upload *fpb.GetUploadByIDResponse,
+	expected *file.Checksum) (*file.Checksum, bool) {
+	if expected == nil {
+		return nil, true
+	}
+
+	checksum, err := r.objectChecksum(c.Request.Context(), upload.GetKey(), upload.GetBucket())
+	if err != nil {
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		loggermiddleware.LogError(r.logger, r.parts.Remove(context.Background(), upload.GetUploadID()))
+		err = r.deleteUploadedContent(c.Request.Context(), err, upload)
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+		return nil, false
+	}
+
+	if err := expected.Verify(checksum); err != nil {
+		loggermiddleware.LogError(r.logger, r.parts.Remove(context.Background(), upload.GetUploadID()))
+		loggermiddleware.LogError(r.logger, r.deleteUploadedContent(c.Request.Context(), err, upload))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("%v, the upload must be restarted", err))
+
+		return nil, false
+	}
+
+	return checksum, true
+}
+
+// storeChecksum stores checksum, if it's non-nil and checksums are stored. Failing to store a checksum
+// doesn't fail the upload, the file has no digest then.
+func (r *Router) storeChecksum(ctx context.Context, checksum *file.Checksum) {
+	if r.checksums == nil || checksum == nil {
+		return
+	}
+
+	if err := r.checksums.Set(ctx, checksum); err != nil {
+		loggermiddleware.LogError(r.logger, fmt.Errorf("failed storing checksum of %s: %v", checksum.Key, err))
+	}
+}
+
+// copyChecksum stores the checksum of sourceKey as the checksum of key, if it's known.
+func (r *Router) copyChecksum(ctx context.Context, sourceKey string, key string) {
+	if r.checksums == nil {
+		return
+	}
+
+	checksum, err := r.checksums.Get(ctx, sourceKey)
+	if err == file.ErrChecksumNotFound {
+		return
+	}
+
+	if err != nil {
+		loggermiddleware.LogError(r.logger, fmt.Errorf("failed getting checksum of %s: %v", sourceKey, err))
+		return
+	}
+
+	copied := *checksum
+	copied.Key = key
+	r.storeChecksum(ctx, &copied)
+}
@@ -0,0 +1,398 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
+	"github.com/meateam/api-gateway/file"
+	loggermiddleware "github.com/meateam/api-gateway/logger"
+	"github.com/meateam/api-gateway/metrics"
+	"github.com/meateam/api-gateway/oauth"
+	"github.com/meateam/api-gateway/user"
+	dpb "github.com/meateam/download-service/proto"
+	fpb "github.com/meateam/file-service/proto/file"
+	ppb "github.com/meateam/permission-service/proto"
+	spb "github.com/meateam/search-service/proto"
+	upb "github.com/meateam/upload-service/proto"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// CopyRole is the role that is required of the authenticated requester to have to a file
+	// to be permitted to copy it.
+	CopyRole = ppb.Role_READ
+)
+
+// copyFileBody is a structure of the json body of a copy request.
+type copyFileBody struct {
+	// Parent is the id of the folder to copy to, the root folder if empty.
+	Parent string `json:"parent"`
+
+	// Name is the name of the copy, the name of the copied file if empty.
+	Name string `json:"name"`
+}
+
+// copyEntry is a file or a folder to copy, with the id of its parent in the copied tree.
+type copyEntry struct {
+	file   *fpb.File
+	parent string
+}
+
+// CopyFile is the request handler for POST /files/:id/copy.
+// It copies the file, or the folder with its descendants that the requester is permitted to copy,
+// to the parent in the request's body and responds with the id of the copy.
+// If copying any of the files fails then the files that were already copied are deleted.
+func (r *Router) CopyFile(c *gin.Context) {
+	reqUser := user.ExtractRequestUser(c)
+	if reqUser == nil {
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
+		return
+	}
+
+	fileID := c.Param("id")
+
+	var body copyFileBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		apierror.AbortWithMessage(c, http.StatusBadRequest, "invalid request body parameters")
+		return
+	}
+
+	role, _, err := file.CheckUserFilePermission(
+		c.Request.Context(),
+		r.fileClient(),
+		r.permissionClient(),
+		reqUser.ID,
+		fileID,
+		CopyRole,
+	)
+	if err != nil {
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+		return
+	}
+
+	if role == "" {
+		apierror.AbortWithStatus(c, http.StatusForbidden)
+		return
+	}
+
+	if r.isTrashed(c.Request.Context(), fileID) {
+		apierror.AbortWithMessage(c, http.StatusNotFound, fileIsTrashedMessage)
+		return
+	}
+
+	isPermitted, err := r.isUploadPermitted(c.Request.Context(), reqUser.ID, body.Parent)
+	if err != nil {
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+		return
+	}
+
+	if !isPermitted {
+		apierror.AbortWithStatus(c, http.StatusForbidden)
+		return
+	}
+
+	entries, err := r.copyEntries(c.Request.Context(), reqUser.ID, fileID)
+	if err != nil {
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.file.GetType() == FolderContentType && entry.file.GetId() == body.Parent {
+			apierror.AbortWithMessage(c, http.StatusBadRequest, "cannot copy a folder into itself")
+			return
+		}
+	}
+
+	size := int64(0)
+	for _, entry := range entries {
+		size += entry.file.GetSize()
+	}
+
+	uncountFailed, ok := r.limitCopy(c, reqUser, entries)
+	if !ok {
+		return
+	}
+	defer uncountFailed()
+
+	releaseQuota, ok := r.reserveQuota(c, reqUser.ID, "copy", size)
+	if !ok {
+		return
+	}
+	defer releaseQuota()
+
+	if body.Name != "" {
+		entries[0].file.Name = body.Name
+	}
+
+	entries[0].parent = body.Parent
+	appID := c.Value(oauth.ContextAppKey).(string)
+
+	copyID, err := r.copyFiles(c.Request.Context(), reqUser, appID, entries)
+	if err != nil {
+		// Roll back with a new context, so the copied files are deleted even if the request was canceled.
+		if copyID != "" {
+			_, deleteErr := file.DeleteFile(context.Background(),
+				r.logger,
+				r.fileClient(),
+				r.uploadClient(),
+				r.searchClient(),
+				r.permissionClient(),
+				nil,
+				copyID,
+				reqUser.ID)
+			if deleteErr != nil {
+				err = fmt.Errorf("%v: %v", err, deleteErr)
+			}
+		}
+
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+		return
+	}
+
+	c.String(http.StatusOK, copyID)
+}
+
+// limitCopy limits the copy of entries as a single upload of the copied file or folder, since the request
+// can't be split into batches and a folder could have more descendants than the limit.
+// Returns a function that uncounts the copy if the request failed, and false if the request was aborted.
+func (r *Router) limitCopy(c *gin.Context, reqUser *user.User, entries []*copyEntry) (func(), bool) {
+	return r.limitUpload(c, uploadKindOf(entries[0].file.GetType()), reqUser, 1)
+}
+
+// copyEntries returns the entries to copy fileID, the file first and then its descendants
+// that userID is permitted to copy, each after its parent.
+func (r *Router) copyEntries(ctx context.Context, userID string, fileID string) ([]*copyEntry, error) {
+	source, err := r.fileClient().GetFileByID(ctx, &fpb.GetByFileByIDRequest{Id: fileID})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*copyEntry{{file: source}}
+	if source.GetType() != FolderContentType {
+		return entries, nil
+	}
+
+	descendants, err := file.PermittedDescendants(
+		ctx,
+		r.fileClient(),
+		r.permissionClient(),
+		nil,
+		r.trash,
+		userID,
+		fileID,
+		CopyRole,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[string][]*fpb.File, len(descendants))
+	for _, descendant := range descendants {
+		parentID := descendant.GetParent().GetId()
+		children[parentID] = append(children[parentID], descendant.GetFile())
+	}
+
+	// Descendants whose parent isn't copied, because the user isn't permitted to it, are skipped.
+	for i := 0; i < len(entries); i++ {
+		for _, child := range children[entries[i].file.GetId()] {
+			entries = append(entries, &copyEntry{file: child, parent: entries[i].file.GetId()})
+		}
+	}
+
+	return entries, nil
+}
+
+// copyFiles copies entries for reqUser, the first entry to its parent and the rest under the copies
+// of their parents, and returns the id of the copy of the first entry.
+// The returned id is non-empty if the first entry was copied, even if copying another entry failed.
+func (r *Router) copyFiles(
+	ctx context.Context,
+	reqUser *user.User,
+	appID string,
+	entries []*copyEntry) (string, error) {
+	copyID := ""
+	copies := make(map[string]string, len(entries))
+	for i, entry := range entries {
+		parent := entry.parent
+		if i > 0 {
+			parent = copies[entry.parent]
+		}
+
+		id, err := r.copyFile(ctx, reqUser, appID, entry.file, parent)
+		if i == 0 {
+			copyID = id
+		}
+
+		if err != nil {
+			return copyID, err
+		}
+
+		copies[entry.file.GetId()] = id
+	}
+
+	return copyID, nil
+}
+
+// copyFile copies source to parent for reqUser, copying its content if it isn't a folder,
+// and indexes the copy in search service. Returns the id of the copy if it was created,
+// even if indexing it or creating its permission failed.
+func (r *Router) copyFile(
+	ctx context.Context,
+	reqUser *user.User,
+	appID string,
+	source *fpb.File,
+	parent string) (string, error) {
+	key := ""
+	if source.GetType() != FolderContentType {
+		keyResp, err := r.fileClient().GenerateKey(ctx, &fpb.GenerateKeyRequest{})
+		if err != nil {
+			return "", err
+		}
+
+		key = keyResp.GetKey()
+		if err := r.copyObject(ctx, source, key, reqUser.Bucket); err != nil {
+			return "", err
+		}
+
+		r.copyChecksum(ctx, source.GetKey(), key)
+	}
+
+	createFileResp, err := r.fileClient().CreateFile(ctx, &fpb.CreateFileRequest{
+		Key:     key,
+		Bucket:  reqUser.Bucket,
+		OwnerID: reqUser.ID,
+		Size:    source.GetSize(),
+		Type:    source.GetType(),
+		Name:    source.GetName(),
+		Parent:  parent,
+		AppID:   appID,
+	})
+	if err != nil {
+		if key != "" {
+			_, deleteErr := r.uploadClient().DeleteObjects(
+				context.Background(),
+				&upb.DeleteObjectsRequest{Bucket: reqUser.Bucket, Keys: []string{key}},
+			)
+			if deleteErr != nil {
+				err = fmt.Errorf("%v: %v", err, deleteErr)
+			}
+		}
+
+		return "", err
+	}
+
+	searchFile := &spb.File{}
+	if err := marshalSearchPB(createFileResp, searchFile); err != nil {
+		return createFileResp.GetId(), err
+	}
+
+	if _, err := r.searchClient().CreateFile(ctx, searchFile); err != nil {
+		return createFileResp.GetId(), err
+	}
+
+	newPermission := ppb.PermissionObject{
+		FileID:  createFileResp.GetId(),
+		UserID:  reqUser.ID,
+		AppID:   appID,
+		Role:    ppb.Role_WRITE,
+		Creator: reqUser.ID,
+	}
+
+	err = file.CreatePermission(ctx,
+		r.fileClient(),
+		r.permissionClient(),
+		reqUser.ID,
+		newPermission,
+	)
+
+	return createFileResp.GetId(), err
+}
+
+// copyObject copies the content of source to key in bucket, downloading it from download service
+// and uploading it to upload service. Content larger than MaxSimpleUploadSize is uploaded in parts.
+func (r *Router) copyObject(ctx context.Context, source *fpb.File, key string, bucket string) error {
+	stream, err := r.downloadClient().Download(ctx, &dpb.DownloadRequest{
+		Key:    source.GetKey(),
+		Bucket: source.GetBucket(),
+	})
+	if err != nil {
+		return err
+	}
+
+	content := file.NewDownloadReader(stream)
+	if source.GetSize() > MaxSimpleUploadSize {
+		return r.copyObjectParts(ctx, content, source, key, bucket)
+	}
+
+	fileBytes, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+
+	ureq := &upb.UploadMediaRequest{
+		Key:         key,
+		Bucket:      bucket,
+		File:        fileBytes,
+		ContentType: source.GetType(),
+	}
+
+	if _, err := r.uploadClient().UploadMedia(ctx, ureq); err != nil {
+		return err
+	}
+
+	metrics.AddStreamedBytes(metrics.DirectionUpload, len(fileBytes))
+
+	return nil
+}
+
+// copyObjectParts uploads content of source to key in bucket in a resumable upload,
+// that's aborted if uploading any part fails.
+func (r *Router) copyObjectParts(
+	ctx context.Context,
+	content io.Reader,
+	source *fpb.File,
+	key string,
+	bucket string) error {
+	initResp, err := r.uploadClient().UploadInit(ctx, &upb.UploadInitRequest{
+		Key:         key,
+		Bucket:      bucket,
+		ContentType: source.GetType(),
+	})
+	if err != nil {
+		return err
+	}
+
+	uploadID := initResp.GetUploadId()
+	bufSize := r.calculateBufSize(source.GetSize())
+	if _, err := r.uploadParts(ctx, content, bufSize, 1, uploadID, key, bucket); err != nil {
+		abortRequest := &upb.UploadAbortRequest{UploadId: uploadID, Key: key, Bucket: bucket}
+		if _, abortErr := r.uploadClient().UploadAbort(context.Background(), abortRequest); abortErr != nil {
+			err = fmt.Errorf("%v: %v", err, abortErr)
+		}
+
+		return err
+	}
+
+	_, err = r.uploadClient().UploadComplete(ctx, &upb.UploadCompleteRequest{
+		UploadId: uploadID,
+		Key:      key,
+		Bucket:   bucket,
+	})
+
+	return err
+}
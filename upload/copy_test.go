@@ -0,0 +1,146 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meateam/api-gateway/user"
+	dpb "github.com/meateam/download-service/proto"
+	fpb "github.com/meateam/file-service/proto/file"
+	upb "github.com/meateam/upload-service/proto"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+// fakeDownloadStream streams content in chunks of chunkSize bytes.
+type fakeDownloadStream struct {
+	dpb.Download_DownloadClient
+	content   string
+	chunkSize int
+}
+
+func (s *fakeDownloadStream) Recv() (*dpb.DownloadResponse, error) {
+	if len(s.content) == 0 {
+		return nil, io.EOF
+	}
+
+	n := s.chunkSize
+	if n > len(s.content) {
+		n = len(s.content)
+	}
+
+	chunk := s.content[:n]
+	s.content = s.content[n:]
+
+	return &dpb.DownloadResponse{File: []byte(chunk)}, nil
+}
+
+// fakeUploadPartStream records the parts sent to it and their numbers, and responds once it's closed.
+type fakeUploadPartStream struct {
+	upb.Upload_UploadPartClient
+	parts       []string
+	partNumbers []int64
+	closed      chan struct{}
+}
+
+func (s *fakeUploadPartStream) Send(req *upb.UploadPartRequest) error {
+	s.parts = append(s.parts, string(req.GetPart()))
+	s.partNumbers = append(s.partNumbers, req.GetPartNumber())
+	return nil
+}
+
+func (s *fakeUploadPartStream) CloseSend() error {
+	close(s.closed)
+	return nil
+}
+
+func (s *fakeUploadPartStream) Recv() (*upb.UploadPartResponse, error) {
+	<-s.closed
+	return nil, io.EOF
+}
+
+// fakeUploadClient is an upload service that uploads parts to stream.
+type fakeUploadClient struct {
+	upb.UploadClient
+	stream *fakeUploadPartStream
+}
+
+func (u *fakeUploadClient) UploadPart(context.Context, ...grpc.CallOption) (upb.Upload_UploadPartClient, error) {
+	return u.stream, nil
+}
+
+func TestRouter_UploadParts(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{name: "last part is shorter", content: "abcdefg", want: []string{"abc", "def", "g"}},
+		{name: "parts are full", content: "abcdef", want: []string{"abc", "def"}},
+		{name: "no content", content: "", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := &fakeUploadPartStream{closed: make(chan struct{})}
+			r := &Router{logger: logrus.New()}
+			r.uploadClient = func() upb.UploadClient { return &fakeUploadClient{stream: stream} }
+
+			content := strings.NewReader(tt.content)
+			uploaded, err := r.uploadParts(context.Background(), content, 3, 1, "id", "key", "bucket")
+			if err != nil {
+				t.Fatalf("failed uploading parts: %v", err)
+			}
+
+			if uploaded != int64(len(tt.content)) {
+				t.Errorf("expected %d bytes to be uploaded, got %d", len(tt.content), uploaded)
+			}
+
+			if !reflect.DeepEqual(stream.parts, tt.want) {
+				t.Errorf("expected parts %q, got %q", tt.want, stream.parts)
+			}
+		})
+	}
+}
+
+func TestRouter_LimitCopy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := &Router{logger: logrus.New(), limiter: NewUploadLimiter(nil, 100, 1, time.Minute)}
+	reqUser := &user.User{ID: "a"}
+
+	// The folder has more descendants than the files and folders that may be uploaded in the window.
+	folder := []*copyEntry{{file: &fpb.File{Id: "folder", Type: FolderContentType}}}
+	for i := 0; i < 150; i++ {
+		folder = append(folder, &copyEntry{file: &fpb.File{Id: fmt.Sprintf("file%d", i)}})
+		folder = append(folder, &copyEntry{file: &fpb.File{Id: fmt.Sprintf("folder%d", i), Type: FolderContentType}})
+	}
+
+	copyEntries := func(entries []*copyEntry) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/files/folder/copy", nil)
+		r.limitCopy(c, reqUser, entries)
+
+		return c
+	}
+
+	if c := copyEntries(folder); c.IsAborted() {
+		t.Fatalf("expected a folder larger than the limit to be copied, got status %d", c.Writer.Status())
+	}
+
+	if c := copyEntries(folder); c.Writer.Status() != http.StatusTooManyRequests {
+		t.Errorf("expected copies exceeding the limit to be rejected, got status %d", c.Writer.Status())
+	}
+
+	if c := copyEntries(folder[1:2]); c.IsAborted() {
+		t.Errorf("expected a file within the limit to be copied, got status %d", c.Writer.Status())
+	}
+}
@@ -0,0 +1,351 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meateam/api-gateway/apierror"
+	loggermiddleware "github.com/meateam/api-gateway/logger"
+	"github.com/meateam/api-gateway/user"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// BatchIDHeader is the header of the id of the batch of an upload, which is chosen by the client.
+	BatchIDHeader = "X-Batch-Id"
+
+	// UploadLimitExceededReason is the reason of the ErrorInfo detail of an upload rejected for exceeding
+	// the maximum number of uploaded files or folders.
+	UploadLimitExceededReason = "UPLOAD_LIMIT_EXCEEDED"
+
+	// maxBatchIDLength is the maximum length of the id of a batch.
+	maxBatchIDLength = 128
+
+	// uploadBatchTTL is the duration that the uploads of a batch are counted for.
+	uploadBatchTTL = 24 * time.Hour
+
+	// limitSweepInterval is the interval in which the expired keys of a memoryLimitStore are deleted.
+	limitSweepInterval = time.Minute
+)
+
+// uploadKind is the kind of the uploads that are limited.
+type uploadKind string
+
+const (
+	uploadKindFile   uploadKind = "files"
+	uploadKindFolder uploadKind = "folders"
+)
+
+// LimitStore counts the uploads under limited keys within sliding windows.
+// A store that's shared between the gateway instances limits the uploads of all of them.
+type LimitStore interface {
+	// Take counts n uploads under each of keys at now if at most limit uploads would then be counted under
+	// each key within its window before now, and reports whether they were counted. The uploads are counted
+	// under all of keys or under none of them.
+	Take(ctx context.Context, keys map[string]time.Duration, limit int, n int, now time.Time) (bool, error)
+
+	// Release uncounts n uploads that were counted under each of keys at now by Take.
+	Release(ctx context.Context, keys []string, n int, now time.Time) error
+
+	// Remaining returns the number of uploads remaining under key of limit within window before now.
+	Remaining(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (int, error)
+}
+
+// memoryLimitStore is a LimitStore that counts the uploads in memory, limiting the uploads of each instance.
+type memoryLimitStore struct {
+	mu        sync.Mutex
+	taken     map[string][]time.Time
+	expiresAt map[string]time.Time
+	sweptAt   time.Time
+}
+
+// NewMemoryLimitStore creates a LimitStore that counts the uploads in memory.
+func NewMemoryLimitStore() LimitStore {
+	return &memoryLimitStore{taken: make(map[string][]time.Time), expiresAt: make(map[string]time.Time)}
+}
+
+// Take counts n uploads under each of keys at now if all of them stay within limit.
+func (s *memoryLimitStore) Take(
+	_ context.Context,
+	keys map[string]time.Duration,
+	limit int,
+	n int,
+	now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	for key, window := range keys {
+		if len(s.window(key, window, now))+n > limit {
+			return false, nil
+		}
+	}
+
+	for key, window := range keys {
+		taken := s.window(key, window, now)
+		for i := 0; i < n; i++ {
+			taken = append(taken, now)
+		}
+
+		s.taken[key] = taken
+		if expiresAt := now.Add(window); expiresAt.After(s.expiresAt[key]) {
+			s.expiresAt[key] = expiresAt
+		}
+	}
+
+	return true, nil
+}
+
+// Release uncounts n uploads that were counted under each of keys at now.
+func (s *memoryLimitStore) Release(_ context.Context, keys []string, n int, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		taken := s.taken[key]
+		released := 0
+		for i := len(taken) - 1; i >= 0 && released < n; i-- {
+			if taken[i].Equal(now) {
+				taken = append(taken[:i], taken[i+1:]...)
+				released++
+			}
+		}
+
+		s.taken[key] = taken
+	}
+
+	return nil
+}
+
+// Remaining returns the number of uploads remaining under key of limit within window.
+func (s *memoryLimitStore) Remaining(
+	_ context.Context,
+	key string,
+	limit int,
+	window time.Duration,
+	now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if remaining := limit - len(s.window(key, window, now)); remaining > 0 {
+		return remaining, nil
+	}
+
+	return 0, nil
+}
+
+// window returns the times of the uploads counted under key within window before now.
+func (s *memoryLimitStore) window(key string, window time.Duration, now time.Time) []time.Time {
+	taken := s.taken[key]
+	for len(taken) > 0 && !taken[0].After(now.Add(-window)) {
+		taken = taken[1:]
+	}
+
+	return taken
+}
+
+// sweep deletes the keys whose uploads are all out of their windows, at most once every limitSweepInterval.
+func (s *memoryLimitStore) sweep(now time.Time) {
+	if now.Sub(s.sweptAt) < limitSweepInterval {
+		return
+	}
+
+	s.sweptAt = now
+	for key, expiresAt := range s.expiresAt {
+		if !expiresAt.After(now) {
+			delete(s.taken, key)
+			delete(s.expiresAt, key)
+		}
+	}
+}
+
+// UploadLimiter limits the number of files and folders that each user uploads within a sliding window,
+// and within each batch of uploads that share the same BatchIDHeader. A batch doesn't extend the user's
+// window, so a client can't evade the limits by changing the ids of its batches.
+type UploadLimiter struct {
+	store      LimitStore
+	maxFiles   int
+	maxFolders int
+	window     time.Duration
+}
+
+// NewUploadLimiter creates an UploadLimiter that counts the uploads in store, or in memory if it's nil.
+// Each user uploads at most maxFiles files and maxFolders folders within window and within each batch,
+// a zero maxFiles or maxFolders is unlimited.
+func NewUploadLimiter(store LimitStore, maxFiles int, maxFolders int, window time.Duration) *UploadLimiter {
+	if store == nil {
+		store = NewMemoryLimitStore()
+	}
+
+	return &UploadLimiter{store: store, maxFiles: maxFiles, maxFolders: maxFolders, window: window}
+}
+
+// limit returns the maximum number of uploads of kind, which is unlimited if it's zero.
+func (l *UploadLimiter) limit(kind uploadKind) int {
+	if kind == uploadKindFolder {
+		return l.maxFolders
+	}
+
+	return l.maxFiles
+}
+
+// uploadKindOf returns the kind of the upload of a file of mimeType.
+func uploadKindOf(mimeType string) uploadKind {
+	if mimeType == FolderContentType {
+		return uploadKindFolder
+	}
+
+	return uploadKindFile
+}
+
+// limitWindows returns the keys of the windows of uploads of kind of userID, and their durations.
+// The uploads of a batch are counted in both the user's window and the batch's window.
+func limitWindows(kind uploadKind, userID string, batchID string, window time.Duration) map[string]time.Duration {
+	windows := map[string]time.Duration{fmt.Sprintf("%s:%s", kind, userID): window}
+	if batchID != "" {
+		windows[fmt.Sprintf("%s:%s:batch:%s", kind, userID, batchID)] = uploadBatchTTL
+	}
+
+	return windows
+}
+
+// remaining returns the number of uploads of kind remaining to userID in the batch of batchID, which is -1
+// if they're unlimited.
+func (l *UploadLimiter) remaining(
+	ctx context.Context,
+	kind uploadKind,
+	userID string,
+	batchID string) (int, error) {
+	limit := l.limit(kind)
+	if limit <= 0 {
+		return -1, nil
+	}
+
+	remaining := limit
+	now := time.Now()
+	for key, window := range limitWindows(kind, userID, batchID, l.window) {
+		keyRemaining, err := l.store.Remaining(ctx, key, limit, window, now)
+		if err != nil {
+			return 0, err
+		}
+
+		if keyRemaining < remaining {
+			remaining = keyRemaining
+		}
+	}
+
+	return remaining, nil
+}
+
+// take counts n uploads of kind of userID in the batch of batchID, and reports whether they're within
+// the limits. Returns a function that uncounts the uploads, which is nil if they weren't counted.
+func (l *UploadLimiter) take(
+	ctx context.Context,
+	kind uploadKind,
+	userID string,
+	batchID string,
+	n int) (func() error, bool, error) {
+	limit := l.limit(kind)
+	if limit <= 0 || n <= 0 {
+		return func() error { return nil }, true, nil
+	}
+
+	now := time.Now()
+	windows := limitWindows(kind, userID, batchID, l.window)
+	ok, err := l.store.Take(ctx, windows, limit, n, now)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	keys := make([]string, 0, len(windows))
+	for key := range windows {
+		keys = append(keys, key)
+	}
+
+	// The uploads are uncounted with a new context, since they're uncounted once the request failed.
+	return func() error {
+		return l.store.Release(context.Background(), keys, n, now)
+	}, true, nil
+}
+
+// limitUpload counts n uploads of kind of reqUser in the batch of the BatchIDHeader of the request,
+// if uploads are limited. Returns false if the request was aborted, with 429 and the remaining counts
+// of files and folders in an ErrorInfo detail if the uploads exceed the limits. Failing to count
+// uploads doesn't fail them. The returned function must be deferred by the handler of the request,
+// it uncounts the uploads if the request was aborted, so only accepted uploads count against the limits.
+func (r *Router) limitUpload(c *gin.Context, kind uploadKind, reqUser *user.User, n int) (func(), bool) {
+	if r.limiter == nil {
+		return func() {}, true
+	}
+
+	batchID := c.GetHeader(BatchIDHeader)
+	if len(batchID) > maxBatchIDLength {
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("%s is too long", BatchIDHeader))
+		return nil, false
+	}
+
+	release, ok, err := r.limiter.take(c.Request.Context(), kind, reqUser.ID, batchID, n)
+	if err != nil {
+		loggermiddleware.LogError(r.logger, fmt.Errorf("failed counting upload of %s: %v", reqUser.ID, err))
+		return func() {}, true
+	}
+
+	if ok {
+		return func() {
+			if c.IsAborted() {
+				loggermiddleware.LogError(r.logger, release())
+			}
+		}, true
+	}
+
+	metadata := map[string]string{}
+	remainingNames := map[uploadKind]string{uploadKindFile: "remainingFiles", uploadKindFolder: "remainingFolders"}
+	for k, name := range remainingNames {
+		remaining, err := r.limiter.remaining(c.Request.Context(), k, reqUser.ID, batchID)
+		if err != nil {
+			loggermiddleware.LogError(r.logger, fmt.Errorf("failed getting remaining %s of %s: %v", k, reqUser.ID, err))
+			continue
+		}
+
+		if remaining >= 0 {
+			metadata[name] = strconv.Itoa(remaining)
+		}
+	}
+
+	if batchID != "" {
+		metadata["batchId"] = batchID
+	}
+
+	loggermiddleware.LogError(r.logger, apierror.AbortWithError(
+		c,
+		http.StatusTooManyRequests,
+		uploadLimitExceededError(kind, reqUser.ID, r.limiter.limit(kind), metadata),
+	))
+
+	return nil, false
+}
+
+// uploadLimitExceededError returns the error of an upload of kind of userID that exceeds limit,
+// with metadata in its ErrorInfo detail.
+func uploadLimitExceededError(kind uploadKind, userID string, limit int, metadata map[string]string) error {
+	message := fmt.Sprintf("at most %d %s may be uploaded in a batch or time window", limit, kind)
+	s, err := status.New(codes.ResourceExhausted, message).WithDetails(
+		&errdetails.QuotaFailure{
+			Violations: []*errdetails.QuotaFailure_Violation{{Subject: "user:" + userID, Description: message}},
+		},
+		&errdetails.ErrorInfo{Reason: UploadLimitExceededReason, Domain: quotaErrorDomain, Metadata: metadata},
+	)
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, message)
+	}
+
+	return s.Err()
+}
@@ -0,0 +1,171 @@
+package upload
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meateam/api-gateway/user"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+func TestMemoryLimitStore_Take(t *testing.T) {
+	store := NewMemoryLimitStore()
+	ctx := context.Background()
+	now := time.Now()
+	keys := map[string]time.Duration{"key": time.Minute}
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := store.Take(ctx, keys, 2, 1, now.Add(time.Duration(i)*time.Second)); !ok {
+			t.Fatalf("expected upload %d within the limit to be counted", i)
+		}
+	}
+
+	if ok, _ := store.Take(ctx, keys, 2, 1, now.Add(59*time.Second)); ok {
+		t.Error("expected an upload exceeding the limit within the window to be rejected")
+	}
+
+	if ok, _ := store.Take(ctx, keys, 2, 1, now.Add(time.Minute)); !ok {
+		t.Error("expected an upload after the first left the window to be counted")
+	}
+
+	if remaining, _ := store.Remaining(ctx, "key", 2, time.Minute, now.Add(time.Minute)); remaining != 0 {
+		t.Errorf("Remaining() = %d, want 0", remaining)
+	}
+
+	if remaining, _ := store.Remaining(ctx, "other", 2, time.Minute, now); remaining != 2 {
+		t.Errorf("Remaining() = %d, want 2", remaining)
+	}
+}
+
+func TestMemoryLimitStore_TakeAllOrNone(t *testing.T) {
+	store := NewMemoryLimitStore()
+	ctx := context.Background()
+	now := time.Now()
+
+	if ok, _ := store.Take(ctx, map[string]time.Duration{"a": time.Minute}, 3, 2, now); !ok {
+		t.Fatal("expected uploads within the limit to be counted")
+	}
+
+	keys := map[string]time.Duration{"a": time.Minute, "b": time.Minute}
+	if ok, _ := store.Take(ctx, keys, 3, 2, now); ok {
+		t.Fatal("expected uploads exceeding the limit of a key to be rejected")
+	}
+
+	if remaining, _ := store.Remaining(ctx, "b", 3, time.Minute, now); remaining != 3 {
+		t.Errorf("expected rejected uploads not to be counted under any key, got %d remaining", remaining)
+	}
+
+	if ok, _ := store.Take(ctx, keys, 3, 1, now); !ok {
+		t.Fatal("expected uploads within the limits of all keys to be counted")
+	}
+
+	if err := store.Release(ctx, []string{"a", "b"}, 1, now); err != nil {
+		t.Fatalf("failed releasing: %v", err)
+	}
+
+	if remaining, _ := store.Remaining(ctx, "a", 3, time.Minute, now); remaining != 1 {
+		t.Errorf("expected a released upload to be uncounted, got %d remaining", remaining)
+	}
+
+	if remaining, _ := store.Remaining(ctx, "b", 3, time.Minute, now); remaining != 3 {
+		t.Errorf("expected a released upload to be uncounted, got %d remaining", remaining)
+	}
+}
+
+func TestRouter_LimitUpload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := &Router{logger: logrus.New(), limiter: NewUploadLimiter(nil, 2, 1, time.Minute)}
+	reqUser := &user.User{ID: "a"}
+
+	limit := func(kind uploadKind, batchID string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/upload", nil)
+		if batchID != "" {
+			c.Request.Header.Set(BatchIDHeader, batchID)
+		}
+
+		r.limitUpload(c, kind, reqUser, 1)
+
+		return c
+	}
+
+	limit(uploadKindFile, "1")
+	limit(uploadKindFolder, "1")
+	if c := limit(uploadKindFolder, "2"); c.Writer.Status() != http.StatusTooManyRequests {
+		t.Errorf("expected a new batch not to evade the window limit, got status %d", c.Writer.Status())
+	}
+
+	c := limit(uploadKindFile, "2")
+	if c.IsAborted() {
+		t.Fatalf("expected a file within the limits to be uploaded, got status %d", c.Writer.Status())
+	}
+
+	c = limit(uploadKindFile, "")
+	if c.Writer.Status() != http.StatusTooManyRequests {
+		t.Fatalf("limitUpload() status = %d, want %d", c.Writer.Status(), http.StatusTooManyRequests)
+	}
+
+	s, _ := status.FromError(c.Errors.Last().Err)
+	info, ok := s.Details()[len(s.Details())-1].(*errdetails.ErrorInfo)
+	if !ok {
+		t.Fatalf("expected an error info detail, got %v", s.Details())
+	}
+
+	if info.GetMetadata()["remainingFiles"] != "0" || info.GetMetadata()["remainingFolders"] != "0" {
+		t.Errorf("expected no remaining files and folders, got %v", info.GetMetadata())
+	}
+
+	r.limiter = NewUploadLimiter(nil, 2, 1, 0)
+	limit(uploadKindFile, "1")
+	limit(uploadKindFile, "1")
+	if c := limit(uploadKindFile, "1"); c.Writer.Status() != http.StatusTooManyRequests {
+		t.Errorf("expected a batch exceeding the limit to be rejected, got status %d", c.Writer.Status())
+	}
+
+	if c := limit(uploadKindFile, "2"); c.IsAborted() {
+		t.Errorf("expected a new batch to be uploaded without a window, got status %d", c.Writer.Status())
+	}
+}
+
+func TestRouter_LimitUploadUncountsFailed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := &Router{logger: logrus.New(), limiter: NewUploadLimiter(nil, 2, 1, time.Minute)}
+	reqUser := &user.User{ID: "a"}
+
+	upload := func(n int, fail bool) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/upload", nil)
+
+		uncountFailed, ok := r.limitUpload(c, uploadKindFile, reqUser, n)
+		if ok {
+			if fail {
+				c.AbortWithStatus(http.StatusInternalServerError)
+			}
+
+			uncountFailed()
+		}
+
+		return c
+	}
+
+	if c := upload(3, false); c.Writer.Status() != http.StatusTooManyRequests {
+		t.Fatalf("expected uploads exceeding the limit at once to be rejected, got status %d", c.Writer.Status())
+	}
+
+	upload(2, true)
+	if c := upload(2, false); c.IsAborted() {
+		t.Fatalf("expected failed uploads to be uncounted, got status %d", c.Writer.Status())
+	}
+
+	if c := upload(1, false); c.Writer.Status() != http.StatusTooManyRequests {
+		t.Errorf("expected accepted uploads to be counted, got status %d", c.Writer.Status())
+	}
+}
@@ -0,0 +1,133 @@
+package upload
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
+	loggermiddleware "github.com/meateam/api-gateway/logger"
+	qpb "github.com/meateam/file-service/proto/quota"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// QuotaExceededReason is the reason of the ErrorInfo detail of a request rejected for exceeding the quota.
+	QuotaExceededReason = "QUOTA_EXCEEDED"
+
+	// quotaErrorDomain is the domain of the ErrorInfo detail of a request rejected for exceeding the quota.
+	quotaErrorDomain = "api-gateway"
+)
+
+// quotaReservations are the bytes of quota reserved by the in-flight uploads of each owner.
+// File service charges the size of an upload or a file to the owner's used quota when it creates it, and
+// refunds it when an upload is deleted, which deleteUploadOnError relies on to return the quota of a failed
+// upload. So a reservation is held from the quota check until the upload or file is created, and it's not
+// kept for the lifetime of a resumable upload, which would charge the upload twice.
+type quotaReservations struct {
+	mu       sync.Mutex
+	reserved map[string]int64
+}
+
+// reserve reserves size bytes of ownerID and returns the bytes reserved by ownerID before them.
+func (q *quotaReservations) reserve(ownerID string, size int64) int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.reserved == nil {
+		q.reserved = make(map[string]int64)
+	}
+
+	reserved := q.reserved[ownerID]
+	q.reserved[ownerID] = reserved + size
+
+	return reserved
+}
+
+// release releases size bytes reserved by ownerID.
+func (q *quotaReservations) release(ownerID string, size int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.reserved[ownerID] -= size
+	if q.reserved[ownerID] <= 0 {
+		delete(q.reserved, ownerID)
+	}
+}
+
+// reserveQuota checks that ownerID has size bytes of quota remaining for action, counting the bytes reserved
+// by the in-flight uploads of ownerID, and reserves them. Returns a function that releases the reservation,
+// which must be called once the upload is counted in the used quota or failed. If the quota is exceeded then
+// the request is aborted with 507 and a QuotaFailure and ErrorInfo details, and false is returned.
+// The bytes are reserved before the quota is fetched, so no lock is held across the fetch: the reservations
+// of ownerID that weren't released by then are counted in the reserved bytes, and the ones that were are counted
+// in the used quota. A reservation released while the quota is fetched may be counted in both, which only rejects
+// an upload at the edge of the quota.
+func (r *Router) reserveQuota(c *gin.Context, ownerID string, action string, size int64) (func(), bool) {
+	reserved := r.quotaReservations.reserve(ownerID, size)
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			r.quotaReservations.release(ownerID, size)
+		})
+	}
+
+	quota, err := r.quotaClient().GetOwnerQuota(c.Request.Context(), &qpb.GetOwnerQuotaRequest{OwnerID: ownerID})
+	if err != nil {
+		release()
+
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+		return nil, false
+	}
+
+	if remaining := quota.GetLimit() - quota.GetUsed() - reserved; size > remaining {
+		release()
+
+		if remaining < 0 {
+			remaining = 0
+		}
+
+		loggermiddleware.LogError(r.logger, apierror.AbortWithError(
+			c,
+			http.StatusInsufficientStorage,
+			quotaExceededError(ownerID, action, size, remaining, quota.GetLimit()),
+		))
+
+		return nil, false
+	}
+
+	return release, true
+}
+
+// quotaExceededError returns the error of action of ownerID that requires size bytes,
+// when only remaining bytes of its quota limit remain.
+func quotaExceededError(ownerID string, action string, size int64, remaining int64, limit int64) error {
+	message := fmt.Sprintf("%s requires %d bytes, only %d bytes of quota remain", action, size, remaining)
+	s, err := status.New(codes.ResourceExhausted, message).WithDetails(
+		&errdetails.QuotaFailure{
+			Violations: []*errdetails.QuotaFailure_Violation{{Subject: "owner:" + ownerID, Description: message}},
+		},
+		&errdetails.ErrorInfo{
+			Reason: QuotaExceededReason,
+			Domain: quotaErrorDomain,
+			Metadata: map[string]string{
+				"required":  strconv.FormatInt(size, 10),
+				"remaining": strconv.FormatInt(remaining, 10),
+				"limit":     strconv.FormatInt(limit, 10),
+			},
+		},
+	)
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, message)
+	}
+
+	return s.Err()
+}
@@ -0,0 +1,144 @@
+package upload
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	qpb "github.com/meateam/file-service/proto/quota"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// blockingQuotaClient is a fakeQuotaClient whose first fetch of the quota blocks until unblock is closed,
+// fetching is closed once it's blocked.
+type blockingQuotaClient struct {
+	fakeQuotaClient
+	calls    int32
+	fetching chan struct{}
+	unblock  chan struct{}
+}
+
+func (q *blockingQuotaClient) GetOwnerQuota(
+	ctx context.Context,
+	in *qpb.GetOwnerQuotaRequest,
+	opts ...grpc.CallOption) (*qpb.GetOwnerQuotaResponse, error) {
+	if atomic.AddInt32(&q.calls, 1) == 1 {
+		close(q.fetching)
+		<-q.unblock
+	}
+
+	return q.fakeQuotaClient.GetOwnerQuota(ctx, in, opts...)
+}
+
+// fakeQuotaClient returns the quota of every owner as limit and used.
+type fakeQuotaClient struct {
+	qpb.QuotaServiceClient
+	limit int64
+	used  int64
+}
+
+func (q *fakeQuotaClient) GetOwnerQuota(
+	_ context.Context,
+	in *qpb.GetOwnerQuotaRequest,
+	_ ...grpc.CallOption) (*qpb.GetOwnerQuotaResponse, error) {
+	return &qpb.GetOwnerQuotaResponse{OwnerID: in.GetOwnerID(), Limit: q.limit, Used: q.used}, nil
+}
+
+func TestRouter_ReserveQuota(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	quota := &fakeQuotaClient{limit: 100, used: 40}
+	r := &Router{logger: logrus.New()}
+	r.quotaClient = func() qpb.QuotaServiceClient { return quota }
+
+	reserve := func(ownerID string, size int64) (*gin.Context, func(), bool) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/upload", nil)
+		release, ok := r.reserveQuota(c, ownerID, "upload", size)
+
+		return c, release, ok
+	}
+
+	_, release, ok := reserve("a", 50)
+	if !ok {
+		t.Fatal("expected an upload within the quota to be reserved")
+	}
+
+	c, _, ok := reserve("a", 20)
+	if ok {
+		t.Fatal("expected an upload exceeding the quota remaining after the in-flight upload to be rejected")
+	}
+
+	if c.Writer.Status() != http.StatusInsufficientStorage {
+		t.Errorf("reserveQuota() status = %d, want %d", c.Writer.Status(), http.StatusInsufficientStorage)
+	}
+
+	s, _ := status.FromError(c.Errors.Last().Err)
+	if len(s.Details()) != 2 {
+		t.Fatalf("expected the quota failure and error info details, got %v", s.Details())
+	}
+
+	if info, ok := s.Details()[1].(*errdetails.ErrorInfo); !ok || info.GetMetadata()["remaining"] != "10" {
+		t.Errorf("expected 10 remaining bytes in the error info, got %v", s.Details()[1])
+	}
+
+	if _, _, ok := reserve("b", 60); !ok {
+		t.Error("expected the in-flight upload not to be counted against another owner")
+	}
+
+	release()
+	release()
+
+	if _, _, ok := reserve("a", 60); !ok {
+		t.Error("expected a released reservation not to be counted")
+	}
+
+	if _, _, ok := reserve("a", 1); ok {
+		t.Error("expected a reservation released twice to be released once")
+	}
+}
+
+func TestRouter_ReserveQuotaDuringFetch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	quota := &blockingQuotaClient{
+		fakeQuotaClient: fakeQuotaClient{limit: 100, used: 40},
+		fetching:        make(chan struct{}),
+		unblock:         make(chan struct{}),
+	}
+	r := &Router{logger: logrus.New()}
+	r.quotaClient = func() qpb.QuotaServiceClient { return quota }
+
+	reserve := func(size int64) bool {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/upload", nil)
+		_, ok := r.reserveQuota(c, "a", "upload", size)
+
+		return ok
+	}
+
+	first := make(chan bool)
+	go func() {
+		first <- reserve(50)
+	}()
+
+	<-quota.fetching
+	if reserve(20) {
+		t.Error("expected an upload exceeding the quota remaining after the upload being checked to be rejected")
+	}
+
+	if !reserve(10) {
+		t.Error("expected an upload within the quota to be reserved while another upload's quota is fetched")
+	}
+
+	close(quota.unblock)
+	if !<-first {
+		t.Error("expected the first upload within the quota to be reserved")
+	}
+}
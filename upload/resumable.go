@@ -0,0 +1,272 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
+	loggermiddleware "github.com/meateam/api-gateway/logger"
+	"github.com/meateam/api-gateway/metrics"
+	fpb "github.com/meateam/file-service/proto/file"
+	upb "github.com/meateam/upload-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ParamUploadID is the name of the upload id param in URL.
+	ParamUploadID = "uploadId"
+
+	// uploadLostMessage is the message responded when a resumable upload no longer exists in upload service.
+	uploadLostMessage = "the upload no longer exists and must be restarted"
+)
+
+// PartRange is a range of bytes of a resumable upload that were committed to upload service.
+// Start and End are inclusive, the same as in the Content-Range header the range was uploaded with.
+type PartRange struct {
+	UploadID    string    `json:"uploadId"`
+	Start       int64     `json:"start"`
+	End         int64     `json:"end"`
+	Size        int64     `json:"size"`
+	CommittedAt time.Time `json:"committedAt"`
+}
+
+// PartStore stores the ranges of bytes committed to the resumable uploads,
+// so an interrupted upload is resumed from its committed ranges.
+type PartStore interface {
+	// Add adds partRange to the committed ranges of its upload.
+	Add(ctx context.Context, partRange *PartRange) error
+
+	// List returns the committed ranges of uploadID, in any order and possibly overlapping.
+	List(ctx context.Context, uploadID string) ([]*PartRange, error)
+
+	// Remove removes the committed ranges of uploadID.
+	Remove(ctx context.Context, uploadID string) error
+}
+
+// memPartStore is a PartStore in memory.
+type memPartStore struct {
+	mu     sync.Mutex
+	ranges map[string][]*PartRange
+}
+
+// NewMemoryPartStore creates a PartStore that stores the committed ranges in memory,
+// so uploads are only resumed through the gateway instance that committed their ranges.
+func NewMemoryPartStore() PartStore {
+	return &memPartStore{ranges: make(map[string][]*PartRange)}
+}
+
+// Add adds partRange to the committed ranges of its upload.
+func (s *memPartStore) Add(_ context.Context, partRange *PartRange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ranges[partRange.UploadID] = append(s.ranges[partRange.UploadID], partRange)
+
+	return nil
+}
+
+// List returns the committed ranges of uploadID.
+func (s *memPartStore) List(_ context.Context, uploadID string) ([]*PartRange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*PartRange(nil), s.ranges[uploadID]...), nil
+}
+
+// Remove removes the committed ranges of uploadID.
+func (s *memPartStore) Remove(_ context.Context, uploadID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.ranges, uploadID)
+
+	return nil
+}
+
+// ByteRange is an inclusive range of bytes.
+type ByteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// uploadStatus is the structure of the response of a resumable upload's status.
+type uploadStatus struct {
+	UploadID  string      `json:"uploadId"`
+	Size      int64       `json:"size"`
+	PartSize  int64       `json:"partSize"`
+	Committed []ByteRange `json:"committed"`
+}
+
+// mergeRanges returns the sorted union of ranges, with overlapping and adjacent ranges merged.
+func mergeRanges(ranges []*PartRange) []ByteRange {
+	merged := make([]ByteRange, 0, len(ranges))
+	for _, partRange := range ranges {
+		merged = append(merged, ByteRange{Start: partRange.Start, End: partRange.End})
+	}
+
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Start < merged[j].Start })
+
+	n := 0
+	for _, byteRange := range merged {
+		if n > 0 && byteRange.Start <= merged[n-1].End+1 {
+			if byteRange.End > merged[n-1].End {
+				merged[n-1].End = byteRange.End
+			}
+
+			continue
+		}
+
+		merged[n] = byteRange
+		n++
+	}
+
+	return merged[:n]
+}
+
+// isComplete reports whether the merged ranges cover all size bytes.
+func isComplete(ranges []ByteRange, size int64) bool {
+	return len(ranges) == 1 && ranges[0].Start == 0 && ranges[0].End == size-1
+}
+
+// isUploadLost reports whether err of upload service means the upload no longer exists,
+// so its parts can't be retried and it has to be restarted.
+func isUploadLost(err error) bool {
+	return status.Code(err) == codes.NotFound || strings.Contains(err.Error(), "NoSuchUpload")
+}
+
+// UploadStatus is the request handler for GET /upload/:uploadId/status request.
+// It responds with the size of the resumable upload, the size of its parts and its committed ranges,
+// so an interrupted upload is resumed by uploading the rest of its bytes. Ranges are resumed from
+// a multiple of the part size, and must end at a multiple of the part size or at the end of the upload.
+func (r *Router) UploadStatus(c *gin.Context) {
+	uploadID := c.Param(ParamUploadID)
+	upload, err := r.fileClient().GetUploadByID(c.Request.Context(), &fpb.GetUploadByIDRequest{UploadID: uploadID})
+	if err != nil {
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+		return
+	}
+
+	ranges, err := r.parts.List(c.Request.Context(), upload.GetUploadID())
+	if err != nil {
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusInternalServerError, err))
+		return
+	}
+
+	uploadStatus := uploadStatus{UploadID: upload.GetUploadID(), Committed: mergeRanges(ranges)}
+	if len(ranges) != 0 {
+		uploadStatus.Size = ranges[0].Size
+		uploadStatus.PartSize = r.calculateBufSize(uploadStatus.Size)
+	}
+
+	c.JSON(http.StatusOK, uploadStatus)
+}
+
+// contentError is an error reading the content of a request, which is caused by the client.
+type contentError struct {
+	err error
+}
+
+func (e *contentError) Error() string {
+	return fmt.Sprintf("failed reading content: %v", e.err)
+}
+
+// uploadParts streams content to uploadID in parts of bufSize bytes numbered from firstPartNumber, and returns
+// once upload service has received all of them. Returns the number of bytes of the parts that upload service
+// has stored, which is 0 with a non-nil error if uploading any part failed. If reading content failed then
+// the parts that were read are still stored, and a *contentError is returned with their number of bytes.
+func (r *Router) uploadParts(
+	ctx context.Context,
+	content io.Reader,
+	bufSize int64,
+	firstPartNumber int64,
+	uploadID string,
+	key string,
+	bucket string) (int64, error) {
+	stream, err := r.uploadClient().UploadPart(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			partResponse, err := stream.Recv()
+			if err == io.EOF {
+				errc <- nil
+				return
+			}
+
+			if err != nil {
+				errc <- err
+				return
+			}
+
+			if partResponse.GetCode() == http.StatusInternalServerError {
+				errc <- errors.New(partResponse.GetMessage())
+				return
+			}
+		}
+	}()
+
+	var sent int64
+	var contentErr error
+	buf := make([]byte, bufSize)
+	for partNumber := firstPartNumber; ; partNumber++ {
+		bytesRead, readErr := io.ReadFull(content, buf)
+		if readErr == io.EOF || (bytesRead == 0 && readErr == io.ErrUnexpectedEOF) {
+			break
+		}
+
+		if readErr != nil && readErr != io.ErrUnexpectedEOF {
+			contentErr = &contentError{err: readErr}
+			break
+		}
+
+		partRequest := &upb.UploadPartRequest{
+			Part:       buf[:bytesRead],
+			Key:        key,
+			Bucket:     bucket,
+			PartNumber: partNumber,
+			UploadId:   uploadID,
+		}
+
+		// Send returns io.EOF when the stream was closed by upload service, with the error received.
+		if err := stream.Send(partRequest); err != nil {
+			if recvErr := <-errc; err == io.EOF && recvErr != nil {
+				return 0, recvErr
+			}
+
+			return 0, err
+		}
+
+		metrics.AddStreamedBytes(metrics.DirectionUpload, bytesRead)
+		sent += int64(bytesRead)
+
+		if readErr == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return 0, err
+	}
+
+	// The responses of the parts are received once all of them were handled by upload service.
+	if err := <-errc; err != nil {
+		return 0, err
+	}
+
+	return sent, contentErr
+}
@@ -0,0 +1,222 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meateam/api-gateway/file"
+	dpb "github.com/meateam/download-service/proto"
+	fpb "github.com/meateam/file-service/proto/file"
+	upb "github.com/meateam/upload-service/proto"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMergeRanges(t *testing.T) {
+	ranges := []*PartRange{
+		{Start: 20, End: 29},
+		{Start: 0, End: 9},
+		{Start: 10, End: 14},
+		{Start: 5, End: 9},
+		{Start: 40, End: 49},
+	}
+
+	want := []ByteRange{{Start: 0, End: 14}, {Start: 20, End: 29}, {Start: 40, End: 49}}
+	if got := mergeRanges(ranges); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeRanges() = %v, want %v", got, want)
+	}
+
+	if !isComplete(mergeRanges([]*PartRange{{Start: 5, End: 9}, {Start: 0, End: 4}}), 10) {
+		t.Error("expected ranges covering all bytes to be complete")
+	}
+
+	if isComplete(mergeRanges(ranges), 50) {
+		t.Error("expected ranges with gaps not to be complete")
+	}
+}
+
+func TestRouter_ParsePartRange(t *testing.T) {
+	const size = 3 * MinPartUploadSize
+
+	tests := []struct {
+		name         string
+		contentRange string
+		committed    []*PartRange
+		wantErr      bool
+	}{
+		{name: "whole file", contentRange: "bytes 0-15728639/15728640"},
+		{name: "aligned part", contentRange: "bytes 5242880-10485759/15728640"},
+		{name: "last part", contentRange: "bytes 10485760-15728639/15728640"},
+		{name: "missing", contentRange: "", wantErr: true},
+		{name: "malformed", contentRange: "bytes 0-/15728640", wantErr: true},
+		{name: "beyond the size", contentRange: "bytes 0-15728640/15728640", wantErr: true},
+		{name: "unaligned start", contentRange: "bytes 1-5242880/15728640", wantErr: true},
+		{name: "unaligned end", contentRange: "bytes 0-5242878/15728640", wantErr: true},
+		{
+			name:         "size changed",
+			contentRange: "bytes 5242880-10485759/15728640",
+			committed:    []*PartRange{{UploadID: "id", Start: 0, End: 5242879, Size: size + 1}},
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Router{logger: logrus.New(), parts: NewMemoryPartStore()}
+			for _, partRange := range tt.committed {
+				if err := r.parts.Add(context.Background(), partRange); err != nil {
+					t.Fatalf("failed committing range: %v", err)
+				}
+			}
+
+			partRange, bufSize, err := r.parsePartRange(context.Background(), tt.contentRange, "id")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePartRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && (partRange.Size != size || bufSize != MinPartUploadSize) {
+				t.Errorf("parsePartRange() = size %d in parts of %d, want size %d in parts of %d",
+					partRange.Size, bufSize, size, MinPartUploadSize)
+			}
+		})
+	}
+}
+
+func TestRouter_AbortUploadPart(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{name: "content ended", err: &contentError{err: errors.New("EOF")}, wantStatus: http.StatusBadRequest},
+		{name: "unavailable", err: status.Error(codes.Unavailable, "down"), wantStatus: http.StatusServiceUnavailable},
+		{name: "part failed", err: errors.New("SlowDown"), wantStatus: http.StatusInternalServerError},
+		{name: "invalid request", err: status.Error(codes.InvalidArgument, "bad"), wantStatus: http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Router{logger: logrus.New(), parts: NewMemoryPartStore()}
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+			r.abortUploadPart(c, tt.err, nil)
+
+			if c.Writer.Status() != tt.wantStatus {
+				t.Errorf("abortUploadPart() status = %d, want %d", c.Writer.Status(), tt.wantStatus)
+			}
+		})
+	}
+}
+
+// fakeObjectDownloadClient is a download service whose objects all have content.
+type fakeObjectDownloadClient struct {
+	dpb.DownloadClient
+	content string
+}
+
+func (d fakeObjectDownloadClient) Download(
+	context.Context,
+	*dpb.DownloadRequest,
+	...grpc.CallOption) (dpb.Download_DownloadClient, error) {
+	return &fakeDownloadStream{content: d.content, chunkSize: 3}, nil
+}
+
+// fakeDeleteObjectsClient is an upload service that records the keys of the deleted objects.
+type fakeDeleteObjectsClient struct {
+	upb.UploadClient
+	deleted []string
+}
+
+func (u *fakeDeleteObjectsClient) DeleteObjects(
+	_ context.Context,
+	in *upb.DeleteObjectsRequest,
+	_ ...grpc.CallOption) (*upb.DeleteObjectsResponse, error) {
+	u.deleted = append(u.deleted, in.GetKeys()...)
+	return &upb.DeleteObjectsResponse{}, nil
+}
+
+// fakeDeleteUploadFileClient is a file service that records the ids of the deleted uploads.
+type fakeDeleteUploadFileClient struct {
+	fpb.FileServiceClient
+	deleted []string
+}
+
+func (f *fakeDeleteUploadFileClient) DeleteUploadByID(
+	_ context.Context,
+	in *fpb.DeleteUploadByIDRequest,
+	_ ...grpc.CallOption) (*fpb.DeleteUploadByIDResponse, error) {
+	f.deleted = append(f.deleted, in.GetUploadID())
+	return &fpb.DeleteUploadByIDResponse{}, nil
+}
+
+func TestRouter_VerifyCompletedUpload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	hasher := file.NewHasher()
+	hasher.Write([]byte("the quick brown fox"))
+	want := hasher.Checksum("key")
+
+	tests := []struct {
+		name        string
+		content     string
+		wantOK      bool
+		wantDeleted bool
+	}{
+		{name: "matching content", content: "the quick brown fox", wantOK: true},
+		{name: "mismatching content", content: "the quick brown cat", wantDeleted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploadClient := &fakeDeleteObjectsClient{}
+			fileClient := &fakeDeleteUploadFileClient{}
+			r := &Router{logger: logrus.New(), parts: NewMemoryPartStore()}
+			r.downloadClient = func() dpb.DownloadClient { return fakeObjectDownloadClient{content: tt.content} }
+			r.uploadClient = func() upb.UploadClient { return uploadClient }
+			r.fileClient = func() fpb.FileServiceClient { return fileClient }
+
+			ctx := context.Background()
+			if err := r.parts.Add(ctx, &PartRange{UploadID: "upload", Start: 0, End: 18, Size: 19}); err != nil {
+				t.Fatalf("failed adding range: %v", err)
+			}
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/upload", nil)
+			upload := &fpb.GetUploadByIDResponse{UploadID: "upload", Key: "key", Bucket: "bucket"}
+
+			checksum, ok := r.verifyCompletedUpload(c, upload, &file.Checksum{SHA256: want.SHA256})
+			if ok != tt.wantOK {
+				t.Fatalf("verifyCompletedUpload() ok = %v, want %v", ok, tt.wantOK)
+			}
+
+			if ok && (checksum.MD5 != want.MD5 || checksum.SHA256 != want.SHA256) {
+				t.Errorf("expected the checksum of the object %v, got %v", want, checksum)
+			}
+
+			if !ok && c.Writer.Status() != http.StatusBadRequest {
+				t.Errorf("expected status %d, got %d", http.StatusBadRequest, c.Writer.Status())
+			}
+
+			deleted := len(uploadClient.deleted) == 1 && len(fileClient.deleted) == 1
+			if deleted != tt.wantDeleted {
+				t.Errorf("expected the object and upload to be deleted: %v, got objects %v and uploads %v",
+					tt.wantDeleted, uploadClient.deleted, fileClient.deleted)
+			}
+
+			ranges, _ := r.parts.List(ctx, "upload")
+			if (len(ranges) == 0) != tt.wantDeleted {
+				t.Errorf("expected the committed ranges to be removed: %v, got %v", tt.wantDeleted, ranges)
+			}
+		})
+	}
+}
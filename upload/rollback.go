@@ -5,6 +5,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
 	loggermiddleware "github.com/meateam/api-gateway/logger"
 	fpb "github.com/meateam/file-service/proto/file"
 	"google.golang.org/grpc/status"
@@ -34,5 +35,5 @@ func (r *Router) deleteUploadOnErrorWithStatus(c *gin.Context, status int, err e
 		err = fmt.Errorf("%v: %v", err, deleteErr)
 	}
 
-	loggermiddleware.LogError(r.logger, c.AbortWithError(status, err))
+	loggermiddleware.LogError(r.logger, apierror.Abort(c, status, err))
 }
@@ -0,0 +1,683 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"  // nolint: gosec
+	"crypto/sha1" // nolint: gosec
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
+	"github.com/meateam/api-gateway/file"
+	loggermiddleware "github.com/meateam/api-gateway/logger"
+	"github.com/meateam/api-gateway/oauth"
+	"github.com/meateam/api-gateway/user"
+	dpb "github.com/meateam/download-service/proto"
+	fpb "github.com/meateam/file-service/proto/file"
+	upb "github.com/meateam/upload-service/proto"
+	uuid "github.com/satori/go.uuid"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// TusVersion is the version of the tus resumable upload protocol that is supported.
+	TusVersion = "1.0.0"
+
+	// TusExtensions are the extensions of the tus protocol that are supported.
+	TusExtensions = "creation,termination,checksum"
+
+	// TusChecksumAlgorithms are the algorithms of the checksums of the tus checksum extension that are supported.
+	TusChecksumAlgorithms = "md5,sha1,sha256"
+
+	// TusResumableHeader is the header of the tus protocol version of a request or response.
+	TusResumableHeader = "Tus-Resumable"
+
+	// TusVersionHeader is the header of the supported tus protocol versions.
+	TusVersionHeader = "Tus-Version"
+
+	// TusExtensionHeader is the header of the supported tus extensions.
+	TusExtensionHeader = "Tus-Extension"
+
+	// TusChecksumAlgorithmHeader is the header of the supported checksum algorithms.
+	TusChecksumAlgorithmHeader = "Tus-Checksum-Algorithm"
+
+	// UploadOffsetHeader is the header of the offset of a tus upload.
+	UploadOffsetHeader = "Upload-Offset"
+
+	// UploadLengthHeader is the header of the size of a tus upload.
+	UploadLengthHeader = "Upload-Length"
+
+	// UploadDeferLengthHeader is the header of a tus upload whose size isn't known yet.
+	UploadDeferLengthHeader = "Upload-Defer-Length"
+
+	// UploadMetadataHeader is the header of the metadata of a tus upload.
+	UploadMetadataHeader = "Upload-Metadata"
+
+	// UploadChecksumHeader is the header of the checksum of the content of a tus PATCH request.
+	UploadChecksumHeader = "Upload-Checksum"
+
+	// FileIDCustomHeader is the header of the id of the file created by a completed tus upload.
+	FileIDCustomHeader = "x-file-id"
+
+	// TusContentType is the content type of the content of a tus PATCH request.
+	TusContentType = "application/offset+octet-stream"
+
+	// StatusChecksumMismatch is the status of a tus PATCH request whose content doesn't match its checksum.
+	StatusChecksumMismatch = 460
+
+	// ParamTusUploadID is the name of the tus upload id param in URL.
+	ParamTusUploadID = "uploadId"
+
+	// tusUploadNotFoundMessage is the message responded when a tus upload doesn't exist.
+	tusUploadNotFoundMessage = "upload not found"
+)
+
+// ErrTusUploadNotFound is returned by a TusStore when a tus upload doesn't exist.
+var ErrTusUploadNotFound = errors.New(tusUploadNotFoundMessage)
+
+// TusUpload is the state of a tus upload, mapped to a resumable upload of file service and upload service.
+// The content is uploaded in parts of PartSize bytes, and the part numbers of the content are derived from
+// its offset. The last Staged bytes before Offset, that are shorter than a part, are kept in a staging object
+// until they're uploaded as a part with the content that follows them, so content of any size is accepted.
+type TusUpload struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	UploadID  string    `json:"uploadId"`
+	Key       string    `json:"key"`
+	Bucket    string    `json:"bucket"`
+	Name      string    `json:"name"`
+	Parent    string    `json:"parent"`
+	Metadata  string    `json:"metadata"`
+	Length    int64     `json:"length"`
+	Offset    int64     `json:"offset"`
+	Staged    int64     `json:"staged"`
+	PartSize  int64     `json:"partSize"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// TusStore stores the state of the tus uploads.
+type TusStore interface {
+	// Create adds upload to the store.
+	Create(ctx context.Context, upload *TusUpload) error
+
+	// Get returns the tus upload of id, or ErrTusUploadNotFound if it doesn't exist.
+	Get(ctx context.Context, id string) (*TusUpload, error)
+
+	// SetOffset sets the offset of the tus upload of id, and the number of bytes before it that are staged.
+	SetOffset(ctx context.Context, id string, offset int64, staged int64) error
+
+	// Remove removes the tus upload of id, if it exists.
+	Remove(ctx context.Context, id string) error
+}
+
+// tusLocks locks the tus uploads that are being patched, so concurrent PATCH requests of an upload conflict.
+type tusLocks struct {
+	mu     sync.Mutex
+	locked map[string]bool
+}
+
+// lock locks id and reports whether it wasn't locked already.
+func (l *tusLocks) lock(id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.locked == nil {
+		l.locked = make(map[string]bool)
+	}
+
+	if l.locked[id] {
+		return false
+	}
+
+	l.locked[id] = true
+
+	return true
+}
+
+// unlock unlocks id.
+func (l *tusLocks) unlock(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.locked, id)
+}
+
+// TusSetup initializes the tus routes under rg.
+func (r *Router) TusSetup(rg *gin.RouterGroup) {
+	checkUploadScope := r.oAuthMiddleware.AuthorizationScopeMiddleware(oauth.UploadScope)
+
+	tus := rg.Group("/upload/tus", checkUploadScope, tusResumableMiddleware)
+	tus.OPTIONS("", r.TusOptions)
+	tus.POST("", r.TusCreate)
+	tus.HEAD("/:"+ParamTusUploadID, r.TusHead)
+	tus.PATCH("/:"+ParamTusUploadID, r.TusPatch)
+	tus.DELETE("/:"+ParamTusUploadID, r.TusTerminate)
+}
+
+// tusResumableMiddleware sets the tus protocol version on the responses, and rejects requests
+// of other versions with 412. OPTIONS requests are exempt, since they discover the supported versions.
+func tusResumableMiddleware(c *gin.Context) {
+	c.Header(TusResumableHeader, TusVersion)
+
+	if c.Request.Method != http.MethodOptions && c.GetHeader(TusResumableHeader) != TusVersion {
+		c.Header(TusVersionHeader, TusVersion)
+		apierror.AbortWithMessage(
+			c,
+			http.StatusPreconditionFailed,
+			fmt.Sprintf("%s %s is required", TusResumableHeader, TusVersion),
+		)
+
+		return
+	}
+
+	c.Next()
+}
+
+// TusOptions is the request handler for OPTIONS /upload/tus request.
+// It responds with the supported tus versions, extensions and checksum algorithms.
+func (r *Router) TusOptions(c *gin.Context) {
+	c.Header(TusVersionHeader, TusVersion)
+	c.Header(TusExtensionHeader, TusExtensions)
+	c.Header(TusChecksumAlgorithmHeader, TusChecksumAlgorithms)
+	c.Status(http.StatusNoContent)
+}
+
+// TusCreate is the request handler for POST /upload/tus request.
+// It creates a tus upload of Upload-Length bytes to the parent query param, or to the parent in Upload-Metadata,
+// named by the filename and typed by the filetype in Upload-Metadata. Responds with the upload's URL in Location.
+func (r *Router) TusCreate(c *gin.Context) {
+	reqUser := user.ExtractRequestUser(c)
+	if reqUser == nil {
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
+		return
+	}
+
+	if c.GetHeader(UploadDeferLengthHeader) != "" {
+		apierror.AbortWithMessage(c, http.StatusBadRequest, "deferred upload length is not supported")
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader(UploadLengthHeader), 10, 64)
+	if err != nil || length <= 0 {
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("%s is invalid", UploadLengthHeader))
+		return
+	}
+
+	metadata, err := parseTusMetadata(c.GetHeader(UploadMetadataHeader))
+	if err != nil {
+		apierror.AbortWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	parent := c.Query(ParentQueryKey)
+	if parent == "" {
+		parent = metadata[ParentQueryKey]
+	}
+
+	isPermitted, err := r.isUploadPermitted(c.Request.Context(), reqUser.ID, parent)
+	if err != nil || !isPermitted {
+		apierror.AbortWithStatus(c, http.StatusForbidden)
+		return
+	}
+
+	name := firstNonEmpty(metadata["filename"], metadata["name"], uuid.NewV4().String())
+	mimeType := firstNonEmpty(metadata["filetype"], metadata["type"], DefaultContentLength)
+
+	uncountFailed, ok := r.limitUpload(c, uploadKindOf(mimeType), reqUser, 1)
+	if !ok {
+		return
+	}
+	defer uncountFailed()
+
+	upload := r.initResumableUpload(c, reqUser, name, mimeType, parent, length)
+	if upload == nil {
+		return
+	}
+
+	tusUpload := &TusUpload{
+		ID:        uuid.NewV4().String(),
+		UserID:    reqUser.ID,
+		UploadID:  upload.GetUploadID(),
+		Key:       upload.GetKey(),
+		Bucket:    upload.GetBucket(),
+		Name:      name,
+		Parent:    parent,
+		Metadata:  c.GetHeader(UploadMetadataHeader),
+		Length:    length,
+		PartSize:  r.calculateBufSize(length),
+		CreatedAt: time.Now(),
+	}
+
+	if err := r.tus.Create(c.Request.Context(), tusUpload); err != nil {
+		loggermiddleware.LogError(r.logger, r.AbortUpload(context.Background(), upload))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusInternalServerError, err))
+
+		return
+	}
+
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+tusUpload.ID)
+	c.Status(http.StatusCreated)
+}
+
+// tusUpload returns the requester's tus upload with the upload id param.
+// If it doesn't exist then the request is aborted and nil is returned.
+func (r *Router) tusUpload(c *gin.Context) *TusUpload {
+	reqUser := user.ExtractRequestUser(c)
+	if reqUser == nil {
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
+		return nil
+	}
+
+	upload, err := r.tus.Get(c.Request.Context(), c.Param(ParamTusUploadID))
+	if err == ErrTusUploadNotFound || (err == nil && upload.UserID != reqUser.ID) {
+		apierror.AbortWithMessage(c, http.StatusNotFound, tusUploadNotFoundMessage)
+		return nil
+	}
+
+	if err != nil {
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusInternalServerError, err))
+		return nil
+	}
+
+	return upload
+}
+
+// TusHead is the request handler for HEAD /upload/tus/:uploadId request.
+// It responds with the offset and length of the upload.
+func (r *Router) TusHead(c *gin.Context) {
+	upload := r.tusUpload(c)
+	if upload == nil {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header(UploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	c.Header(UploadLengthHeader, strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		c.Header(UploadMetadataHeader, upload.Metadata)
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// TusPatch is the request handler for PATCH /upload/tus/:uploadId request.
+// It uploads the whole parts of the staged content and the content at Upload-Offset, and stages the rest of
+// the content unless it completes the upload, and responds with the new offset. Once the upload is complete
+// its file is created the same as a completed resumable upload, and its id is responded in x-file-id.
+func (r *Router) TusPatch(c *gin.Context) {
+	upload := r.tusUpload(c)
+	if upload == nil {
+		return
+	}
+
+	if c.ContentType() != TusContentType {
+		apierror.AbortWithMessage(
+			c,
+			http.StatusUnsupportedMediaType,
+			fmt.Sprintf("content type must be %s", TusContentType),
+		)
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader(UploadOffsetHeader), 10, 64)
+	if err != nil {
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("%s is invalid", UploadOffsetHeader))
+		return
+	}
+
+	contentLength := c.Request.ContentLength
+	if contentLength < 0 {
+		apierror.AbortWithStatus(c, http.StatusLengthRequired)
+		return
+	}
+
+	checksum, expectedSum, err := parseUploadChecksum(c.GetHeader(UploadChecksumHeader))
+	if err != nil {
+		apierror.AbortWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !r.tusLocks.lock(upload.ID) {
+		apierror.AbortWithMessage(c, http.StatusConflict, "the upload is being patched by another request")
+		return
+	}
+
+	defer r.tusLocks.unlock(upload.ID)
+
+	// Refetch the upload, its offset may have been advanced by a request that held the lock.
+	if upload = r.tusUpload(c); upload == nil {
+		return
+	}
+
+	if offset != upload.Offset {
+		apierror.AbortWithMessage(
+			c,
+			http.StatusConflict,
+			fmt.Sprintf("%s must be %d", UploadOffsetHeader, upload.Offset),
+		)
+		return
+	}
+
+	if offset+contentLength > upload.Length {
+		apierror.AbortWithMessage(c, http.StatusRequestEntityTooLarge, "content exceeds the upload length")
+		return
+	}
+
+	staged, err := r.stagedContent(c.Request.Context(), upload)
+	if err != nil {
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+		return
+	}
+
+	content := io.Reader(c.Request.Body)
+	if checksum != nil {
+		content = io.TeeReader(content, checksum)
+	}
+
+	stagedOffset := upload.Offset - upload.Staged
+	newOffset := offset + contentLength
+	partsEnd, rest, err := r.writeTusParts(
+		c.Request.Context(),
+		upload,
+		io.MultiReader(bytes.NewReader(staged), content),
+		newOffset,
+	)
+	if _, ok := err.(*contentError); ok {
+		// The parts of interrupted content are kept, unless the content had to match a checksum.
+		if checksum == nil && partsEnd > offset {
+			loggermiddleware.LogError(r.logger, r.setTusOffset(c.Request.Context(), upload, stagedOffset, partsEnd, nil))
+		}
+
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusBadRequest, err))
+
+		return
+	}
+
+	if err != nil {
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+		return
+	}
+
+	// The parts are overwritten when the content is resent, since their numbers are derived from their offset.
+	if checksum != nil && string(checksum.Sum(nil)) != string(expectedSum) {
+		apierror.AbortWithMessage(c, StatusChecksumMismatch, "checksum mismatch")
+		return
+	}
+
+	// The rest of empty content is the content that's staged already.
+	if len(rest) > 0 && contentLength > 0 {
+		if err := r.stageContent(c.Request.Context(), upload, partsEnd, rest); err != nil {
+			httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+			loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+			return
+		}
+	}
+
+	if err := r.setTusOffset(c.Request.Context(), upload, stagedOffset, newOffset, rest); err != nil {
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusInternalServerError, err))
+		return
+	}
+
+	if newOffset == upload.Length {
+		createdFile := r.completeUpload(c, upload.fileUpload(), upload.Parent, nil)
+		if createdFile == nil {
+			return
+		}
+
+		loggermiddleware.LogError(r.logger, r.tus.Remove(c.Request.Context(), upload.ID))
+		c.Header(FileIDCustomHeader, createdFile.GetId())
+	}
+
+	c.Header(UploadOffsetHeader, strconv.FormatInt(newOffset, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// setTusOffset sets the offset of upload to newOffset, with the staged bytes before it, and deletes the object
+// that staged the content of upload from stagedOffset if its content was uploaded as a part.
+func (r *Router) setTusOffset(
+	ctx context.Context,
+	upload *TusUpload,
+	stagedOffset int64,
+	newOffset int64,
+	staged []byte) error {
+	if err := r.tus.SetOffset(ctx, upload.ID, newOffset, int64(len(staged))); err != nil {
+		return err
+	}
+
+	if upload.Staged > 0 && newOffset-int64(len(staged)) > stagedOffset {
+		loggermiddleware.LogError(r.logger, r.deleteStagedContent(ctx, upload, stagedOffset))
+	}
+
+	return nil
+}
+
+// writeTusParts uploads the whole parts of content, which is the content of upload from its staged content
+// to end, and the rest of content if it ends the upload. Returns the offset after the uploaded parts and
+// the rest of content that's shorter than a part. If content ended early then a *contentError is returned
+// with the offset after the whole parts that were uploaded.
+func (r *Router) writeTusParts(
+	ctx context.Context,
+	upload *TusUpload,
+	content io.Reader,
+	end int64) (int64, []byte, error) {
+	start := upload.Offset - upload.Staged
+	partsEnd := end
+	if partsEnd < upload.Length {
+		partsEnd -= partsEnd % upload.PartSize
+	}
+
+	if partsEnd > start {
+		uploaded, err := r.uploadParts(
+			ctx,
+			io.LimitReader(content, partsEnd-start),
+			upload.PartSize,
+			start/upload.PartSize+1,
+			upload.UploadID,
+			upload.Key,
+			upload.Bucket,
+		)
+		if err == nil && uploaded != partsEnd-start {
+			err = &contentError{err: io.ErrUnexpectedEOF}
+		}
+
+		if err != nil {
+			// Only the whole parts of interrupted content are kept, the last part is resent.
+			return start + uploaded - uploaded%upload.PartSize, nil, err
+		}
+	}
+
+	rest := make([]byte, end-partsEnd)
+	if _, err := io.ReadFull(content, rest); err != nil {
+		return partsEnd, nil, &contentError{err: err}
+	}
+
+	return partsEnd, rest, nil
+}
+
+// tusStagingKey returns the key of the object that stages the content of upload from offset.
+func tusStagingKey(upload *TusUpload, offset int64) string {
+	return fmt.Sprintf("%s.tus-%d", upload.Key, offset)
+}
+
+// stagedContent returns the staged content of upload, that precedes the content at its offset.
+func (r *Router) stagedContent(ctx context.Context, upload *TusUpload) ([]byte, error) {
+	if upload.Staged == 0 {
+		return nil, nil
+	}
+
+	stream, err := r.downloadClient().Download(ctx, &dpb.DownloadRequest{
+		Key:    tusStagingKey(upload, upload.Offset-upload.Staged),
+		Bucket: upload.Bucket,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The staging object is longer than the staged content if it was restaged and the offset wasn't set.
+	staged, err := ioutil.ReadAll(io.LimitReader(file.NewDownloadReader(stream), upload.Staged))
+	if err == nil && int64(len(staged)) != upload.Staged {
+		err = fmt.Errorf("staged content of tus upload %s is missing", upload.ID)
+	}
+
+	return staged, err
+}
+
+// stageContent stages content, the content of upload from offset that's shorter than a part.
+func (r *Router) stageContent(ctx context.Context, upload *TusUpload, offset int64, content []byte) error {
+	_, err := r.uploadClient().UploadMedia(ctx, &upb.UploadMediaRequest{
+		Key:         tusStagingKey(upload, offset),
+		Bucket:      upload.Bucket,
+		File:        content,
+		ContentType: DefaultContentLength,
+	})
+
+	return err
+}
+
+// deleteStagedContent deletes the object that staged the content of upload from offset.
+func (r *Router) deleteStagedContent(ctx context.Context, upload *TusUpload, offset int64) error {
+	key := tusStagingKey(upload, offset)
+	res, err := r.uploadClient().DeleteObjects(ctx, &upb.DeleteObjectsRequest{
+		Bucket: upload.Bucket,
+		Keys:   []string{key},
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(res.GetFailed()) != 0 {
+		return fmt.Errorf("failed deleting staged content %s of tus upload %s", key, upload.ID)
+	}
+
+	return nil
+}
+
+// TusTerminate is the request handler for DELETE /upload/tus/:uploadId request.
+// It aborts the upload and deletes its uploaded parts and staged content.
+func (r *Router) TusTerminate(c *gin.Context) {
+	upload := r.tusUpload(c)
+	if upload == nil {
+		return
+	}
+
+	if err := r.AbortUpload(c.Request.Context(), upload.fileUpload()); err != nil {
+		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+		return
+	}
+
+	if upload.Staged > 0 {
+		stagedOffset := upload.Offset - upload.Staged
+		loggermiddleware.LogError(r.logger, r.deleteStagedContent(c.Request.Context(), upload, stagedOffset))
+	}
+
+	if err := r.tus.Remove(c.Request.Context(), upload.ID); err != nil {
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusInternalServerError, err))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// fileUpload returns the upload of file service of upload.
+func (upload *TusUpload) fileUpload() *fpb.GetUploadByIDResponse {
+	return &fpb.GetUploadByIDResponse{
+		UploadID: upload.UploadID,
+		Key:      upload.Key,
+		Bucket:   upload.Bucket,
+		Name:     upload.Name,
+	}
+}
+
+// parseTusMetadata parses the Upload-Metadata header, comma separated pairs of a key
+// and its base64 encoded value separated by a space. The value of a key may be omitted.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s is invalid", UploadMetadataHeader)
+		}
+
+		value := ""
+		if len(fields) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("%s value of %s is invalid", UploadMetadataHeader, fields[0])
+			}
+
+			value = string(decoded)
+		}
+
+		metadata[fields[0]] = value
+	}
+
+	return metadata, nil
+}
+
+// parseUploadChecksum parses the Upload-Checksum header, the name of a checksum algorithm and the base64
+// encoded checksum separated by a space. Returns the hash of the algorithm and the expected checksum,
+// or a nil hash if the header is empty.
+func parseUploadChecksum(header string) (hash.Hash, []byte, error) {
+	if header == "" {
+		return nil, nil, nil
+	}
+
+	fields := strings.Fields(header)
+	if len(fields) != 2 {
+		return nil, nil, fmt.Errorf("%s is invalid", UploadChecksumHeader)
+	}
+
+	var checksum hash.Hash
+	switch fields[0] {
+	case "md5":
+		checksum = md5.New() // nolint: gosec
+	case "sha1":
+		checksum = sha1.New() // nolint: gosec
+	case "sha256":
+		checksum = sha256.New()
+	default:
+		return nil, nil, fmt.Errorf("checksum algorithm %s is not supported", fields[0])
+	}
+
+	sum, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s is invalid", UploadChecksumHeader)
+	}
+
+	return checksum, sum, nil
+}
+
+// firstNonEmpty returns the first of values that isn't empty.
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
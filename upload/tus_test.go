@@ -0,0 +1,313 @@
+package upload
+
+import (
+	"context"
+	"crypto/md5" // nolint: gosec
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/meateam/api-gateway/user"
+	dpb "github.com/meateam/download-service/proto"
+	upb "github.com/meateam/upload-service/proto"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+// memTusStore is a TusStore in memory.
+type memTusStore struct {
+	mu      sync.Mutex
+	uploads map[string]*TusUpload
+}
+
+func (s *memTusStore) Create(_ context.Context, upload *TusUpload) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads[upload.ID] = upload
+
+	return nil
+}
+
+func (s *memTusStore) Get(_ context.Context, id string) (*TusUpload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[id]
+	if !ok {
+		return nil, ErrTusUploadNotFound
+	}
+
+	copied := *upload
+
+	return &copied, nil
+}
+
+func (s *memTusStore) SetOffset(_ context.Context, id string, offset int64, staged int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[id]
+	if !ok {
+		return ErrTusUploadNotFound
+	}
+
+	upload.Offset = offset
+	upload.Staged = staged
+
+	return nil
+}
+
+func (s *memTusStore) Remove(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.uploads, id)
+
+	return nil
+}
+
+// fakeStagingClient is an upload and download service of the staging objects, that records the uploaded parts.
+type fakeStagingClient struct {
+	upb.UploadClient
+	dpb.DownloadClient
+	streams []*fakeUploadPartStream
+	objects map[string]string
+}
+
+func newFakeStagingClient() *fakeStagingClient {
+	return &fakeStagingClient{objects: make(map[string]string)}
+}
+
+func (f *fakeStagingClient) UploadPart(context.Context, ...grpc.CallOption) (upb.Upload_UploadPartClient, error) {
+	stream := &fakeUploadPartStream{closed: make(chan struct{})}
+	f.streams = append(f.streams, stream)
+
+	return stream, nil
+}
+
+// parts returns the parts uploaded by all of the streams and their numbers.
+func (f *fakeStagingClient) parts() ([]string, []int64) {
+	var parts []string
+	var partNumbers []int64
+	for _, stream := range f.streams {
+		parts = append(parts, stream.parts...)
+		partNumbers = append(partNumbers, stream.partNumbers...)
+	}
+
+	return parts, partNumbers
+}
+
+func (f *fakeStagingClient) UploadMedia(
+	_ context.Context,
+	in *upb.UploadMediaRequest,
+	_ ...grpc.CallOption) (*upb.UploadMediaResponse, error) {
+	f.objects[in.GetKey()] = string(in.GetFile())
+	return &upb.UploadMediaResponse{}, nil
+}
+
+func (f *fakeStagingClient) DeleteObjects(
+	_ context.Context,
+	in *upb.DeleteObjectsRequest,
+	_ ...grpc.CallOption) (*upb.DeleteObjectsResponse, error) {
+	for _, key := range in.GetKeys() {
+		delete(f.objects, key)
+	}
+
+	return &upb.DeleteObjectsResponse{Deleted: in.GetKeys()}, nil
+}
+
+func (f *fakeStagingClient) Download(
+	_ context.Context,
+	in *dpb.DownloadRequest,
+	_ ...grpc.CallOption) (dpb.Download_DownloadClient, error) {
+	return &fakeDownloadStream{content: f.objects[in.GetKey()], chunkSize: 2}, nil
+}
+
+// newTusRouter creates a Router with the tus uploads of store, whose content is uploaded to client.
+func newTusRouter(store TusStore, client *fakeStagingClient) *Router {
+	r := &Router{logger: logrus.New(), tus: store}
+	r.uploadClient = func() upb.UploadClient { return client }
+	r.downloadClient = func() dpb.DownloadClient { return client }
+
+	return r
+}
+
+// patchTus patches the tus upload with content at offset, and returns the request's context.
+func patchTus(r *Router, offset int64, content string, checksum string) *gin.Context {
+	req := httptest.NewRequest(http.MethodPatch, "/upload/tus/tus", strings.NewReader(content))
+	req.Header.Set(ContentTypeHeader, TusContentType)
+	req.Header.Set(UploadOffsetHeader, strconv.FormatInt(offset, 10))
+	if checksum != "" {
+		req.Header.Set(UploadChecksumHeader, checksum)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = req
+	c.Params = gin.Params{{Key: ParamTusUploadID, Value: "tus"}}
+	c.Set(user.ContextUserKey, user.User{ID: "user"})
+
+	r.TusPatch(c)
+
+	return c
+}
+
+func TestParseTusMetadata(t *testing.T) {
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name    string
+		header  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "values",
+			header: "filename " + encode("report.pdf") + ", filetype " + encode("application/pdf"),
+			want:   map[string]string{"filename": "report.pdf", "filetype": "application/pdf"},
+		},
+		{name: "omitted value", header: "is_confidential", want: map[string]string{"is_confidential": ""}},
+		{name: "empty", header: "", want: map[string]string{}},
+		{name: "invalid value", header: "filename not-base64!", wantErr: true},
+		{name: "extra fields", header: "filename a b", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTusMetadata(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTusMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTusMetadata() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouter_TusPatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	md5Checksum := func(s string) string {
+		sum := md5.Sum([]byte(s)) // nolint: gosec
+		return "md5 " + base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	tests := []struct {
+		name            string
+		offset          int64
+		content         string
+		checksum        string
+		wantStatus      int
+		wantOffset      int64
+		wantParts       []string
+		wantPartNumbers []int64
+	}{
+		{
+			name:            "whole parts are uploaded numbered by their offset",
+			offset:          3,
+			content:         "defghij",
+			checksum:        md5Checksum("defghij"),
+			wantStatus:      http.StatusNoContent,
+			wantOffset:      10,
+			wantParts:       []string{"def", "ghi"},
+			wantPartNumbers: []int64{2, 3},
+		},
+		{
+			name:       "content shorter than a part is staged",
+			offset:     3,
+			content:    "de",
+			wantStatus: http.StatusNoContent,
+			wantOffset: 5,
+		},
+		{
+			name:       "offset conflict",
+			offset:     0,
+			content:    "abc",
+			wantStatus: http.StatusConflict,
+			wantOffset: 3,
+		},
+		{
+			name:       "content exceeds the length",
+			offset:     3,
+			content:    "defghijklmn",
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantOffset: 3,
+		},
+		{
+			name:            "checksum mismatch",
+			offset:          3,
+			content:         "defghij",
+			checksum:        md5Checksum("other"),
+			wantStatus:      StatusChecksumMismatch,
+			wantOffset:      3,
+			wantParts:       []string{"def", "ghi"},
+			wantPartNumbers: []int64{2, 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memTusStore{uploads: map[string]*TusUpload{
+				"tus": {ID: "tus", UserID: "user", UploadID: "id", Length: 12, Offset: 3, PartSize: 3},
+			}}
+			client := newFakeStagingClient()
+			c := patchTus(newTusRouter(store, client), tt.offset, tt.content, tt.checksum)
+
+			if c.Writer.Status() != tt.wantStatus {
+				t.Errorf("TusPatch() status = %d, want %d", c.Writer.Status(), tt.wantStatus)
+			}
+
+			if upload, _ := store.Get(context.Background(), "tus"); upload.Offset != tt.wantOffset {
+				t.Errorf("TusPatch() offset = %d, want %d", upload.Offset, tt.wantOffset)
+			}
+
+			if parts, partNumbers := client.parts(); !reflect.DeepEqual(parts, tt.wantParts) ||
+				!reflect.DeepEqual(partNumbers, tt.wantPartNumbers) {
+				t.Errorf("TusPatch() parts = %v numbered %v, want %v numbered %v",
+					parts, partNumbers, tt.wantParts, tt.wantPartNumbers)
+			}
+		})
+	}
+}
+
+func TestRouter_TusPatchSmallChunks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store := &memTusStore{uploads: map[string]*TusUpload{
+		"tus": {ID: "tus", UserID: "user", UploadID: "id", Key: "key", Length: 12, PartSize: 3},
+	}}
+	client := newFakeStagingClient()
+	r := newTusRouter(store, client)
+
+	// The chunks are shorter than a part, each part is uploaded once the chunks that follow it complete it.
+	content := "abcdefghijk"
+	for offset := int64(0); offset < int64(len(content)); offset += 2 {
+		end := offset + 2
+		if end > int64(len(content)) {
+			end = int64(len(content))
+		}
+
+		if c := patchTus(r, offset, content[offset:end], ""); c.Writer.Status() != http.StatusNoContent {
+			t.Fatalf("TusPatch() at offset %d status = %d, want %d", offset, c.Writer.Status(), http.StatusNoContent)
+		}
+	}
+
+	upload, _ := store.Get(context.Background(), "tus")
+	if upload.Offset != 11 || upload.Staged != 2 {
+		t.Errorf("TusPatch() offset = %d with %d staged bytes, want 11 with 2", upload.Offset, upload.Staged)
+	}
+
+	parts, partNumbers := client.parts()
+	if want := []string{"abc", "def", "ghi"}; !reflect.DeepEqual(parts, want) ||
+		!reflect.DeepEqual(partNumbers, []int64{1, 2, 3}) {
+		t.Errorf("TusPatch() parts = %v numbered %v, want %v", parts, partNumbers, want)
+	}
+
+	if want := map[string]string{"key.tus-9": "jk"}; !reflect.DeepEqual(client.objects, want) {
+		t.Errorf("expected only the rest of the content to be staged, got %v", client.objects)
+	}
+}
@@ -1,6 +1,7 @@
 package upload
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -8,6 +9,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
+	"github.com/meateam/api-gateway/file"
 	loggermiddleware "github.com/meateam/api-gateway/logger"
 	fpb "github.com/meateam/file-service/proto/file"
 	ppb "github.com/meateam/permission-service/proto"
@@ -58,7 +61,7 @@ func (r *Router) Update(c *gin.Context) {
 
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return
 	}
 
@@ -66,14 +69,25 @@ func (r *Router) Update(c *gin.Context) {
 		return
 	}
 
+	if r.isTrashed(c.Request.Context(), fileID) {
+		apierror.AbortWithMessage(c, http.StatusNotFound, fileIsTrashedMessage)
+		return
+	}
+
 	newFileSize, err := strconv.ParseInt(c.Request.Header.Get(ContentLengthCustomHeader), 10, 64)
 	if err != nil {
-		c.String(http.StatusBadRequest, fmt.Sprintf("%s is invalid", ContentLengthCustomHeader))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("%s is invalid", ContentLengthCustomHeader))
 		return
 	}
 
 	if newFileSize < 0 {
-		c.String(http.StatusBadRequest, fmt.Sprintf("%s is must be positive", ContentLengthCustomHeader))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("%s is must be positive", ContentLengthCustomHeader))
+		return
+	}
+
+	// The new content is charged to the file's owner, whose quota is checked.
+	releaseQuota, ok := r.reserveQuota(c, file.GetOwnerID(), "update", newFileSize)
+	if !ok {
 		return
 	}
 
@@ -85,9 +99,12 @@ func (r *Router) Update(c *gin.Context) {
 		Size:    newFileSize,
 	})
 
+	// The created update is counted in the used quota.
+	releaseQuota()
+
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return
 	}
 
@@ -118,8 +135,11 @@ func (r *Router) Update(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
-// UpdateComplete completes a resumable update-file upload and updates the user quota, and deletes the old file's content.
-func (r *Router) UpdateComplete(c *gin.Context) {
+// UpdateComplete completes a resumable update-file upload and updates the user quota. The old file's content
+// is kept as a version of the file if versions are enabled, otherwise it's deleted.
+// The checksum of the new content is stored if it's non-nil. If expected is non-nil then the new content
+// is verified against it before the file is updated, and its checksum is stored.
+func (r *Router) UpdateComplete(c *gin.Context, checksum *file.Checksum, expected *file.Checksum) {
 	reqUser := r.getUserFromContext(c)
 	if reqUser == nil {
 		return
@@ -133,7 +153,7 @@ func (r *Router) UpdateComplete(c *gin.Context) {
 	upload, err := r.fileClient().GetUploadByID(c.Request.Context(), &fpb.GetUploadByIDRequest{UploadID: uploadID})
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return
 	}
 
@@ -144,7 +164,7 @@ func (r *Router) UpdateComplete(c *gin.Context) {
 	)
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return
 	}
 
@@ -160,6 +180,15 @@ func (r *Router) UpdateComplete(c *gin.Context) {
 		return
 	}
 
+	verified, ok := r.verifyCompletedUpload(c, upload, expected)
+	if !ok {
+		return
+	}
+
+	if verified != nil {
+		checksum = verified
+	}
+
 	deleteUploadRequest := &fpb.DeleteUploadByIDRequest{
 		UploadID: upload.GetUploadID(),
 	}
@@ -170,7 +199,7 @@ func (r *Router) UpdateComplete(c *gin.Context) {
 	_, err = r.fileClient().DeleteUploadByID(c.Request.Context(), deleteUploadRequest)
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return
 	}
 
@@ -200,10 +229,24 @@ func (r *Router) UpdateComplete(c *gin.Context) {
 	// Only refers to one, because it cannot update more than one
 	if len(updateFilesResponse.GetFailedFiles()) != 0 {
 		failedFileID := updateFilesResponse.GetFailedFiles()[0]
-		c.String(http.StatusInternalServerError, fmt.Sprintf("Error while updating file %s", failedFileID))
+		apierror.AbortWithMessage(c, http.StatusInternalServerError, fmt.Sprintf("Error while updating file %s", failedFileID))
 		return
 	}
 
+	file.InvalidateContentCaches(fileID, r.contentCaches...)
+	r.storeChecksum(c.Request.Context(), checksum)
+
+	// Keep the old file's content as a version, or delete it if versions aren't kept.
+	if r.versions != nil {
+		_, err := r.versions.Archive(c.Request.Context(), oldFile, reqUser.ID)
+		if err == nil {
+			c.String(http.StatusOK, fileID)
+			return
+		}
+
+		loggermiddleware.LogError(r.logger, fmt.Errorf("failed keeping version of file %s: %v", fileID, err))
+	}
+
 	deleteObjectsResponse, err := r.uploadClient().DeleteObjects(c.Request.Context(), &upb.DeleteObjectsRequest{
 		Bucket: upload.Bucket,
 		Keys:   []string{oldFile.Key},
@@ -212,7 +255,7 @@ func (r *Router) UpdateComplete(c *gin.Context) {
 	// Only refers to one, because it cannot delete more than one
 	if len(deleteObjectsResponse.GetFailed()) != 0 {
 		failedFileID := deleteObjectsResponse.GetFailed()[0]
-		c.String(http.StatusInternalServerError, fmt.Sprintf("Error while deleting file %s", failedFileID))
+		apierror.AbortWithMessage(c, http.StatusInternalServerError, fmt.Sprintf("Error while deleting file %s", failedFileID))
 		return
 	}
 
@@ -227,7 +270,16 @@ func (r *Router) deleteUpdateOnError(c *gin.Context, err error, upload *fpb.GetU
 		return
 	}
 
-	deleteObjectsResponse, deleteErr := r.uploadClient().DeleteObjects(c.Request.Context(), &upb.DeleteObjectsRequest{
+	err = r.deleteUploadedContent(c.Request.Context(), err, upload)
+
+	httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+	loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+}
+
+// deleteUploadedContent deletes the uploaded content of upload and the upload itself after err.
+// Returns err with the errors of the deletions, if any occurred.
+func (r *Router) deleteUploadedContent(ctx context.Context, err error, upload *fpb.GetUploadByIDResponse) error {
+	deleteObjectsResponse, deleteErr := r.uploadClient().DeleteObjects(ctx, &upb.DeleteObjectsRequest{
 		Bucket: upload.GetBucket(),
 		Keys:   []string{upload.GetKey()},
 	})
@@ -247,13 +299,12 @@ func (r *Router) deleteUpdateOnError(c *gin.Context, err error, upload *fpb.GetU
 		UploadID: upload.GetUploadID(),
 	}
 
-	_, deleteUploadErr := r.fileClient().DeleteUploadByID(c.Request.Context(), deleteUploadRequest)
+	_, deleteUploadErr := r.fileClient().DeleteUploadByID(ctx, deleteUploadRequest)
 	if deleteUploadErr != nil {
 		err = fmt.Errorf("%v: fail to delete upload %v", err, deleteUploadErr)
 	}
 
-	httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-	loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+	return err
 }
 
 // changeExtensionByMimeType returns the same file name and changes the extension by the mime type
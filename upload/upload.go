@@ -5,20 +5,25 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"mime/multipart"
 	"net/http"
 	"net/url"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
+	"github.com/meateam/api-gateway/cache"
 	"github.com/meateam/api-gateway/factory"
 	"github.com/meateam/api-gateway/file"
 	loggermiddleware "github.com/meateam/api-gateway/logger"
+	"github.com/meateam/api-gateway/metrics"
 	"github.com/meateam/api-gateway/oauth"
 	"github.com/meateam/api-gateway/user"
+	dpb "github.com/meateam/download-service/proto"
 	fpb "github.com/meateam/file-service/proto/file"
+	qpb "github.com/meateam/file-service/proto/quota"
 	grpcPoolTypes "github.com/meateam/grpc-go-conn-pool/grpc/types"
 	ppb "github.com/meateam/permission-service/proto"
 	spb "github.com/meateam/search-service/proto"
@@ -83,6 +88,9 @@ const (
 	// UploadRole is the role that is required of the authenticated requester to have to be
 	// permitted to make an upload action.
 	UploadRole = ppb.Role_WRITE
+
+	// fileIsTrashedMessage is the error message for a file that's in the trash.
+	fileIsTrashedMessage = "file is in the trash"
 )
 
 func marshalSearchPB(f *fpb.File, file *spb.File) error {
@@ -119,9 +127,43 @@ type Router struct {
 	// SearchClientFactory
 	searchClient factory.SearchClientFactory
 
+	// DownloadClientFactory
+	downloadClient factory.DownloadClientFactory
+
+	// QuotaClientFactory
+	quotaClient factory.QuotaClientFactory
+
 	oAuthMiddleware *oauth.Middleware
 	logger          *logrus.Logger
 	mu              sync.Mutex
+
+	// contentCaches cache the previews and thumbnails of files under file.CacheKeyPrefix,
+	// which are invalidated when a file's content is replaced.
+	contentCaches []cache.Cache
+
+	// versions keeps the replaced contents of updated files, they're deleted if it's nil.
+	versions *file.Versions
+
+	// checksums stores the checksums of the uploaded contents, they're verified but not stored if it's nil.
+	checksums file.ChecksumStore
+
+	// tus stores the state of the tus uploads, the tus routes aren't set up if it's nil.
+	tus TusStore
+
+	// tusLocks locks the tus uploads that are being patched.
+	tusLocks tusLocks
+
+	// parts stores the ranges committed to the resumable uploads.
+	parts PartStore
+
+	// quotaReservations are the bytes of quota reserved by the in-flight uploads.
+	quotaReservations quotaReservations
+
+	// limiter limits the number of files and folders uploaded by each user, uploads are unlimited if it's nil.
+	limiter *UploadLimiter
+
+	// trash is the trash of the deleted files, whose contents can't be uploaded to or copied.
+	trash file.TrashStore
 }
 
 // uploadInitBody is a structure of the json body of upload init request.
@@ -130,23 +172,28 @@ type uploadInitBody struct {
 	MimeType string `json:"mimeType"`
 }
 
-// resumableFileUploadProgress is a structure of a resumable file upload progress.
-type resumableFileUploadProgress struct {
-	rangeStart int64
-	rangeEnd   int64
-	bufSize    int64
-	upload     *fpb.GetUploadByIDResponse
-	file       *multipart.Part
-}
-
-// NewRouter creates a new Router, and initializes clients of Upload Service
-// and File Service with the given connections. If logger is non-nil then it will
-// be set as-is, otherwise logger would default to logrus.New().
+// NewRouter creates a new Router, and initializes clients of Upload Service, File Service,
+// Download Service, Permission Service and Search Service with the given connections. If logger is non-nil then it will
+// be set as-is, otherwise logger would default to logrus.New(). The values of a file in contentCaches are deleted
+// when the file's content is updated. If versions is non-nil then the replaced contents of updated files are kept
+// as their versions, otherwise they're deleted. The checksums of uploaded contents are stored in checksums
+// if it's non-nil. If tus is non-nil then the tus resumable upload routes are set up
+// with tus storing the state of the uploads. The ranges committed to resumable uploads are stored in parts,
+// or in memory if it's nil. If limiter is non-nil then the number of files and folders uploaded by each user
+// is limited by it. If trash is non-nil then files can't be uploaded to the folders in it, or copied from it.
 func NewRouter(uploadConn *grpcPoolTypes.ConnPool,
 	fileConn *grpcPoolTypes.ConnPool,
+	downloadConn *grpcPoolTypes.ConnPool,
 	permissionConn *grpcPoolTypes.ConnPool,
 	searchConn *grpcPoolTypes.ConnPool,
 	oAuthMiddleware *oauth.Middleware,
+	contentCaches []cache.Cache,
+	versions *file.Versions,
+	checksums file.ChecksumStore,
+	tus TusStore,
+	parts PartStore,
+	limiter *UploadLimiter,
+	trash file.TrashStore,
 	logger *logrus.Logger) *Router {
 	// If no logger is given, use a default logger.
 	if logger == nil {
@@ -163,6 +210,14 @@ func NewRouter(uploadConn *grpcPoolTypes.ConnPool,
 		return fpb.NewFileServiceClient((*fileConn).Conn())
 	}
 
+	r.downloadClient = func() dpb.DownloadClient {
+		return dpb.NewDownloadClient((*downloadConn).Conn())
+	}
+
+	r.quotaClient = func() qpb.QuotaServiceClient {
+		return qpb.NewQuotaServiceClient((*fileConn).Conn())
+	}
+
 	r.permissionClient = func() ppb.PermissionClient {
 		return ppb.NewPermissionClient((*permissionConn).Conn())
 	}
@@ -173,6 +228,24 @@ func NewRouter(uploadConn *grpcPoolTypes.ConnPool,
 
 	r.oAuthMiddleware = oAuthMiddleware
 
+	r.contentCaches = contentCaches
+
+	r.versions = versions
+
+	r.checksums = checksums
+
+	r.tus = tus
+
+	if parts == nil {
+		parts = NewMemoryPartStore()
+	}
+
+	r.parts = parts
+
+	r.limiter = limiter
+
+	r.trash = trash
+
 	return r
 }
 
@@ -181,9 +254,16 @@ func (r *Router) Setup(rg *gin.RouterGroup) {
 	checkUploadScope := r.oAuthMiddleware.AuthorizationScopeMiddleware(oauth.UploadScope)
 
 	rg.POST("/upload", checkUploadScope, r.Upload)
+	rg.GET("/upload/:"+ParamUploadID+"/status", checkUploadScope, r.UploadStatus)
+	rg.POST("/files/:id/copy", checkUploadScope, r.CopyFile)
 
 	// initializes UPDATE routes
 	r.UpdateSetup(rg)
+
+	// initializes tus routes
+	if r.tus != nil {
+		r.TusSetup(rg)
+	}
 }
 
 // Upload is the request handler for /upload request.
@@ -193,7 +273,7 @@ func (r *Router) Upload(c *gin.Context) {
 	if reqUser == nil {
 		loggermiddleware.LogError(
 			r.logger,
-			c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("error extracting user from request")),
+			apierror.AbortWithMessage(c, http.StatusUnauthorized, "error extracting user from request"),
 		)
 		return
 	}
@@ -217,7 +297,7 @@ func (r *Router) Upload(c *gin.Context) {
 	case ResumableUploadType:
 		r.UploadPart(c)
 	default:
-		c.String(http.StatusBadRequest, fmt.Sprintf("unknown uploadType=%v", uploadType))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("unknown uploadType=%v", uploadType))
 		return
 	}
 }
@@ -251,7 +331,7 @@ func (r *Router) UploadFolder(c *gin.Context) {
 
 	isPermitted, err := r.isUploadPermitted(c.Request.Context(), reqUser.ID, parent)
 	if err != nil || !isPermitted {
-		c.AbortWithStatus(http.StatusForbidden)
+		apierror.AbortWithStatus(c, http.StatusForbidden)
 		return
 	}
 
@@ -259,12 +339,18 @@ func (r *Router) UploadFolder(c *gin.Context) {
 	if folderFullName == "" {
 		loggermiddleware.LogError(
 			r.logger,
-			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("folder name not specified")),
+			apierror.AbortWithMessage(c, http.StatusBadRequest, "folder name not specified"),
 		)
 
 		return
 	}
 
+	uncountFailed, ok := r.limitUpload(c, uploadKindFolder, reqUser, 1)
+	if !ok {
+		return
+	}
+	defer uncountFailed()
+
 	createFolderResp, err := r.fileClient().CreateFile(c.Request.Context(), &fpb.CreateFileRequest{
 		Key:     "",
 		Bucket:  reqUser.Bucket,
@@ -278,7 +364,7 @@ func (r *Router) UploadFolder(c *gin.Context) {
 
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return
 	}
 
@@ -316,39 +402,62 @@ func (r *Router) UploadFolder(c *gin.Context) {
 }
 
 // UploadComplete completes a resumable file upload and creates the uploaded file.
-func (r *Router) UploadComplete(c *gin.Context) {
+// The file's checksum is stored if it's non-nil. If expected is non-nil then the file's content
+// is verified against it, and its checksum is stored.
+func (r *Router) UploadComplete(c *gin.Context, checksum *file.Checksum, expected *file.Checksum) {
 	reqUser := user.ExtractRequestUser(c)
 	parent := c.Query(ParentQueryKey)
 
 	isPermitted, err := r.isUploadPermitted(c.Request.Context(), reqUser.ID, parent)
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
 
 	if !isPermitted {
-		c.AbortWithStatus(http.StatusForbidden)
+		apierror.AbortWithStatus(c, http.StatusForbidden)
 		return
 	}
 
 	uploadID, exists := c.GetQuery(UploadIDQueryKey)
 	if !exists {
-		c.String(http.StatusBadRequest, fmt.Sprintf("%s is required", UploadIDQueryKey))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("%s is required", UploadIDQueryKey))
 		return
 	}
 
 	upload, err := r.fileClient().GetUploadByID(c.Request.Context(), &fpb.GetUploadByIDRequest{UploadID: uploadID})
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+
+		return
+	}
 
+	createdFile := r.completeUpload(c, upload, parent, expected)
+	if createdFile == nil {
 		return
 	}
 
+	r.storeChecksum(c.Request.Context(), checksum)
+
+	c.String(http.StatusOK, createdFile.GetId())
+}
+
+// completeUpload completes the resumable upload of upload and creates its file in parent, owned by the requester.
+// The file is indexed in search service and the requester is permitted to it. If expected is non-nil then the
+// content is verified against it before the file is created, and its checksum is stored. Returns the created file,
+// or nil if it failed and the request was aborted.
+func (r *Router) completeUpload(
+	c *gin.Context,
+	upload *fpb.GetUploadByIDResponse,
+	parent string,
+	expected *file.Checksum) *fpb.File {
+	reqUser := user.ExtractRequestUser(c)
+
 	uploadCompleteRequest := &upb.UploadCompleteRequest{
-		UploadId: uploadID,
+		UploadId: upload.GetUploadID(),
 		Key:      upload.GetKey(),
 		Bucket:   upload.GetBucket(),
 	}
@@ -356,9 +465,14 @@ func (r *Router) UploadComplete(c *gin.Context) {
 	resp, err := r.uploadClient().UploadComplete(c.Request.Context(), uploadCompleteRequest)
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
-		return
+		return nil
+	}
+
+	checksum, ok := r.verifyCompletedUpload(c, upload, expected)
+	if !ok {
+		return nil
 	}
 
 	deleteUploadRequest := &fpb.DeleteUploadByIDRequest{
@@ -370,9 +484,9 @@ func (r *Router) UploadComplete(c *gin.Context) {
 	_, err = r.fileClient().DeleteUploadByID(c.Request.Context(), deleteUploadRequest)
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
-		return
+		return nil
 	}
 
 	appID := c.Value(oauth.ContextAppKey).(string)
@@ -389,21 +503,21 @@ func (r *Router) UploadComplete(c *gin.Context) {
 	})
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
-		return
+		return nil
 	}
 
 	searchFile := &spb.File{}
 
 	if err := marshalSearchPB(createFileResp, searchFile); err != nil {
 		r.deleteOnError(c, err, createFileResp.GetId())
-		return
+		return nil
 	}
 
 	if _, err := r.searchClient().CreateFile(c.Request.Context(), searchFile); err != nil {
 		r.deleteOnError(c, err, createFileResp.GetId())
-		return
+		return nil
 	}
 
 	newPermission := ppb.PermissionObject{
@@ -421,90 +535,134 @@ func (r *Router) UploadComplete(c *gin.Context) {
 	)
 	if err != nil {
 		r.deleteOnError(c, err, createFileResp.GetId())
-		return
+		return nil
 	}
 
-	c.String(http.StatusOK, createFileResp.GetId())
+	r.storeChecksum(c.Request.Context(), checksum)
+
+	return createFileResp
 }
 
 // UploadMedia uploads a file from request's body.
 func (r *Router) UploadMedia(c *gin.Context) {
 	fileReader := c.Request.Body
 	if fileReader == nil {
-		c.String(http.StatusBadRequest, "missing file body")
+		apierror.AbortWithMessage(c, http.StatusBadRequest, "missing file body")
 		return
 	}
 
 	if c.Request.ContentLength > MaxSimpleUploadSize {
-		c.String(http.StatusBadRequest, fmt.Sprintf("max file size exceeded %d", MaxSimpleUploadSize))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("max file size exceeded %d", MaxSimpleUploadSize))
+		return
+	}
+
+	checksum, ok := expectedChecksum(c, c.Request.Header)
+	if !ok {
 		return
 	}
 
 	contentType := c.ContentType()
 	fileName := extractFileName(c)
 
-	r.UploadFile(c, fileReader, contentType, fileName)
+	r.UploadFile(c, fileReader, contentType, fileName, checksum)
 }
 
 // UploadMultipart uploads a file from multipart/form-data request.
 func (r *Router) UploadMultipart(c *gin.Context) {
 	multipartForm, err := c.MultipartForm()
 	if err != nil {
-		c.String(http.StatusBadRequest, fmt.Sprintf("failed parsing multipart form data: %v", err))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("failed parsing multipart form data: %v", err))
 		return
 	}
 	defer loggermiddleware.LogError(r.logger, multipartForm.RemoveAll())
 
 	fileHeader, err := c.FormFile(FileFormName)
 	if err != nil {
-		c.String(http.StatusBadRequest, fmt.Sprintf("failed getting file: %v", err))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("failed getting file: %v", err))
 		return
 	}
 
 	if fileHeader.Size > MaxSimpleUploadSize {
-		c.String(http.StatusBadRequest, fmt.Sprintf("max file size exceeded %d", MaxSimpleUploadSize))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("max file size exceeded %d", MaxSimpleUploadSize))
+		return
+	}
+
+	// The checksum of the file is in the headers of its part, or in the headers of the request.
+	checksum, ok := expectedChecksum(c, http.Header(fileHeader.Header))
+	if ok && checksum == nil {
+		checksum, ok = expectedChecksum(c, c.Request.Header)
+	}
+
+	if !ok {
 		return
 	}
 
 	file, err := fileHeader.Open()
 	if err != nil {
-		loggermiddleware.LogError(r.logger, c.AbortWithError(http.StatusInternalServerError, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusInternalServerError, err))
 		return
 	}
 
 	contentType := fileHeader.Header.Get(ContentTypeHeader)
 
-	r.UploadFile(c, file, contentType, fileHeader.Filename)
+	r.UploadFile(c, file, contentType, fileHeader.Filename, checksum)
 }
 
 // UploadFile uploads file from fileReader of type contentType with name filename to
-// upload service and creates it in file service.
-func (r *Router) UploadFile(c *gin.Context, fileReader io.ReadCloser, contentType string, filename string) {
+// upload service and creates it in file service. The content is hashed while it's read,
+// and rejected if expected is non-nil and the content doesn't match it.
+func (r *Router) UploadFile(
+	c *gin.Context,
+	fileReader io.ReadCloser,
+	contentType string,
+	filename string,
+	expected *file.Checksum) {
 	reqUser := user.ExtractRequestUser(c)
 
 	parent := c.Query(ParentQueryKey)
 
 	isPermitted, err := r.isUploadPermitted(c.Request.Context(), reqUser.ID, parent)
 	if err != nil || !isPermitted {
-		c.AbortWithStatus(http.StatusForbidden)
+		apierror.AbortWithStatus(c, http.StatusForbidden)
 		return
 	}
 
-	fileBytes, err := ioutil.ReadAll(fileReader)
+	uncountFailed, ok := r.limitUpload(c, uploadKindFile, reqUser, 1)
+	if !ok {
+		return
+	}
+	defer uncountFailed()
+
+	hasher := file.NewHasher()
+	fileBytes, err := ioutil.ReadAll(io.TeeReader(fileReader, hasher))
 	if err != nil {
-		loggermiddleware.LogError(r.logger, c.AbortWithError(http.StatusInternalServerError, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusInternalServerError, err))
 		return
 	}
 
+	releaseQuota, ok := r.reserveQuota(c, reqUser.ID, "upload", int64(len(fileBytes)))
+	if !ok {
+		return
+	}
+	defer releaseQuota()
+
 	keyResp, err := r.fileClient().GenerateKey(c.Request.Context(), &fpb.GenerateKeyRequest{})
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
 
 	key := keyResp.GetKey()
+	checksum := hasher.Checksum(key)
+	if expected != nil {
+		if err := expected.Verify(checksum); err != nil {
+			loggermiddleware.LogError(r.logger, apierror.AbortWithMessage(c, http.StatusBadRequest, err.Error()))
+			return
+		}
+	}
+
 	ureq := &upb.UploadMediaRequest{
 		Key:    key,
 		Bucket: reqUser.Bucket,
@@ -517,11 +675,13 @@ func (r *Router) UploadFile(c *gin.Context, fileReader io.ReadCloser, contentTyp
 
 	if _, err = r.uploadClient().UploadMedia(c.Request.Context(), ureq); err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
 
+	metrics.AddStreamedBytes(metrics.DirectionUpload, len(fileBytes))
+
 	fileFullName := uuid.NewV4().String()
 	if filename != "" {
 		fileFullName = filename
@@ -540,9 +700,12 @@ func (r *Router) UploadFile(c *gin.Context, fileReader io.ReadCloser, contentTyp
 		AppID:   appID,
 	})
 
+	// The created file is counted in the used quota.
+	releaseQuota()
+
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 
 		return
 	}
@@ -579,6 +742,8 @@ func (r *Router) UploadFile(c *gin.Context, fileReader io.ReadCloser, contentTyp
 		return
 	}
 
+	r.storeChecksum(c.Request.Context(), checksum)
+
 	c.String(http.StatusOK, createFileResp.GetId())
 }
 
@@ -588,13 +753,13 @@ func (r *Router) UploadInit(c *gin.Context) {
 	parent := c.Query(ParentQueryKey)
 	isPermitted, err := r.isUploadPermitted(c.Request.Context(), reqUser.ID, parent)
 	if err != nil || !isPermitted {
-		c.AbortWithStatus(http.StatusForbidden)
+		apierror.AbortWithStatus(c, http.StatusForbidden)
 		return
 	}
 
 	var reqBody uploadInitBody
 	if err := c.BindJSON(&reqBody); err != nil {
-		c.String(http.StatusBadRequest, "invalid request body parameters")
+		apierror.AbortWithMessage(c, http.StatusBadRequest, "invalid request body parameters")
 		return
 	}
 
@@ -604,7 +769,7 @@ func (r *Router) UploadInit(c *gin.Context) {
 
 	fileSize, err := strconv.ParseInt(c.Request.Header.Get(ContentLengthCustomHeader), 10, 64)
 	if err != nil {
-		c.String(http.StatusBadRequest, fmt.Sprintf("%s is invalid", ContentLengthCustomHeader))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("%s is invalid", ContentLengthCustomHeader))
 		return
 	}
 
@@ -612,34 +777,68 @@ func (r *Router) UploadInit(c *gin.Context) {
 		fileSize = 0
 	}
 
+	mimeType := reqBody.MimeType
+	if mimeType == "" {
+		mimeType = DefaultContentLength
+	}
+
+	uncountFailed, ok := r.limitUpload(c, uploadKindOf(mimeType), reqUser, 1)
+	if !ok {
+		return
+	}
+	defer uncountFailed()
+
+	upload := r.initResumableUpload(c, reqUser, reqBody.Title, mimeType, parent, fileSize)
+	if upload == nil {
+		return
+	}
+
+	c.Header(UploadIDCustomHeader, upload.GetUploadID())
+	c.Status(http.StatusOK)
+}
+
+// initResumableUpload creates an upload of a file of fileSize bytes named name to parent in file service,
+// and initiates its resumable upload in upload service. Returns the created upload,
+// or nil if it failed and the request was aborted.
+func (r *Router) initResumableUpload(
+	c *gin.Context,
+	reqUser *user.User,
+	name string,
+	mimeType string,
+	parent string,
+	fileSize int64) *fpb.GetUploadByIDResponse {
+	releaseQuota, ok := r.reserveQuota(c, reqUser.ID, "upload", fileSize)
+	if !ok {
+		return nil
+	}
+
 	createUploadResponse, err := r.fileClient().CreateUpload(c.Request.Context(), &fpb.CreateUploadRequest{
 		Bucket:  reqUser.Bucket,
-		Name:    reqBody.Title,
+		Name:    name,
 		OwnerID: reqUser.ID,
 		Parent:  parent,
 		Size:    fileSize,
 	})
 
+	// The created upload is counted in the used quota.
+	releaseQuota()
+
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
-		return
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
+		return nil
 	}
 
 	uploadInitReq := &upb.UploadInitRequest{
-		Key:    createUploadResponse.GetKey(),
-		Bucket: reqUser.Bucket,
-	}
-
-	uploadInitReq.ContentType = reqBody.MimeType
-	if reqBody.MimeType == "" {
-		uploadInitReq.ContentType = DefaultContentLength
+		Key:         createUploadResponse.GetKey(),
+		Bucket:      reqUser.Bucket,
+		ContentType: mimeType,
 	}
 
 	resp, err := r.uploadClient().UploadInit(c.Request.Context(), uploadInitReq)
 	if err != nil {
 		r.deleteUploadOnError(c, err, createUploadResponse.GetKey(), createUploadResponse.GetBucket())
-		return
+		return nil
 	}
 
 	_, err = r.fileClient().UpdateUploadID(c.Request.Context(), &fpb.UpdateUploadIDRequest{
@@ -650,218 +849,229 @@ func (r *Router) UploadInit(c *gin.Context) {
 
 	if err != nil {
 		r.deleteUploadOnError(c, err, createUploadResponse.GetKey(), createUploadResponse.GetBucket())
-		return
+		return nil
 	}
 
-	c.Header(UploadIDCustomHeader, resp.GetUploadId())
-	c.Status(http.StatusOK)
+	return &fpb.GetUploadByIDResponse{
+		Key:      createUploadResponse.GetKey(),
+		Bucket:   reqUser.Bucket,
+		UploadID: resp.GetUploadId(),
+		Name:     name,
+	}
 }
 
-// UploadPart uploads a multipart file to a resumable upload.
+// UploadPart uploads the range of a resumable upload in the Content-Range header from a multipart file.
+// The parts of the range are numbered by its offset, so ranges are retried and resumed from the upload's
+// status without aborting it. The range is committed once upload service has stored all of its parts,
+// or the parts that were read if the content ended early, and the upload is completed once all of its
+// bytes are committed. Only an upload that no longer exists in upload service is aborted. A range that
+// doesn't match its Content-MD5 or Digest headers isn't committed. The checksum of the file is kept if it was
+// uploaded in a single range, or if the whole file's digests were sent in the FileDigestHeader of the range that
+// completed the upload, in which case the completed file is verified against them.
 func (r *Router) UploadPart(c *gin.Context) {
 	multipartReader, err := c.Request.MultipartReader()
 	if err != nil {
-		c.String(http.StatusBadRequest, fmt.Sprintf("failed reading multipart form data: %v", err))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("failed reading multipart form data: %v", err))
 		return
 	}
 
-	file, err := multipartReader.NextPart()
+	filePart, err := multipartReader.NextPart()
 	if err != nil {
-		loggermiddleware.LogError(r.logger, c.AbortWithError(http.StatusInternalServerError, err))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("failed reading multipart form data: %v", err))
+		return
+	}
+	defer filePart.Close()
+
+	// The checksum of the range is in the headers of its part, or in the headers of the request.
+	expected, ok := expectedChecksum(c, http.Header(filePart.Header))
+	if ok && expected == nil {
+		expected, ok = expectedChecksum(c, c.Request.Header)
+	}
+
+	if !ok {
+		return
+	}
+
+	expectedFile, ok := expectedFileChecksum(c)
+	if !ok {
 		return
 	}
-	defer file.Close()
 
 	uploadID, exists := c.GetQuery(UploadIDQueryKey)
 	if !exists {
-		c.String(http.StatusBadRequest, fmt.Sprintf("%s is required", UploadIDQueryKey))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("%s is required", UploadIDQueryKey))
 		return
 	}
 
 	upload, err := r.fileClient().GetUploadByID(c.Request.Context(), &fpb.GetUploadByIDRequest{UploadID: uploadID})
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
-		return
-	}
-
-	fileRange := c.GetHeader(ContentRangeHeader)
-	if fileRange == "" {
-		ContentRangeHeaderErr := fmt.Errorf("%s is required", ContentRangeHeader)
-		r.deleteUploadOnErrorWithStatus(c, http.StatusBadRequest, ContentRangeHeaderErr, upload.GetKey(), upload.GetBucket())
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return
 	}
 
-	rangeStart := int64(0)
-	rangeEnd := int64(0)
-	fileSize := int64(0)
-	_, err = fmt.Sscanf(fileRange, "bytes %d-%d/%d", &rangeStart, &rangeEnd, &fileSize)
+	partRange, bufSize, err := r.parsePartRange(c.Request.Context(), c.GetHeader(ContentRangeHeader), uploadID)
 	if err != nil {
-		contentRangeErr := fmt.Errorf("%s is invalid: %v", ContentRangeHeader, err)
-		r.deleteUploadOnErrorWithStatus(c, http.StatusInternalServerError, contentRangeErr, upload.GetKey(), upload.GetBucket())
+		apierror.AbortWithMessage(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	bufSize := r.calculateBufSize(fileSize)
-
 	span, spanCtx := loggermiddleware.StartSpan(c.Request.Context(), "/upload.Upload/UploadPart")
 	defer span.End()
 
-	stream, err := r.uploadClient().UploadPart(spanCtx)
-	if err != nil {
-		r.deleteUploadOnError(c, err, upload.GetKey(), upload.GetBucket())
-		return
+	// The range is hashed while it's uploaded, its checksum is the file's if it's the whole file.
+	hasher := file.NewHasher()
+	rangeLength := partRange.End - partRange.Start + 1
+	uploaded, err := r.uploadParts(
+		spanCtx,
+		io.TeeReader(io.LimitReader(filePart, rangeLength), hasher),
+		bufSize,
+		partRange.Start/bufSize+1,
+		upload.GetUploadID(),
+		upload.GetKey(),
+		upload.GetBucket(),
+	)
+	if err == nil && uploaded != rangeLength {
+		err = &contentError{err: io.ErrUnexpectedEOF}
+	}
+
+	// A range that doesn't match its checksum isn't committed, its parts are overwritten when it's retried.
+	// A range of the whole file is also verified against the file's checksum, which is then already known.
+	checksum := hasher.Checksum(upload.GetKey())
+	wholeFile := partRange.Start == 0 && partRange.End == partRange.Size-1
+	if err == nil && expected != nil {
+		if err := expected.Verify(checksum); err != nil {
+			loggermiddleware.LogError(r.logger, apierror.AbortWithMessage(c, http.StatusBadRequest, err.Error()))
+			return
+		}
 	}
 
-	errc := make(chan error, 1)
-	defer close(errc)
+	if err == nil && wholeFile && expectedFile != nil {
+		if err := expectedFile.Verify(checksum); err != nil {
+			loggermiddleware.LogError(r.logger, apierror.AbortWithMessage(c, http.StatusBadRequest, err.Error()))
+			return
+		}
+	}
 
-	responseWG := sync.WaitGroup{}
-	responseWG.Add(1)
+	if wholeFile {
+		expectedFile = nil
+	} else {
+		checksum = nil
+	}
 
-	go r.HandleError(spanCtx, c, errc, &responseWG, stream, upload)
+	// Only the whole parts of a range that ended early are committed, its last part is resent.
+	if uploaded != rangeLength {
+		uploaded -= uploaded % bufSize
+	}
 
-	progress := &resumableFileUploadProgress{
-		rangeStart: rangeStart,
-		rangeEnd:   rangeEnd,
-		bufSize:    bufSize,
-		file:       file,
-		upload:     upload,
+	if uploaded > 0 {
+		partRange.End = partRange.Start + uploaded - 1
+		if addErr := r.parts.Add(spanCtx, partRange); addErr != nil && err == nil {
+			err = addErr
+		}
 	}
 
-	if err := r.HandleUpload(
-		spanCtx,
-		c,
-		errc,
-		&responseWG,
-		progress,
-		stream); err == io.EOF {
+	if err != nil {
+		r.abortUploadPart(c, err, upload)
 		return
 	}
 
-	// Close the stream after finishing uploading all file parts.
-	loggermiddleware.LogError(r.logger, stream.CloseSend())
-	responseWG.Wait()
-}
+	ranges, err := r.parts.List(spanCtx, uploadID)
+	if err != nil {
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusInternalServerError, err))
+		return
+	}
 
-// HandleError receive messages from bi-directional stream and handles upload service
-// errors. If received non-nil and non-EOF errors it sends the error through errc,
-// and aborts the upload.
-func (r *Router) HandleError(
-	ctx context.Context,
-	c *gin.Context,
-	errc chan error,
-	wg *sync.WaitGroup,
-	stream upb.Upload_UploadPartClient,
-	upload *fpb.GetUploadByIDResponse) {
-	defer wg.Done()
-	for {
-		partResponse, err := stream.Recv()
-
-		// Upload response that all parts have finished uploading.
-		if err == io.EOF {
-			if !upload.GetIsUpdate() {
-				r.UploadComplete(c)
-			} else {
-				r.UpdateComplete(c)
-			}
-			return
-		}
+	committed := mergeRanges(ranges)
+	if !isComplete(committed, partRange.Size) {
+		c.JSON(http.StatusAccepted, uploadStatus{
+			UploadID:  uploadID,
+			Size:      partRange.Size,
+			PartSize:  bufSize,
+			Committed: committed,
+		})
 
-		// If there's an error uploading any part then abort the upload process.
-		if err != nil || partResponse.GetCode() == 500 {
-			if err != nil {
-				errc <- err
-			}
+		return
+	}
 
-			if partResponse.GetCode() == 500 {
-				errc <- fmt.Errorf(partResponse.GetMessage())
-			}
+	if !upload.GetIsUpdate() {
+		r.UploadComplete(c, checksum, expectedFile)
+	} else {
+		r.UpdateComplete(c, checksum, expectedFile)
+	}
 
-			return
-		}
+	if !c.IsAborted() {
+		loggermiddleware.LogError(r.logger, r.parts.Remove(context.Background(), uploadID))
 	}
 }
 
-// HandleUpload sends to bi-directional stream file found in progress. Upload file bytes
-// from progress.rangeStart to progress.rangeEnd sending in parts in size of progress.bufSize.
-// Receives errors from errc, if any error is received then the operation would be aborted.
-// Returns nil error when sending is done with no errors, if stream is broken
-// then returns io.EOF.
-func (r *Router) HandleUpload(
+// parsePartRange parses the Content-Range header of a range of uploadID, and returns the range with the size
+// of its parts. Returns a non-nil error if the range is invalid, isn't aligned to its parts, or its size differs
+// from the size of the ranges that were already committed.
+func (r *Router) parsePartRange(
 	ctx context.Context,
-	c *gin.Context,
-	errc chan error,
-	wg *sync.WaitGroup,
-	progress *resumableFileUploadProgress,
-	stream upb.Upload_UploadPartClient) error {
-	partNumber := int64(1)
-
-	for {
-		// If there's an error stop uploading file parts.
-		// Otherwise continue uploading the remaining parts.
-		select {
-		case err := <-errc:
-			loggermiddleware.LogError(r.logger, r.AbortUpload(context.Background(), progress.upload))
-
-			httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-			loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
-
-			break
-		default:
-		}
-
-		if progress.rangeEnd-progress.rangeStart+1 < progress.bufSize {
-			progress.bufSize = progress.rangeEnd - progress.rangeStart + 1
-		}
-
-		if progress.bufSize == 0 {
-			break
-		}
+	contentRange string,
+	uploadID string) (*PartRange, int64, error) {
+	if contentRange == "" {
+		return nil, 0, fmt.Errorf("%s is required", ContentRangeHeader)
+	}
 
-		buf := make([]byte, progress.bufSize)
-		bytesRead, err := io.ReadFull(progress.file, buf)
-		if err != nil {
-			if err == io.ErrUnexpectedEOF {
-				loggermiddleware.LogError(r.logger, r.AbortUpload(context.Background(), progress.upload))
-				c.Abort()
+	partRange := &PartRange{UploadID: uploadID, CommittedAt: time.Now()}
+	_, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &partRange.Start, &partRange.End, &partRange.Size)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s is invalid: %v", ContentRangeHeader, err)
+	}
 
-				break
-			}
+	if partRange.Start < 0 || partRange.Start > partRange.End || partRange.End >= partRange.Size {
+		return nil, 0, fmt.Errorf("%s is invalid", ContentRangeHeader)
+	}
 
-			loggermiddleware.LogError(r.logger, c.AbortWithError(http.StatusInternalServerError, err))
+	bufSize := r.calculateBufSize(partRange.Size)
+	if partRange.Start%bufSize != 0 || (partRange.End+1)%bufSize != 0 && partRange.End != partRange.Size-1 {
+		return nil, 0, fmt.Errorf(
+			"%s must start at a multiple of %d bytes and end at a multiple of %d bytes or at the end of the file",
+			ContentRangeHeader,
+			bufSize,
+			bufSize,
+		)
+	}
 
-			break
-		}
+	ranges, err := r.parts.List(ctx, uploadID)
+	if err != nil {
+		return nil, 0, err
+	}
 
-		partRequest := &upb.UploadPartRequest{
-			Part:       buf,
-			Key:        progress.upload.GetKey(),
-			Bucket:     progress.upload.GetBucket(),
-			PartNumber: partNumber,
-			UploadId:   progress.upload.GetUploadID(),
-		}
+	if len(ranges) != 0 && ranges[0].Size != partRange.Size {
+		return nil, 0, fmt.Errorf("%s size must be %d", ContentRangeHeader, ranges[0].Size)
+	}
 
-		if err := stream.Send(partRequest); err != nil {
-			httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-			loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+	return partRange, bufSize, nil
+}
 
-			// Stream is broken, wait for wg and return.
-			if err == io.EOF {
-				wg.Wait()
-				c.Request.Body.Close()
+// abortUploadPart aborts the request of c with the error of uploading a range of upload.
+// Errors reading the content are the client's, and the other errors are retried unless
+// upload no longer exists in upload service, in which case it's aborted.
+func (r *Router) abortUploadPart(c *gin.Context, err error, upload *fpb.GetUploadByIDResponse) {
+	if _, ok := err.(*contentError); ok {
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, http.StatusBadRequest, err))
+		return
+	}
 
-				return io.EOF
-			}
+	if isUploadLost(err) {
+		loggermiddleware.LogError(r.logger, r.AbortUpload(context.Background(), upload))
+		loggermiddleware.LogError(r.logger, r.parts.Remove(context.Background(), upload.GetUploadID()))
+		loggermiddleware.LogError(r.logger, err)
+		apierror.AbortWithMessage(c, http.StatusGone, uploadLostMessage)
 
-			break
-		}
+		return
+	}
 
-		progress.rangeStart += int64(bytesRead)
-		partNumber++
+	httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
+	if httpStatusCode < http.StatusInternalServerError {
+		httpStatusCode = http.StatusBadGateway
 	}
 
-	return nil
+	loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 }
 
 // AbortUpload aborts upload in upload service and file service, returns non-nil error if any occurred.
@@ -888,7 +1098,7 @@ func (r *Router) AbortUpload(ctx context.Context, upload *fpb.GetUploadByIDRespo
 }
 
 // isUploadPermitted checks if userID has permission to upload a file to fileID,
-// requires ppb.Role_WRITE permission.
+// requires ppb.Role_WRITE permission. Uploads to a folder that's in the trash aren't permitted.
 func (r *Router) isUploadPermitted(ctx context.Context, userID string, fileID string) (bool, error) {
 	userFilePermission, _, err := file.CheckUserFilePermission(
 		ctx,
@@ -900,7 +1110,23 @@ func (r *Router) isUploadPermitted(ctx context.Context, userID string, fileID st
 	if err != nil {
 		return false, err
 	}
-	return userFilePermission != "", nil
+	return userFilePermission != "" && !r.isTrashed(ctx, fileID), nil
+}
+
+// isTrashed reports whether fileID or any of its ancestors is in the trash. If the trash couldn't be checked
+// then the error is logged and fileID is considered not trashed, so the trash doesn't block uploads.
+func (r *Router) isTrashed(ctx context.Context, fileID string) bool {
+	if r.trash == nil || fileID == "" {
+		return false
+	}
+
+	trashed, err := file.InTrash(ctx, r.fileClient(), r.trash, fileID)
+	if err != nil {
+		loggermiddleware.LogError(r.logger, fmt.Errorf("failed checking if file %s is trashed: %v", fileID, err))
+		return false
+	}
+
+	return trashed
 }
 
 // calculateBufSize gets a file size and calculates the size of the buffer to read the file
@@ -921,7 +1147,7 @@ func (r *Router) calculateBufSize(fileSize int64) int64 {
 func (r *Router) deleteOnError(c *gin.Context, err error, fileID string) {
 	reqUser := user.ExtractRequestUser(c)
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 
@@ -931,6 +1157,7 @@ func (r *Router) deleteOnError(c *gin.Context, err error, fileID string) {
 		r.uploadClient(),
 		r.searchClient(),
 		r.permissionClient(),
+		nil,
 		fileID,
 		reqUser.ID)
 	httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
@@ -938,5 +1165,5 @@ func (r *Router) deleteOnError(c *gin.Context, err error, fileID string) {
 		err = fmt.Errorf("%v: %v", err, deleteErr)
 	}
 
-	loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+	loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 }
@@ -6,6 +6,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
 	"github.com/meateam/api-gateway/file"
 	loggermiddleware "github.com/meateam/api-gateway/logger"
 	"github.com/meateam/api-gateway/user"
@@ -17,7 +18,7 @@ import (
 func (r *Router) getUserFromContext(c *gin.Context) *user.User {
 	reqUser := user.ExtractRequestUser(c)
 	if reqUser == nil {
-		loggermiddleware.LogError(r.logger, c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("error extracting user from request")))
+		loggermiddleware.LogError(r.logger, apierror.AbortWithMessage(c, http.StatusUnauthorized, "error extracting user from request"))
 		return nil
 	}
 	return reqUser
@@ -44,13 +45,13 @@ func (r *Router) HandleUserFilePermission(c *gin.Context, fileID string, role pp
 
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return false
 	}
 
 	// If no permission is returned it means there is no permission to do the action
 	if userFilePermission == "" {
-		loggermiddleware.LogError(r.logger, c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("You do not have permission to do this operation")))
+		loggermiddleware.LogError(r.logger, apierror.AbortWithMessage(c, http.StatusUnauthorized, "You do not have permission to do this operation"))
 	}
 
 	return true
@@ -60,7 +61,7 @@ func (r *Router) HandleUserFilePermission(c *gin.Context, fileID string, role pp
 func (r *Router) getQueryFromContext(c *gin.Context, query string) (string, bool) {
 	queryRes, exists := c.GetQuery(query)
 	if !exists {
-		c.String(http.StatusBadRequest, fmt.Sprintf("%s is required", query))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("%s is required", query))
 		return "", false
 	}
 	return queryRes, true
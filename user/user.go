@@ -9,6 +9,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/meateam/api-gateway/apierror"
 	"github.com/meateam/api-gateway/factory"
 	loggermiddleware "github.com/meateam/api-gateway/logger"
 	grpcPoolTypes "github.com/meateam/grpc-go-conn-pool/grpc/types"
@@ -131,18 +132,18 @@ func (r *Router) Setup(rg *gin.RouterGroup) {
 func (r *Router) GetUserByID(c *gin.Context) {
 	reqUser := ExtractRequestUser(c)
 	if reqUser == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		apierror.AbortWithStatus(c, http.StatusUnauthorized)
 		return
 	}
 	userID := c.Param(ParamUserID)
 	if userID == "" {
-		c.String(http.StatusBadRequest, "id is required")
+		apierror.AbortWithMessage(c, http.StatusBadRequest, "id is required")
 		return
 	}
 
 	destination := c.GetHeader(HeaderDestionation)
 	if destination != "" && destination != viper.GetString(ConfigCtsDest) && destination != viper.GetString(ConfigTomcalDest) {
-		c.String(http.StatusBadRequest, fmt.Sprintf("destination %s doesnt supported", destination))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("destination %s doesnt supported", destination))
 		return
 	}
 
@@ -155,7 +156,7 @@ func (r *Router) GetUserByID(c *gin.Context) {
 
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return
 	}
 
@@ -182,14 +183,14 @@ func (r *Router) SearchByRouter(c *gin.Context) {
 func (r *Router) FindByMail(c *gin.Context) {
 	mail := c.Query(ParamRequestContent)
 	if mail == "" {
-		c.String(http.StatusBadRequest, "mail required")
+		apierror.AbortWithMessage(c, http.StatusBadRequest, "mail required")
 		return
 	}
 
 	
 	destination := c.GetHeader(HeaderDestionation)
 	if destination != "" && destination != viper.GetString(ConfigCtsDest) {
-		c.String(http.StatusBadRequest, fmt.Sprintf("destination %s doesnt supported", destination))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("destination %s doesnt supported", destination))
 		return
 	}
 
@@ -206,7 +207,7 @@ func (r *Router) FindByMail(c *gin.Context) {
 
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return
 	}
 
@@ -219,7 +220,7 @@ func (r *Router) FindByMail(c *gin.Context) {
 func (r *Router) FindByUserT(c *gin.Context) {
 	userT := c.Query(ParamRequestContent)
 	if userT == "" {
-		c.String(http.StatusBadRequest, "userT required")
+		apierror.AbortWithMessage(c, http.StatusBadRequest, "userT required")
 		return
 	}
 
@@ -232,7 +233,7 @@ func (r *Router) FindByUserT(c *gin.Context) {
 
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return
 	}
 
@@ -245,13 +246,13 @@ func (r *Router) FindByUserT(c *gin.Context) {
 func (r *Router) SearchByName(c *gin.Context) {
 	partialName := c.Query(ParamRequestContent)
 	if partialName == "" {
-		c.String(http.StatusBadRequest, "partial name required")
+		apierror.AbortWithMessage(c, http.StatusBadRequest, "partial name required")
 		return
 	}
 
 	destination := c.GetHeader(HeaderDestionation)
 	if destination != "" && destination != viper.GetString(ConfigCtsDest) && destination != viper.GetString(ConfigTomcalDest) {
-		c.String(http.StatusBadRequest, fmt.Sprintf("destination %s doesnt supported", destination))
+		apierror.AbortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("destination %s doesnt supported", destination))
 		return
 	}
 
@@ -264,7 +265,7 @@ func (r *Router) SearchByName(c *gin.Context) {
 
 	if err != nil {
 		httpStatusCode := gwruntime.HTTPStatusFromCode(status.Code(err))
-		loggermiddleware.LogError(r.logger, c.AbortWithError(httpStatusCode, err))
+		loggermiddleware.LogError(r.logger, apierror.Abort(c, httpStatusCode, err))
 		return
 	}
 